@@ -0,0 +1,183 @@
+// HTTPフォールバック接続管理（WebSocketがブロックされる環境向け）
+// 下りはSSE（EventSourceが使えない場合はロングポーリング）、上りはPOSTで送受信する
+
+import type { ConnectionHandler, MessageHandler } from "./websocket";
+
+export class StreamClient {
+  private baseUrl: string;
+  private onMessage: MessageHandler;
+  private onConnect: ConnectionHandler;
+  private onDisconnect: ConnectionHandler;
+
+  private id: string | null = null;
+  private events: EventSource | null = null;
+  private polling: boolean = false;
+  private pending: ArrayBuffer[] = [];
+  private flushing: boolean = false;
+
+  constructor(
+    baseUrl: string,
+    onMessage: MessageHandler,
+    onConnect: ConnectionHandler,
+    onDisconnect: ConnectionHandler
+  ) {
+    this.baseUrl = baseUrl;
+    this.onMessage = onMessage;
+    this.onConnect = onConnect;
+    this.onDisconnect = onDisconnect;
+  }
+
+  async connect(): Promise<void> {
+    try {
+      const res = await fetch(this.baseUrl, { method: "POST" });
+      if (!res.ok) {
+        throw new Error(`open failed: ${res.status}`);
+      }
+      const body: { id: string } = await res.json();
+      this.id = body.id;
+    } catch (error) {
+      console.error("Stream error:", error);
+      this.onDisconnect();
+      return;
+    }
+
+    console.log("Stream connected");
+    this.onConnect();
+    if (typeof EventSource !== "undefined") {
+      this.listen();
+    } else {
+      this.poll();
+    }
+  }
+
+  // SSEで下りフレームを受信する（切断時はEventSourceが自動で再接続する）
+  private listen(): void {
+    this.events = new EventSource(`${this.baseUrl}/${this.id}/events`);
+    this.events.addEventListener("frame", (event) => {
+      this.onMessage(decodeBase64((event as MessageEvent<string>).data));
+    });
+    this.events.addEventListener("close", () => {
+      this.close();
+    });
+  }
+
+  // ロングポーリングで下りフレームを受信する
+  private async poll(): Promise<void> {
+    this.polling = true;
+    while (this.polling && this.id !== null) {
+      let res: Response;
+      try {
+        res = await fetch(`${this.baseUrl}/${this.id}/poll`, { cache: "no-store" });
+      } catch (error) {
+        console.error("Stream poll error:", error);
+        this.close();
+        return;
+      }
+      if (res.status === 204) {
+        continue;
+      }
+      if (!res.ok) {
+        // 410: サーバーが接続を閉じた
+        this.close();
+        return;
+      }
+      for (const frame of decodeFrames(await res.arrayBuffer())) {
+        this.onMessage(frame);
+      }
+    }
+  }
+
+  send(data: ArrayBuffer): void {
+    if (this.id === null) {
+      return;
+    }
+    this.pending.push(data);
+    if (!this.flushing) {
+      this.flushing = true;
+      // 同じタスク内の送信を1回のPOSTにまとめる
+      queueMicrotask(() => this.flush());
+    }
+  }
+
+  private async flush(): Promise<void> {
+    const frames = this.pending;
+    this.pending = [];
+    try {
+      const res = await fetch(`${this.baseUrl}/${this.id}/send`, {
+        method: "POST",
+        headers: { "Content-Type": "application/octet-stream" },
+        body: encodeFrames(frames),
+      });
+      if (res.status === 404 || res.status === 410) {
+        this.close();
+      }
+    } catch (error) {
+      console.error("Stream send error:", error);
+    } finally {
+      this.flushing = false;
+      if (this.pending.length > 0) {
+        this.flushing = true;
+        queueMicrotask(() => this.flush());
+      }
+    }
+  }
+
+  disconnect(): void {
+    if (this.id !== null) {
+      fetch(`${this.baseUrl}/${this.id}`, { method: "DELETE" }).catch(() => {});
+    }
+    this.close();
+  }
+
+  isConnected(): boolean {
+    return this.id !== null;
+  }
+
+  private close(): void {
+    if (this.id === null) {
+      return;
+    }
+    this.id = null;
+    this.polling = false;
+    this.events?.close();
+    this.events = null;
+    console.log("Stream disconnected");
+    this.onDisconnect();
+  }
+}
+
+// フレームを [length u32 (リトルエンディアン)] + [payload] で連結する
+function encodeFrames(frames: ArrayBuffer[]): ArrayBuffer {
+  const size = frames.reduce((n, f) => n + 4 + f.byteLength, 0);
+  const buffer = new ArrayBuffer(size);
+  const view = new DataView(buffer);
+  const bytes = new Uint8Array(buffer);
+  let offset = 0;
+  for (const frame of frames) {
+    view.setUint32(offset, frame.byteLength, true);
+    bytes.set(new Uint8Array(frame), offset + 4);
+    offset += 4 + frame.byteLength;
+  }
+  return buffer;
+}
+
+function decodeFrames(buffer: ArrayBuffer): ArrayBuffer[] {
+  const view = new DataView(buffer);
+  const frames: ArrayBuffer[] = [];
+  let offset = 0;
+  while (offset + 4 <= buffer.byteLength) {
+    const length = view.getUint32(offset, true);
+    frames.push(buffer.slice(offset + 4, offset + 4 + length));
+    offset += 4 + length;
+  }
+  return frames;
+}
+
+function decodeBase64(data: string): ArrayBuffer {
+  const binary = atob(data);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes.buffer;
+}
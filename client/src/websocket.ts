@@ -1,5 +1,10 @@
 // WebSocket 接続管理
 
+import { StreamClient } from "./stream";
+
+// サーバーが要求するWebSocketサブプロトコル
+export const SUBPROTOCOL = "withered.v1";
+
 export type MessageHandler = (data: ArrayBuffer) => void;
 export type ConnectionHandler = () => void;
 
@@ -9,6 +14,10 @@ export class WebSocketClient {
   private onMessage: MessageHandler;
   private onConnect: ConnectionHandler;
   private onDisconnect: ConnectionHandler;
+  private opened: boolean = false;
+  private closing: boolean = false;
+  // WebSocketが開けなかった場合に使うHTTPフォールバック
+  private fallback: StreamClient | null = null;
 
   constructor(
     url: string,
@@ -23,11 +32,12 @@ export class WebSocketClient {
   }
 
   connect(): void {
-    this.ws = new WebSocket(this.url);
+    this.ws = new WebSocket(this.url, SUBPROTOCOL);
     this.ws.binaryType = "arraybuffer";
 
     this.ws.onopen = () => {
       console.log("WebSocket connected");
+      this.opened = true;
       this.onConnect();
     };
 
@@ -38,6 +48,13 @@ export class WebSocketClient {
     };
 
     this.ws.onclose = () => {
+      this.ws = null;
+      if (!this.opened && !this.closing) {
+        // アップグレードがブロックされた可能性があるため、HTTPフォールバックを試す
+        console.log("WebSocket unavailable, falling back to HTTP stream");
+        this.connectFallback();
+        return;
+      }
       console.log("WebSocket disconnected");
       this.onDisconnect();
     };
@@ -47,20 +64,39 @@ export class WebSocketClient {
     };
   }
 
+  private connectFallback(): void {
+    // ws://host/ws → http://host/stream
+    const url = this.url.replace(/^ws/, "http").replace(/\/ws$/, "/stream");
+    this.fallback = new StreamClient(url, this.onMessage, this.onConnect, this.onDisconnect);
+    this.fallback.connect();
+  }
+
   send(data: ArrayBuffer): void {
+    if (this.fallback) {
+      this.fallback.send(data);
+      return;
+    }
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       this.ws.send(data);
     }
   }
 
   disconnect(): void {
+    if (this.fallback) {
+      this.fallback.disconnect();
+      this.fallback = null;
+    }
     if (this.ws) {
+      this.closing = true;
       this.ws.close();
       this.ws = null;
     }
   }
 
   isConnected(): boolean {
+    if (this.fallback) {
+      return this.fallback.isConnected();
+    }
     return this.ws !== null && this.ws.readyState === WebSocket.OPEN;
   }
 }
\ No newline at end of file
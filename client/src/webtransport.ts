@@ -0,0 +1,153 @@
+// WebTransport 接続管理（HTTP/3）
+// Controlメッセージは双方向ストリーム（制御ストリーム）、Input/Actor/Voiceはデータグラムで送受信する
+// データグラムはヘッドオブラインブロッキングが起きないため、ロスのある回線でも入力・位置更新が遅れにくい
+
+import { DATA_TYPE_CONTROL, HEADER_SIZE, PAYLOAD_HEADER_SIZE } from "./protocol";
+import type { ConnectionHandler, MessageHandler } from "./websocket";
+
+// 制御ストリームの先頭で送るバージョン（サーバーの controlStreamVersion と一致させる）
+const CONTROL_STREAM_VERSION = 1;
+
+export class WebTransportClient {
+  private url: string;
+  private certificateHash: Uint8Array | null;
+  private onMessage: MessageHandler;
+  private onConnect: ConnectionHandler;
+  private onDisconnect: ConnectionHandler;
+
+  private transport: WebTransport | null = null;
+  private control: WritableStreamDefaultWriter<Uint8Array> | null = null;
+  private datagrams: WritableStreamDefaultWriter<Uint8Array> | null = null;
+
+  // certificateHash: 自己署名証明書を使う開発環境では、サーバーが起動時に出力するSHA-256ハッシュを指定する
+  constructor(
+    url: string,
+    certificateHash: Uint8Array | null,
+    onMessage: MessageHandler,
+    onConnect: ConnectionHandler,
+    onDisconnect: ConnectionHandler
+  ) {
+    this.url = url;
+    this.certificateHash = certificateHash;
+    this.onMessage = onMessage;
+    this.onConnect = onConnect;
+    this.onDisconnect = onDisconnect;
+  }
+
+  static isSupported(): boolean {
+    return typeof WebTransport !== "undefined";
+  }
+
+  async connect(): Promise<void> {
+    const options: WebTransportOptions = {};
+    if (this.certificateHash !== null) {
+      options.serverCertificateHashes = [{ algorithm: "sha-256", value: this.certificateHash }];
+    }
+    try {
+      const transport = new WebTransport(this.url, options);
+      await transport.ready;
+      const stream = await transport.createBidirectionalStream();
+      this.control = stream.writable.getWriter();
+      await this.control.write(new Uint8Array([CONTROL_STREAM_VERSION]));
+      this.datagrams = transport.datagrams.writable.getWriter();
+      this.transport = transport;
+
+      this.readControl(stream.readable);
+      this.readDatagrams(transport.datagrams.readable);
+      transport.closed
+        .catch((error) => console.error("WebTransport error:", error))
+        .finally(() => this.close());
+    } catch (error) {
+      console.error("WebTransport error:", error);
+      this.onDisconnect();
+      return;
+    }
+
+    console.log("WebTransport connected");
+    this.onConnect();
+  }
+
+  // 制御ストリームは [length u32 (リトルエンディアン)] + [payload] の連続
+  private async readControl(readable: ReadableStream<Uint8Array>): Promise<void> {
+    const reader = readable.getReader();
+    let buffer = new Uint8Array(0);
+    try {
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          return;
+        }
+        buffer = concat(buffer, value);
+        while (buffer.byteLength >= 4) {
+          const length = new DataView(buffer.buffer, buffer.byteOffset).getUint32(0, true);
+          if (buffer.byteLength < 4 + length) {
+            break;
+          }
+          this.onMessage(buffer.slice(4, 4 + length).buffer);
+          buffer = buffer.slice(4 + length);
+        }
+      }
+    } catch {
+      // セッション終了時はtransport.closedで処理する
+    }
+  }
+
+  private async readDatagrams(readable: ReadableStream<Uint8Array>): Promise<void> {
+    const reader = readable.getReader();
+    try {
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          return;
+        }
+        this.onMessage(value.slice().buffer);
+      }
+    } catch {
+      // セッション終了時はtransport.closedで処理する
+    }
+  }
+
+  send(data: ArrayBuffer): void {
+    if (this.control === null || this.datagrams === null) {
+      return;
+    }
+    const bytes = new Uint8Array(data);
+    const reliable = bytes.byteLength < HEADER_SIZE + PAYLOAD_HEADER_SIZE || bytes[HEADER_SIZE] === DATA_TYPE_CONTROL;
+    const maxDatagramSize = this.transport?.datagrams.maxDatagramSize ?? 0;
+    if (!reliable && bytes.byteLength <= maxDatagramSize) {
+      this.datagrams.write(bytes).catch(() => {});
+      return;
+    }
+    const frame = new Uint8Array(4 + bytes.byteLength);
+    new DataView(frame.buffer).setUint32(0, bytes.byteLength, true);
+    frame.set(bytes, 4);
+    this.control.write(frame).catch(() => {});
+  }
+
+  disconnect(): void {
+    this.transport?.close({ closeCode: 1000, reason: "" });
+    this.close();
+  }
+
+  isConnected(): boolean {
+    return this.transport !== null;
+  }
+
+  private close(): void {
+    if (this.transport === null) {
+      return;
+    }
+    this.transport = null;
+    this.control = null;
+    this.datagrams = null;
+    console.log("WebTransport disconnected");
+    this.onDisconnect();
+  }
+}
+
+function concat(a: Uint8Array, b: Uint8Array): Uint8Array {
+  const out = new Uint8Array(a.byteLength + b.byteLength);
+  out.set(a, 0);
+  out.set(b, a.byteLength);
+  return out;
+}
@@ -0,0 +1,42 @@
+package adapterhttpstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ロングポーリングとPOSTの本文: フレームを [length u32 (リトルエンディアン)] + [payload] で連結したもの
+const frameHeaderSize = 4
+
+// ErrFrameTooLarge はフレーム長がMaxFrameSizeを超えた場合に返されるエラーです。
+var ErrFrameTooLarge = errors.New("httpstream: frame too large")
+
+// AppendFrame はbにフレームを長さプレフィックス付きで追加します。
+func AppendFrame(b, frame []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+	return append(b, frame...)
+}
+
+// ReadFrames は長さプレフィックス付きで連結されたフレームを読み込みます。
+func ReadFrames(r io.Reader, maxFrameSize int) ([][]byte, error) {
+	var frames [][]byte
+	var header [frameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			return nil, err
+		}
+		n := binary.LittleEndian.Uint32(header[:])
+		if int64(n) > int64(maxFrameSize) {
+			return nil, ErrFrameTooLarge
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+}
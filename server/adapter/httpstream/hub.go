@@ -0,0 +1,109 @@
+package adapterhttpstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// Hub は稼働中のTransportをIDで管理します。
+// IDは推測できない乱数で、後続のリクエストに対する接続の所有の証明を兼ねます。
+type Hub struct {
+	opts Options
+
+	mu         sync.Mutex
+	transports map[string]*Transport
+}
+
+// NewHub は新しいHubを作成します。
+func NewHub(opts Options) *Hub {
+	def := DefaultOptions()
+	if opts.InboxSize < 1 {
+		opts.InboxSize = def.InboxSize
+	}
+	if opts.OutboxSize < 1 {
+		opts.OutboxSize = def.OutboxSize
+	}
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = def.MaxFrameSize
+	}
+	return &Hub{
+		opts:       opts,
+		transports: make(map[string]*Transport),
+	}
+}
+
+// Options はHubの設定を返します。
+func (h *Hub) Options() Options {
+	return h.opts
+}
+
+// Create は新しいTransportを作成して登録します。
+func (h *Hub) Create() *Transport {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	t := newTransport(base64.RawURLEncoding.EncodeToString(b[:]), h.opts)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transports[t.id] = t
+	return t
+}
+
+// Get はIDに対応するTransportを返します。
+func (h *Hub) Get(id string) (*Transport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.transports[id]
+	return t, ok
+}
+
+// Len は登録中のTransportの数を返します。
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.transports)
+}
+
+// Run はctxがキャンセルされるまで、受信者のいない接続の終了と閉じた接続の削除を定期的に行います。
+func (h *Hub) Run(ctx context.Context) {
+	interval := h.opts.IdleTimeout / 4
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.reap(now)
+		}
+	}
+}
+
+func (h *Hub) reap(now time.Time) {
+	if h.opts.IdleTimeout <= 0 {
+		return
+	}
+	h.mu.Lock()
+	var idle []*Transport
+	for id, t := range h.transports {
+		remove, isIdle := t.expired(now, h.opts.IdleTimeout)
+		if remove {
+			delete(h.transports, id)
+		} else if isIdle {
+			idle = append(idle, t)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, t := range idle {
+		t.shutdown(&CloseError{Code: domain.CloseCodeIdle, Reason: "idle"}, ErrIdleTimeout)
+	}
+}
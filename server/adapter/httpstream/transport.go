@@ -0,0 +1,253 @@
+package adapterhttpstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+var (
+	// ErrTransportClosed はClose済みのTransportを操作した場合に返されるエラーです。
+	ErrTransportClosed = fmt.Errorf("httpstream: %w", domain.ErrTransportClosed)
+	// ErrIdleTimeout は下り方向の受信者（SSE・ロングポーリング）が一定時間いなかった場合にReadが返すエラーです。
+	ErrIdleTimeout = errors.New("httpstream: no downstream consumer")
+)
+
+// CloseError はサーバーが接続を閉じた後、下り方向の受信者に渡されるクローズ情報です。
+type CloseError struct {
+	Code   int32  `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("httpstream: closed (code=%d, reason=%q)", e.Code, e.Reason)
+}
+
+// Options はHTTPストリーミングトランスポートの設定です。
+type Options struct {
+	// InboxSize は上り方向（POST）で受け取り、未読のフレームを保持する数です。
+	InboxSize int
+	// OutboxSize は下り方向で受信者に渡していないフレームを保持する数です。
+	OutboxSize int
+	// IdleTimeout は下り方向の受信者がいない状態を許容する時間です。
+	// 超えた場合はクライアントが去ったとみなして接続を閉じます。
+	IdleTimeout time.Duration
+	// PollTimeout はロングポーリングで下りフレームを待つ最大時間です。
+	PollTimeout time.Duration
+	// MaxBatchSize はロングポーリング1回の応答に含めるフレームの合計サイズの上限です。
+	MaxBatchSize int
+	// HeartbeatInterval はSSEで無通信時にコメント行を送る間隔です（中継プロキシによる切断を防ぐ）。
+	HeartbeatInterval time.Duration
+	// MaxFrameSize は受け付けるフレームの最大サイズです。
+	MaxFrameSize int
+}
+
+// DefaultOptions はプロトコルに合わせたデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		InboxSize:         256,
+		OutboxSize:        1024,
+		IdleTimeout:       30 * time.Second,
+		PollTimeout:       25 * time.Second,
+		MaxBatchSize:      256 * 1024,
+		HeartbeatInterval: 15 * time.Second,
+		MaxFrameSize:      domain.MaxFrameSize,
+	}
+}
+
+// Transport はHTTPリクエストの組で1接続を表すdomain.Transport実装です。
+// 上りフレームはPOSTでDeliverされ、下りフレームはSSEまたはロングポーリングでNextから取り出されます。
+// 同じIDへのリクエストは全て同じTransportに届く必要があります（セッションアフィニティ）。
+type Transport struct {
+	id     string
+	inbox  chan []byte
+	outbox chan []byte
+
+	mu         sync.Mutex
+	consumer   context.CancelFunc // 現在の下り方向の受信者
+	attached   int
+	lastActive time.Time
+	closeErr   *CloseError // サーバーが閉じた場合のみ設定される
+	readErr    error       // クライアントが去った場合にReadが返すエラー
+	closedAt   time.Time
+	delivered  bool // クローズを受信者に渡した
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newTransport(id string, opts Options) *Transport {
+	return &Transport{
+		id:         id,
+		inbox:      make(chan []byte, opts.InboxSize),
+		outbox:     make(chan []byte, opts.OutboxSize),
+		lastActive: time.Now(),
+		closed:     make(chan struct{}),
+	}
+}
+
+// ID は接続のIDを返します。
+func (t *Transport) ID() string {
+	return t.id
+}
+
+func (t *Transport) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-t.inbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-t.inbox:
+		return data, nil
+	case <-t.closed:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.readErr != nil {
+			return nil, t.readErr
+		}
+		return nil, ErrTransportClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Transport) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	select {
+	case t.outbox <- buf:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close は接続を閉じます。未送信の下りフレームとクローズ情報は、この後も受信者に渡されます。
+func (t *Transport) Close(code int32, reason string) error {
+	if !t.shutdown(&CloseError{Code: code, Reason: reason}, nil) {
+		return ErrTransportClosed
+	}
+	return nil
+}
+
+// Deliver はクライアントからPOSTされた上りフレームを渡します。
+func (t *Transport) Deliver(ctx context.Context, data []byte) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.inbox <- data:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PeerClose はクライアントが接続を終了したことを通知します。以降のReadはio.EOFを返します。
+func (t *Transport) PeerClose() {
+	t.shutdown(&CloseError{Code: domain.CloseCodeNormal}, io.EOF)
+}
+
+// Attach は下り方向の受信者として登録し、受信者のctxを返します。
+// 既に受信者がいる場合、その受信者のctxはキャンセルされます（再接続したSSEが古い接続を置き換える）。
+// 受信を終えたらdetachを呼び出す必要があります。
+func (t *Transport) Attach(ctx context.Context) (consumerCtx context.Context, detach func()) {
+	consumerCtx, cancel := context.WithCancel(ctx)
+
+	t.mu.Lock()
+	if t.consumer != nil {
+		t.consumer()
+	}
+	t.consumer = cancel
+	t.attached++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return consumerCtx, func() {
+		once.Do(func() {
+			cancel()
+			t.mu.Lock()
+			t.attached--
+			t.lastActive = time.Now()
+			t.mu.Unlock()
+		})
+	}
+}
+
+// Next は次の下りフレームを返します。サーバーが閉じた場合は、未送信のフレームを返し切った後に*CloseErrorを返します。
+func (t *Transport) Next(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-t.outbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-t.outbox:
+		return data, nil
+	case <-t.closed:
+		if data, ok := t.TryNext(); ok {
+			return data, nil
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.delivered = true
+		return nil, t.closeErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryNext は待たずに取り出せる下りフレームを返します。
+func (t *Transport) TryNext() ([]byte, bool) {
+	select {
+	case data := <-t.outbox:
+		return data, true
+	default:
+		return nil, false
+	}
+}
+
+// shutdown は接続を閉じた状態にします。初回の呼び出しのみtrueを返します。
+func (t *Transport) shutdown(closeErr *CloseError, readErr error) bool {
+	first := false
+	t.closeOnce.Do(func() {
+		first = true
+		t.mu.Lock()
+		t.closeErr = closeErr
+		t.readErr = readErr
+		t.closedAt = time.Now()
+		t.mu.Unlock()
+		close(t.closed)
+	})
+	return first
+}
+
+// expired は接続を表から取り除くべきか、クライアントが去ったとみなすべきかを判定します。
+func (t *Transport) expired(now time.Time, idleTimeout time.Duration) (remove, idle bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.closed:
+		// クローズを受信者に渡したか、受信者が来ないまま猶予を過ぎたら取り除く
+		return t.delivered || now.Sub(t.closedAt) > idleTimeout, false
+	default:
+	}
+	return false, t.attached == 0 && now.Sub(t.lastActive) > idleTimeout
+}
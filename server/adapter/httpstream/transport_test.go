@@ -0,0 +1,122 @@
+package adapterhttpstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+)
+
+func TestTransport_DrainsBeforeCloseError(t *testing.T) {
+	ctx := context.Background()
+	hub := NewHub(DefaultOptions())
+	tr := hub.Create()
+
+	tr.Write(ctx, []byte{1})
+	if err := tr.Close(domain.CloseCodeKicked, "kicked"); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := tr.Close(domain.CloseCodeNormal, ""); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("second Close error = %v, want %v", err, ErrTransportClosed)
+	}
+
+	if data, err := tr.Next(ctx); err != nil || data[0] != 1 {
+		t.Fatalf("Next = (%v, %v), want pending frame", data, err)
+	}
+	var closeErr *CloseError
+	if _, err := tr.Next(ctx); !errors.As(err, &closeErr) || closeErr.Code != domain.CloseCodeKicked {
+		t.Errorf("Next error = %v, want close code %d", err, domain.CloseCodeKicked)
+	}
+}
+
+func TestTransport_DeliverAndPeerClose(t *testing.T) {
+	ctx := context.Background()
+	tr := NewHub(DefaultOptions()).Create()
+
+	if err := tr.Deliver(ctx, []byte{7}); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+	tr.PeerClose()
+
+	if data, err := tr.Read(ctx); err != nil || data[0] != 7 {
+		t.Fatalf("Read = (%v, %v), want delivered frame", data, err)
+	}
+	if _, err := tr.Read(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("Read error = %v, want %v", err, io.EOF)
+	}
+	if err := tr.Deliver(ctx, []byte{8}); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("Deliver after close error = %v, want %v", err, ErrTransportClosed)
+	}
+}
+
+func TestTransport_AttachReplacesConsumer(t *testing.T) {
+	tr := NewHub(DefaultOptions()).Create()
+
+	first, detachFirst := tr.Attach(context.Background())
+	defer detachFirst()
+	_, detachSecond := tr.Attach(context.Background())
+	defer detachSecond()
+
+	select {
+	case <-first.Done():
+	default:
+		t.Error("first consumer was not cancelled by the second")
+	}
+}
+
+func TestHub_ReapsIdleAndClosedTransports(t *testing.T) {
+	opts := DefaultOptions()
+	opts.IdleTimeout = time.Minute
+	hub := NewHub(opts)
+
+	idle := hub.Create()
+	attached := hub.Create()
+	_, detach := attached.Attach(context.Background())
+	defer detach()
+
+	now := time.Now().Add(2 * time.Minute)
+	hub.reap(now)
+	if _, err := idle.Read(context.Background()); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("idle Read error = %v, want %v", err, ErrIdleTimeout)
+	}
+	if err := attached.Write(context.Background(), []byte{1}); err != nil {
+		t.Errorf("attached transport was closed: %v", err)
+	}
+	if hub.Len() != 2 {
+		t.Fatalf("Len = %d, want 2 (closed transport kept until delivered)", hub.Len())
+	}
+
+	// クローズが受信者に渡らないまま猶予を過ぎたら取り除く
+	hub.reap(now.Add(2 * time.Minute))
+	if _, ok := hub.Get(idle.ID()); ok {
+		t.Error("closed transport was not removed")
+	}
+	if _, ok := hub.Get(attached.ID()); !ok {
+		t.Error("attached transport was removed")
+	}
+}
+
+func TestFrames_RoundTrip(t *testing.T) {
+	body := AppendFrame(nil, []byte{1, 2})
+	body = AppendFrame(body, []byte{})
+	body = AppendFrame(body, []byte{3})
+
+	frames, err := ReadFrames(bytes.NewReader(body), 16)
+	if err != nil {
+		t.Fatalf("ReadFrames failed: %v", err)
+	}
+	if len(frames) != 3 || !bytes.Equal(frames[0], []byte{1, 2}) || len(frames[1]) != 0 || frames[2][0] != 3 {
+		t.Errorf("ReadFrames = %v", frames)
+	}
+
+	if _, err := ReadFrames(bytes.NewReader(AppendFrame(nil, make([]byte, 17))), 16); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ReadFrames error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	if _, err := ReadFrames(bytes.NewReader(body[:len(body)-1]), 16); err == nil {
+		t.Error("ReadFrames accepted a truncated body")
+	}
+}
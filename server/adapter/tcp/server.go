@@ -0,0 +1,140 @@
+package adaptertcp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ErrServerClosed はClose/Shutdown後にServeが返すエラーです。
+var ErrServerClosed = errors.New("tcp: server closed")
+
+// HandleFunc は受け付けた接続ごとに呼ばれ、接続が終了するまでブロックします。
+type HandleFunc func(ctx context.Context, transport domain.Transport, remoteAddr string)
+
+// Server は長さプレフィックス付きフレームでプロトコルを提供するTCPサーバーです。
+type Server struct {
+	addr   string
+	opts   Options
+	handle HandleFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	ln     net.Listener
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer(addr string, opts Options, handle HandleFunc) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		addr:   addr,
+		opts:   opts,
+		handle: handle,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Serve はaddrで待ち受けを開始し、Close/Shutdownまで接続を受け付けます。
+func (s *Server) Serve() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(ln)
+}
+
+// ServeListener は指定したリスナーで接続を受け付けます。
+func (s *Server) ServeListener(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				slog.Warn("tcp: accept error, retrying", "err", err)
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(s.ctx, NewTransportFrom(conn, s.opts), conn.RemoteAddr().String())
+		}()
+	}
+}
+
+// Shutdown は新規接続の受け付けを止め、処理中の接続が終了するのを待ちます。
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.closeListener(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close は新規接続の受け付けを止め、処理中の接続のコンテキストをキャンセルします。
+func (s *Server) Close() error {
+	err := s.closeListener()
+	s.cancel()
+	return err
+}
+
+// Addr は待ち受け中のアドレスを返します。待ち受け前は設定値を返します。
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+	return s.addr
+}
+
+func (s *Server) closeListener() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,150 @@
+package adaptertcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// フレーム: [length u32 (リトルエンディアン)] + [payload (length バイト)]
+const frameHeaderSize = 4
+
+var (
+	// ErrFrameTooLarge はフレーム長がMaxFrameSizeを超えた場合に返されるエラーです。
+	ErrFrameTooLarge = errors.New("tcp: frame too large")
+	// ErrTransportClosed はClose済みのTransportを操作した場合に返されるエラーです。
+	ErrTransportClosed = fmt.Errorf("tcp: %w", domain.ErrTransportClosed)
+)
+
+// Options はTCPトランスポートの設定です。
+type Options struct {
+	// ReadTimeout は1フレームの読み込みを待つ最大時間です。0の場合は無制限です。
+	ReadTimeout time.Duration
+	// WriteTimeout は1フレームの書き込みを待つ最大時間です。0の場合は無制限です。
+	WriteTimeout time.Duration
+	// MaxFrameSize は受け付けるフレームの最大サイズです。
+	MaxFrameSize int
+}
+
+// DefaultOptions はプロトコルに合わせたデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		ReadTimeout:  60 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		MaxFrameSize: domain.MaxFrameSize,
+	}
+}
+
+type tcpTransport struct {
+	conn net.Conn
+	opts Options
+	r    *bufio.Reader
+
+	wmu sync.Mutex // 書き込みは複数goroutineから呼ばれうる
+
+	closeOnce sync.Once
+}
+
+// NewTransportFrom はnet.Connを長さプレフィックス付きフレームで送受信するTransportに変換します。
+func NewTransportFrom(conn net.Conn, opts Options) domain.Transport {
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = domain.MaxFrameSize
+	}
+	return &tcpTransport{
+		conn: conn,
+		opts: opts,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// Dial はTCPサーバーに接続し、Transportを返します。
+func Dial(ctx context.Context, addr string, opts Options) (domain.Transport, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewTransportFrom(conn, opts), nil
+}
+
+func (t *tcpTransport) Read(ctx context.Context) ([]byte, error) {
+	// ctxのキャンセルでブロック中のReadを解除する
+	stop := context.AfterFunc(ctx, func() {
+		_ = t.conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	if err := t.conn.SetReadDeadline(deadline(t.opts.ReadTimeout)); err != nil {
+		return nil, err
+	}
+
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(t.r, header[:]); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	if int64(n) > int64(t.opts.MaxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(t.r, data); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return data, nil
+}
+
+func (t *tcpTransport) Write(ctx context.Context, data []byte) error {
+	if len(data) > t.opts.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = t.conn.SetWriteDeadline(time.Now())
+	})
+	defer stop()
+	if err := t.conn.SetWriteDeadline(deadline(t.opts.WriteTimeout)); err != nil {
+		return err
+	}
+
+	var header [frameHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(data)))
+	buffers := net.Buffers{header[:], data}
+	if _, err := buffers.WriteTo(t.conn); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// Close は接続を閉じます。
+// TCPにはクローズフレームが存在しないため、codeとreasonは相手に送られません。
+func (t *tcpTransport) Close(code int32, reason string) error {
+	err := ErrTransportClosed
+	t.closeOnce.Do(func() {
+		err = t.conn.Close()
+	})
+	return err
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// contextError はctxのキャンセルによるデッドライン超過をctxのエラーに置き換えます。
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package adapterudp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ErrHandshakeFailed はDialのハンドシェイクが規定回数内に完了しなかった場合に返されるエラーです。
+var ErrHandshakeFailed = errors.New("udp: handshake failed")
+
+// Dial はUDPサーバーとハンドシェイクを行い、Transportを返します。
+func Dial(ctx context.Context, addr string, opts Options) (domain.Transport, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	connID, err := handshake(ctx, conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t := newTransport(connID, opts, func(p []byte) error {
+		_, err := conn.Write(p)
+		return err
+	}, nil)
+	go readLoop(conn, t)
+	return t, nil
+}
+
+// handshake はhello → cookie → hello(cookie) → welcome の順で接続を確立します。
+func handshake(ctx context.Context, conn *net.UDPConn, opts Options) (uint32, error) {
+	var cookie [cookieSize]byte
+	buf := make([]byte, opts.MTU)
+	// cookieRounds はcookieを受け取った回数です。cookieを返し続けるサーバーで無限に再送しないよう、
+	// 待たずに再送するのは1回の試行につき1回までにします
+	cookieRounds := 0
+	for attempt := 0; attempt < opts.HandshakeRetries; attempt++ {
+		if _, err := conn.Write(encodeHello(cookie)); err != nil {
+			return 0, err
+		}
+		deadline := time.Now().Add(opts.HandshakeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return 0, err
+		}
+		switch packetType(buf[0]) {
+		case packetCookie:
+			if n < 1+cookieSize {
+				continue
+			}
+			copy(cookie[:], buf[1:1+cookieSize])
+			// cookieを受け取ったら待たずにhelloを再送する
+			if cookieRounds++; cookieRounds <= opts.HandshakeRetries {
+				attempt--
+			}
+		case packetWelcome:
+			connID, err := parseConnID(buf[:n])
+			if err != nil {
+				continue
+			}
+			return connID, conn.SetReadDeadline(time.Time{})
+		}
+	}
+	return 0, ErrHandshakeFailed
+}
+
+// readLoop はDialした接続の受信パケットをTransportに供給します。
+func readLoop(conn *net.UDPConn, t *udpTransport) {
+	defer conn.Close()
+	go func() {
+		// Transportが閉じられたらソケットも閉じて受信を止める
+		<-t.closed
+		conn.Close()
+	}()
+	buf := make([]byte, t.opts.MTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.shutdown(err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		connID, err := parseConnID(buf[:n])
+		if err != nil || connID != t.connID {
+			continue
+		}
+		t.handlePacket(packetType(buf[0]), buf[:n])
+	}
+}
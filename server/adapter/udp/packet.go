@@ -0,0 +1,142 @@
+package adapterudp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"withered/server/domain"
+)
+
+var byteOrder = binary.LittleEndian
+
+// packetType はUDPパケットの種別
+type packetType uint8
+
+// パケットフォーマット
+//
+//	hello    c→s  [type][cookie 16B][padding]  - cookieが空の場合はcookieを要求
+//	cookie   s→c  [type][cookie 16B]
+//	welcome  s→c  [type][connID u32]
+//	data     双方向 [type][connID u32][payload]            - 非信頼チャネル
+//	reliable 双方向 [type][connID u32][seq u32][payload]   - 信頼チャネル
+//	fragment 双方向 [type][connID u32][seq u32][payload]   - 信頼チャネルで分割したフレームの途中の断片
+//	ack      双方向 [type][connID u32][seq u32]
+//	close    双方向 [type][connID u32]
+const (
+	packetHello    packetType = 0x01
+	packetCookie   packetType = 0x02
+	packetWelcome  packetType = 0x03
+	packetData     packetType = 0x10
+	packetReliable packetType = 0x11
+	packetAck      packetType = 0x12
+	packetClose    packetType = 0x13
+	packetFragment packetType = 0x14
+)
+
+const (
+	cookieSize = 16
+	// helloSize はhelloパケットの最小サイズ
+	// cookie応答より大きくすることで、送信元偽装による増幅攻撃に使われないようにする
+	helloSize = 64
+
+	connHeaderSize     = 1 + 4
+	reliableHeaderSize = connHeaderSize + 4
+
+	// cookieWindow はcookieの有効期間の単位（直前のwindowまで有効）
+	cookieWindow = 10 * time.Second
+)
+
+var (
+	errShortPacket   = errors.New("udp: short packet")
+	errInvalidCookie = errors.New("udp: invalid cookie")
+)
+
+func encodeHello(cookie [cookieSize]byte) []byte {
+	b := make([]byte, helloSize)
+	b[0] = byte(packetHello)
+	copy(b[1:1+cookieSize], cookie[:])
+	return b
+}
+
+func parseHello(b []byte) ([cookieSize]byte, error) {
+	var cookie [cookieSize]byte
+	if len(b) < helloSize {
+		return cookie, errShortPacket
+	}
+	copy(cookie[:], b[1:1+cookieSize])
+	return cookie, nil
+}
+
+func encodeCookie(cookie [cookieSize]byte) []byte {
+	b := make([]byte, 1+cookieSize)
+	b[0] = byte(packetCookie)
+	copy(b[1:], cookie[:])
+	return b
+}
+
+func encodeConnPacket(t packetType, connID uint32, payload []byte) []byte {
+	b := make([]byte, connHeaderSize+len(payload))
+	b[0] = byte(t)
+	byteOrder.PutUint32(b[1:5], connID)
+	copy(b[connHeaderSize:], payload)
+	return b
+}
+
+func encodeSeqPacket(t packetType, connID, seq uint32, payload []byte) []byte {
+	b := make([]byte, reliableHeaderSize+len(payload))
+	b[0] = byte(t)
+	byteOrder.PutUint32(b[1:5], connID)
+	byteOrder.PutUint32(b[5:9], seq)
+	copy(b[reliableHeaderSize:], payload)
+	return b
+}
+
+func parseConnID(b []byte) (uint32, error) {
+	if len(b) < connHeaderSize {
+		return 0, errShortPacket
+	}
+	return byteOrder.Uint32(b[1:5]), nil
+}
+
+func parseSeq(b []byte) (uint32, error) {
+	if len(b) < reliableHeaderSize {
+		return 0, errShortPacket
+	}
+	return byteOrder.Uint32(b[5:9]), nil
+}
+
+// cookieFor は送信元アドレスと時間窓からステートレスなcookieを計算します。
+func cookieFor(secret []byte, addr string, window int64) [cookieSize]byte {
+	mac := hmac.New(sha256.New, secret)
+	var w [8]byte
+	byteOrder.PutUint64(w[:], uint64(window))
+	mac.Write(w[:])
+	mac.Write([]byte(addr))
+	var cookie [cookieSize]byte
+	copy(cookie[:], mac.Sum(nil))
+	return cookie
+}
+
+// verifyCookie は現在または直前の時間窓で発行されたcookieかを検証します。
+func verifyCookie(secret []byte, addr string, cookie [cookieSize]byte, now time.Time) bool {
+	window := now.UnixNano() / int64(cookieWindow)
+	for _, w := range []int64{window, window - 1} {
+		expected := cookieFor(secret, addr, w)
+		if hmac.Equal(expected[:], cookie[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReliableFrame はプロトコルフレームを信頼チャネルで送るべきかを判定します。
+// Controlメッセージのみ信頼チャネルを使い、Input/Actor/Voiceは非信頼チャネルで送ります。
+func isReliableFrame(data []byte) bool {
+	if len(data) < domain.HeaderSize+domain.PayloadHeaderSize {
+		return false
+	}
+	return domain.DataType(data[domain.HeaderSize]) == domain.DataTypeControl
+}
@@ -0,0 +1,231 @@
+package adapterudp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ErrServerClosed はClose/Shutdown後にServeが返すエラーです。
+var ErrServerClosed = errors.New("udp: server closed")
+
+// HandleFunc は確立した接続ごとに呼ばれ、接続が終了するまでブロックします。
+type HandleFunc func(ctx context.Context, transport domain.Transport, remoteAddr string)
+
+// Server はUDP上でプロトコルを提供するサーバーです。
+// cookieハンドシェイクで送信元アドレスを確認した後に接続状態を確保し、
+// 以降は送信元アドレスで接続を識別します。
+type Server struct {
+	addr   string
+	opts   Options
+	handle HandleFunc
+	secret []byte
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	pc         net.PacketConn
+	conns      map[string]*udpTransport
+	nextConnID uint32
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+func NewServer(addr string, opts Options, handle HandleFunc) *Server {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		addr:   addr,
+		opts:   opts,
+		handle: handle,
+		secret: secret,
+		ctx:    ctx,
+		cancel: cancel,
+		conns:  make(map[string]*udpTransport),
+	}
+}
+
+// Serve はaddrで待ち受けを開始し、Close/Shutdownまでパケットを処理します。
+func (s *Server) Serve() error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServePacketConn(pc)
+}
+
+// ServePacketConn は指定したPacketConnでパケットを処理します。
+func (s *Server) ServePacketConn(pc net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = pc.Close()
+		return ErrServerClosed
+	}
+	s.pc = pc
+	s.mu.Unlock()
+
+	buf := make([]byte, s.opts.MTU)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		s.handlePacket(pc, addr, buf[:n])
+	}
+}
+
+func (s *Server) handlePacket(pc net.PacketConn, addr net.Addr, b []byte) {
+	typ := packetType(b[0])
+	key := addr.String()
+
+	if typ == packetHello {
+		s.handleHello(pc, addr, b)
+		return
+	}
+
+	connID, err := parseConnID(b)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	t, ok := s.conns[key]
+	s.mu.Unlock()
+	if !ok || t.connID != connID {
+		return
+	}
+	t.handlePacket(typ, b)
+}
+
+// handleHello はcookieハンドシェイクを処理します。
+// cookieを持たないhelloにはcookieを返すだけで、接続状態は確保しません。
+func (s *Server) handleHello(pc net.PacketConn, addr net.Addr, b []byte) {
+	cookie, err := parseHello(b)
+	if err != nil {
+		return
+	}
+	key := addr.String()
+	now := time.Now()
+	if cookie == ([cookieSize]byte{}) || !verifyCookie(s.secret, key, cookie, now) {
+		fresh := cookieFor(s.secret, key, now.UnixNano()/int64(cookieWindow))
+		_, _ = pc.WriteTo(encodeCookie(fresh), addr)
+		return
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	t, ok := s.conns[key]
+	if !ok {
+		s.nextConnID++
+		connID := s.nextConnID
+		t = newTransport(connID, s.opts, func(p []byte) error {
+			_, err := pc.WriteTo(p, addr)
+			return err
+		}, func() { s.removeConn(key, connID) })
+		s.conns[key] = t
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(s.ctx, t, key)
+		}()
+	}
+	s.mu.Unlock()
+
+	// welcomeが失われた場合はクライアントがhelloを再送するため、既存接続でも応答する
+	_, _ = pc.WriteTo(encodeConnPacket(packetWelcome, t.connID, nil), addr)
+	if !ok {
+		slog.Debug("udp: connection established", "remoteAddr", key, "connID", t.connID)
+	}
+}
+
+func (s *Server) removeConn(key string, connID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.conns[key]; ok && t.connID == connID {
+		delete(s.conns, key)
+	}
+}
+
+// Shutdown は新規接続の受け付けを止め、処理中の接続が終了するのを待ちます。
+// ソケットは全接続の終了後に閉じられます。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return s.closePacketConn()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close は全接続を閉じ、ソケットを閉じます。
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := make([]*udpTransport, 0, len(s.conns))
+	for _, t := range s.conns {
+		conns = append(conns, t)
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	for _, t := range conns {
+		_ = t.Close(1000, "")
+	}
+	return s.closePacketConn()
+}
+
+// Addr は待ち受け中のアドレスを返します。待ち受け前は設定値を返します。
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pc != nil {
+		return s.pc.LocalAddr().String()
+	}
+	return s.addr
+}
+
+func (s *Server) closePacketConn() error {
+	s.mu.Lock()
+	pc := s.pc
+	s.mu.Unlock()
+	if pc == nil {
+		return nil
+	}
+	return pc.Close()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,388 @@
+package adapterudp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+var (
+	// ErrPacketTooLarge はフレームがMaxFrameSizeを超える場合に返されるエラーです。
+	ErrPacketTooLarge = errors.New("udp: frame exceeds max frame size")
+	// ErrPeerUnreachable は信頼チャネルの再送が上限に達した場合に返されるエラーです。
+	ErrPeerUnreachable = errors.New("udp: peer unreachable")
+	// ErrTransportClosed はClose済みのTransportを操作した場合に返されるエラーです。
+	ErrTransportClosed = fmt.Errorf("udp: %w", domain.ErrTransportClosed)
+)
+
+// Options はUDPトランスポートの設定です。
+type Options struct {
+	// MTU は1パケットの最大サイズ（UDPペイロード）です。
+	// 経路上でのIPフラグメントを避けるため、デフォルトはIPv6最小MTUに収まる1200です。
+	MTU int
+	// ReliableControl はControlメッセージを信頼チャネル（再送・順序保証あり）で送ります。
+	ReliableControl bool
+	// RetransmitInterval は信頼チャネルで未ACKのパケットを再送するまでの時間です。
+	RetransmitInterval time.Duration
+	// MaxRetransmits は信頼チャネルの再送回数の上限です。超えると接続を閉じます。
+	MaxRetransmits int
+	// ReliableWindow は信頼チャネルで同時に未ACKにできるパケット数です。
+	ReliableWindow int
+	// InboxSize は受信済みで未読のパケットを保持する数です。超えた非信頼パケットは破棄されます。
+	InboxSize int
+	// MaxFrameSize は送受信できるフレームの最大サイズです。
+	// 1パケットに収まらないフレームは種別にかかわらず信頼チャネルで分割して送ります。
+	MaxFrameSize int
+	// HandshakeTimeout はDial時のハンドシェイク1回あたりの待ち時間です。
+	HandshakeTimeout time.Duration
+	// HandshakeRetries はDial時のハンドシェイクの試行回数です。
+	HandshakeRetries int
+}
+
+// DefaultOptions はリアルタイム通信向けのデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		MTU:                1200,
+		ReliableControl:    true,
+		RetransmitInterval: 100 * time.Millisecond,
+		MaxRetransmits:     20,
+		ReliableWindow:     64,
+		InboxSize:          256,
+		MaxFrameSize:       64 << 10,
+		HandshakeTimeout:   500 * time.Millisecond,
+		HandshakeRetries:   5,
+	}
+}
+
+// MaxPayloadSize は1パケットに載せられる最大サイズを返します。これを超えるフレームは分割して送ります。
+func (o Options) MaxPayloadSize() int {
+	return o.MTU - reliableHeaderSize
+}
+
+type pendingPacket struct {
+	packet   []byte
+	sentAt   time.Time
+	attempts int
+}
+
+// udpTransport はUDP上の1接続を表すdomain.Transport実装です。
+// パケットの受信はServer（またはDialした接続の受信goroutine）がhandlePacketで供給します。
+type udpTransport struct {
+	connID uint32
+	opts   Options
+	send   func([]byte) error
+	// onClose はClose時に一度だけ呼ばれる（Serverの接続表からの削除等）
+	onClose func()
+
+	inbox chan []byte
+
+	// 信頼チャネル（送信側）
+	// reliableMu は分割したフレームの断片が他のフレームと混ざらないよう、信頼チャネルへの書き込みを直列化する
+	reliableMu sync.Mutex
+	sendMu     sync.Mutex
+	nextSeq    uint32
+	pending    map[uint32]*pendingPacket
+	window     chan struct{} // 未ACKパケット数のセマフォ
+	// 信頼チャネル（受信側）
+	recvMu      sync.Mutex
+	expectedSeq uint32
+	outOfOrder  map[uint32]reliablePacket
+	partial     []byte // 組み立て中の分割されたフレーム
+	ordered     [][]byte
+	orderedCh   chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+func newTransport(connID uint32, opts Options, send func([]byte) error, onClose func()) *udpTransport {
+	t := &udpTransport{
+		connID:      connID,
+		opts:        opts,
+		send:        send,
+		onClose:     onClose,
+		inbox:       make(chan []byte, opts.InboxSize),
+		nextSeq:     1,
+		pending:     make(map[uint32]*pendingPacket),
+		window:      make(chan struct{}, opts.ReliableWindow),
+		expectedSeq: 1,
+		outOfOrder:  make(map[uint32]reliablePacket),
+		orderedCh:   make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+	go t.retransmitLoop()
+	return t
+}
+
+func (t *udpTransport) Read(ctx context.Context) ([]byte, error) {
+	for {
+		if data, ok := t.popOrdered(); ok {
+			return data, nil
+		}
+		select {
+		case data := <-t.inbox:
+			return data, nil
+		case <-t.orderedCh:
+			continue
+		case <-t.closed:
+			// 閉じる前に届いていたデータは読み切る
+			if data, ok := t.popOrdered(); ok {
+				return data, nil
+			}
+			select {
+			case data := <-t.inbox:
+				return data, nil
+			default:
+			}
+			return nil, t.closeErr
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Write はフレームを送信します。
+// Controlメッセージは（ReliableControlが有効な場合）信頼チャネル、それ以外は非信頼チャネルで送ります。
+// 1パケットに収まらないフレーム（多人数のActorなど）は信頼チャネルで分割して送ります。
+func (t *udpTransport) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	if len(data) > t.opts.MaxFrameSize {
+		return ErrPacketTooLarge
+	}
+	if len(data) > t.opts.MaxPayloadSize() {
+		return t.writeFragmented(ctx, data)
+	}
+	if t.opts.ReliableControl && isReliableFrame(data) {
+		t.reliableMu.Lock()
+		defer t.reliableMu.Unlock()
+		return t.writeReliable(ctx, packetReliable, data)
+	}
+	return t.send(encodeConnPacket(packetData, t.connID, data))
+}
+
+// writeFragmented はフレームをMaxPayloadSizeごとに分割し、信頼チャネルで順に送ります。
+// 最後の断片以外はpacketFragmentで送り、受信側は最後の断片（packetReliable）を受け取った時点で組み立てます。
+func (t *udpTransport) writeFragmented(ctx context.Context, data []byte) error {
+	t.reliableMu.Lock()
+	defer t.reliableMu.Unlock()
+	size := t.opts.MaxPayloadSize()
+	for len(data) > size {
+		if err := t.writeReliable(ctx, packetFragment, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return t.writeReliable(ctx, packetReliable, data)
+}
+
+// writeReliable は信頼チャネルでパケットを1つ送ります。reliableMuを取って呼び出します。
+func (t *udpTransport) writeReliable(ctx context.Context, typ packetType, data []byte) error {
+	// 未ACKパケットがwindowに達している場合はACKを待つ
+	select {
+	case t.window <- struct{}{}:
+	case <-t.closed:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.sendMu.Lock()
+	seq := t.nextSeq
+	t.nextSeq++
+	packet := encodeSeqPacket(typ, t.connID, seq, data)
+	t.pending[seq] = &pendingPacket{packet: packet, sentAt: time.Now(), attempts: 1}
+	t.sendMu.Unlock()
+
+	return t.send(packet)
+}
+
+// Close は相手にcloseパケットを送り、接続を閉じます。
+// UDPにはクローズコードを運ぶ仕組みがないため、codeとreasonは相手に送られません。
+func (t *udpTransport) Close(code int32, reason string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	// shutdownでソケットが閉じられる場合があるため、closeパケットを先に送る
+	err := t.send(encodeConnPacket(packetClose, t.connID, nil))
+	t.shutdown(ErrTransportClosed)
+	return err
+}
+
+// shutdown は接続を閉じた状態にします。初回の呼び出しのみtrueを返します。
+func (t *udpTransport) shutdown(err error) bool {
+	first := false
+	t.closeOnce.Do(func() {
+		first = true
+		t.closeErr = err
+		close(t.closed)
+		if t.onClose != nil {
+			t.onClose()
+		}
+	})
+	return first
+}
+
+// handlePacket は受信したパケットを処理します。呼び出し元をブロックしません。
+func (t *udpTransport) handlePacket(typ packetType, b []byte) {
+	switch typ {
+	case packetData:
+		payload := copyPayload(b[connHeaderSize:])
+		select {
+		case t.inbox <- payload:
+		default:
+			// 非信頼チャネルは読み手が遅い場合に破棄する
+		}
+	case packetReliable, packetFragment:
+		seq, err := parseSeq(b)
+		if err != nil {
+			return
+		}
+		ack, err := t.receiveReliable(seq, reliablePacket{data: copyPayload(b[reliableHeaderSize:]), more: typ == packetFragment})
+		if err != nil {
+			t.shutdown(err)
+			return
+		}
+		if ack {
+			_ = t.send(encodeSeqPacket(packetAck, t.connID, seq, nil))
+		}
+	case packetAck:
+		seq, err := parseSeq(b)
+		if err != nil {
+			return
+		}
+		t.sendMu.Lock()
+		if _, ok := t.pending[seq]; ok {
+			delete(t.pending, seq)
+			<-t.window
+		}
+		t.sendMu.Unlock()
+	case packetClose:
+		t.shutdown(io.EOF)
+	}
+}
+
+// reliablePacket は信頼チャネルで受信したパケットです。moreは後に続く断片があることを表します。
+type reliablePacket struct {
+	data []byte
+	more bool
+}
+
+// receiveReliable は信頼チャネルのパケットを順序通りに並べ、分割されたフレームを組み立てます。
+// ACKを返すべき場合にtrueを返します（受信キューが満杯の場合はACKせず再送を待つ）。
+// 組み立て中のフレームがMaxFrameSizeを超えた場合はErrPacketTooLargeを返します。
+func (t *udpTransport) receiveReliable(seq uint32, packet reliablePacket) (bool, error) {
+	t.recvMu.Lock()
+	defer t.recvMu.Unlock()
+
+	switch {
+	case seq < t.expectedSeq:
+		// 重複（ACKが失われた）: 再度ACKする
+		return true, nil
+	case seq >= t.expectedSeq+uint32(t.opts.ReliableWindow):
+		return false, nil
+	case len(t.ordered)+len(t.outOfOrder) >= t.opts.InboxSize:
+		return false, nil
+	}
+
+	t.outOfOrder[seq] = packet
+	delivered := false
+	for {
+		p, ok := t.outOfOrder[t.expectedSeq]
+		if !ok {
+			break
+		}
+		delete(t.outOfOrder, t.expectedSeq)
+		t.expectedSeq++
+		if p.more || t.partial != nil {
+			t.partial = append(t.partial, p.data...)
+			if len(t.partial) > t.opts.MaxFrameSize {
+				return false, ErrPacketTooLarge
+			}
+			if p.more {
+				continue
+			}
+			p.data, t.partial = t.partial, nil
+		}
+		t.ordered = append(t.ordered, p.data)
+		delivered = true
+	}
+	if delivered {
+		select {
+		case t.orderedCh <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (t *udpTransport) popOrdered() ([]byte, bool) {
+	t.recvMu.Lock()
+	defer t.recvMu.Unlock()
+	if len(t.ordered) == 0 {
+		return nil, false
+	}
+	data := t.ordered[0]
+	t.ordered[0] = nil
+	t.ordered = t.ordered[1:]
+	return data, true
+}
+
+// retransmitLoop は未ACKのパケットを定期的に再送します。
+func (t *udpTransport) retransmitLoop() {
+	ticker := time.NewTicker(t.opts.RetransmitInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case now := <-ticker.C:
+			if !t.retransmit(now) {
+				t.shutdown(ErrPeerUnreachable)
+				return
+			}
+		}
+	}
+}
+
+// retransmit は再送時刻を過ぎたパケットを再送します。再送上限を超えた場合はfalseを返します。
+func (t *udpTransport) retransmit(now time.Time) bool {
+	t.sendMu.Lock()
+	var resend [][]byte
+	for _, p := range t.pending {
+		if now.Sub(p.sentAt) < t.opts.RetransmitInterval {
+			continue
+		}
+		if p.attempts > t.opts.MaxRetransmits {
+			t.sendMu.Unlock()
+			return false
+		}
+		p.attempts++
+		p.sentAt = now
+		resend = append(resend, p.packet)
+	}
+	t.sendMu.Unlock()
+
+	for _, packet := range resend {
+		_ = t.send(packet)
+	}
+	return true
+}
+
+// copyPayload は受信バッファを再利用できるようにペイロードを複製します。
+func copyPayload(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
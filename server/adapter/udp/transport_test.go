@@ -0,0 +1,305 @@
+package adapterudp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+)
+
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.RetransmitInterval = 10 * time.Millisecond
+	opts.MaxRetransmits = 100
+	opts.HandshakeTimeout = 100 * time.Millisecond
+	return opts
+}
+
+// controlFrame はseqを埋め込んだControlメッセージを作成します。
+func controlFrame(seq uint16) []byte {
+	header := domain.Header{Version: 1, Seq: seq, Length: domain.PayloadHeaderSize}
+	payloadHeader := domain.PayloadHeader{DataType: domain.DataTypeControl, SubType: uint8(domain.ControlSubTypePing)}
+	return append(header.Encode(), payloadHeader.Encode()...)
+}
+
+func inputFrame() []byte {
+	header := domain.Header{Version: 1, Length: domain.PayloadHeaderSize + domain.InputPayloadSize}
+	payloadHeader := domain.PayloadHeader{DataType: domain.DataTypeInput}
+	input := domain.InputPayload{KeyMask: 1}
+	data := append(header.Encode(), payloadHeader.Encode()...)
+	return append(data, input.Encode()...)
+}
+
+// newLossyPair はソケットを使わず、指定した確率でパケットを落とすTransportの組を作成します。
+func newLossyPair(t *testing.T, lossRate float64) (*udpTransport, *udpTransport) {
+	t.Helper()
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(1))
+	drop := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64() < lossRate
+	}
+
+	var a, b *udpTransport
+	deliver := func(to **udpTransport) func([]byte) error {
+		return func(p []byte) error {
+			if drop() {
+				return nil
+			}
+			pkt := copyPayload(p)
+			go (*to).handlePacket(packetType(pkt[0]), pkt)
+			return nil
+		}
+	}
+	a = newTransport(1, testOptions(), deliver(&b), nil)
+	b = newTransport(1, testOptions(), deliver(&a), nil)
+	t.Cleanup(func() {
+		a.shutdown(ErrTransportClosed)
+		b.shutdown(ErrTransportClosed)
+	})
+	return a, b
+}
+
+func TestTransport_ReliableControlInOrderUnderLoss(t *testing.T) {
+	a, b := newLossyPair(t, 0.3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 50
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := a.Write(ctx, controlFrame(uint16(i))); err != nil {
+				t.Errorf("Write failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		data, err := b.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read[%d] failed: %v", i, err)
+		}
+		header, err := domain.ParseHeader(data)
+		if err != nil {
+			t.Fatalf("ParseHeader failed: %v", err)
+		}
+		if header.Seq != uint16(i) {
+			t.Fatalf("Read[%d] seq = %d, want %d", i, header.Seq, i)
+		}
+	}
+}
+
+func TestTransport_PeerUnreachable(t *testing.T) {
+	a, _ := newLossyPair(t, 1.0)
+	a.opts.MaxRetransmits = 2
+
+	if err := a.Write(context.Background(), controlFrame(0)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := a.Read(ctx); !errors.Is(err, ErrPeerUnreachable) {
+		t.Errorf("Read error = %v, want %v", err, ErrPeerUnreachable)
+	}
+}
+
+// TestTransport_FragmentedFrameUnderLoss はMTUを超えるフレームが分割して送られ、
+// ロスがあっても元のフレームとして順序通りに届くことを確認します。
+func TestTransport_FragmentedFrameUnderLoss(t *testing.T) {
+	a, b := newLossyPair(t, 0.2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 100人分のActorに相当する大きさのフレームとControlメッセージを交互に送る
+	large := make([][]byte, 5)
+	for i := range large {
+		large[i] = make([]byte, 25+4+24*100+i)
+		rand.New(rand.NewSource(int64(i))).Read(large[i])
+		if len(large[i]) <= a.opts.MaxPayloadSize() {
+			t.Fatalf("frame size %d fits in one packet", len(large[i]))
+		}
+	}
+	go func() {
+		for i, data := range large {
+			if err := a.Write(ctx, data); err != nil {
+				t.Errorf("Write large failed: %v", err)
+				return
+			}
+			if err := a.Write(ctx, controlFrame(uint16(i))); err != nil {
+				t.Errorf("Write control failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i, want := range large {
+		data, err := b.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read large[%d] failed: %v", i, err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Fatalf("large[%d] = %d bytes, want %d bytes", i, len(data), len(want))
+		}
+		data, err = b.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read control[%d] failed: %v", i, err)
+		}
+		if header, _ := domain.ParseHeader(data); header.Seq != uint16(i) {
+			t.Fatalf("control[%d] seq = %d", i, header.Seq)
+		}
+	}
+}
+
+func TestTransport_PacketTooLarge(t *testing.T) {
+	a, _ := newLossyPair(t, 0)
+
+	data := make([]byte, a.opts.MaxFrameSize+1)
+	if err := a.Write(context.Background(), data); !errors.Is(err, ErrPacketTooLarge) {
+		t.Errorf("Write error = %v, want %v", err, ErrPacketTooLarge)
+	}
+}
+
+func TestVerifyCookie(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	window := now.UnixNano() / int64(cookieWindow)
+
+	if !verifyCookie(secret, "127.0.0.1:1000", cookieFor(secret, "127.0.0.1:1000", window), now) {
+		t.Error("current cookie rejected")
+	}
+	if !verifyCookie(secret, "127.0.0.1:1000", cookieFor(secret, "127.0.0.1:1000", window-1), now) {
+		t.Error("previous window cookie rejected")
+	}
+	if verifyCookie(secret, "127.0.0.1:1000", cookieFor(secret, "127.0.0.1:1000", window-2), now) {
+		t.Error("expired cookie accepted")
+	}
+	if verifyCookie(secret, "127.0.0.1:2000", cookieFor(secret, "127.0.0.1:1000", window), now) {
+		t.Error("cookie for another address accepted")
+	}
+}
+
+// TestServer_Loopback はループバック上でハンドシェイクし、両チャネルで送受信できることを確認します。
+func TestServer_Loopback(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	// 受信したフレームをそのまま返す
+	srv := NewServer("", testOptions(), func(ctx context.Context, tr domain.Transport, remoteAddr string) {
+		for {
+			data, err := tr.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := tr.Write(ctx, data); err != nil {
+				return
+			}
+		}
+	})
+	served := make(chan error, 1)
+	go func() { served <- srv.ServePacketConn(pc) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, pc.LocalAddr().String(), testOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	for _, frame := range [][]byte{controlFrame(7), inputFrame()} {
+		if err := client.Write(ctx, frame); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		got, err := client.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if !bytes.Equal(got, frame) {
+			t.Errorf("Read = %v, want %v", got, frame)
+		}
+	}
+
+	// クライアントが閉じるとサーバー側のハンドラも終了する
+	client.Close(1000, "")
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServer_HelloWithoutCookieAllocatesNothing(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	srv := NewServer("", testOptions(), func(ctx context.Context, tr domain.Transport, remoteAddr string) {})
+	go srv.ServePacketConn(pc)
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(encodeHello([cookieSize]byte{1, 2, 3})); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if packetType(buf[0]) != packetCookie || n != 1+cookieSize {
+		t.Errorf("reply = %v, want cookie packet", buf[:n])
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.conns) != 0 {
+		t.Errorf("conns = %d, want 0", len(srv.conns))
+	}
+}
+
+// TestDial_CookieLoopFails はcookieを返し続けるサーバーに対して、ctxに期限がなくてもDialが失敗することを確認します。
+func TestDial_CookieLoopFails(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for i := byte(0); ; i++ {
+			_, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(encodeCookie([cookieSize]byte{i}), addr)
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Dial(context.Background(), pc.LocalAddr().String(), testOptions())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrHandshakeFailed) {
+			t.Errorf("Dial = %v, want %v", err, ErrHandshakeFailed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial did not give up")
+	}
+}
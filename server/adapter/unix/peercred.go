@@ -0,0 +1,49 @@
+package adapterunix
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"withered/server/domain"
+)
+
+// ErrPeerCredUnsupported はこのOSでピア資格情報を取得できない場合に返されるエラーです。
+var ErrPeerCredUnsupported = errors.New("unix: peer credentials not supported")
+
+// PeerCred は接続元プロセスの資格情報（SO_PEERCRED）です。
+// 値はカーネルが接続時に記録したもので、接続元が偽ることはできません。
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (c PeerCred) String() string {
+	return fmt.Sprintf("pid=%d,uid=%d,gid=%d", c.PID, c.UID, c.GID)
+}
+
+// TrustPolicy はピア資格情報から接続の識別情報を決めるポリシーです。
+type TrustPolicy struct {
+	// SuperUserUIDs はスーパーユーザーのロールを与えるUIDです。
+	SuperUserUIDs []uint32
+	// MultiplexUIDs は多重化接続のロールを与えるUIDです。
+	MultiplexUIDs []uint32
+}
+
+// Identity はピア資格情報に対応する識別情報を返します。
+// 資格情報を取得できなかった接続（ok=false）は匿名として扱います。
+func (p TrustPolicy) Identity(cred PeerCred, ok bool) domain.Identity {
+	if !ok {
+		return domain.Identity{}
+	}
+	identity := domain.Identity{UserID: "uid:" + strconv.FormatUint(uint64(cred.UID), 10)}
+	if slices.Contains(p.SuperUserUIDs, cred.UID) {
+		identity.Roles = append(identity.Roles, domain.RoleSuperUser)
+	}
+	if slices.Contains(p.MultiplexUIDs, cred.UID) {
+		identity.Roles = append(identity.Roles, domain.RoleMultiplex)
+	}
+	return identity
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package adapterunix
+
+import "net"
+
+// peerCred はSO_PEERCREDのないOSでは常にErrPeerCredUnsupportedを返します。
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, ErrPeerCredUnsupported
+}
@@ -0,0 +1,206 @@
+package adapterunix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	adaptertcp "withered/server/adapter/tcp"
+	"withered/server/domain"
+)
+
+var (
+	// ErrServerClosed はClose/Shutdown後にServeが返すエラーです。
+	ErrServerClosed = errors.New("unix: server closed")
+	// ErrSocketInUse はソケットのパスで別のサーバーが待ち受けている場合に返されるエラーです。
+	ErrSocketInUse = errors.New("unix: socket already in use")
+)
+
+// HandleFunc は受け付けた接続ごとに呼ばれ、接続が終了するまでブロックします。
+// credはピア資格情報を取得できた場合のみ有効です（ok=true）。
+type HandleFunc func(ctx context.Context, transport domain.Transport, cred PeerCred, ok bool)
+
+// Options はUnixドメインソケットサーバーの設定です。
+type Options struct {
+	// Transport はフレームの送受信の設定です。TCPと同じ長さプレフィックス付きフレームを使います。
+	Transport adaptertcp.Options
+	// Mode はソケットファイルのパーミッションです。接続できるユーザーをファイルシステムの権限で制限します。
+	Mode fs.FileMode
+}
+
+// DefaultOptions は同一ホストのプロセス向けのデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		Transport: adaptertcp.DefaultOptions(),
+		Mode:      0o660,
+	}
+}
+
+// Server は長さプレフィックス付きフレームでプロトコルを提供するUnixドメインソケットサーバーです。
+// 同じホストで動くボットやサイドカーが、ループバックのTCP/WebSocketを経由せずに接続するために使用します。
+type Server struct {
+	path   string
+	opts   Options
+	handle HandleFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	ln     *net.UnixListener
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer(path string, opts Options, handle HandleFunc) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		path:   path,
+		opts:   opts,
+		handle: handle,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Serve はpathで待ち受けを開始し、Close/Shutdownまで接続を受け付けます。
+// 前回の異常終了で残ったソケットファイルは削除してから待ち受けます。
+func (s *Server) Serve() error {
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: s.path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if s.opts.Mode != 0 {
+		if err := os.Chmod(s.path, s.opts.Mode); err != nil {
+			_ = ln.Close()
+			return err
+		}
+	}
+	return s.ServeListener(ln)
+}
+
+// ServeListener は指定したリスナーで接続を受け付けます。
+func (s *Server) ServeListener(ln *net.UnixListener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				slog.Warn("unix: accept error, retrying", "err", err)
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		cred, err := peerCred(conn)
+		if err != nil && !errors.Is(err, ErrPeerCredUnsupported) {
+			slog.Warn("unix: failed to get peer credentials", "err", err)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(s.ctx, adaptertcp.NewTransportFrom(conn, s.opts.Transport), cred, err == nil)
+		}()
+	}
+}
+
+// Shutdown は新規接続の受け付けを止め、処理中の接続が終了するのを待ちます。
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.closeListener(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close は新規接続の受け付けを止め、処理中の接続のコンテキストをキャンセルします。
+func (s *Server) Close() error {
+	err := s.closeListener()
+	s.cancel()
+	return err
+}
+
+// Addr は待ち受けるソケットのパスを返します。
+func (s *Server) Addr() string {
+	return s.path
+}
+
+func (s *Server) closeListener() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.ln != nil {
+		// UnixListenerはClose時にソケットファイルを削除する
+		return s.ln.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// removeStaleSocket はpathに残ったソケットファイルを削除します。
+// 接続できる場合は稼働中のサーバーがいるためErrSocketInUseを返します。
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("unix: %s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, 100*time.Millisecond); err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+	return os.Remove(path)
+}
+
+// Dial はUnixドメインソケットのサーバーに接続し、Transportを返します。
+func Dial(ctx context.Context, path string, opts adaptertcp.Options) (domain.Transport, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return adaptertcp.NewTransportFrom(conn, opts), nil
+}
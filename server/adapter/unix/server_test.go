@@ -0,0 +1,143 @@
+package adapterunix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	adaptertcp "withered/server/adapter/tcp"
+	"withered/server/domain"
+)
+
+type accepted struct {
+	cred PeerCred
+	ok   bool
+}
+
+// startServer はエコーするサーバーを起動し、受け付けた接続の資格情報を返すチャネルを返します。
+func startServer(t *testing.T, path string) (*Server, <-chan accepted) {
+	t.Helper()
+	ch := make(chan accepted, 1)
+	s := NewServer(path, DefaultOptions(), func(ctx context.Context, transport domain.Transport, cred PeerCred, ok bool) {
+		ch <- accepted{cred: cred, ok: ok}
+		for {
+			data, err := transport.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := transport.Write(ctx, data); err != nil {
+				return
+			}
+		}
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve() }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-errCh; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve error = %v, want %v", err, ErrServerClosed)
+		}
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		listening := s.ln != nil
+		s.mu.Unlock()
+		if listening {
+			return s, ch
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServer_EchoAndPeerCred(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "withered.sock")
+	_, acceptedCh := startServer(t, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	transport, err := Dial(ctx, path, adaptertcp.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer transport.Close(1000, "")
+
+	if err := transport.Write(ctx, []byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := transport.Read(ctx)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Read = (%q, %v), want hello", data, err)
+	}
+
+	a := <-acceptedCh
+	if runtime.GOOS != "linux" {
+		if a.ok {
+			t.Errorf("peer credentials available on %s", runtime.GOOS)
+		}
+		return
+	}
+	if !a.ok {
+		t.Fatal("peer credentials not available")
+	}
+	if a.cred.PID != int32(os.Getpid()) || a.cred.UID != uint32(os.Getuid()) || a.cred.GID != uint32(os.Getgid()) {
+		t.Errorf("cred = %v, want pid=%d uid=%d gid=%d", a.cred, os.Getpid(), os.Getuid(), os.Getgid())
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != DefaultOptions().Mode {
+		t.Errorf("socket mode = %v, want %v", mode, DefaultOptions().Mode)
+	}
+}
+
+func TestServer_SocketFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// 異常終了で残ったソケットファイルは削除して待ち受ける
+	stale := filepath.Join(dir, "stale.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+	startServer(t, stale)
+
+	// 稼働中のサーバーがいるパスでは待ち受けない
+	if err := NewServer(stale, DefaultOptions(), nil).Serve(); !errors.Is(err, ErrSocketInUse) {
+		t.Errorf("Serve error = %v, want %v", err, ErrSocketInUse)
+	}
+
+	// ソケットでないファイルは削除しない
+	regular := filepath.Join(dir, "regular")
+	os.WriteFile(regular, nil, 0o600)
+	if err := NewServer(regular, DefaultOptions(), nil).Serve(); err == nil {
+		t.Error("Serve succeeded on a regular file")
+	}
+}
+
+func TestTrustPolicy_Identity(t *testing.T) {
+	policy := TrustPolicy{SuperUserUIDs: []uint32{1001}}
+
+	if id := policy.Identity(PeerCred{UID: 1001}, true); id.UserID != "uid:1001" || !id.HasRole(domain.RoleSuperUser) {
+		t.Errorf("Identity(1001) = %+v, want superuser", id)
+	}
+	if id := policy.Identity(PeerCred{UID: 1000}, true); id.UserID != "uid:1000" || id.HasRole(domain.RoleSuperUser) {
+		t.Errorf("Identity(1000) = %+v, want plain user", id)
+	}
+	if id := policy.Identity(PeerCred{UID: 1001}, false); !id.IsAnonymous() {
+		t.Errorf("Identity without credentials = %+v, want anonymous", id)
+	}
+}
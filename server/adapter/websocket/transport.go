@@ -2,11 +2,18 @@ package adapterwebsocker
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/coder/websocket"
 	"withered/server/domain"
 )
 
+// Subprotocol はWebSocketアップグレード時にネゴシエートするサブプロトコル名です。
+const Subprotocol = "withered.v1"
+
+// MultiplexSubprotocol は1接続で複数のセッションを多重化する場合のサブプロトコル名です。
+const MultiplexSubprotocol = "withered.mux.v1"
+
 type wsTransport struct {
 	conn *websocket.Conn
 }
@@ -18,15 +25,24 @@ func NewTransportFrom(conn *websocket.Conn) domain.Transport {
 func (t *wsTransport) Read(ctx context.Context) ([]byte, error) {
 	_, data, err := t.conn.Read(ctx)
 	if err != nil {
-		return nil, err
+		return nil, closedError(err)
 	}
 	return data, nil
 }
 
 func (t *wsTransport) Write(ctx context.Context, data []byte) error {
-	return t.conn.Write(ctx, websocket.MessageBinary, data)
+	return closedError(t.conn.Write(ctx, websocket.MessageBinary, data))
 }
 
 func (t *wsTransport) Close(code int32, reason string) error {
 	return t.conn.Close(websocket.StatusCode(code), reason)
 }
+
+// closedError は接続のクローズによるエラーをdomain.ErrTransportClosedでラップします。
+// クローズコードはwebsocket.CloseStatusで引き続き取り出せます。
+func closedError(err error) error {
+	if err != nil && websocket.CloseStatus(err) != -1 {
+		return fmt.Errorf("%w: %w", domain.ErrTransportClosed, err)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package adapterwebtransport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+)
+
+// SelfSignedValidity は自己署名証明書の有効期間です。
+// ブラウザのserverCertificateHashesは有効期間が14日以内の証明書のみ受け付けます。
+const SelfSignedValidity = 10 * 24 * time.Hour
+
+// GenerateSelfSigned はローカル開発・テスト用の自己署名証明書（ECDSA P-256）を生成します。
+// hostsにはDNS名またはIPアドレスを指定します。
+// ブラウザからはCertificateHashの値をserverCertificateHashesに指定して接続します。
+func GenerateSelfSigned(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "withered"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(SelfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// CertificateHash は証明書のSHA-256ハッシュを返します。
+// ブラウザのWebTransportのserverCertificateHashesに指定する値です。
+func CertificateHash(cert tls.Certificate) [32]byte {
+	return sha256.Sum256(cert.Certificate[0])
+}
@@ -0,0 +1,35 @@
+package adapterwebtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/quic-go/webtransport-go"
+	"withered/server/domain"
+)
+
+// Dial はWebTransportサーバーに接続し、制御ストリームを開いてTransportを返します。
+// urlは "https://host:port/path" 形式です。headerは認証等のためにCONNECTリクエストに付与されます。
+func Dial(ctx context.Context, url string, tlsConfig *tls.Config, header http.Header, opts Options) (domain.Transport, error) {
+	d := &webtransport.Dialer{TLSClientConfig: tlsConfig}
+	rsp, sess, err := d.Dial(ctx, url, header)
+	if err != nil {
+		_ = d.Close()
+		if rsp != nil {
+			return nil, fmt.Errorf("webtransport: handshake rejected (%s): %w", rsp.Status, err)
+		}
+		return nil, err
+	}
+	control, err := sess.OpenStreamSync(ctx)
+	if err == nil {
+		_, err = control.Write([]byte{controlStreamVersion})
+	}
+	if err != nil {
+		_ = sess.CloseWithError(closeCodeProtocolError, "control stream handshake failed")
+		_ = d.Close()
+		return nil, err
+	}
+	return newTransport(sess, control, opts, func() { _ = d.Close() }), nil
+}
@@ -0,0 +1,199 @@
+package adapterwebtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"withered/server/domain"
+)
+
+var (
+	// ErrServerClosed はClose/Shutdown後にServeが返すエラーです。
+	ErrServerClosed = errors.New("webtransport: server closed")
+	// ErrOriginNotAllowed はリクエストのOriginが許可されていない場合に返されるエラーです。
+	ErrOriginNotAllowed = errors.New("webtransport: origin not allowed")
+)
+
+// closeCodeProtocolError は制御ストリームのハンドシェイクに失敗した場合のクローズコードです。
+const closeCodeProtocolError = 1002
+
+// Server はHTTP/3でWebTransportセッションを受け付けるサーバーです。
+// CONNECTリクエストはhandlerに渡され、handlerがUpgradeを呼び出してTransportを得ます。
+type Server struct {
+	addr string
+	opts ServerOptions
+	wt   *webtransport.Server
+
+	mu     sync.Mutex
+	pc     net.PacketConn
+	closed bool
+}
+
+// ServerOptions はServerの設定です。
+type ServerOptions struct {
+	Transport Options
+	// AllowedOrigins は許可するOriginのホストパターン（path.Match形式）です。
+	// 空の場合はリクエストのHostと同一のOriginのみ許可します。Originヘッダーのないリクエストは許可します。
+	AllowedOrigins []string
+}
+
+// DefaultServerOptions はデフォルトのServerOptionsを返します。
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{Transport: DefaultOptions()}
+}
+
+func NewServer(addr string, tlsConfig *tls.Config, opts ServerOptions, handler http.Handler) *Server {
+	s := &Server{addr: addr, opts: opts}
+	h3 := &http3.Server{
+		Addr:      addr,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+		Handler:   handler,
+		QUICConfig: &quic.Config{
+			EnableDatagrams:                  true,
+			EnableStreamResetPartialDelivery: true,
+		},
+	}
+	webtransport.ConfigureHTTP3Server(h3)
+	s.wt = &webtransport.Server{H3: h3, CheckOrigin: s.checkOrigin}
+	return s
+}
+
+// Serve はaddrのUDPで待ち受けを開始し、Close/Shutdownまでセッションを受け付けます。
+func (s *Server) Serve() error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServePacketConn(pc)
+}
+
+// ServePacketConn は指定したPacketConnでセッションを受け付けます。
+func (s *Server) ServePacketConn(pc net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = pc.Close()
+		return ErrServerClosed
+	}
+	s.pc = pc
+	s.mu.Unlock()
+
+	err := s.wt.Serve(pc)
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Upgrade はWebTransportのCONNECTリクエストを受け入れ、クライアントが制御ストリームを開くのを待ってTransportを返します。
+// 失敗した場合はエラーレスポンスを書き込むか、セッションを閉じた状態でエラーを返します。
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request) (domain.Transport, error) {
+	if !s.checkOrigin(r) {
+		http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return nil, ErrOriginNotAllowed
+	}
+	sess, err := s.wt.Upgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.opts.Transport.HandshakeTimeout)
+	defer cancel()
+	control, err := acceptControlStream(ctx, sess)
+	if err != nil {
+		_ = sess.CloseWithError(closeCodeProtocolError, "control stream handshake failed")
+		return nil, err
+	}
+	return newTransport(sess, control, s.opts.Transport, nil), nil
+}
+
+func acceptControlStream(ctx context.Context, sess *webtransport.Session) (*webtransport.Stream, error) {
+	control, err := sess.AcceptStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("webtransport: control stream not opened: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = control.SetReadDeadline(deadline)
+	}
+	var version [1]byte
+	if _, err := control.Read(version[:]); err != nil {
+		return nil, fmt.Errorf("webtransport: control stream not opened: %w", err)
+	}
+	_ = control.SetReadDeadline(time.Time{})
+	if version[0] != controlStreamVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	return control, nil
+}
+
+// Shutdown は新規セッションの受け付けを止め、HTTP/3接続が終了するのを待ちます。
+// WebTransportセッションは対象外のため、先にSessionRegistry等で閉じておく必要があります。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.markClosed()
+	err := s.wt.H3.Shutdown(ctx)
+	if closeErr := s.wt.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close は新規セッションの受け付けを止め、全ての接続を閉じます。
+func (s *Server) Close() error {
+	s.markClosed()
+	return s.wt.Close()
+}
+
+// Addr は待ち受け中のアドレスを返します。待ち受け前は設定値を返します。
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pc != nil {
+		return s.pc.LocalAddr().String()
+	}
+	return s.addr
+}
+
+func (s *Server) markClosed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if len(s.opts.AllowedOrigins) == 0 {
+		return host == strings.ToLower(r.Host)
+	}
+	for _, pattern := range s.opts.AllowedOrigins {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
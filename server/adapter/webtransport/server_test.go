@@ -0,0 +1,166 @@
+package adapterwebtransport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/webtransport-go"
+	"withered/server/domain"
+)
+
+func controlFrame(seq uint16) []byte {
+	header := domain.Header{Version: 1, Seq: seq, Length: domain.PayloadHeaderSize}
+	payloadHeader := domain.PayloadHeader{DataType: domain.DataTypeControl, SubType: uint8(domain.ControlSubTypePing)}
+	return append(header.Encode(), payloadHeader.Encode()...)
+}
+
+func inputFrame(payloadSize int) []byte {
+	header := domain.Header{Version: 1, Length: uint16(domain.PayloadHeaderSize + payloadSize)}
+	payloadHeader := domain.PayloadHeader{DataType: domain.DataTypeInput}
+	data := append(header.Encode(), payloadHeader.Encode()...)
+	return append(data, bytes.Repeat([]byte{0xab}, payloadSize)...)
+}
+
+// startServer は自己署名証明書でループバックに待ち受け、受け入れたTransportをhandleに渡すサーバーを起動します。
+func startServer(t *testing.T, opts ServerOptions, handle func(ctx context.Context, tr domain.Transport)) (*Server, string, *tls.Config) {
+	t.Helper()
+	cert, err := GenerateSelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatalf("GenerateSelfSigned failed: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	srv := NewServer("", &tls.Config{Certificates: []tls.Certificate{cert}}, opts, mux)
+	mux.HandleFunc("/wt", func(w http.ResponseWriter, r *http.Request) {
+		tr, err := srv.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		handle(r.Context(), tr)
+	})
+	served := make(chan error, 1)
+	go func() { served <- srv.ServePacketConn(pc) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve error = %v, want %v", err, ErrServerClosed)
+		}
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	return srv, "https://" + pc.LocalAddr().String() + "/wt", &tls.Config{RootCAs: roots}
+}
+
+func echo(ctx context.Context, tr domain.Transport) {
+	for {
+		data, err := tr.Read(ctx)
+		if err != nil {
+			return
+		}
+		if err := tr.Write(ctx, data); err != nil {
+			return
+		}
+	}
+}
+
+// TestServer_Loopback は制御ストリーム・データグラムの両方で送受信でき、
+// データグラムに収まらないフレームも届くことを確認します。
+func TestServer_Loopback(t *testing.T) {
+	_, url, clientTLS := startServer(t, DefaultServerOptions(), echo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, url, clientTLS, nil, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close(domain.CloseCodeNormal, "")
+
+	for _, frame := range [][]byte{controlFrame(7), inputFrame(domain.InputPayloadSize), inputFrame(4000)} {
+		// データグラムはループバックでも失われうるため、届くまで再送する
+		var got []byte
+		for got == nil {
+			if err := client.Write(ctx, frame); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			readCtx, cancelRead := context.WithTimeout(ctx, 200*time.Millisecond)
+			got, err = client.Read(readCtx)
+			cancelRead()
+			if err != nil && ctx.Err() != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+		}
+		if !bytes.Equal(got, frame) {
+			t.Errorf("Read = %d bytes, want %d bytes", len(got), len(frame))
+		}
+	}
+}
+
+// TestServer_CloseCodeReachesPeer はCloseのコードと理由が相手に届くことを確認します。
+func TestServer_CloseCodeReachesPeer(t *testing.T) {
+	_, url, clientTLS := startServer(t, DefaultServerOptions(), func(ctx context.Context, tr domain.Transport) {
+		tr.Close(4002, "kicked")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, url, clientTLS, nil, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	_, err = client.Read(ctx)
+	var sessErr *webtransport.SessionError
+	if !errors.As(err, &sessErr) {
+		t.Fatalf("Read error = %v, want *webtransport.SessionError", err)
+	}
+	if sessErr.ErrorCode != 4002 || sessErr.Message != "kicked" {
+		t.Errorf("close = (%d, %q), want (4002, %q)", sessErr.ErrorCode, sessErr.Message, "kicked")
+	}
+	if err := client.Write(ctx, controlFrame(1)); err == nil {
+		t.Error("Write after peer close succeeded")
+	}
+}
+
+func TestServer_RejectsOrigin(t *testing.T) {
+	opts := DefaultServerOptions()
+	opts.AllowedOrigins = []string{"game.example.com"}
+	_, url, clientTLS := startServer(t, opts, echo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	header := http.Header{"Origin": []string{"https://evil.example.com"}}
+	if _, err := Dial(ctx, url, clientTLS, header, DefaultOptions()); err == nil {
+		t.Fatal("Dial from disallowed origin succeeded")
+	}
+
+	header.Set("Origin", "https://game.example.com")
+	client, err := Dial(ctx, url, clientTLS, header, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial from allowed origin failed: %v", err)
+	}
+	client.Close(domain.CloseCodeNormal, "")
+}
+
+func TestIsReliableFrame(t *testing.T) {
+	if !isReliableFrame(controlFrame(1)) {
+		t.Error("control frame should use the control stream")
+	}
+	if isReliableFrame(inputFrame(domain.InputPayloadSize)) {
+		t.Error("input frame should use datagrams")
+	}
+	if !isReliableFrame([]byte{1, 2, 3}) {
+		t.Error("truncated frame should use the control stream")
+	}
+}
@@ -0,0 +1,270 @@
+// Package adapterwebtransport はHTTP/3のWebTransport上でプロトコルを提供します。
+//
+// 1つのWebTransportセッションは、クライアントが開く1本の双方向ストリーム（制御ストリーム）と
+// QUICのデータグラムを使い分けます。
+//   - Controlメッセージは制御ストリームで送ります（再送・順序保証あり）。
+//   - Input/Actor/Voiceはデータグラムで送ります（再送なし・ヘッドオブラインブロッキングなし）。
+//     データグラムに収まらないフレームは制御ストリームで送ります。
+//
+// 制御ストリームのフレームはTCPアダプタと同じく [length u32 (リトルエンディアン)] + [payload] です。
+// クライアントは制御ストリームを開いた直後にcontrolStreamVersionの1バイトを送ります。
+package adapterwebtransport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+	"withered/server/domain"
+)
+
+// controlStreamVersion は制御ストリームの先頭で送るバージョンです。
+const controlStreamVersion byte = 1
+
+const frameHeaderSize = 4
+
+var (
+	// ErrFrameTooLarge はフレーム長がMaxFrameSizeを超えた場合に返されるエラーです。
+	ErrFrameTooLarge = errors.New("webtransport: frame too large")
+	// ErrTransportClosed はClose済みのTransportを操作した場合に返されるエラーです。
+	ErrTransportClosed = fmt.Errorf("webtransport: %w", domain.ErrTransportClosed)
+	// ErrUnsupportedVersion は制御ストリームのバージョンが異なる場合に返されるエラーです。
+	ErrUnsupportedVersion = errors.New("webtransport: unsupported control stream version")
+)
+
+// Options はWebTransportトランスポートの設定です。
+type Options struct {
+	// HandshakeTimeout はセッション確立後、制御ストリームが開かれるまで待つ最大時間です。
+	HandshakeTimeout time.Duration
+	// WriteTimeout は制御ストリームへの1フレームの書き込みを待つ最大時間です。0の場合は無制限です。
+	WriteTimeout time.Duration
+	// MaxFrameSize は受け付けるフレームの最大サイズです。
+	MaxFrameSize int
+	// InboxSize は受信済みで未読のフレームを保持する数です。超えたデータグラムは破棄されます。
+	InboxSize int
+}
+
+// DefaultOptions はプロトコルに合わせたデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		HandshakeTimeout: 10 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		MaxFrameSize:     domain.MaxFrameSize,
+		InboxSize:        256,
+	}
+}
+
+// wtTransport はWebTransportの1セッションを表すdomain.Transport実装です。
+type wtTransport struct {
+	sess    *webtransport.Session
+	control *webtransport.Stream
+	opts    Options
+	// onClose はClose時に一度だけ呼ばれる（Dialした接続の後始末等）
+	onClose func()
+
+	inbox chan []byte
+
+	wmu sync.Mutex // 制御ストリームへの書き込みは複数goroutineから呼ばれうる
+
+	closeOnce sync.Once
+	done      chan struct{}
+	errMu     sync.Mutex
+	err       error
+}
+
+func newTransport(sess *webtransport.Session, control *webtransport.Stream, opts Options, onClose func()) *wtTransport {
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = domain.MaxFrameSize
+	}
+	if opts.InboxSize < 1 {
+		opts.InboxSize = DefaultOptions().InboxSize
+	}
+	t := &wtTransport{
+		sess:    sess,
+		control: control,
+		opts:    opts,
+		onClose: onClose,
+		inbox:   make(chan []byte, opts.InboxSize),
+		done:    make(chan struct{}),
+	}
+	go t.streamLoop()
+	go t.datagramLoop()
+	return t
+}
+
+func (t *wtTransport) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-t.inbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-t.inbox:
+		return data, nil
+	case <-t.done:
+		return nil, t.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Write はControlメッセージを制御ストリーム、それ以外をデータグラムで送ります。
+func (t *wtTransport) Write(ctx context.Context, data []byte) error {
+	if len(data) > t.opts.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	select {
+	case <-t.done:
+		return t.closeErr()
+	default:
+	}
+	if !isReliableFrame(data) {
+		err := t.sess.SendDatagram(data)
+		var tooLarge *quic.DatagramTooLargeError
+		if !errors.As(err, &tooLarge) {
+			return closedError(err)
+		}
+	}
+	return t.writeStream(ctx, data)
+}
+
+func (t *wtTransport) writeStream(ctx context.Context, data []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = t.control.SetWriteDeadline(time.Now())
+	})
+	defer stop()
+	if err := t.control.SetWriteDeadline(deadline(t.opts.WriteTimeout)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, frameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[frameHeaderSize:], data)
+	if _, err := t.control.Write(buf); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return closedError(err)
+	}
+	return nil
+}
+
+// Close はセッションを閉じます。codeとreasonはWebTransportのセッションクローズで相手に送られます。
+func (t *wtTransport) Close(code int32, reason string) error {
+	if !t.shutdown(ErrTransportClosed) {
+		return ErrTransportClosed
+	}
+	return t.sess.CloseWithError(webtransport.SessionErrorCode(code), reason)
+}
+
+func (t *wtTransport) shutdown(err error) bool {
+	first := false
+	t.closeOnce.Do(func() {
+		first = true
+		t.errMu.Lock()
+		t.err = closedError(err)
+		t.errMu.Unlock()
+		close(t.done)
+		if t.onClose != nil {
+			t.onClose()
+		}
+	})
+	return first
+}
+
+func (t *wtTransport) closeErr() error {
+	t.errMu.Lock()
+	defer t.errMu.Unlock()
+	return t.err
+}
+
+// streamLoop は制御ストリームのフレームを受信します。信頼チャネルのため、inboxが満杯の場合は待ちます。
+func (t *wtTransport) streamLoop() {
+	r := bufio.NewReader(t.control)
+	for {
+		data, err := readFrame(r, t.opts.MaxFrameSize)
+		if err != nil {
+			t.shutdown(err)
+			return
+		}
+		select {
+		case t.inbox <- data:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// datagramLoop はデータグラムを受信します。非信頼チャネルのため、inboxが満杯の場合は破棄します。
+func (t *wtTransport) datagramLoop() {
+	ctx := t.sess.Context()
+	for {
+		data, err := t.sess.ReceiveDatagram(ctx)
+		if err != nil {
+			// セッションが閉じられた場合、クローズの理由（webtransport.SessionError）はstreamLoopが受け取る
+			if ctx.Err() == nil {
+				t.shutdown(err)
+			}
+			return
+		}
+		select {
+		case t.inbox <- data:
+		case <-t.done:
+			return
+		default:
+		}
+	}
+}
+
+// closedError はセッションのクローズによるエラーをdomain.ErrTransportClosedでラップします。
+// クローズの理由はwebtransport.SessionErrorとして引き続き取り出せます。
+func closedError(err error) error {
+	var sessErr *webtransport.SessionError
+	if errors.As(err, &sessErr) {
+		return fmt.Errorf("%w: %w", domain.ErrTransportClosed, err)
+	}
+	return err
+}
+
+func readFrame(r io.Reader, maxFrameSize int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	if int64(n) > int64(maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// isReliableFrame はプロトコルフレームを制御ストリームで送るべきかを判定します。
+// Controlメッセージのみ制御ストリームを使い、Input/Actor/Voiceはデータグラムで送ります。
+// ペイロードヘッダーに満たないフレームは種類を判定できないため制御ストリームで送ります。
+func isReliableFrame(data []byte) bool {
+	if len(data) < domain.HeaderSize+domain.PayloadHeaderSize {
+		return true
+	}
+	return domain.DataType(data[domain.HeaderSize]) == domain.DataTypeControl
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
@@ -172,7 +172,8 @@ func (app *WitheredApplication) handleControl(ctx context.Context, sessionID dom
 		app.field.Remove(sessionID)
 		slog.DebugContext(ctx, "handleControl:leave", "sessionID", sessionID)
 	case domain.ControlSubTypeKick:
-		slog.DebugContext(ctx, "handleControl:kick", "sessionID", sessionID)
+		// Kickはサーバー発のメッセージであり、退出処理はRoomがLeaveとして通知する
+		slog.WarnContext(ctx, "handleControl:kick ignored", "sessionID", sessionID)
 	case domain.ControlSubTypePing:
 		slog.DebugContext(ctx, "handleControl:ping", "sessionID", sessionID)
 	case domain.ControlSubTypePong:
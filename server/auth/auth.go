@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"withered/server/domain"
+)
+
+var (
+	// ErrNoCredentials はリクエストに認証情報が含まれていない場合に返されるエラーです。
+	ErrNoCredentials = errors.New("no credentials provided")
+	// ErrInvalidToken はトークンの形式・署名・クレームが不正な場合に返されるエラーです。
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired はトークンの有効期限が切れている場合に返されるエラーです。
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Authenticator はWebSocketアップグレード時にリクエストを認証します。
+// 認証に失敗した場合は上記のエラーを返し、アップグレードは拒否されます。
+type Authenticator interface {
+	Authenticate(r *http.Request) (domain.Identity, error)
+}
+
+// Anonymous は全ての接続を匿名として受け入れるAuthenticatorです。
+// 開発環境など、認証を必要としない場合に使用します。
+type Anonymous struct{}
+
+func (Anonymous) Authenticate(r *http.Request) (domain.Identity, error) {
+	return domain.Identity{}, nil
+}
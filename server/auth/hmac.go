@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"withered/server/domain"
+)
+
+const (
+	// TokenQueryParam はトークンを渡すクエリパラメータ名です。
+	// ブラウザのWebSocket APIはヘッダーを設定できないため、クエリパラメータでも受け付けます。
+	TokenQueryParam = "token"
+
+	algHS256 = "HS256"
+
+	// clockSkew は exp/nbf の判定で許容する時刻のずれです。
+	clockSkew = 30 * time.Second
+)
+
+// Claims はトークンに含まれるクレームです（JWTの登録済みクレーム名に準拠）。
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// HMACAuthenticator はHS256で署名されたJWT互換トークンを検証するAuthenticatorです。
+// トークンは Authorization: Bearer ヘッダー、または token クエリパラメータから取得します。
+type HMACAuthenticator struct {
+	key []byte
+	now func() time.Time
+}
+
+func NewHMACAuthenticator(key []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{key: key, now: time.Now}
+}
+
+// Authenticate はリクエストからトークンを取り出して検証し、識別情報を返します。
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (domain.Identity, error) {
+	token := tokenFromRequest(r)
+	if token == "" {
+		return domain.Identity{}, ErrNoCredentials
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return domain.Identity{}, err
+	}
+	return domain.Identity{UserID: claims.Subject, Roles: claims.Roles}, nil
+}
+
+// Verify はトークンの署名とクレームを検証します。
+func (a *HMACAuthenticator) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	// alg: none 等による署名回避を防ぐため、HS256以外は受け付けない
+	if header.Alg != algHS256 {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal(signature, a.sign(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	now := a.now()
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// Sign はクレームにHS256で署名したトークンを生成します。
+// ボットやテストなど、サーバー側でトークンを発行する用途を想定しています。
+func (a *HMACAuthenticator) Sign(claims Claims) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: algHS256, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(a.sign(signingInput)), nil
+}
+
+func (a *HMACAuthenticator) sign(signingInput string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// tokenFromRequest は Authorization ヘッダー、クエリパラメータの順でトークンを取り出します。
+func tokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get(TokenQueryParam)
+}
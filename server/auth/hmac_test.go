@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("test-secret-key")
+
+func TestHMACAuthenticator_SignAndVerify(t *testing.T) {
+	a := NewHMACAuthenticator(testKey)
+	token, err := a.Sign(Claims{
+		Subject:   "user-1",
+		Roles:     []string{"superuser"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	claims, err := a.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.Subject != "user-1" {
+		t.Errorf("Subject = %q, want %q", claims.Subject, "user-1")
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "superuser" {
+		t.Errorf("Roles = %v, want [superuser]", claims.Roles)
+	}
+}
+
+func TestHMACAuthenticator_VerifyErrors(t *testing.T) {
+	a := NewHMACAuthenticator(testKey)
+	valid, _ := a.Sign(Claims{Subject: "user-1"})
+	expired, _ := a.Sign(Claims{Subject: "user-1", ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	notYet, _ := a.Sign(Claims{Subject: "user-1", NotBefore: time.Now().Add(time.Hour).Unix()})
+	noSubject, _ := a.Sign(Claims{})
+	otherKey, _ := NewHMACAuthenticator([]byte("other-key")).Sign(Claims{Subject: "user-1"})
+	parts := strings.Split(valid, ".")
+	// {"alg":"none"}
+	algNone := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "abc", ErrInvalidToken},
+		{"expired", expired, ErrTokenExpired},
+		{"not before", notYet, ErrInvalidToken},
+		{"no subject", noSubject, ErrInvalidToken},
+		{"wrong key", otherKey, ErrInvalidToken},
+		{"alg none", algNone, ErrInvalidToken},
+		{"tampered payload", parts[0] + "." + parts[1] + "x." + parts[2], ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticator_Authenticate(t *testing.T) {
+	a := NewHMACAuthenticator(testKey)
+	token, _ := a.Sign(Claims{Subject: "user-1", Roles: []string{"player"}})
+
+	t.Run("bearer header", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		id, err := a.Authenticate(r)
+		if err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+		if id.UserID != "user-1" || !id.HasRole("player") {
+			t.Errorf("Identity = %+v, want user-1 with role player", id)
+		}
+	})
+
+	t.Run("query param", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/ws?token="+token, nil)
+		if _, err := a.Authenticate(r); err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authenticate() error = %v, want %v", err, ErrNoCredentials)
+		}
+	})
+}
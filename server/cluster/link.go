@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"withered/server/domain"
+)
+
+// link は他ノードとの1本の接続です。相手ノードが購読しているトピックを保持し、
+// それにマッチするメッセージだけを送ります。
+type link struct {
+	nodeID string
+	addr   string
+	// initiator は接続を開始したノードのIDです。同じノードとの接続が重複した場合の選択に使います。
+	initiator string
+	inbound   bool
+	tr        domain.Transport
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// 相手ノードの購読
+	mu       sync.RWMutex
+	topics   map[domain.Topic]struct{}
+	patterns map[string]domain.TopicPattern
+
+	// ctrl は購読の変更など落とせないフレームです。dataより先に送ります。
+	ctrlMu sync.Mutex
+	ctrl   [][]byte
+	notify chan struct{}
+	// data はpublishのフレームです。満杯の場合は破棄します。
+	data chan []byte
+
+	forwarded atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newLink(nodeID, addr, initiator string, inbound bool, tr domain.Transport, queueSize int) *link {
+	return &link{
+		nodeID:    nodeID,
+		addr:      addr,
+		initiator: initiator,
+		inbound:   inbound,
+		tr:        tr,
+		done:      make(chan struct{}),
+		topics:    make(map[domain.Topic]struct{}),
+		patterns:  make(map[string]domain.TopicPattern),
+		notify:    make(chan struct{}, 1),
+		data:      make(chan []byte, queueSize),
+	}
+}
+
+// wants は相手ノードがtopicを購読しているかを返します。
+func (l *link) wants(topic domain.Topic) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.topics[topic]; ok {
+		return true
+	}
+	for _, p := range l.patterns {
+		if p.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *link) interestCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.topics) + len(l.patterns)
+}
+
+// applyInterest は相手ノードから受信した購読の変更を反映します。
+func (l *link) applyInterest(t frameType, in interest) error {
+	var pattern domain.TopicPattern
+	if in.kind == interestPattern && t == frameSubscribe {
+		var err error
+		if pattern, err = domain.ParseTopicPattern(in.value); err != nil {
+			return ErrProtocol
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case in.kind == interestTopic && t == frameSubscribe:
+		l.topics[domain.Topic(in.value)] = struct{}{}
+	case in.kind == interestTopic:
+		delete(l.topics, domain.Topic(in.value))
+	case t == frameSubscribe:
+		l.patterns[in.value] = pattern
+	default:
+		delete(l.patterns, in.value)
+	}
+	return nil
+}
+
+// sendCtrl は落とせないフレームを送信待ちに積みます。
+func (l *link) sendCtrl(frame []byte) {
+	l.ctrlMu.Lock()
+	l.ctrl = append(l.ctrl, frame)
+	l.ctrlMu.Unlock()
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+// send はpublishのフレームを送信待ちに積みます。キューが満杯の場合は破棄します。
+func (l *link) send(frame []byte) {
+	select {
+	case l.data <- frame:
+		l.forwarded.Add(1)
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *link) writeLoop(ctx context.Context, heartbeat time.Duration) error {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	ping := []byte{byte(framePing)}
+
+	for {
+		if err := l.flushCtrl(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.done:
+			return nil
+		case <-l.notify:
+		case frame := <-l.data:
+			// frameより前に積まれた購読の変更を先に送る
+			if err := l.flushCtrl(ctx); err != nil {
+				return err
+			}
+			if err := l.tr.Write(ctx, frame); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := l.tr.Write(ctx, ping); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (l *link) flushCtrl(ctx context.Context) error {
+	l.ctrlMu.Lock()
+	frames := l.ctrl
+	l.ctrl = nil
+	l.ctrlMu.Unlock()
+	for _, frame := range frames {
+		if err := l.tr.Write(ctx, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *link) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		_ = l.tr.Close(domain.CloseCodeNormal, "")
+	})
+}
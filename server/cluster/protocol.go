@@ -0,0 +1,245 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ノード間のフレームは adaptertcp の長さプレフィックス付きフレームで送り、先頭1バイトが種別を表す
+//
+//	hello:       [type] [version u8] [nonce 16] [nodeIDLen u8] [nodeID]
+//	auth:        [type] [mac 32]
+//	subscribe:   [type] [kind u8] [topic or pattern]
+//	unsubscribe: [type] [kind u8] [topic or pattern]
+//	publish:     [type] [topicLen u16] [topic] [sessionIDLen u8] [sessionID]
+//	             [dataType u8] [subType u8] [seq u16] [receivedAt i64] [originLen u8] [origin]
+//	             [excludeCount u8] ([sessionIDLen u8] [sessionID])...
+//	             [replyToLen u16] [replyTo] [correlationIDLen u8] [correlationID] [data]
+//	ping:        [type]
+//
+// receivedAt はUnixナノ秒で、0はゼロ値の時刻を表します。
+// helloを交換した後、各ノードはauthで共有の秘密鍵を知っていることを示します（authMACを参照）。
+const protocolVersion = 5
+
+type frameType uint8
+
+const (
+	frameHello       frameType = 1
+	frameSubscribe   frameType = 2
+	frameUnsubscribe frameType = 3
+	framePublish     frameType = 4
+	framePing        frameType = 5
+	frameAuth        frameType = 6
+)
+
+const (
+	// nonceSize はhelloで送るnonceの長さです。
+	nonceSize = 16
+	// macSize はauthで送るHMAC-SHA256の長さです。
+	macSize = sha256.Size
+)
+
+var (
+	// ErrProtocol は相手ノードから不正なフレームを受信した場合に返されるエラーです。
+	ErrProtocol = errors.New("cluster: protocol error")
+	// ErrUnsupportedVersion は相手ノードのプロトコルバージョンに対応していない場合に返されるエラーです。
+	ErrUnsupportedVersion = errors.New("cluster: unsupported protocol version")
+)
+
+// interestKind は購読の種類です。
+type interestKind uint8
+
+const (
+	interestTopic   interestKind = 0
+	interestPattern interestKind = 1
+)
+
+// interest はノードが購読しているトピックまたはパターンです。
+type interest struct {
+	kind  interestKind
+	value string
+}
+
+func encodeHello(nodeID string, nonce [nonceSize]byte) []byte {
+	b := make([]byte, 0, 3+nonceSize+len(nodeID))
+	b = append(b, byte(frameHello), protocolVersion)
+	b = append(b, nonce[:]...)
+	b = append(b, byte(len(nodeID)))
+	return append(b, nodeID...)
+}
+
+func decodeHello(b []byte) (string, [nonceSize]byte, error) {
+	var nonce [nonceSize]byte
+	if len(b) < 2 || frameType(b[0]) != frameHello {
+		return "", nonce, ErrProtocol
+	}
+	if b[1] != protocolVersion {
+		return "", nonce, ErrUnsupportedVersion
+	}
+	if len(b) < 3+nonceSize {
+		return "", nonce, ErrProtocol
+	}
+	copy(nonce[:], b[2:2+nonceSize])
+	n := int(b[2+nonceSize])
+	if n == 0 || len(b) != 3+nonceSize+n {
+		return "", nonce, ErrProtocol
+	}
+	return string(b[3+nonceSize:]), nonce, nil
+}
+
+// authMAC はノードnodeIDが送るauthのMACです。相手のnonce・自分のnonce・自分のノードIDに対するHMAC-SHA256で、
+// 相手のnonceを含めるため再送（リプレイ）できず、自分のノードIDを含めるため相手のMACを送り返しても通りません。
+func authMAC(secret []byte, peerNonce, nonce [nonceSize]byte, nodeID string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(peerNonce[:])
+	mac.Write(nonce[:])
+	mac.Write([]byte(nodeID))
+	return mac.Sum(nil)
+}
+
+func encodeAuth(mac []byte) []byte {
+	return append([]byte{byte(frameAuth)}, mac...)
+}
+
+func decodeAuth(b []byte) ([]byte, error) {
+	if len(b) != 1+macSize || frameType(b[0]) != frameAuth {
+		return nil, ErrProtocol
+	}
+	return b[1:], nil
+}
+
+func encodeInterest(t frameType, in interest) []byte {
+	b := make([]byte, 0, 2+len(in.value))
+	b = append(b, byte(t), byte(in.kind))
+	return append(b, in.value...)
+}
+
+func decodeInterest(b []byte) (interest, error) {
+	if len(b) < 3 {
+		return interest{}, ErrProtocol
+	}
+	kind := interestKind(b[1])
+	if kind != interestTopic && kind != interestPattern {
+		return interest{}, ErrProtocol
+	}
+	return interest{kind: kind, value: string(b[2:])}, nil
+}
+
+// publishEnvelopeSize はpublishフレームのうち、可変長部分を除いたエンベロープの大きさです。
+const publishEnvelopeSize = 1 + 1 + 2 + 8 + 1
+
+// encodePublish はpublishフレームを作ります。originはメッセージをPublishしたノードのIDです。
+func encodePublish(topic domain.Topic, origin string, msg domain.Message) []byte {
+	exclude, replyTo, correlationID := msg.Exclude(), msg.ReplyTo(), msg.CorrelationID()
+	size := 4 + len(topic) + len(msg.SessionID) + publishEnvelopeSize + len(origin) + 1 +
+		2 + len(replyTo) + 1 + len(correlationID) + len(msg.Data)
+	for _, id := range exclude {
+		size += 1 + len(id)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, byte(framePublish))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(topic)))
+	b = append(b, topic...)
+	b = append(b, byte(len(msg.SessionID)))
+	b = append(b, msg.SessionID...)
+	b = append(b, byte(msg.DataType), msg.SubType)
+	b = binary.LittleEndian.AppendUint16(b, msg.Seq)
+	var receivedAt int64
+	if !msg.ReceivedAt.IsZero() {
+		receivedAt = msg.ReceivedAt.UnixNano()
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(receivedAt))
+	b = append(b, byte(len(origin)))
+	b = append(b, origin...)
+	b = append(b, byte(len(exclude)))
+	for _, id := range exclude {
+		b = append(b, byte(len(id)))
+		b = append(b, id...)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(replyTo)))
+	b = append(b, replyTo...)
+	b = append(b, byte(len(correlationID)))
+	b = append(b, correlationID...)
+	return append(b, msg.Data...)
+}
+
+// encodableExclude はExcludeがpublishフレームの長さの上限に収まるかを返します。
+func encodableExclude(exclude []domain.SessionID) bool {
+	if len(exclude) > math.MaxUint8 {
+		return false
+	}
+	for _, id := range exclude {
+		if len(id) > math.MaxUint8 {
+			return false
+		}
+	}
+	return true
+}
+
+func decodePublish(b []byte) (domain.Topic, domain.Message, error) {
+	if len(b) < 4 {
+		return "", domain.Message{}, ErrProtocol
+	}
+	n := int(binary.LittleEndian.Uint16(b[1:3]))
+	b = b[3:]
+	if n == 0 || len(b) < n+1 {
+		return "", domain.Message{}, ErrProtocol
+	}
+	topic := domain.Topic(b[:n])
+	b = b[n:]
+	m := int(b[0])
+	if len(b) < 1+m+publishEnvelopeSize {
+		return "", domain.Message{}, ErrProtocol
+	}
+	msg := domain.Message{SessionID: domain.SessionID(b[1 : 1+m])}
+	b = b[1+m:]
+	msg.DataType = domain.DataType(b[0])
+	msg.SubType = b[1]
+	msg.Seq = binary.LittleEndian.Uint16(b[2:4])
+	if receivedAt := int64(binary.LittleEndian.Uint64(b[4:12])); receivedAt != 0 {
+		msg.ReceivedAt = time.Unix(0, receivedAt)
+	}
+	o := int(b[12])
+	b = b[13:]
+	if len(b) < o+1 {
+		return "", domain.Message{}, ErrProtocol
+	}
+	var routing domain.MessageRouting
+	routing.Origin = string(b[:o])
+	b = b[o:]
+	excludes := int(b[0])
+	b = b[1:]
+	if excludes > 0 {
+		routing.Exclude = make([]domain.SessionID, 0, excludes)
+	}
+	for range excludes {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return "", domain.Message{}, ErrProtocol
+		}
+		l := int(b[0])
+		routing.Exclude = append(routing.Exclude, domain.SessionID(b[1:1+l]))
+		b = b[1+l:]
+	}
+	if len(b) < 2 || len(b) < 2+int(binary.LittleEndian.Uint16(b))+1 {
+		return "", domain.Message{}, ErrProtocol
+	}
+	r := int(binary.LittleEndian.Uint16(b))
+	routing.ReplyTo = domain.Topic(b[2 : 2+r])
+	b = b[2+r:]
+	c := int(b[0])
+	if len(b) < 1+c {
+		return "", domain.Message{}, ErrProtocol
+	}
+	routing.CorrelationID = string(b[1 : 1+c])
+	msg.Data = b[1+c:]
+	if routing.Origin != "" || len(routing.Exclude) > 0 || routing.ReplyTo != "" || routing.CorrelationID != "" {
+		msg.Routing = &routing
+	}
+	return topic, msg, nil
+}
@@ -0,0 +1,483 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	adaptertcp "withered/server/adapter/tcp"
+	"withered/server/domain"
+)
+
+// ErrInvalidNodeID はノードIDが空または長すぎる場合に返されるエラーです。
+var ErrInvalidNodeID = errors.New("cluster: invalid node id")
+
+var (
+	// errSelf は自分自身に接続した場合のエラーです。設定のピアに自ノードが含まれている場合に起こります。
+	errSelf = errors.New("cluster: connected to self")
+	// errDuplicate は同じノードとの接続が既にある場合のエラーです。
+	errDuplicate = errors.New("cluster: duplicate link")
+	// errUnauthorized は相手ノードが共有の秘密鍵を知っていることを示せなかった場合のエラーです。
+	errUnauthorized = errors.New("cluster: peer authentication failed")
+)
+
+// maxNodeIDLen はノードIDの最大長です（helloフレームで1バイトの長さを使うため）。
+const maxNodeIDLen = math.MaxUint8
+
+// Options はクラスタPubSubの設定です。
+type Options struct {
+	// NodeID はクラスタ内でノードを識別するIDです。空の場合はランダムに生成します。
+	NodeID string
+	// ListenAddr は他ノードからの接続を待ち受けるアドレスです。空の場合は待ち受けず、Peersへの接続のみ行います。
+	ListenAddr string
+	// Peers は接続する他ノードのアドレスです。自ノードのアドレスが含まれていても構いません。
+	Peers []string
+	// Secret はクラスタの全ノードで共有する秘密鍵です。helloの後に互いのnonceに対するHMACを交換し、
+	// 同じSecretを持たないノードとの接続は切断します。空の場合も空の鍵で交換するため、
+	// 信頼できるネットワークでのテスト以外では必ず指定します。
+	Secret []byte
+	// DialTimeout はピアへの接続とhelloの交換を待つ最大時間です。
+	DialTimeout time.Duration
+	// HeartbeatInterval はpingを送る間隔です。この3倍の間なにも受信しない接続は切断します。
+	HeartbeatInterval time.Duration
+	// ReconnectMin, ReconnectMax は切断後に再接続するまでの待ち時間の範囲です。失敗するたびに倍にします。
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+	// WriteTimeout は1フレームの書き込みを待つ最大時間です。
+	WriteTimeout time.Duration
+	// SendQueueSize はピアごとに送信待ちにできるpublishの件数です。超えた分は破棄します。
+	SendQueueSize int
+}
+
+// DefaultOptions はデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		DialTimeout:       3 * time.Second,
+		HeartbeatInterval: 5 * time.Second,
+		ReconnectMin:      100 * time.Millisecond,
+		ReconnectMax:      5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		SendQueueSize:     4096,
+	}
+}
+
+// PeerInfo は接続中のピアの状態のスナップショットです。
+type PeerInfo struct {
+	NodeID string `json:"nodeId"`
+	Addr   string `json:"addr"`
+	// Inbound は相手から接続された場合にtrueです。
+	Inbound bool `json:"inbound"`
+	// Interests は相手ノードが購読しているトピックとパターンの数です。
+	Interests int `json:"interests"`
+	// Forwarded は相手ノードに送ったメッセージ数、Dropped は送信待ちが満杯で破棄した数です。
+	Forwarded uint64 `json:"forwarded"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// PubSub はローカルのPubSubをTCPで他ノードとつなぐdomain.PubSubの実装です。
+//
+// 各ノードは設定されたピアに接続し（静的なディスカバリー）、自ノードに購読者がいるトピックを
+// ピアに通知します。Publishはローカルに配送したうえで、そのトピックを購読しているピアにだけ転送します。
+// ピアから受信したメッセージはローカルにのみ配送し、さらに転送はしません（フルメッシュを前提とします）。
+type PubSub struct {
+	local domain.PubSub
+	opts  Options
+
+	mu sync.RWMutex
+	// interests は自ノードの購読の参照カウントです。0から1になったときにピアへ通知します。
+	interests map[interest]int
+	links     map[string]*link
+}
+
+// New はlocalに配送するクラスタPubSubを作成します。Runを呼ぶまでピアとは接続しません。
+func New(local domain.PubSub, opts Options) (*PubSub, error) {
+	if opts.NodeID == "" {
+		opts.NodeID = rand.Text()
+	}
+	if len(opts.NodeID) > maxNodeIDLen {
+		return nil, ErrInvalidNodeID
+	}
+	defaults := DefaultOptions()
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaults.DialTimeout
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if opts.ReconnectMin <= 0 {
+		opts.ReconnectMin = defaults.ReconnectMin
+	}
+	if opts.ReconnectMax < opts.ReconnectMin {
+		opts.ReconnectMax = max(defaults.ReconnectMax, opts.ReconnectMin)
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaults.WriteTimeout
+	}
+	if opts.SendQueueSize <= 0 {
+		opts.SendQueueSize = defaults.SendQueueSize
+	}
+	return &PubSub{
+		local:     local,
+		opts:      opts,
+		interests: make(map[interest]int),
+		links:     make(map[string]*link),
+	}, nil
+}
+
+// NodeID は自ノードのIDを返します。
+func (p *PubSub) NodeID() string {
+	return p.opts.NodeID
+}
+
+// Peers は接続中のピアをノードIDの順に返します。
+func (p *PubSub) Peers() []PeerInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make([]PeerInfo, 0, len(p.links))
+	for _, l := range p.links {
+		peers = append(peers, PeerInfo{
+			NodeID:    l.nodeID,
+			Addr:      l.addr,
+			Inbound:   l.inbound,
+			Interests: l.interestCount(),
+			Forwarded: l.forwarded.Load(),
+			Dropped:   l.dropped.Load(),
+		})
+	}
+	slices.SortFunc(peers, func(a, b PeerInfo) int {
+		switch {
+		case a.NodeID < b.NodeID:
+			return -1
+		case a.NodeID > b.NodeID:
+			return 1
+		}
+		return 0
+	})
+	return peers
+}
+
+// PubSubStats はローカルのPubSubの計測値を返します。ピアから転送されたメッセージも含みます。
+// ローカルのPubSubが計測値を提供しない場合はゼロ値を返します。
+func (p *PubSub) PubSubStats() domain.PubSubStats {
+	if reporter, ok := p.local.(domain.PubSubStatsReporter); ok {
+		return reporter.PubSubStats()
+	}
+	return domain.PubSubStats{}
+}
+
+// Subscribe はトピックを購読します。購読中はピアからもこのトピックのメッセージが転送されます。
+func (p *PubSub) Subscribe(ctx context.Context, topic domain.Topic, opts domain.SubscribeOptions) *domain.Subscription {
+	sub := p.local.Subscribe(ctx, topic, opts)
+	p.track(sub, interest{kind: interestTopic, value: string(topic)})
+	return sub
+}
+
+// SubscribePattern はpatternにマッチする全てのトピックを購読します。
+// 購読中はピアからもマッチするトピックのメッセージが転送されます。
+func (p *PubSub) SubscribePattern(ctx context.Context, pattern domain.TopicPattern, opts domain.SubscribeOptions) *domain.Subscription {
+	sub := p.local.SubscribePattern(ctx, pattern, opts)
+	p.track(sub, interest{kind: interestPattern, value: pattern.String()})
+	return sub
+}
+
+// track は購読が終了するまでinを自ノードの購読として数えます。
+func (p *PubSub) track(sub *domain.Subscription, in interest) {
+	p.updateInterest(in, 1)
+	go func() {
+		<-sub.Done()
+		p.updateInterest(in, -1)
+	}()
+}
+
+func (p *PubSub) updateInterest(in interest, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := p.interests[in] + delta
+	var frame []byte
+	switch {
+	case n == 1 && delta > 0:
+		frame = encodeInterest(frameSubscribe, in)
+		p.interests[in] = n
+	case n == 0:
+		frame = encodeInterest(frameUnsubscribe, in)
+		delete(p.interests, in)
+	default:
+		p.interests[in] = n
+		return
+	}
+	for _, l := range p.links {
+		l.sendCtrl(frame)
+	}
+}
+
+// Publish はローカルに配送し、トピックを購読しているピアに転送します。
+// ピアへの転送はbest-effort: 送信待ちが満杯のピアへは破棄して継続します。
+func (p *PubSub) Publish(ctx context.Context, topic domain.Topic, msg domain.Message) {
+	p.local.Publish(ctx, topic, msg)
+	// 自ノードでPublishされたメッセージは、転送先で自ノードが発信元になる
+	origin := msg.Origin()
+	if origin == "" {
+		origin = p.opts.NodeID
+	}
+	if len(topic) > math.MaxUint16 || len(msg.SessionID) > math.MaxUint8 || len(origin) > math.MaxUint8 || !encodableExclude(msg.Exclude()) ||
+		len(msg.ReplyTo()) > math.MaxUint16 || len(msg.CorrelationID()) > math.MaxUint8 {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var frame []byte
+	for _, l := range p.links {
+		if !l.wants(topic) {
+			continue
+		}
+		if frame == nil {
+			frame = encodePublish(topic, origin, msg)
+		}
+		l.send(frame)
+	}
+}
+
+// Run はListenAddrで待ち受け、ctxがキャンセルされるまでピアとの接続を維持します。
+func (p *PubSub) Run(ctx context.Context) error {
+	if p.opts.ListenAddr == "" {
+		return p.Serve(ctx, nil)
+	}
+	ln, err := net.Listen("tcp", p.opts.ListenAddr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ctx, ln)
+}
+
+// Serve はlnで他ノードからの接続を受け付け、ctxがキャンセルされるまでピアとの接続を維持します。
+// lnがnilの場合は待ち受けず、Peersへの接続のみ行います。戻る前に全ての接続を閉じます。
+func (p *PubSub) Serve(ctx context.Context, ln net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, addr := range p.opts.Peers {
+		wg.Go(func() { p.dialLoop(ctx, addr) })
+	}
+	if ln == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Go(func() {
+			_, err := p.handle(ctx, conn, false)
+			switch {
+			case ctx.Err() != nil:
+			case errors.Is(err, errUnauthorized):
+				slog.WarnContext(ctx, "cluster: rejected unauthenticated peer", "remoteAddr", conn.RemoteAddr())
+			case err != nil:
+				slog.DebugContext(ctx, "cluster: inbound link closed", "remoteAddr", conn.RemoteAddr(), "err", err)
+			}
+		})
+	}
+}
+
+// dialLoop はaddrのピアに接続し、切断されるたびに再接続します。
+func (p *PubSub) dialLoop(ctx context.Context, addr string) {
+	backoff := p.opts.ReconnectMin
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, p.opts.DialTimeout)
+		var d net.Dialer
+		conn, err := d.DialContext(dialCtx, "tcp", addr)
+		cancel()
+		if err == nil {
+			var existing *link
+			existing, err = p.handle(ctx, conn, true)
+			switch {
+			case errors.Is(err, errSelf):
+				return
+			case errors.Is(err, errDuplicate):
+				// 相手から張られた接続を使う。それが切れたら改めて接続する
+				select {
+				case <-existing.done:
+				case <-ctx.Done():
+				}
+				backoff = p.opts.ReconnectMin
+			case existing != nil:
+				// 接続できていたので待ち時間を戻す
+				backoff = p.opts.ReconnectMin
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.DebugContext(ctx, "cluster: peer disconnected, reconnecting", "addr", addr, "after", backoff, "err", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, p.opts.ReconnectMax)
+	}
+}
+
+// handle はhelloを交換して接続をピアとして登録し、切断されるまで送受信します。
+// 登録できた場合、または既存の接続と重複した場合はそのlinkを返します。
+func (p *PubSub) handle(ctx context.Context, conn net.Conn, dialed bool) (*link, error) {
+	tr := adaptertcp.NewTransportFrom(conn, adaptertcp.Options{
+		ReadTimeout:  3 * p.opts.HeartbeatInterval,
+		WriteTimeout: p.opts.WriteTimeout,
+		MaxFrameSize: domain.MaxFrameSize + 1024,
+	})
+	remoteID, err := p.hello(ctx, tr)
+	if err != nil {
+		_ = tr.Close(domain.CloseCodeNormal, "")
+		return nil, err
+	}
+
+	initiator := remoteID
+	if dialed {
+		initiator = p.opts.NodeID
+	}
+	l := newLink(remoteID, conn.RemoteAddr().String(), initiator, !dialed, tr, p.opts.SendQueueSize)
+	if existing, ok := p.register(l); !ok {
+		_ = tr.Close(domain.CloseCodeNormal, "")
+		return existing, errDuplicate
+	}
+	defer p.unregister(l)
+	slog.InfoContext(ctx, "cluster: peer connected", "nodeID", remoteID, "addr", l.addr, "inbound", l.inbound)
+
+	linkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- l.writeLoop(linkCtx, p.opts.HeartbeatInterval)
+		l.close()
+	}()
+	err = p.readLoop(linkCtx, l)
+	l.close()
+	cancel()
+	if wErr := <-writeErr; err == nil {
+		err = wErr
+	}
+	slog.InfoContext(ctx, "cluster: peer disconnected", "nodeID", remoteID, "addr", l.addr, "err", err)
+	return l, err
+}
+
+// hello はノードIDとnonceを交換し、互いに共有の秘密鍵を知っていることを確かめます。
+// 認証できなかった接続は登録せず、その接続から受信したフレームは処理しません。
+func (p *PubSub) hello(ctx context.Context, tr domain.Transport) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.DialTimeout)
+	defer cancel()
+	var nonce [nonceSize]byte
+	rand.Read(nonce[:])
+	if err := tr.Write(ctx, encodeHello(p.opts.NodeID, nonce)); err != nil {
+		return "", err
+	}
+	data, err := tr.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+	remoteID, remoteNonce, err := decodeHello(data)
+	if err != nil {
+		return "", err
+	}
+	if remoteID == p.opts.NodeID {
+		return "", errSelf
+	}
+
+	if err := tr.Write(ctx, encodeAuth(authMAC(p.opts.Secret, remoteNonce, nonce, p.opts.NodeID))); err != nil {
+		return "", err
+	}
+	if data, err = tr.Read(ctx); err != nil {
+		return "", err
+	}
+	mac, err := decodeAuth(data)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(mac, authMAC(p.opts.Secret, nonce, remoteNonce, remoteID)) {
+		return "", errUnauthorized
+	}
+	return remoteID, nil
+}
+
+// register はlをピアとして登録し、自ノードの購読を送ります。
+// 同じノードとの接続が既にある場合は、開始したノードのIDが小さい方を残します。
+// 双方のノードが同じ規則で選ぶため、重複した接続のどちらを残すかは両端で一致します。
+func (p *PubSub) register(l *link) (*link, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cur, ok := p.links[l.nodeID]; ok {
+		if cur.initiator < l.initiator {
+			return cur, false
+		}
+		// 同じノードからの再接続は、古い接続が切断を検知する前でも新しい方を使う
+		cur.close()
+	}
+	p.links[l.nodeID] = l
+	for in := range p.interests {
+		l.sendCtrl(encodeInterest(frameSubscribe, in))
+	}
+	return l, true
+}
+
+func (p *PubSub) unregister(l *link) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.links[l.nodeID] == l {
+		delete(p.links, l.nodeID)
+	}
+}
+
+func (p *PubSub) readLoop(ctx context.Context, l *link) error {
+	for {
+		data, err := l.tr.Read(ctx)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return ErrProtocol
+		}
+		switch t := frameType(data[0]); t {
+		case framePublish:
+			topic, msg, err := decodePublish(data)
+			if err != nil {
+				return err
+			}
+			// ピアから受信したメッセージはローカルにのみ配送する
+			p.local.Publish(ctx, topic, msg)
+		case frameSubscribe, frameUnsubscribe:
+			in, err := decodeInterest(data)
+			if err != nil {
+				return err
+			}
+			if err := l.applyInterest(t, in); err != nil {
+				return err
+			}
+		case framePing:
+		default:
+			return fmt.Errorf("%w: unknown frame type %d", ErrProtocol, t)
+		}
+	}
+}
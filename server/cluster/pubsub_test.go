@@ -0,0 +1,493 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	adaptertcp "withered/server/adapter/tcp"
+	"withered/server/domain"
+)
+
+type testNode struct {
+	*PubSub
+	addr   string
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// startNode はaddrで待ち受けるノードを起動します。addrが空の場合は空いているポートを使います。
+func startNode(t *testing.T, nodeID, addr string, peers []string) *testNode {
+	t.Helper()
+	return startNodeWith(t, nodeID, addr, peers, testSecret)
+}
+
+// testSecret はテストのノードが共有する秘密鍵です。
+var testSecret = []byte("test-secret")
+
+// startNodeWith はsecretを共有の秘密鍵とするノードを起動します。
+func startNodeWith(t *testing.T, nodeID, addr string, peers []string, secret []byte) *testNode {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.NodeID = nodeID
+	opts.Peers = peers
+	opts.Secret = secret
+	opts.ReconnectMin = 10 * time.Millisecond
+	opts.ReconnectMax = 50 * time.Millisecond
+	ps, err := New(domain.NewSimplePubSub(), opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &testNode{PubSub: ps, addr: ln.Addr().String(), cancel: cancel, done: make(chan error, 1)}
+	go func() { n.done <- ps.Serve(ctx, ln) }()
+	t.Cleanup(n.stop)
+	return n
+}
+
+// freeAddr は空いているポートのアドレスを返します。ピアの設定に自ノードのアドレスを含める場合に使います。
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func (n *testNode) stop() {
+	n.cancel()
+	<-n.done
+	n.done <- nil // 2回目のstopでブロックしないようにする
+}
+
+// waitFor はcondが満たされるまで待ちます。
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func peer(n *testNode, nodeID string) (PeerInfo, bool) {
+	for _, p := range n.Peers() {
+		if p.NodeID == nodeID {
+			return p, true
+		}
+	}
+	return PeerInfo{}, false
+}
+
+func receive(t *testing.T, sub *domain.Subscription) domain.Message {
+	t.Helper()
+	select {
+	case msg := <-sub.C():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message on %s", sub.Topic())
+		return domain.Message{}
+	}
+}
+
+// TestCluster_MultiNode は3ノードをlocalhostで起動し、購読しているノードにだけメッセージが届くことと、
+// ノードの再起動後に再接続されることを確認します。
+func TestCluster_MultiNode(t *testing.T) {
+	// 先にアドレスを確保するため、aは待ち受けのみ、b・cはそれより前のノードに接続する
+	a := startNode(t, "node-a", "", nil)
+	b := startNode(t, "node-b", "", []string{a.addr})
+	// cはaとbの両方に接続し、自分自身のアドレスも含める（設定を全ノードで共有する場合）
+	cAddr := freeAddr(t)
+	c := startNode(t, "node-c", cAddr, []string{a.addr, b.addr, cAddr})
+
+	for _, n := range []*testNode{a, b, c} {
+		waitFor(t, n.NodeID()+" to connect to all peers", func() bool { return len(n.Peers()) == 2 })
+	}
+
+	ctx := context.Background()
+	sub := b.Subscribe(ctx, "room:x", domain.SubscribeOptions{})
+	rooms, _ := domain.ParseTopicPattern("room:*")
+	pattern := c.SubscribePattern(ctx, rooms, domain.SubscribeOptions{})
+	waitFor(t, "interest to reach node-a", func() bool {
+		pb, _ := peer(a, "node-b")
+		pc, _ := peer(a, "node-c")
+		return pb.Interests == 1 && pc.Interests == 1
+	})
+
+	msg := domain.Message{SessionID: domain.NewSessionID(), Data: []byte("hello")}
+	a.Publish(ctx, "room:x", msg)
+	for _, s := range []*domain.Subscription{sub, pattern} {
+		got := receive(t, s)
+		if got.Topic != "room:x" || got.SessionID != msg.SessionID || !bytes.Equal(got.Data, msg.Data) {
+			t.Errorf("received %+v, want %+v on room:x", got, msg)
+		}
+		if got.Origin() != "node-a" {
+			t.Errorf("Origin = %q, want node-a", got.Origin())
+		}
+	}
+
+	// 購読者のいないトピックはピアに送られない
+	a.Publish(ctx, "session:nobody", msg)
+	a.Publish(ctx, "room:y", msg)
+	if got := receive(t, pattern); got.Topic != "room:y" {
+		t.Errorf("Topic = %q, want room:y", got.Topic)
+	}
+	if pb, _ := peer(a, "node-b"); pb.Forwarded != 1 {
+		t.Errorf("forwarded to node-b = %d, want 1", pb.Forwarded)
+	}
+
+	// ピアから受信したメッセージは再転送しない
+	asub := a.Subscribe(ctx, "room:x", domain.SubscribeOptions{})
+	waitFor(t, "interest to reach node-b", func() bool {
+		pa, _ := peer(b, "node-a")
+		return pa.Interests == 1
+	})
+	c.Publish(ctx, "room:x", msg)
+	receive(t, sub)
+	receive(t, asub)
+	if pa, _ := peer(b, "node-a"); pa.Forwarded != 0 {
+		t.Errorf("node-b forwarded %d messages to node-a, want 0", pa.Forwarded)
+	}
+	asub.Close()
+
+	sub.Close()
+	waitFor(t, "unsubscribe to reach node-a", func() bool {
+		pb, _ := peer(a, "node-b")
+		return pb.Interests == 0
+	})
+
+	// node-bを再起動すると、同じアドレスに再接続される
+	b.stop()
+	waitFor(t, "node-a to notice node-b is gone", func() bool {
+		_, ok := peer(a, "node-b")
+		return !ok
+	})
+	b2 := startNode(t, "node-b", b.addr, []string{a.addr})
+	waitFor(t, "node-b to reconnect", func() bool {
+		_, ab := peer(a, "node-b")
+		_, cb := peer(c, "node-b")
+		return ab && cb
+	})
+	sub2 := b2.Subscribe(ctx, "room:x", domain.SubscribeOptions{})
+	defer sub2.Close()
+	waitFor(t, "interest to reach node-c", func() bool {
+		pb, _ := peer(c, "node-b")
+		return pb.Interests == 1
+	})
+	c.Publish(ctx, "room:x", msg)
+	if got := receive(t, sub2); got.SessionID != msg.SessionID {
+		t.Errorf("SessionID = %q, want %q", got.SessionID, msg.SessionID)
+	}
+}
+
+// TestCluster_DuplicateLinks は互いに接続し合うノードの間で接続が1本に絞られることを確認します。
+func TestCluster_DuplicateLinks(t *testing.T) {
+	// 全ノードが全ノード（自分を含む）に接続する
+	addrs := make([]string, 4)
+	for i := range addrs {
+		addrs[i] = freeAddr(t)
+	}
+	nodes := make([]*testNode, len(addrs))
+	for i := range addrs {
+		nodes[i] = startNode(t, fmt.Sprintf("node-%d", i), addrs[i], addrs)
+	}
+	for _, n := range nodes {
+		waitFor(t, n.NodeID()+" to connect to all peers", func() bool { return len(n.Peers()) == len(nodes)-1 })
+	}
+
+	ctx := context.Background()
+	subs := make([]*domain.Subscription, len(nodes))
+	for i, n := range nodes {
+		subs[i] = n.Subscribe(ctx, "room:all", domain.SubscribeOptions{})
+	}
+	for _, n := range nodes {
+		waitFor(t, "interests", func() bool {
+			for _, p := range n.Peers() {
+				if p.Interests != 1 {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	nodes[0].Publish(ctx, "room:all", domain.Message{Data: []byte{1}})
+	for _, sub := range subs {
+		receive(t, sub)
+	}
+	// 重複した接続から二重に届いていない
+	time.Sleep(20 * time.Millisecond)
+	for i, sub := range subs {
+		select {
+		case <-sub.C():
+			t.Errorf("node-%d received a duplicate message", i)
+		default:
+		}
+	}
+}
+
+func TestProtocol_RoundTrip(t *testing.T) {
+	nonce := [nonceSize]byte{1, 2, 3}
+	id, gotNonce, err := decodeHello(encodeHello("node-a", nonce))
+	if err != nil || id != "node-a" || gotNonce != nonce {
+		t.Errorf("decodeHello = (%q, %v, %v), want node-a %v", id, gotNonce, err, nonce)
+	}
+	bad := encodeHello("node-a", nonce)
+	bad[1] = protocolVersion + 1
+	if _, _, err := decodeHello(bad); err != ErrUnsupportedVersion {
+		t.Errorf("decodeHello error = %v, want %v", err, ErrUnsupportedVersion)
+	}
+	mac := authMAC(testSecret, nonce, [nonceSize]byte{4}, "node-a")
+	if got, err := decodeAuth(encodeAuth(mac)); err != nil || !bytes.Equal(got, mac) {
+		t.Errorf("decodeAuth = (%x, %v), want %x", got, err, mac)
+	}
+	if _, err := decodeAuth(encodeAuth(mac[:8])); err != ErrProtocol {
+		t.Errorf("decodeAuth short error = %v, want %v", err, ErrProtocol)
+	}
+
+	in := interest{kind: interestPattern, value: "room:*"}
+	got, err := decodeInterest(encodeInterest(frameSubscribe, in))
+	if err != nil || got != in {
+		t.Errorf("decodeInterest = (%+v, %v), want %+v", got, err, in)
+	}
+
+	msg := domain.Message{
+		SessionID:  domain.NewSessionID(),
+		DataType:   domain.DataTypeInput,
+		SubType:    3,
+		Seq:        42,
+		ReceivedAt: time.Unix(0, 1700000000123456789),
+		Data:       []byte{1, 2, 3},
+	}
+	topic, decoded, err := decodePublish(encodePublish("room:x", "node-a", msg))
+	if err != nil || topic != "room:x" || decoded.SessionID != msg.SessionID || !bytes.Equal(decoded.Data, msg.Data) {
+		t.Errorf("decodePublish = (%q, %+v, %v), want room:x %+v", topic, decoded, err, msg)
+	}
+	if decoded.DataType != msg.DataType || decoded.SubType != msg.SubType || decoded.Seq != msg.Seq ||
+		!decoded.ReceivedAt.Equal(msg.ReceivedAt) || decoded.Origin() != "node-a" {
+		t.Errorf("decodePublish envelope = %+v, want %+v from node-a", decoded, msg)
+	}
+	// マルチキャストの除外リスト
+	msg.Routing = &domain.MessageRouting{Exclude: []domain.SessionID{domain.NewSessionID(), domain.NewSessionID()}}
+	_, decoded, err = decodePublish(encodePublish("multicast:x", "node-a", msg))
+	if err != nil || !slices.Equal(decoded.Exclude(), msg.Exclude()) || !bytes.Equal(decoded.Data, msg.Data) {
+		t.Errorf("decodePublish Exclude = (%v, %q, %v), want %v", decoded.Exclude(), decoded.Data, err, msg.Exclude())
+	}
+	// リクエストの返信先と相関ID
+	msg.Routing = &domain.MessageRouting{ReplyTo: domain.ReplyTopic("corr"), CorrelationID: "corr"}
+	_, decoded, err = decodePublish(encodePublish("room:x", "node-a", msg))
+	if err != nil || decoded.ReplyTo() != msg.ReplyTo() || decoded.CorrelationID() != "corr" || !bytes.Equal(decoded.Data, msg.Data) {
+		t.Errorf("decodePublish ReplyTo = (%q, %q, %q, %v), want %q corr", decoded.ReplyTo(), decoded.CorrelationID(), decoded.Data, err, msg.ReplyTo())
+	}
+	// ゼロ値の時刻はゼロ値のまま復元される
+	_, decoded, err = decodePublish(encodePublish("room:x", "node-a", domain.Message{}))
+	if err != nil || !decoded.ReceivedAt.IsZero() {
+		t.Errorf("decodePublish ReceivedAt = (%v, %v), want zero", decoded.ReceivedAt, err)
+	}
+	for _, b := range [][]byte{{byte(framePublish)}, {byte(framePublish), 5, 0, 'r'}, {byte(frameSubscribe), 9, 'x'}} {
+		if _, _, err := decodePublish(b); err == nil && b[0] == byte(framePublish) {
+			t.Errorf("decodePublish(%v) succeeded", b)
+		}
+		if _, err := decodeInterest(b); err == nil && b[0] == byte(frameSubscribe) {
+			t.Errorf("decodeInterest(%v) succeeded", b)
+		}
+	}
+}
+
+// TestCluster_RequestReply は別ノードで処理されるリクエストの返信を受け取れることを確認します。
+func TestCluster_RequestReply(t *testing.T) {
+	a := startNode(t, "node-a", "", nil)
+	b := startNode(t, "node-b", "", []string{a.addr})
+	waitFor(t, "nodes to connect", func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go domain.ServeRequests(ctx, b, "room:x", func(_ context.Context, req domain.Message) domain.Message {
+		return domain.Message{Data: append([]byte("re:"), req.Data...)}
+	})
+	waitFor(t, "interest to reach node-a", func() bool {
+		pb, _ := peer(a, "node-b")
+		return pb.Interests == 1
+	})
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer reqCancel()
+	reply, err := domain.Request(reqCtx, a, "room:x", domain.Message{Data: []byte("lookup")})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if string(reply.Data) != "re:lookup" || reply.Origin() != "node-b" {
+		t.Errorf("reply = %+v, want re:lookup from node-b", reply)
+	}
+	// 返信の購読は終了し、ピアへの購読も取り消される
+	waitFor(t, "reply interest to be removed", func() bool {
+		pa, _ := peer(b, "node-a")
+		return pa.Interests == 0
+	})
+}
+
+// TestCluster_RequestBackToBack は返信の購読がリクエストより先に相手ノードへ届くことを確認します。
+// 返信トピックの購読の伝搬は待たずに、複数のgoroutineから連続してリクエストを送ります。
+func TestCluster_RequestBackToBack(t *testing.T) {
+	a := startNode(t, "node-a", "", nil)
+	b := startNode(t, "node-b", "", []string{a.addr})
+	waitFor(t, "nodes to connect", func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go domain.ServeRequests(ctx, b, "room:x", func(_ context.Context, req domain.Message) domain.Message {
+		return domain.Message{Data: req.Data}
+	})
+	// リクエスト先の購読だけを待つ
+	waitFor(t, "interest to reach node-a", func() bool {
+		pb, _ := peer(a, "node-b")
+		return pb.Interests == 1
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				want := fmt.Sprintf("%d-%d", g, i)
+				reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+				reply, err := domain.Request(reqCtx, a, "room:x", domain.Message{Data: []byte(want)})
+				reqCancel()
+				if err != nil {
+					errs <- fmt.Errorf("Request %s: %w", want, err)
+					return
+				}
+				if string(reply.Data) != want {
+					errs <- fmt.Errorf("reply = %q, want %q", reply.Data, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// gatedTransport は最初のWriteをgateが閉じられるまで止め、書き込んだフレームを記録します。
+type gatedTransport struct {
+	gate    chan struct{}
+	blocked chan struct{}
+	once    sync.Once
+	written chan []byte
+}
+
+func (t *gatedTransport) Read(ctx context.Context) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (t *gatedTransport) Write(ctx context.Context, data []byte) error {
+	t.once.Do(func() {
+		close(t.blocked)
+		<-t.gate
+	})
+	t.written <- data
+	return nil
+}
+
+func (t *gatedTransport) Close(int32, string) error { return nil }
+
+// TestLink_CtrlBeforeData は購読の変更がその後に積まれたpublishより先に送られることを確認します。
+func TestLink_CtrlBeforeData(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		tr := &gatedTransport{gate: make(chan struct{}), blocked: make(chan struct{}), written: make(chan []byte, 3)}
+		l := newLink("node-b", "", "node-a", false, tr, 4)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- l.writeLoop(ctx, time.Hour) }()
+
+		// 最初のctrlの書き込み中に次のctrlとdataを積む
+		l.sendCtrl([]byte("ctrl-1"))
+		<-tr.blocked
+		l.sendCtrl([]byte("ctrl-2"))
+		l.send([]byte("data"))
+		close(tr.gate)
+
+		for _, want := range []string{"ctrl-1", "ctrl-2", "data"} {
+			if got := <-tr.written; string(got) != want {
+				t.Fatalf("run %d: written %q, want %q", i, got, want)
+			}
+		}
+		cancel()
+		<-done
+	}
+}
+
+// TestCluster_RejectsWrongSecret は異なる秘密鍵を持つノードとは接続しないことを確認します。
+func TestCluster_RejectsWrongSecret(t *testing.T) {
+	a := startNode(t, "node-a", "", nil)
+	b := startNodeWith(t, "node-b", "", []string{a.addr}, []byte("other-secret"))
+	// bは再接続を繰り返すが、一度もピアにならない
+	time.Sleep(100 * time.Millisecond)
+	if len(a.Peers()) != 0 || len(b.Peers()) != 0 {
+		t.Errorf("peers = %v / %v, want none", a.Peers(), b.Peers())
+	}
+}
+
+// TestCluster_UnauthenticatedPublish は認証できなかった接続から送られたpublishを配送しないことを確認します。
+func TestCluster_UnauthenticatedPublish(t *testing.T) {
+	a := startNode(t, "node-a", "", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub := a.Subscribe(ctx, "session:victim", domain.SubscribeOptions{})
+	defer sub.Close()
+
+	conn, err := net.Dial("tcp", a.addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	tr := adaptertcp.NewTransportFrom(conn, adaptertcp.DefaultOptions())
+	defer tr.Close(domain.CloseCodeNormal, "")
+	if err := tr.Write(ctx, encodeHello("intruder", [nonceSize]byte{})); err != nil {
+		t.Fatalf("Write hello: %v", err)
+	}
+	if _, err := tr.Read(ctx); err != nil {
+		t.Fatalf("Read hello: %v", err)
+	}
+	// 秘密鍵を知らないため、正しいMACを作れない
+	_ = tr.Write(ctx, encodeAuth(make([]byte, macSize)))
+	_ = tr.Write(ctx, encodePublish("session:victim", "intruder", domain.Message{Data: []byte("kick")}))
+
+	// 接続は認証の時点で切断される
+	for {
+		if _, err := tr.Read(ctx); err != nil {
+			if ctx.Err() != nil {
+				t.Fatal("connection was not closed")
+			}
+			break
+		}
+	}
+	select {
+	case msg := <-sub.C():
+		t.Errorf("received %q from unauthenticated peer", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(a.Peers()) != 0 {
+		t.Errorf("peers = %v, want none", a.Peers())
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
@@ -9,12 +11,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"withered/server"
+	adapterhttpstream "withered/server/adapter/httpstream"
+	adaptertcp "withered/server/adapter/tcp"
+	adapterudp "withered/server/adapter/udp"
+	adapterunix "withered/server/adapter/unix"
+	adapterwebtransport "withered/server/adapter/webtransport"
 	"withered/server/application"
+	"withered/server/auth"
+	"withered/server/cluster"
 	"withered/server/domain"
+	"withered/server/handler"
+	"withered/server/transport/capture"
+	"withered/server/transport/instrument"
+	"withered/server/transport/netsim"
 	"withered/utils"
 )
 
@@ -27,9 +41,95 @@ func main() {
 
 	addr := utils.GetEnvDefault("ADDR", "localhost")
 	port := utils.GetEnvDefault("PORT", "9090")
+	// TCP_PORT を指定した場合、同じプロトコルを長さプレフィックス付きTCPでも提供する
+	// TCP接続は認証を行わないため、TCP_ADDR（デフォルトはループバック）で待ち受け、AUTH_HMAC_KEYとは併用できない
+	tcpPort := utils.GetEnvDefault("TCP_PORT", "")
+	tcpAddr := utils.GetEnvDefault("TCP_ADDR", "127.0.0.1")
+	// UDP_PORT を指定した場合、Input/Actorを非信頼チャネルで送るUDPでも提供する
+	// TCPと同じく認証を行わないため、UDP_ADDR（デフォルトはループバック）で待ち受け、AUTH_HMAC_KEYとは併用できない
+	udpPort := utils.GetEnvDefault("UDP_PORT", "")
+	udpAddr := utils.GetEnvDefault("UDP_ADDR", "127.0.0.1")
+	// WEBTRANSPORT_PORT を指定した場合、HTTP/3のWebTransport（UDP）でも提供する
+	webTransportPort := utils.GetEnvDefault("WEBTRANSPORT_PORT", "")
+	// UNIX_SOCKET を指定した場合、同一ホストのボット・サイドカー向けにUnixドメインソケットでも提供する
+	unixSocket := utils.GetEnvDefault("UNIX_SOCKET", "")
+	// ADMIN_ADDR は管理API（/admin/*）とメトリクス（/metrics）を提供するアドレス。認証を行わないためループバックに限る
+	// 空を指定した場合は提供しない
+	adminAddr := utils.GetEnvDefault("ADMIN_ADDR", "127.0.0.1:9091")
 
 	// PubSub初期化
-	pubsub := domain.NewSimplePubSub()
+	// PUBSUB_SHARDS を指定した場合、トピックごとにシャードを分けたPubSubを使う（多コア環境向け）
+	var pubsub domain.PubSub = domain.NewSimplePubSub()
+	if shards := utils.GetEnvInt("PUBSUB_SHARDS", 0); shards > 0 {
+		pubsub = domain.NewShardedPubSub(shards)
+	}
+	// TOPIC_LOG を指定した場合、マッチするトピックのメッセージをログに残し、過去のオフセットから購読できるようにする
+	// ルームのブロードキャストは"multicast:<ルームID>"、ルーム宛のメッセージは"room:<ルームID>"のトピックに流れる
+	// 例: TOPIC_LOG="multicast:*" TOPIC_LOG_DIR="/var/lib/withered/topics"（TOPIC_LOG_DIR未指定時はメモリに残す）
+	if patterns := utils.GetEnvList("TOPIC_LOG", nil); len(patterns) > 0 {
+		logOpts := domain.LogPubSubOptions{
+			Dir: utils.GetEnvDefault("TOPIC_LOG_DIR", ""),
+			Retention: domain.LogRetention{
+				MaxEntries: utils.GetEnvInt("TOPIC_LOG_MAX_ENTRIES", domain.DefaultLogMaxEntries),
+				MaxAge:     time.Duration(utils.GetEnvInt("TOPIC_LOG_MAX_AGE_SEC", 0)) * time.Second,
+			},
+		}
+		for _, s := range patterns {
+			pattern, err := domain.ParseTopicPattern(s)
+			if err != nil {
+				log.Fatalf("invalid TOPIC_LOG pattern %q: %v", s, err)
+			}
+			logOpts.Topics = append(logOpts.Topics, pattern)
+		}
+		logPubSub := domain.NewLogPubSub(pubsub, logOpts)
+		defer logPubSub.Close()
+		pubsub = logPubSub
+		slog.InfoContext(ctx, "topic log enabled", "topics", patterns, "dir", logOpts.Dir)
+	}
+	// CLUSTER_LISTEN / CLUSTER_PEERS を指定した場合、他のサーバーノードとPubSubのトピックを中継する
+	// ピアは任意のトピックにPublishできるため、CLUSTER_SECRET（全ノードで共有する秘密鍵）で認証し、プライベートなアドレスで待ち受ける
+	// 例: CLUSTER_LISTEN="10.0.0.1:7946" CLUSTER_PEERS="10.0.0.1:7946,10.0.0.2:7946" CLUSTER_SECRET="..."（全ノードで同じ一覧を使える）
+	clusterListen := utils.GetEnvDefault("CLUSTER_LISTEN", "")
+	clusterPeers := utils.GetEnvList("CLUSTER_PEERS", nil)
+	if clusterListen != "" || len(clusterPeers) > 0 {
+		secret := utils.GetEnvDefault("CLUSTER_SECRET", "")
+		if secret == "" {
+			log.Fatalf("CLUSTER_SECRET is required when CLUSTER_LISTEN or CLUSTER_PEERS is set")
+		}
+		opts := cluster.DefaultOptions()
+		opts.NodeID = utils.GetEnvDefault("CLUSTER_NODE_ID", "")
+		opts.ListenAddr = clusterListen
+		opts.Peers = clusterPeers
+		opts.Secret = []byte(secret)
+		clusterPubSub, err := cluster.New(pubsub, opts)
+		if err != nil {
+			log.Fatalf("invalid cluster config: %v", err)
+		}
+		go func() {
+			if err := clusterPubSub.Run(ctx); err != nil {
+				slog.ErrorContext(ctx, "cluster pubsub error", "err", err)
+			}
+		}()
+		pubsub = clusterPubSub
+		slog.InfoContext(ctx, "cluster pubsub enabled", "nodeID", clusterPubSub.NodeID(), "listen", clusterListen, "peers", clusterPeers)
+	}
+
+	// 認証設定（AUTH_HMAC_KEY未設定時は匿名接続を許可）
+	var authenticator auth.Authenticator = auth.Anonymous{}
+	if key := utils.GetEnvDefault("AUTH_HMAC_KEY", ""); key != "" {
+		authenticator = auth.NewHMACAuthenticator([]byte(key))
+	} else {
+		slog.WarnContext(ctx, "AUTH_HMAC_KEY is not set, accepting anonymous connections")
+	}
+
+	// WebSocketアップグレード設定
+	upgradeOpts := handler.DefaultUpgradeOptions()
+	// 開発時はViteのdev server（localhost:5173）からの接続を許可する
+	upgradeOpts.AllowedOrigins = utils.GetEnvList("WS_ALLOWED_ORIGINS", []string{"localhost:*"})
+	upgradeOpts.Compression = utils.GetEnvBool("WS_COMPRESSION", false)
+
+	// セッションレジストリ初期化
+	registry := domain.NewSessionRegistry()
 
 	// デフォルトルーム設定（固定のUUID: 00000000-0000-0000-0000-000000000001）
 	defaultRoomID := domain.RoomID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
@@ -44,8 +144,46 @@ func main() {
 		}
 	}()
 
-	handler := server.Route(pubsub, roomManager)
-	s := server.NewServer(fmt.Sprintf("%s:%s", addr, port), handler)
+	runner := handler.NewEndpointRunner(pubsub, roomManager, registry)
+	// デバッグ用: NETSIM を指定した場合、全接続に回線状態（遅延・ロス等）を再現する
+	// 例: NETSIM="latency=80ms,jitter=10ms,loss=0.02,down.bandwidth=64000"
+	if spec := utils.GetEnvDefault("NETSIM", ""); spec != "" {
+		profile, err := netsim.ParseProfile(spec)
+		if err != nil {
+			log.Fatalf("invalid NETSIM: %v", err)
+		}
+		opts := netsim.DefaultOptions()
+		opts.Script = netsim.Static(profile)
+		runner.Use(netsim.Middleware(opts))
+		slog.WarnContext(ctx, "network simulation enabled", "profile", spec)
+	}
+	// CAPTURE_DIR を指定した場合、接続ごとの送受信フレームをキャプチャファイルに記録する
+	// SessionEndpointから見た通りに記録するため、他のミドルウェアより外側に置く
+	if dir := utils.GetEnvDefault("CAPTURE_DIR", ""); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatalf("failed to create CAPTURE_DIR: %v", err)
+		}
+		runner.Use(capture.Middleware(dir))
+		slog.WarnContext(ctx, "session capture enabled", "dir", dir)
+	}
+	// 接続ごとの送受信量・書き込み所要時間・エラーを計測し、/admin/sessions と /metrics で公開する
+	// SessionEndpointから見た値を計測するため、最も外側に置く
+	var transportStats domain.TransportStatsReporter
+	if utils.GetEnvBool("TRANSPORT_METRICS", true) {
+		collector := instrument.NewCollector()
+		runner.Use(collector.Middleware())
+		transportStats = collector
+	}
+	// WebSocketがブロックされる環境向けのHTTPフォールバック（SSE/ロングポーリング）
+	streamHub := adapterhttpstream.NewHub(adapterhttpstream.DefaultOptions())
+	go streamHub.Run(ctx)
+
+	// トピックごとの購読者数・配送数・破棄数を /admin/pubsub と /metrics で公開する
+	pubsubStats, _ := pubsub.(domain.PubSubStatsReporter)
+
+	mux := server.Route(runner, authenticator, upgradeOpts, streamHub)
+	s := server.NewServer(fmt.Sprintf("%s:%s", addr, port), mux)
+	servers := []domain.Server{s}
 
 	go func() {
 		if err := s.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -54,17 +192,139 @@ func main() {
 	}()
 	slog.InfoContext(ctx, "server listening", "addr", addr+":"+port)
 
+	if adminAddr != "" {
+		adminServer := server.NewServer(adminAddr, server.AdminRoute(registry, transportStats, pubsubStats, []*domain.Room{room}))
+		servers = append(servers, adminServer)
+		go func() {
+			if err := adminServer.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("admin server error: %v", err)
+			}
+		}()
+		slog.InfoContext(ctx, "admin server listening", "addr", adminAddr)
+	}
+
+	if tcpPort != "" {
+		// TCP接続は全て匿名で扱うため、認証を有効にしている場合は起動しない（認証を迂回できてしまう）
+		if _, anonymous := authenticator.(auth.Anonymous); !anonymous {
+			log.Fatalf("TCP_PORT cannot be used with AUTH_HMAC_KEY: tcp connections are not authenticated")
+		}
+		tcpServer := adaptertcp.NewServer(fmt.Sprintf("%s:%s", tcpAddr, tcpPort), adaptertcp.DefaultOptions(), runner.HandleTransport)
+		servers = append(servers, tcpServer)
+		go func() {
+			if err := tcpServer.Serve(); err != nil && !errors.Is(err, adaptertcp.ErrServerClosed) {
+				log.Fatalf("tcp server error: %v", err)
+			}
+		}()
+		slog.InfoContext(ctx, "tcp server listening", "addr", tcpAddr+":"+tcpPort)
+	}
+
+	if udpPort != "" {
+		// UDP接続は全て匿名で扱うため、認証を有効にしている場合は起動しない（認証を迂回できてしまう）
+		if _, anonymous := authenticator.(auth.Anonymous); !anonymous {
+			log.Fatalf("UDP_PORT cannot be used with AUTH_HMAC_KEY: udp connections are not authenticated")
+		}
+		udpServer := adapterudp.NewServer(fmt.Sprintf("%s:%s", udpAddr, udpPort), adapterudp.DefaultOptions(), runner.HandleTransport)
+		servers = append(servers, udpServer)
+		go func() {
+			if err := udpServer.Serve(); err != nil && !errors.Is(err, adapterudp.ErrServerClosed) {
+				log.Fatalf("udp server error: %v", err)
+			}
+		}()
+		slog.InfoContext(ctx, "udp server listening", "addr", udpAddr+":"+udpPort)
+	}
+
+	if webTransportPort != "" {
+		// WEBTRANSPORT_CERT_FILE/WEBTRANSPORT_KEY_FILE 未設定時は開発用の自己署名証明書を生成する
+		cert, err := loadWebTransportCertificate(ctx, addr)
+		if err != nil {
+			log.Fatalf("failed to prepare webtransport certificate: %v", err)
+		}
+		wtOpts := adapterwebtransport.DefaultServerOptions()
+		wtOpts.AllowedOrigins = upgradeOpts.AllowedOrigins
+		wtMux := http.NewServeMux()
+		wtServer := adapterwebtransport.NewServer(fmt.Sprintf("%s:%s", addr, webTransportPort), &tls.Config{Certificates: []tls.Certificate{cert}}, wtOpts, wtMux)
+		wtMux.Handle("/wt", handler.NewWebTransportHandler(runner, authenticator, wtServer, upgradeOpts.AllowedOrigins))
+		servers = append(servers, wtServer)
+		go func() {
+			if err := wtServer.Serve(); err != nil && !errors.Is(err, adapterwebtransport.ErrServerClosed) {
+				log.Fatalf("webtransport server error: %v", err)
+			}
+		}()
+		slog.InfoContext(ctx, "webtransport server listening", "addr", addr+":"+webTransportPort)
+	}
+
+	if unixSocket != "" {
+		// 接続元のUIDで信頼を判断する（UNIX_SUPERUSER_UIDS に含まれるUIDはスーパーユーザー）
+		// UNIX_MULTIPLEX_UIDS に含まれるUIDからの接続は多重化接続として扱う
+		policy := adapterunix.TrustPolicy{
+			SuperUserUIDs: parseUIDs(utils.GetEnvList("UNIX_SUPERUSER_UIDS", nil)),
+			MultiplexUIDs: parseUIDs(utils.GetEnvList("UNIX_MULTIPLEX_UIDS", nil)),
+		}
+		unixServer := adapterunix.NewServer(unixSocket, adapterunix.DefaultOptions(), func(ctx context.Context, transport domain.Transport, cred adapterunix.PeerCred, ok bool) {
+			identity := policy.Identity(cred, ok)
+			run := runner.Run
+			if identity.HasRole(domain.RoleMultiplex) {
+				run = runner.RunMultiplexed
+			}
+			if err := run(ctx, identity, transport, "unix:"+cred.String()); err != nil {
+				slog.ErrorContext(ctx, "failed to run session endpoint", "err", err)
+			}
+		})
+		servers = append(servers, unixServer)
+		go func() {
+			if err := unixServer.Serve(); err != nil && !errors.Is(err, adapterunix.ErrServerClosed) {
+				log.Fatalf("unix server error: %v", err)
+			}
+		}()
+		slog.InfoContext(ctx, "unix server listening", "path", unixSocket)
+	}
+
 	<-ctx.Done()
 	slog.InfoContext(ctx, "shutdown initiated")
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := s.Shutdown(shutdownCtx); err != nil {
-		slog.ErrorContext(ctx, "graceful shutdown failed", "error", err)
-		if err := s.Close(); err != nil {
-			slog.ErrorContext(ctx, "forced close failed", "error", err)
+	// WebSocket接続はhttp.Server.Shutdownの対象外のため先に閉じる
+	registry.CloseAll(shutdownCtx)
+
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			slog.ErrorContext(ctx, "graceful shutdown failed", "error", err)
+			if err := s.Close(); err != nil {
+				slog.ErrorContext(ctx, "forced close failed", "error", err)
+			}
 		}
 	}
 	slog.InfoContext(ctx, "server shutdown complete")
 }
+
+// loadWebTransportCertificate はWebTransport用の証明書を読み込みます。
+// ファイルが指定されていない場合は自己署名証明書を生成し、ブラウザのserverCertificateHashesに指定するハッシュを出力します。
+func loadWebTransportCertificate(ctx context.Context, host string) (tls.Certificate, error) {
+	certFile := utils.GetEnvDefault("WEBTRANSPORT_CERT_FILE", "")
+	keyFile := utils.GetEnvDefault("WEBTRANSPORT_KEY_FILE", "")
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	cert, err := adapterwebtransport.GenerateSelfSigned(host)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	hash := adapterwebtransport.CertificateHash(cert)
+	slog.WarnContext(ctx, "using self-signed webtransport certificate", "sha256", base64.StdEncoding.EncodeToString(hash[:]), "validity", adapterwebtransport.SelfSignedValidity)
+	return cert, nil
+}
+
+// parseUIDs はUIDの文字列のリストを変換します。解釈できない値は起動時のエラーとします。
+func parseUIDs(values []string) []uint32 {
+	uids := make([]uint32, 0, len(values))
+	for _, v := range values {
+		uid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatalf("invalid uid %q: %v", v, err)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids
+}
@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"sync"
+	"time"
+)
+
+// BoundedQueue は容量固定のリングバッファによるFIFOキューです。
+// 満杯時のPushは失敗を返し、破棄の扱いは呼び出し側に委ねます。
+type BoundedQueue[T any] struct {
+	mu   sync.Mutex
+	buf  []T
+	head int
+	size int
+
+	// highWatermark 以上の要素が積まれている間、highSinceにその開始時刻を保持する（0 = 計測しない）。
+	// 要素数と同じロックで更新し、PushとPopが並行しても開始時刻が消えないようにする
+	highWatermark int
+	highSince     time.Time
+
+	// notify はPush成功時に待機中の消費者を起こすためのチャネル（容量1）
+	notify chan struct{}
+}
+
+// NewBoundedQueue は指定した容量のBoundedQueueを作成します。
+// capacityが1未満の場合は1として扱います。
+func NewBoundedQueue[T any](capacity int) *BoundedQueue[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &BoundedQueue[T]{
+		buf:    make([]T, capacity),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Push は末尾に要素を追加します。満杯の場合はfalseを返します。
+func (q *BoundedQueue[T]) Push(v T) bool {
+	q.mu.Lock()
+	if q.size == len(q.buf) {
+		q.mu.Unlock()
+		return false
+	}
+	q.buf[(q.head+q.size)%len(q.buf)] = v
+	q.size++
+	if q.highWatermark > 0 && q.size == q.highWatermark {
+		q.highSince = time.Now()
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Pop は先頭の要素を取り出します。空の場合はfalseを返します。
+func (q *BoundedQueue[T]) Pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var zero T
+	if q.size == 0 {
+		return zero, false
+	}
+	v := q.buf[q.head]
+	q.buf[q.head] = zero // 参照を残さない
+	q.head = (q.head + 1) % len(q.buf)
+	q.size--
+	if q.size < q.highWatermark {
+		q.highSince = time.Time{}
+	}
+	return v, true
+}
+
+// SetHighWatermark は高水位とみなす要素数を設定します。キューを使い始める前に呼び出します。
+func (q *BoundedQueue[T]) SetHighWatermark(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.highWatermark = n
+}
+
+// HighSince は要素数が高水位以上になった時刻を返します。高水位未満の場合はゼロ値を返します。
+func (q *BoundedQueue[T]) HighSince() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.highSince
+}
+
+// Len は現在の要素数を返します。
+func (q *BoundedQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Cap はキューの容量を返します。
+func (q *BoundedQueue[T]) Cap() int {
+	return len(q.buf)
+}
+
+// Notify はPushが行われたことを通知するチャネルを返します。
+// 通知は合流されるため、受信後はPopが失敗するまで取り出す必要があります。
+func (q *BoundedQueue[T]) Notify() <-chan struct{} {
+	return q.notify
+}
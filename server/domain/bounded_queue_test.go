@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBoundedQueue_FIFO(t *testing.T) {
+	q := NewBoundedQueue[int](3)
+
+	for i := 1; i <= 3; i++ {
+		if !q.Push(i) {
+			t.Fatalf("Push(%d) failed", i)
+		}
+	}
+	if q.Push(4) {
+		t.Fatal("Push succeeded on full queue")
+	}
+	if q.Len() != 3 {
+		t.Errorf("Len = %d, want 3", q.Len())
+	}
+
+	for i := 1; i <= 3; i++ {
+		v, ok := q.Pop()
+		if !ok || v != i {
+			t.Errorf("Pop = (%d, %v), want (%d, true)", v, ok, i)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("Pop succeeded on empty queue")
+	}
+}
+
+func TestBoundedQueue_WrapAround(t *testing.T) {
+	q := NewBoundedQueue[int](2)
+
+	for i := 0; i < 10; i++ {
+		if !q.Push(i) {
+			t.Fatalf("Push(%d) failed", i)
+		}
+		v, ok := q.Pop()
+		if !ok || v != i {
+			t.Fatalf("Pop = (%d, %v), want (%d, true)", v, ok, i)
+		}
+	}
+}
+
+func TestBoundedQueue_Notify(t *testing.T) {
+	q := NewBoundedQueue[int](4)
+
+	q.Push(1)
+	q.Push(2)
+
+	select {
+	case <-q.Notify():
+	default:
+		t.Fatal("expected notification after Push")
+	}
+	// 通知は合流されるため2回目は届かない
+	select {
+	case <-q.Notify():
+		t.Fatal("unexpected second notification")
+	default:
+	}
+}
+
+func TestBoundedQueue_HighWatermark(t *testing.T) {
+	q := NewBoundedQueue[int](4)
+	q.SetHighWatermark(3)
+
+	q.Push(1)
+	q.Push(2)
+	if !q.HighSince().IsZero() {
+		t.Fatal("HighSince set below watermark")
+	}
+	q.Push(3)
+	since := q.HighSince()
+	if since.IsZero() {
+		t.Fatal("HighSince not set at watermark")
+	}
+	// 高水位以上の間は開始時刻を保つ
+	q.Push(4)
+	q.Pop()
+	if got := q.HighSince(); !got.Equal(since) {
+		t.Errorf("HighSince = %v, want %v", got, since)
+	}
+	q.Pop()
+	if !q.HighSince().IsZero() {
+		t.Error("HighSince not cleared below watermark")
+	}
+}
+
+// PushとPopが並行しても、高水位以上で終わった場合は開始時刻が残る
+func TestBoundedQueue_HighWatermarkConcurrent(t *testing.T) {
+	for range 100 {
+		q := NewBoundedQueue[int](8)
+		q.SetHighWatermark(4)
+		for i := range 4 {
+			q.Push(i)
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			q.Push(0)
+		}()
+		go func() {
+			defer wg.Done()
+			q.Pop()
+		}()
+		wg.Wait()
+		if q.Len() >= 4 && q.HighSince().IsZero() {
+			t.Fatalf("Len = %d but HighSince is zero", q.Len())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package domain
+
+import "fmt"
+
+// CloseReason はサーバーが接続を閉じる理由を表します。
+type CloseReason uint8
+
+const (
+	CloseNormal       CloseReason = iota // 通常終了
+	CloseIdle                            // 無通信タイムアウト
+	CloseSlowConsumer                    // 送信キューが飽和し続けた
+	CloseKicked                          // サーバーによる強制退出
+)
+
+// クローズコード（WebSocketのステータスコードに準拠）
+// 4000-4999 はアプリケーション定義の範囲
+const (
+	CloseCodeNormal       int32 = 1000
+	CloseCodeIdle         int32 = 4000
+	CloseCodeSlowConsumer int32 = 4001
+	CloseCodeKicked       int32 = 4002
+)
+
+// Code はトランスポートに渡すクローズコードを返します。
+func (r CloseReason) Code() int32 {
+	switch r {
+	case CloseIdle:
+		return CloseCodeIdle
+	case CloseSlowConsumer:
+		return CloseCodeSlowConsumer
+	case CloseKicked:
+		return CloseCodeKicked
+	default:
+		return CloseCodeNormal
+	}
+}
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNormal:
+		return "normal"
+	case CloseIdle:
+		return "idle"
+	case CloseSlowConsumer:
+		return "slow consumer"
+	case CloseKicked:
+		return "kicked"
+	default:
+		return fmt.Sprintf("unknown(%d)", r)
+	}
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"sync/atomic"
+	"time"
 )
 
 type ConnectionID uint64
@@ -13,25 +14,79 @@ var connectionIDCounter atomic.Uint64
 type Connection struct {
 	SessionID    SessionID
 	ConnectionID ConnectionID
+	RemoteAddr   string
+	ConnectedAt  time.Time
 	transport    Transport
+
+	// counters: ミドルウェアの有無や多重化によらず、レジストリが送受信量を返せるよう常に数える
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
 }
 
-func NewConnection(sessionID SessionID, transport Transport) *Connection {
+func NewConnection(sessionID SessionID, transport Transport, remoteAddr string) *Connection {
 	return &Connection{
 		SessionID:    sessionID,
 		ConnectionID: ConnectionID(connectionIDCounter.Add(1)),
+		RemoteAddr:   remoteAddr,
+		ConnectedAt:  time.Now(),
 		transport:    transport,
 	}
 }
 
 func (c *Connection) Write(ctx context.Context, data []byte) error {
-	return c.transport.Write(ctx, data)
+	if err := c.transport.Write(ctx, data); err != nil {
+		return err
+	}
+	c.bytesOut.Add(uint64(len(data)))
+	c.messagesOut.Add(1)
+	return nil
 }
 
 func (c *Connection) Read(ctx context.Context) ([]byte, error) {
-	return c.transport.Read(ctx)
+	data, err := c.transport.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.bytesIn.Add(uint64(len(data)))
+	c.messagesIn.Add(1)
+	return data, nil
+}
+
+// Stats は接続の送受信カウンタを返します。
+func (c *Connection) Stats() ConnectionStats {
+	return ConnectionStats{
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		MessagesIn:  c.messagesIn.Load(),
+		MessagesOut: c.messagesOut.Load(),
+	}
+}
+
+// TransportStats はトランスポートが計測値を提供する場合にそのスナップショットを返します。
+// 書き込みの所要時間やエラーの種類はtransport/instrumentで計測した場合のみ得られます。
+func (c *Connection) TransportStats() (TransportStats, bool) {
+	reporter, ok := c.transport.(TransportStatsReporter)
+	if !ok {
+		return TransportStats{}, false
+	}
+	return reporter.TransportStats(), true
 }
 
 func (c *Connection) Close() {
-	_ = c.transport.Close(1000, "")
+	c.CloseWithReason(CloseNormal)
+}
+
+// CloseWithReason は理由に応じたクローズコードで接続を閉じます。
+func (c *Connection) CloseWithReason(reason CloseReason) {
+	_ = c.transport.Close(reason.Code(), reason.String())
+}
+
+// ConnectionStats は接続の送受信カウンタのスナップショットです。
+type ConnectionStats struct {
+	BytesIn     uint64 `json:"bytesIn"`
+	BytesOut    uint64 `json:"bytesOut"`
+	MessagesIn  uint64 `json:"messagesIn"`
+	MessagesOut uint64 `json:"messagesOut"`
 }
@@ -16,9 +16,12 @@ const (
 
 	// ctrl
 	evClose // セッション終了
+	evKick  // サーバーによる強制退出
 )
 
 type endpointEvent struct {
-	kind endpointEventKind
-	err  error
+	kind   endpointEventKind
+	err    error
+	reason CloseReason // evClose のときのみ使用
+	data   []byte      // evKick のときクライアントへ送るKickメッセージ
 }
@@ -0,0 +1,346 @@
+package domain
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogSegmentBytes はファイルのログを分割するセグメントの大きさです。
+const DefaultLogSegmentBytes = 4 << 20
+
+// ErrLogRecordTooLarge はログに追記するメッセージが大きすぎる場合に返されるエラーです。
+var ErrLogRecordTooLarge = errors.New("topic log: record too large")
+
+// errCorruptLogRecord はセグメントの途中に読み取れないレコードがある場合のエラーです。
+var errCorruptLogRecord = errors.New("topic log: corrupt record")
+
+// ファイルのログはディレクトリ内のセグメントファイル（先頭のオフセットを名前にした"<offset>.log"）に追記し、
+// 古いセグメントから削除します。各レコードは次の形式です（リトルエンディアン）。
+//
+//	[size u32] [offset u64] [at i64] [receivedAt i64] [dataType u8] [subType u8] [seq u16]
+//	[sessionIDLen u8] [sessionID] [originLen u8] [origin] [data]
+//
+// sizeはsize自身を除いたレコードの長さです。receivedAtはUnixナノ秒で、0はゼロ値の時刻を表します。
+const logRecordFixedSize = 8 + 8 + 8 + 1 + 1 + 2 + 1 + 1
+
+// logSegment はセグメントファイル1つ分の情報です。
+type logSegment struct {
+	path   string
+	first  uint64
+	last   uint64
+	lastAt time.Time
+	size   int64
+}
+
+// fileTopicLog はセグメントファイルにメッセージを追記するTopicLogです。
+// 書き込みごとのfsyncは行わないため、プロセスの異常終了では直近のメッセージが失われることがあります。
+type fileTopicLog struct {
+	dir          string
+	retention    LogRetention
+	segmentBytes int64
+
+	mu       sync.Mutex
+	segments []logSegment // 古い順。最後が書き込み中のセグメント
+	f        *os.File
+	next     uint64
+}
+
+// OpenFileTopicLog はdirのセグメントファイルにメッセージを保持するTopicLogを開きます。
+// dirに既存のログがあれば続きのオフセットから追記し、末尾の書きかけのレコードは切り詰めます。
+func OpenFileTopicLog(dir string, retention LogRetention) (TopicLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &fileTopicLog{
+		dir:          dir,
+		retention:    retention.withDefaults(),
+		segmentBytes: DefaultLogSegmentBytes,
+		next:         1,
+	}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *fileTopicLog) recover() error {
+	names, err := filepath.Glob(filepath.Join(l.dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	var segments []logSegment
+	for _, path := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".log"), 10, 64)
+		if err != nil {
+			continue // ログ以外のファイル
+		}
+		segments = append(segments, logSegment{path: path, first: first})
+	}
+	slices.SortFunc(segments, func(a, b logSegment) int { return cmp.Compare(a.first, b.first) })
+
+	for i := range segments {
+		seg := &segments[i]
+		err := scanLogSegment(seg.path, func(offset uint64, at time.Time, end int64, _ []byte) bool {
+			seg.last, seg.lastAt, seg.size = offset, at, end
+			return true
+		})
+		if err != nil && !errors.Is(err, errCorruptLogRecord) {
+			return err
+		}
+		// 書きかけのレコードを切り詰める。読めたところまでを有効とする
+		if err := os.Truncate(seg.path, seg.size); err != nil {
+			return err
+		}
+	}
+	// レコードのないセグメントは捨てる
+	for _, seg := range segments {
+		if seg.size == 0 {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+		l.segments = append(l.segments, seg)
+	}
+	if n := len(l.segments); n > 0 {
+		last := l.segments[n-1]
+		l.next = last.last + 1
+		f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		l.f = f
+	}
+	return nil
+}
+
+func (l *fileTopicLog) Append(msg Message, at time.Time) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	offset := l.next
+	rec, err := encodeLogRecord(offset, at, msg)
+	if err != nil {
+		return 0, err
+	}
+	// 空のセグメントには大きさにかかわらず書く
+	if l.f == nil || l.full(len(rec)) {
+		if err := l.rotate(offset); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := l.f.Write(rec); err != nil {
+		return 0, err
+	}
+	seg := &l.segments[len(l.segments)-1]
+	seg.last, seg.lastAt = offset, at
+	seg.size += int64(len(rec))
+	l.next++
+	l.trim(at)
+	return offset, nil
+}
+
+func (l *fileTopicLog) full(n int) bool {
+	seg := l.segments[len(l.segments)-1]
+	return seg.size > 0 && seg.size+int64(n) > l.segmentBytes
+}
+
+// rotate は書き込み中のセグメントを閉じ、firstから始まる新しいセグメントを作ります。
+func (l *fileTopicLog) rotate(first uint64) error {
+	if l.f != nil {
+		if err := l.f.Close(); err != nil {
+			return err
+		}
+		l.f = nil
+	}
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d.log", first))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.segments = append(l.segments, logSegment{path: path, first: first})
+	return nil
+}
+
+// trim は全てのレコードが保持範囲外になったセグメントを削除します。書き込み中のセグメントは残します。
+func (l *fileTopicLog) trim(now time.Time) {
+	head := l.next - 1
+	for len(l.segments) > 1 {
+		seg := l.segments[0]
+		if l.retention.retained(seg.last, head, seg.lastAt, now) {
+			return
+		}
+		// 削除に失敗しても読み出しでは保持範囲外として扱われる
+		_ = os.Remove(seg.path)
+		l.segments = l.segments[1:]
+	}
+}
+
+func (l *fileTopicLog) Read(start StartPosition, fn func(Message) bool) error {
+	if start.IsLatest() {
+		return nil
+	}
+	now := time.Now()
+	// ファイルの読み出し中もAppendを止めないよう、呼び出し時点のセグメントの一覧だけを取る
+	l.mu.Lock()
+	segments := slices.Clone(l.segments)
+	head := l.next - 1
+	l.mu.Unlock()
+
+	for i, seg := range segments {
+		if start.kind == startOffset && seg.last < start.offset {
+			continue
+		}
+		done := false
+		err := scanLogSegment(seg.path, func(offset uint64, at time.Time, _ int64, rec []byte) bool {
+			if offset > head {
+				done = true
+				return false
+			}
+			if !start.includes(offset, at) || !l.retention.retained(offset, head, at, now) {
+				return true
+			}
+			msg, err := decodeLogRecord(rec)
+			if err != nil {
+				return true
+			}
+			if !fn(msg) {
+				done = true
+				return false
+			}
+			return true
+		})
+		if errors.Is(err, fs.ErrNotExist) && i < len(segments)-1 {
+			// 読み出し中に保持範囲外になり削除された
+			continue
+		}
+		if err != nil && !errors.Is(err, errCorruptLogRecord) {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *fileTopicLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// scanLogSegment はセグメントのレコードを先頭から順にfnに渡します。endはレコードの終わりのファイル上の位置、
+// recはsizeを除いたレコードです（fnの呼び出し後は再利用されます）。
+// 末尾が書きかけの場合や読み取れないレコードがある場合はerrCorruptLogRecordを返します。
+func scanLogSegment(path string, fn func(offset uint64, at time.Time, end int64, rec []byte) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var (
+		pos  int64
+		size [4]byte
+		rec  []byte
+	)
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errCorruptLogRecord
+		}
+		n := int(binary.LittleEndian.Uint32(size[:]))
+		if n < logRecordFixedSize {
+			return errCorruptLogRecord
+		}
+		rec = slices.Grow(rec[:0], n)[:n]
+		if _, err := io.ReadFull(r, rec); err != nil {
+			return errCorruptLogRecord
+		}
+		pos += int64(4 + n)
+		offset := binary.LittleEndian.Uint64(rec[0:8])
+		at := time.Unix(0, int64(binary.LittleEndian.Uint64(rec[8:16])))
+		if !fn(offset, at, pos, rec) {
+			return nil
+		}
+	}
+}
+
+func encodeLogRecord(offset uint64, at time.Time, msg Message) ([]byte, error) {
+	if len(msg.SessionID) > math.MaxUint8 || len(msg.Origin()) > math.MaxUint8 {
+		return nil, ErrLogRecordTooLarge
+	}
+	n := logRecordFixedSize + len(msg.SessionID) + len(msg.Origin()) + len(msg.Data)
+	if uint64(n) > math.MaxUint32 {
+		return nil, ErrLogRecordTooLarge
+	}
+	b := make([]byte, 0, 4+n)
+	b = binary.LittleEndian.AppendUint32(b, uint32(n))
+	b = binary.LittleEndian.AppendUint64(b, offset)
+	b = binary.LittleEndian.AppendUint64(b, uint64(at.UnixNano()))
+	var receivedAt int64
+	if !msg.ReceivedAt.IsZero() {
+		receivedAt = msg.ReceivedAt.UnixNano()
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(receivedAt))
+	b = append(b, byte(msg.DataType), msg.SubType)
+	b = binary.LittleEndian.AppendUint16(b, msg.Seq)
+	b = append(b, byte(len(msg.SessionID)))
+	b = append(b, msg.SessionID...)
+	b = append(b, byte(len(msg.Origin())))
+	b = append(b, msg.Origin()...)
+	return append(b, msg.Data...), nil
+}
+
+// decodeLogRecord はsizeを除いたレコードからメッセージを復元します。Dataはrecを複製したものです。
+func decodeLogRecord(rec []byte) (Message, error) {
+	msg := Message{Offset: binary.LittleEndian.Uint64(rec[0:8])}
+	if receivedAt := int64(binary.LittleEndian.Uint64(rec[16:24])); receivedAt != 0 {
+		msg.ReceivedAt = time.Unix(0, receivedAt)
+	}
+	msg.DataType = DataType(rec[24])
+	msg.SubType = rec[25]
+	msg.Seq = binary.LittleEndian.Uint16(rec[26:28])
+	b := rec[28:]
+	m := int(b[0])
+	if len(b) < 1+m+1 {
+		return Message{}, errCorruptLogRecord
+	}
+	msg.SessionID = SessionID(b[1 : 1+m])
+	b = b[1+m:]
+	o := int(b[0])
+	if len(b) < 1+o {
+		return Message{}, errCorruptLogRecord
+	}
+	if o > 0 {
+		msg.Routing = &MessageRouting{Origin: string(b[1 : 1+o])}
+	}
+	msg.Data = slices.Clone(b[1+o:])
+	return msg, nil
+}
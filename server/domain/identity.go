@@ -0,0 +1,27 @@
+package domain
+
+import "slices"
+
+const (
+	// RoleSuperUser はボーンデータ・位置を直接送信するスーパーユーザーのロールです（protocol.md「ユーザーの種類」）。
+	RoleSuperUser = "superuser"
+	// RoleMultiplex は1つの接続で複数のセッションを多重化できるロールです（負荷試験・ボット用）。
+	RoleMultiplex = "multiplex"
+)
+
+// Identity は認証済みユーザーの識別情報を表します。
+// 認証を行わない接続ではゼロ値（匿名）になります。
+type Identity struct {
+	UserID string   `json:"userId,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// IsAnonymous は認証されていない接続かどうかを判定します。
+func (i Identity) IsAnonymous() bool {
+	return i.UserID == ""
+}
+
+// HasRole は指定したロールを持っているかどうかを判定します。
+func (i Identity) HasRole(role string) bool {
+	return slices.Contains(i.Roles, role)
+}
@@ -0,0 +1,270 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogPubSubOptions はLogPubSubの設定です。
+type LogPubSubOptions struct {
+	// Topics はログを残すトピックのパターンです。マッチしないトピックは内側のPubSubにそのまま渡します。
+	// ルームのブロードキャスト（Room.Broadcast）を残す場合は"multicast:*"、ルーム宛のメッセージも
+	// 残す場合は"room:*"も指定します（RoomMulticastTopicとRoomTopicを参照）。
+	Topics []TopicPattern
+	// Retention はトピックごとのログを保持する範囲です。
+	Retention LogRetention
+	// Dir が空でない場合、トピックごとのサブディレクトリにファイルでログを残します（再起動後も続きから追記します）。
+	// 空の場合はメモリに残します。
+	Dir string
+}
+
+// LogPubSub はPubSubをラップし、指定したトピックにPublishされたメッセージをログに残すPubSubの実装です。
+//
+// ログを残すトピックのメッセージにはオフセット（Message.Offset）が付き、SubscribeOptions.Startで
+// 過去のメッセージから購読できます（途中参加の観戦者や録画など）。
+// ルームの出力を録画する場合はRoomMulticastTopicのトピックをログに残し、そのトピックを購読します。
+// 同じトピックへのPublishはログへの追記と配送の順序を揃えるため直列化されます。
+//
+// Retention.MaxAgeを指定した場合、購読者がおらず最新のメッセージがMaxAgeより古いトピックのログは
+// 閉じて破棄します（保持しているメッセージは全て期限切れのため）。購読者の有無は内側のPubSubの計測値で判断し、
+// 計測値を提供しない場合は破棄しません。破棄したトピックに再びPublishするとログを開き直し、
+// メモリに残す場合はオフセットが1からやり直しになります。
+type LogPubSub struct {
+	inner PubSub
+	// opts は作成後に変更しない。ログを残さないトピックはロックを取らずにoptsで判定する
+	opts LogPubSubOptions
+	// closed はCloseが呼ばれるとtrueになり、以降はログを残さない
+	closed atomic.Bool
+
+	mu   sync.Mutex
+	logs map[Topic]*loggedTopic
+	// lastEvict は最後に破棄するログを探した時刻です
+	lastEvict time.Time
+}
+
+// loggedTopic はトピックのログです。logがnilの場合、ログを開けなかったか、破棄・Closeされたためログを残しません。
+type loggedTopic struct {
+	// mu はログへの追記と配送を直列化し、オフセットの順に配送されるようにします
+	mu  sync.Mutex
+	log TopicLog
+	// newest は最後に追記した時刻（追記していない場合はログを開いた時刻）です
+	newest time.Time
+}
+
+// NewLogPubSub はinnerに配送し、opts.Topicsにマッチするトピックのログを残すLogPubSubを作成します。
+func NewLogPubSub(inner PubSub, opts LogPubSubOptions) *LogPubSub {
+	return &LogPubSub{
+		inner: inner,
+		opts:  opts,
+		logs:  make(map[Topic]*loggedTopic),
+	}
+}
+
+// logFor はトピックのログを返します。ログを残さないトピックの場合はnilを返します。
+// セッション宛など大半のトピックはログを残さないため、パターンの判定はロックの外で行います。
+func (p *LogPubSub) logFor(topic Topic) *loggedTopic {
+	if !p.logged(topic) || p.closed.Load() {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed.Load() {
+		return nil
+	}
+	now := time.Now()
+	p.evictLocked(now)
+	if l, ok := p.logs[topic]; ok {
+		if l.log == nil {
+			return nil
+		}
+		return l
+	}
+	l := &loggedTopic{newest: now}
+	if p.opts.Dir == "" {
+		l.log = NewMemoryTopicLog(p.opts.Retention)
+	} else {
+		log, err := OpenFileTopicLog(filepath.Join(p.opts.Dir, url.PathEscape(string(topic))), p.opts.Retention)
+		if err != nil {
+			// 開けなかったトピックは破棄されるまでログを残さない（Publishのたびに開き直さない）
+			slog.Error("pub/sub: failed to open topic log", "topic", topic, "err", err)
+			p.logs[topic] = l
+			return nil
+		}
+		l.log = log
+	}
+	p.logs[topic] = l
+	return l
+}
+
+// evictLocked は購読者がおらず、最新のメッセージがMaxAgeより古いトピックのログを閉じて破棄します。
+// 内側のPubSubの計測値を集めるため、探すのはMaxAgeに1回だけです。p.muを保持して呼び出します。
+func (p *LogPubSub) evictLocked(now time.Time) {
+	maxAge := p.opts.Retention.MaxAge
+	if maxAge <= 0 || now.Sub(p.lastEvict) < maxAge {
+		return
+	}
+	reporter, ok := p.inner.(PubSubStatsReporter)
+	if !ok {
+		return
+	}
+	p.lastEvict = now
+
+	subscribed := make(map[Topic]struct{})
+	for _, t := range reporter.PubSubStats().Topics {
+		if t.Subscribers > 0 {
+			subscribed[t.Topic] = struct{}{}
+		}
+	}
+	for topic, l := range p.logs {
+		if _, ok := subscribed[topic]; ok {
+			continue
+		}
+		// Publish中のトピックは使われているため破棄しない
+		if !l.mu.TryLock() {
+			continue
+		}
+		if now.Sub(l.newest) > maxAge {
+			if l.log != nil {
+				if err := l.log.Close(); err != nil {
+					slog.Warn("pub/sub: failed to close topic log", "topic", topic, "err", err)
+				}
+				l.log = nil
+			}
+			delete(p.logs, topic)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (p *LogPubSub) logged(topic Topic) bool {
+	for _, pattern := range p.opts.Topics {
+		if pattern.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe はトピックを購読します。ログを残すトピックでopts.Startを指定した場合、
+// 開始位置から保持しているメッセージを受け取った後、新しいメッセージを続けて受け取ります。
+func (p *LogPubSub) Subscribe(ctx context.Context, topic Topic, opts SubscribeOptions) *Subscription {
+	if opts.Start.IsLatest() {
+		return p.inner.Subscribe(ctx, topic, opts)
+	}
+	l := p.logFor(topic)
+	if l == nil {
+		return p.inner.Subscribe(ctx, topic, opts)
+	}
+	l.mu.Lock()
+	log := l.log
+	l.mu.Unlock()
+	if log == nil {
+		return p.inner.Subscribe(ctx, topic, opts)
+	}
+
+	// 先に購読を始め、ログの読み出し中にPublishされたメッセージも取りこぼさないようにする
+	live := p.inner.Subscribe(ctx, topic, opts)
+	sub := NewSubscription(ctx, topic, SubscribeOptions{BufferSize: opts.BufferSize}, func(*Subscription) { live.Close() })
+	go p.replay(log, opts.Start, live, sub)
+	return sub
+}
+
+// replay はログのメッセージをsubに送った後、liveのメッセージを転送します。
+// ログから送ったメッセージと重複するものはオフセットで除きます。
+func (p *LogPubSub) replay(log TopicLog, start StartPosition, live, sub *Subscription) {
+	defer sub.Close()
+
+	var last uint64
+	err := log.Read(start, func(msg Message) bool {
+		msg.Topic = sub.Topic()
+		last = msg.Offset
+		return sub.send(msg)
+	})
+	if err != nil {
+		slog.Warn("pub/sub: failed to read topic log", "topic", sub.Topic(), "err", err)
+	}
+	for msg := range live.C() {
+		if msg.Offset != 0 && msg.Offset <= last {
+			msg.Payload.Release()
+			continue
+		}
+		if !sub.send(msg) {
+			msg.Payload.Release()
+			return
+		}
+	}
+}
+
+// SubscribePattern はpatternにマッチする全てのトピックを購読します。過去のメッセージは受け取りません。
+func (p *LogPubSub) SubscribePattern(ctx context.Context, pattern TopicPattern, opts SubscribeOptions) *Subscription {
+	return p.inner.SubscribePattern(ctx, pattern, opts)
+}
+
+// Publish はログを残すトピックであればログに追記してオフセットを付け、内側のPubSubに配送します。
+// ログへの追記に失敗した場合も配送は行います（オフセットは付きません）。
+func (p *LogPubSub) Publish(ctx context.Context, topic Topic, msg Message) {
+	for {
+		l := p.logFor(topic)
+		if l == nil {
+			p.inner.Publish(ctx, topic, msg)
+			return
+		}
+		if p.publishLogged(ctx, l, topic, msg) {
+			return
+		}
+		// logForの後に破棄またはCloseされたため、ログを取り直す（Close後はlogForがnilを返す）
+	}
+}
+
+// publishLogged はlのログに追記して配送します。ログが破棄・Closeされていた場合は何もせずにfalseを返します。
+func (p *LogPubSub) publishLogged(ctx context.Context, l *loggedTopic, topic Topic, msg Message) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.log == nil {
+		return false
+	}
+	now := time.Now()
+	offset, err := l.log.Append(msg, now)
+	if err != nil {
+		slog.WarnContext(ctx, "pub/sub: failed to append to topic log", "topic", topic, "err", err)
+	} else {
+		msg.Offset = offset
+		l.newest = now
+	}
+	p.inner.Publish(ctx, topic, msg)
+	return true
+}
+
+// PubSubStats は内側のPubSubの計測値を返します。計測値を提供しない場合はゼロ値を返します。
+func (p *LogPubSub) PubSubStats() PubSubStats {
+	if reporter, ok := p.inner.(PubSubStatsReporter); ok {
+		return reporter.PubSubStats()
+	}
+	return PubSubStats{}
+}
+
+// Close は全てのトピックのログを閉じます。Close後のPublishはログを残さずに配送します。
+func (p *LogPubSub) Close() error {
+	p.closed.Store(true)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var firstErr error
+	for topic, l := range p.logs {
+		if l.log == nil {
+			continue
+		}
+		l.mu.Lock()
+		if err := l.log.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		l.log = nil
+		l.mu.Unlock()
+		delete(p.logs, topic)
+	}
+	return firstErr
+}
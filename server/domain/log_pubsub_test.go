@@ -0,0 +1,253 @@
+package domain
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLogPubSub(t *testing.T, dir string) *LogPubSub {
+	t.Helper()
+	pattern, err := ParseTopicPattern("room:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := NewLogPubSub(NewSimplePubSub(), LogPubSubOptions{Topics: []TopicPattern{pattern}, Dir: dir})
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+func TestLogPubSub_ReplayFromOffset(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dir  func(t *testing.T) string
+	}{
+		{"memory", func(*testing.T) string { return "" }},
+		{"file", func(t *testing.T) string { return t.TempDir() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ps := newTestLogPubSub(t, tc.dir(t))
+			ctx := context.Background()
+			for i := range 5 {
+				ps.Publish(ctx, "room:a", Message{Seq: uint16(i + 1)})
+			}
+
+			sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{BufferSize: 16, Start: StartAtOffset(3)})
+			defer sub.Close()
+			ps.Publish(ctx, "room:a", Message{Seq: 6})
+
+			msgs := receiveAll(t, sub, 4)
+			for i, msg := range msgs {
+				if want := uint64(i + 3); msg.Offset != want || msg.Seq != uint16(want) || msg.Topic != "room:a" {
+					t.Errorf("msgs[%d] = offset %d seq %d topic %q, want offset/seq %d", i, msg.Offset, msg.Seq, msg.Topic, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogPubSub_ReplayFromTime(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+	ps.Publish(ctx, "room:a", Message{Seq: 1})
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	ps.Publish(ctx, "room:a", Message{Seq: 2})
+
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{Start: StartAtTime(since)})
+	defer sub.Close()
+	if msgs := receiveAll(t, sub, 1); msgs[0].Seq != 2 {
+		t.Errorf("Seq = %d, want 2", msgs[0].Seq)
+	}
+}
+
+// ログの読み出し中にPublishされても、重複や欠落なくオフセット順に受け取れる
+func TestLogPubSub_ReplayConcurrentPublish(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+	const before, during = 100, 100
+	for range before {
+		ps.Publish(ctx, "room:a", Message{})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range during {
+			ps.Publish(ctx, "room:a", Message{})
+		}
+	}()
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{BufferSize: before + during, Start: StartAtOffset(1)})
+	defer sub.Close()
+	wg.Wait()
+
+	// 購読開始より後にPublishされた分を含め、最後のオフセットまで1つずつ増える
+	var last uint64
+	for last < before+during {
+		select {
+		case msg := <-sub.C():
+			if msg.Offset != last+1 {
+				t.Fatalf("Offset = %d after %d", msg.Offset, last)
+			}
+			last = msg.Offset
+		case <-time.After(time.Second):
+			t.Fatalf("last offset = %d, want %d", last, before+during)
+		}
+	}
+}
+
+func TestLogPubSub_PassThrough(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+
+	// ログを残さないトピックはStartを指定しても過去のメッセージを受け取らず、オフセットも付かない
+	ps.Publish(ctx, "session:a", Message{})
+	unlogged := ps.Subscribe(ctx, "session:a", SubscribeOptions{Start: StartAtOffset(1)})
+	defer unlogged.Close()
+	// StartLatestはこれからのメッセージだけを受け取る
+	ps.Publish(ctx, "room:a", Message{})
+	latest := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	defer latest.Close()
+
+	ps.Publish(ctx, "session:a", Message{Seq: 1})
+	ps.Publish(ctx, "room:a", Message{Seq: 1})
+	if msg := receiveAll(t, unlogged, 1)[0]; msg.Seq != 1 || msg.Offset != 0 {
+		t.Errorf("unlogged msg = seq %d offset %d, want seq 1 offset 0", msg.Seq, msg.Offset)
+	}
+	if msg := receiveAll(t, latest, 1)[0]; msg.Seq != 1 || msg.Offset != 2 {
+		t.Errorf("latest msg = seq %d offset %d, want seq 1 offset 2", msg.Seq, msg.Offset)
+	}
+}
+
+func TestLogPubSub_CloseSubscription(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+	ps.Publish(ctx, "room:a", Message{})
+
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{Start: StartAtOffset(1)})
+	receiveAll(t, sub, 1)
+	sub.Close()
+
+	// 内側の購読も閉じられる
+	deadline := time.Now().Add(time.Second)
+	for ps.PubSubStats().Topics != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("topics = %+v, want none", ps.PubSubStats().Topics)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLogPubSub_EvictsIdleTopics(t *testing.T) {
+	pattern, err := ParseTopicPattern("room:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const maxAge = 20 * time.Millisecond
+	ps := NewLogPubSub(NewSimplePubSub(), LogPubSubOptions{Topics: []TopicPattern{pattern}, Retention: LogRetention{MaxAge: maxAge}})
+	defer ps.Close()
+	ctx := context.Background()
+
+	ps.Publish(ctx, "room:idle", Message{})
+	ps.Publish(ctx, "room:watched", Message{})
+	watcher := ps.Subscribe(ctx, "room:watched", SubscribeOptions{BufferSize: 4})
+	defer watcher.Close()
+	time.Sleep(2 * maxAge)
+
+	// 次のPublishで、購読者のいない古いトピックのログだけが破棄される
+	ps.Publish(ctx, "room:new", Message{})
+	ps.mu.Lock()
+	_, idle := ps.logs["room:idle"]
+	_, watched := ps.logs["room:watched"]
+	n := len(ps.logs)
+	ps.mu.Unlock()
+	if idle || !watched || n != 2 {
+		t.Errorf("logs: idle=%v watched=%v len=%d, want only room:watched and room:new", idle, watched, n)
+	}
+
+	// 購読者のいるトピックはオフセットが続き、破棄したトピックはログを開き直す
+	ps.Publish(ctx, "room:watched", Message{})
+	if msg := receiveAll(t, watcher, 1)[0]; msg.Offset != 2 {
+		t.Errorf("watched offset = %d, want 2", msg.Offset)
+	}
+	ps.Publish(ctx, "room:idle", Message{Seq: 1})
+	sub := ps.Subscribe(ctx, "room:idle", SubscribeOptions{BufferSize: 4, Start: StartAtOffset(1)})
+	defer sub.Close()
+	if msg := receiveAll(t, sub, 1)[0]; msg.Seq != 1 || msg.Offset != 1 {
+		t.Errorf("idle msg = seq %d offset %d, want seq 1 offset 1", msg.Seq, msg.Offset)
+	}
+}
+
+// TestLogPubSub_ReplayRoomBroadcast はルームのブロードキャストをマルチキャストのトピックのログから再生できることを確認します。
+func TestLogPubSub_ReplayRoomBroadcast(t *testing.T) {
+	pattern, err := ParseTopicPattern("multicast:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := NewLogPubSub(NewSimplePubSub(), LogPubSubOptions{Topics: []TopicPattern{pattern}})
+	defer ps.Close()
+	ctx := context.Background()
+	id := RoomID{1}
+	room := NewRoom(id, ps, nil)
+
+	room.Broadcast(ctx, []byte("first"))
+	room.Broadcast(ctx, []byte("second"), NewSessionID())
+
+	// 途中から参加した観戦者が過去のブロードキャストを受け取る
+	sub := ps.Subscribe(ctx, RoomMulticastTopic(id), SubscribeOptions{BufferSize: 4, Start: StartAtOffset(1)})
+	defer sub.Close()
+	room.Broadcast(ctx, []byte("third"))
+
+	for i, msg := range receiveAll(t, sub, 3) {
+		want := []string{"first", "second", "third"}[i]
+		if string(msg.Data) != want || msg.Offset != uint64(i+1) || msg.Topic != RoomMulticastTopic(id) {
+			t.Errorf("msgs[%d] = %q offset %d topic %q, want %q offset %d", i, msg.Data, msg.Offset, msg.Topic, want, i+1)
+		}
+	}
+}
+
+// TestLogPubSub_UnloggedTopicsSkipLock はログを残さないトピックのPublishがLogPubSubのロックを取らないことを確認します。
+func TestLogPubSub_UnloggedTopicsSkipLock(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+	sub := ps.Subscribe(ctx, "session:a", SubscribeOptions{BufferSize: 1})
+	defer sub.Close()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		ps.Publish(ctx, "session:a", Message{Seq: 1})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish to an unlogged topic waited for the lock")
+	}
+	if msg := receiveAll(t, sub, 1)[0]; msg.Seq != 1 || msg.Offset != 0 {
+		t.Errorf("msg = seq %d offset %d, want seq 1 offset 0", msg.Seq, msg.Offset)
+	}
+}
+
+func TestLogPubSub_PublishAfterClose(t *testing.T) {
+	ps := newTestLogPubSub(t, "")
+	ctx := context.Background()
+	ps.Publish(ctx, "room:a", Message{})
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{BufferSize: 1})
+	defer sub.Close()
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Close後は配送だけを行い、ログを開き直さない
+	ps.Publish(ctx, "room:a", Message{Seq: 1})
+	if msg := receiveAll(t, sub, 1)[0]; msg.Seq != 1 || msg.Offset != 0 {
+		t.Errorf("msg = seq %d offset %d, want seq 1 offset 0", msg.Seq, msg.Offset)
+	}
+	if len(ps.logs) != 0 {
+		t.Errorf("logs = %d after Close, want 0", len(ps.logs))
+	}
+}
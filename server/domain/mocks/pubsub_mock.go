@@ -54,27 +54,29 @@ func (mr *MockPubSubMockRecorder) Publish(ctx, topic, msg any) *gomock.Call {
 }
 
 // Subscribe mocks base method.
-func (m *MockPubSub) Subscribe(topic domain.Topic) <-chan domain.Message {
+func (m *MockPubSub) Subscribe(ctx context.Context, topic domain.Topic, opts domain.SubscribeOptions) *domain.Subscription {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Subscribe", topic)
-	ret0, _ := ret[0].(<-chan domain.Message)
+	ret := m.ctrl.Call(m, "Subscribe", ctx, topic, opts)
+	ret0, _ := ret[0].(*domain.Subscription)
 	return ret0
 }
 
 // Subscribe indicates an expected call of Subscribe.
-func (mr *MockPubSubMockRecorder) Subscribe(topic any) *gomock.Call {
+func (mr *MockPubSubMockRecorder) Subscribe(ctx, topic, opts any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockPubSub)(nil).Subscribe), topic)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockPubSub)(nil).Subscribe), ctx, topic, opts)
 }
 
-// Unsubscribe mocks base method.
-func (m *MockPubSub) Unsubscribe(topic domain.Topic, ch <-chan domain.Message) {
+// SubscribePattern mocks base method.
+func (m *MockPubSub) SubscribePattern(ctx context.Context, pattern domain.TopicPattern, opts domain.SubscribeOptions) *domain.Subscription {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Unsubscribe", topic, ch)
+	ret := m.ctrl.Call(m, "SubscribePattern", ctx, pattern, opts)
+	ret0, _ := ret[0].(*domain.Subscription)
+	return ret0
 }
 
-// Unsubscribe indicates an expected call of Unsubscribe.
-func (mr *MockPubSubMockRecorder) Unsubscribe(topic, ch any) *gomock.Call {
+// SubscribePattern indicates an expected call of SubscribePattern.
+func (mr *MockPubSubMockRecorder) SubscribePattern(ctx, pattern, opts any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsubscribe", reflect.TypeOf((*MockPubSub)(nil).Unsubscribe), topic, ch)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribePattern", reflect.TypeOf((*MockPubSub)(nil).SubscribePattern), ctx, pattern, opts)
 }
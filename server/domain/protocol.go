@@ -3,6 +3,7 @@ package domain
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
 	"time"
 )
@@ -14,6 +15,22 @@ const (
 	HeaderSize        = 25
 	PayloadHeaderSize = 2
 	JoinPayloadSize   = 16
+
+	// MaxFrameSize は1メッセージの最大サイズ（Header.Lengthがu16のため）
+	MaxFrameSize = HeaderSize + math.MaxUint16
+)
+
+// Headerの各フィールドのフレーム先頭からの位置。[Start, End)の範囲に格納される
+const (
+	HeaderVersionOffset  = 0
+	HeaderSessionIDStart = 1
+	HeaderSessionIDEnd   = HeaderSessionIDStart + 16
+	HeaderSeqStart       = HeaderSessionIDEnd
+	HeaderSeqEnd         = HeaderSeqStart + 2
+	HeaderLengthStart    = HeaderSeqEnd
+	HeaderLengthEnd      = HeaderLengthStart + 2
+	HeaderTimestampStart = HeaderLengthEnd
+	HeaderTimestampEnd   = HeaderTimestampStart + 4
 )
 
 // Header はメッセージヘッダー (25バイト)
@@ -84,25 +101,25 @@ func ParseHeader(data []byte) (*Header, error) {
 	}
 
 	var sessionID [16]byte
-	copy(sessionID[:], data[1:17])
+	copy(sessionID[:], data[HeaderSessionIDStart:HeaderSessionIDEnd])
 
 	return &Header{
-		Version:   data[0],
+		Version:   data[HeaderVersionOffset],
 		SessionID: sessionID,
-		Seq:       byteOrder.Uint16(data[17:19]),
-		Length:    byteOrder.Uint16(data[19:21]),
-		Timestamp: byteOrder.Uint32(data[21:25]),
+		Seq:       byteOrder.Uint16(data[HeaderSeqStart:HeaderSeqEnd]),
+		Length:    byteOrder.Uint16(data[HeaderLengthStart:HeaderLengthEnd]),
+		Timestamp: byteOrder.Uint32(data[HeaderTimestampStart:HeaderTimestampEnd]),
 	}, nil
 }
 
 // Encode はHeaderをバイト列にエンコードする
 func (h *Header) Encode() []byte {
 	data := make([]byte, HeaderSize)
-	data[0] = h.Version
-	copy(data[1:17], h.SessionID[:])
-	byteOrder.PutUint16(data[17:19], h.Seq)
-	byteOrder.PutUint16(data[19:21], h.Length)
-	byteOrder.PutUint32(data[21:25], h.Timestamp)
+	data[HeaderVersionOffset] = h.Version
+	copy(data[HeaderSessionIDStart:HeaderSessionIDEnd], h.SessionID[:])
+	byteOrder.PutUint16(data[HeaderSeqStart:HeaderSeqEnd], h.Seq)
+	byteOrder.PutUint16(data[HeaderLengthStart:HeaderLengthEnd], h.Length)
+	byteOrder.PutUint32(data[HeaderTimestampStart:HeaderTimestampEnd], h.Timestamp)
 	return data
 }
 
@@ -147,6 +164,95 @@ func EncodeAssignMessage(sessionID SessionID) []byte {
 	return data
 }
 
+// EncodeControlMessage はControlメッセージにHeader+PayloadHeaderを付与してエンコードする
+func EncodeControlMessage(sessionID SessionID, subType ControlSubType, payload []byte) []byte {
+	header := Header{
+		Version:   1,
+		SessionID: sessionID.Bytes(),
+		Seq:       0,
+		Length:    uint16(PayloadHeaderSize + len(payload)),
+		Timestamp: uint32(time.Now().UnixMilli() & 0xFFFFFFFF),
+	}
+	payloadHeader := PayloadHeader{
+		DataType: DataTypeControl,
+		SubType:  uint8(subType),
+	}
+
+	data := make([]byte, HeaderSize+PayloadHeaderSize+len(payload))
+	copy(data[:HeaderSize], header.Encode())
+	copy(data[HeaderSize:HeaderSize+PayloadHeaderSize], payloadHeader.Encode())
+	copy(data[HeaderSize+PayloadHeaderSize:], payload)
+	return data
+}
+
+// IsControlMessage はdataが指定したサブタイプのControlメッセージかどうかを判定する
+func IsControlMessage(data []byte, subType ControlSubType) bool {
+	if len(data) < HeaderSize+PayloadHeaderSize {
+		return false
+	}
+	return DataType(data[HeaderSize]) == DataTypeControl && ControlSubType(data[HeaderSize+1]) == subType
+}
+
+// KickReason は強制退出の理由コード
+type KickReason uint8
+
+const (
+	KickReasonUnspecified     KickReason = 0
+	KickReasonAdmin           KickReason = 1 // 管理者操作
+	KickReasonRoomClosed      KickReason = 2 // ルームの終了
+	KickReasonPolicyViolation KickReason = 3 // 規約違反
+	KickReasonServerShutdown  KickReason = 4 // サーバー停止
+)
+
+func (r KickReason) String() string {
+	switch r {
+	case KickReasonUnspecified:
+		return "unspecified"
+	case KickReasonAdmin:
+		return "admin"
+	case KickReasonRoomClosed:
+		return "room closed"
+	case KickReasonPolicyViolation:
+		return "policy violation"
+	case KickReasonServerShutdown:
+		return "server shutdown"
+	default:
+		return fmt.Sprintf("unknown(%d)", r)
+	}
+}
+
+// KickPayloadSize はKickPayloadのサイズ
+const KickPayloadSize = 1
+
+// KickPayload は強制退出メッセージのペイロード (1バイト)
+// サーバーからクライアントへの一方向のみ使用する
+//
+//	reason  u8  (1)  - 理由コード
+type KickPayload struct {
+	Reason KickReason
+}
+
+var ErrInvalidKickPayloadSize = errors.New("invalid kick payload size")
+
+// ParseKickPayload はバイト列からKickPayloadをパースする
+func ParseKickPayload(data []byte) (*KickPayload, error) {
+	if len(data) < KickPayloadSize {
+		return nil, ErrInvalidKickPayloadSize
+	}
+	return &KickPayload{Reason: KickReason(data[0])}, nil
+}
+
+// Encode はKickPayloadをバイト列にエンコードする
+func (k *KickPayload) Encode() []byte {
+	return []byte{byte(k.Reason)}
+}
+
+// EncodeKickMessage は強制退出メッセージをエンコードする
+func EncodeKickMessage(sessionID SessionID, reason KickReason) []byte {
+	payload := KickPayload{Reason: reason}
+	return EncodeControlMessage(sessionID, ControlSubTypeKick, payload.Encode())
+}
+
 // JoinPayload はルーム参加メッセージのペイロード (16バイト)
 //
 //	roomID  [16]byte  - ルームID (UUID)
@@ -286,3 +286,52 @@ func floatEqual(a, b float32) bool {
 	const epsilon = 1e-6
 	return math.Abs(float64(a-b)) < epsilon
 }
+
+func TestKickMessageRoundTrip(t *testing.T) {
+	sessionID := NewSessionID()
+	data := EncodeKickMessage(sessionID, KickReasonAdmin)
+
+	if !IsControlMessage(data, ControlSubTypeKick) {
+		t.Fatal("IsControlMessage(kick) = false")
+	}
+	if IsControlMessage(data, ControlSubTypeJoin) {
+		t.Error("IsControlMessage(join) = true for kick message")
+	}
+
+	header, err := ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if header.SessionID != sessionID.Bytes() {
+		t.Errorf("SessionID = %v, want %v", header.SessionID, sessionID.Bytes())
+	}
+	if header.Length != PayloadHeaderSize+KickPayloadSize {
+		t.Errorf("Length = %d, want %d", header.Length, PayloadHeaderSize+KickPayloadSize)
+	}
+
+	payload, err := ParseKickPayload(data[HeaderSize+PayloadHeaderSize:])
+	if err != nil {
+		t.Fatalf("ParseKickPayload failed: %v", err)
+	}
+	if payload.Reason != KickReasonAdmin {
+		t.Errorf("Reason = %v, want %v", payload.Reason, KickReasonAdmin)
+	}
+}
+
+// TestHeaderOffsets はヘッダーのフィールドの位置がHeaderSizeとEncodeの結果に一致することを確認します。
+func TestHeaderOffsets(t *testing.T) {
+	if HeaderTimestampEnd != HeaderSize {
+		t.Fatalf("HeaderTimestampEnd = %d, want HeaderSize %d", HeaderTimestampEnd, HeaderSize)
+	}
+	h := Header{Version: 1, SessionID: [16]byte{0: 0xaa, 15: 0xbb}, Seq: 0x0102, Timestamp: 0x03040506}
+	data := h.Encode()
+	if data[HeaderSessionIDStart] != 0xaa || data[HeaderSessionIDEnd-1] != 0xbb {
+		t.Errorf("sessionID at [%d:%d] = %x", HeaderSessionIDStart, HeaderSessionIDEnd, data[HeaderSessionIDStart:HeaderSessionIDEnd])
+	}
+	if got := byteOrder.Uint16(data[HeaderSeqStart:HeaderSeqEnd]); got != h.Seq {
+		t.Errorf("seq = %#x, want %#x", got, h.Seq)
+	}
+	if got := byteOrder.Uint32(data[HeaderTimestampStart:HeaderTimestampEnd]); got != h.Timestamp {
+		t.Errorf("timestamp = %#x, want %#x", got, h.Timestamp)
+	}
+}
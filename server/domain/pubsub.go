@@ -1,6 +1,10 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"slices"
+	"time"
+)
 
 //go:generate go tool mockgen -destination=./mocks/pubsub_mock.go -package=mocks . PubSub
 
@@ -8,18 +12,133 @@ import "context"
 type Topic string
 
 // Message はPubSubで配送されるメッセージを表します。
+//
+// クライアントから受信したフレームでは、SessionEndpointが解析したヘッダーの内容を
+// DataType・SubType・Seq・ReceivedAtに設定するため、受信側はDataを再度解析せずに振り分けられます。
+// サーバー発のメッセージ（ブロードキャストなど）ではこれらはゼロ値です。
+//
+// Messageは購読者ごとにチャネルへ値で積まれるため、一部のメッセージでしか使わないフィールドは
+// Routingにまとめてポインタで持ちます。
 type Message struct {
+	// Topic は配送されたトピックです。Publish時にPubSubが設定します。
+	// パターン購読で実際のトピックを知るために使います。
+	Topic     Topic
 	SessionID SessionID
-	Data      []byte
+
+	DataType DataType
+	SubType  uint8
+	// Seq はHeaderのシーケンス番号です。
+	Seq uint16
+	// ReceivedAt はサーバーがフレームを受信した時刻です。
+	ReceivedAt time.Time
+	// Offset はトピックのログでのオフセットです。ログを残すトピック（LogPubSub）でのみ1以上になります。
+	Offset uint64
+
+	Data []byte
+	// Payload はDataを共有する参照カウント付きバッファです。ルームのマルチキャストで使います。
+	// 設定されている場合、PubSubは購読者に積むたびにRetainし、受信者は使い終えたらReleaseします。
+	Payload *SharedPayload
+	// Routing は中継元・リクエスト・除外するセッションの情報です。いずれも使わない場合はnilです。
+	// 購読者の間で共有されるため、変更する場合は新しいMessageRoutingに差し替えます（WithRoutingを参照）。
+	Routing *MessageRouting
+}
+
+// MessageRouting はMessageのうち、クラスタの中継・リクエスト・マルチキャストでのみ使うフィールドです。
+type MessageRouting struct {
+	// Origin はメッセージをPublishしたノードのIDです。自ノードでPublishされた場合は空です。
+	Origin string
+	// ReplyTo はリクエストの返信先のトピックです。Requestで送ったメッセージにのみ設定されます。
+	ReplyTo Topic
+	// CorrelationID はリクエストと返信を対応付けるIDです。Requestが設定し、Replyが返信に引き継ぎます。
+	CorrelationID string
+	// Exclude はこのメッセージを受け取らないセッションです（送信者自身など）。
+	// 受信したSessionEndpointが自分のIDを含む場合に破棄します。
+	Exclude []SessionID
+}
+
+// Origin はRouting.Originを返します。Routingがnilの場合は空です。
+func (m Message) Origin() string {
+	if m.Routing == nil {
+		return ""
+	}
+	return m.Routing.Origin
+}
+
+// ReplyTo はRouting.ReplyToを返します。Routingがnilの場合は空です。
+func (m Message) ReplyTo() Topic {
+	if m.Routing == nil {
+		return ""
+	}
+	return m.Routing.ReplyTo
+}
+
+// CorrelationID はRouting.CorrelationIDを返します。Routingがnilの場合は空です。
+func (m Message) CorrelationID() string {
+	if m.Routing == nil {
+		return ""
+	}
+	return m.Routing.CorrelationID
+}
+
+// Exclude はRouting.Excludeを返します。Routingがnilの場合はnilです。
+func (m Message) Exclude() []SessionID {
+	if m.Routing == nil {
+		return nil
+	}
+	return m.Routing.Exclude
+}
+
+// Excludes はsessionIDがExcludeに含まれるかを返します。
+func (m Message) Excludes(sessionID SessionID) bool {
+	return slices.Contains(m.Exclude(), sessionID)
+}
+
+// WithRouting はRoutingの複製をfnで変更したMessageを返します。元のMessageとRoutingを共有する
+// 他の購読者には影響しません。変更の結果が全て空の場合、Routingはnilになります。
+func (m Message) WithRouting(fn func(*MessageRouting)) Message {
+	var r MessageRouting
+	if m.Routing != nil {
+		r = *m.Routing
+	}
+	fn(&r)
+	if r.Origin == "" && r.ReplyTo == "" && r.CorrelationID == "" && len(r.Exclude) == 0 {
+		m.Routing = nil
+	} else {
+		m.Routing = &r
+	}
+	return m
+}
+
+// withHeaders はDataTypeが設定されていないメッセージについて、Dataのヘッダーを解析して補います。
+// 解析できない場合はそのまま返します。
+func (m Message) withHeaders() Message {
+	if m.DataType != 0 || len(m.Data) < HeaderSize+PayloadHeaderSize {
+		return m
+	}
+	header, err := ParseHeader(m.Data)
+	if err != nil {
+		return m
+	}
+	payloadHeader, err := ParsePayloadHeader(m.Data[HeaderSize:])
+	if err != nil {
+		return m
+	}
+	m.DataType = payloadHeader.DataType
+	m.SubType = payloadHeader.SubType
+	m.Seq = header.Seq
+	return m
 }
 
 // PubSub はトピックベースのメッセージ配送を提供します。
 type PubSub interface {
-	// Subscribe はトピックを購読し、メッセージを受信するチャネルを返します。
-	Subscribe(topic Topic) <-chan Message
+	// Subscribe はトピックを購読します。
+	// バッファサイズと満杯時の振る舞いはoptsで指定し、ゼロ値はデフォルト設定です。
+	// 購読はSubscriptionのCloseを呼ぶか、ctxがキャンセルされると終了します。
+	Subscribe(ctx context.Context, topic Topic, opts SubscribeOptions) *Subscription
 
-	// Unsubscribe は購読を解除します。
-	Unsubscribe(topic Topic, ch <-chan Message)
+	// SubscribePattern はpatternにマッチする全てのトピックを購読します。
+	// 監視や録画のように、複数のルーム・セッションの通信をまとめて観測する場合に使います。
+	SubscribePattern(ctx context.Context, pattern TopicPattern, opts SubscribeOptions) *Subscription
 
 	// Publish はトピックにメッセージを配信します。
 	// 配送はbest-effort（一部の購読者への配送失敗は無視して継続）。
@@ -0,0 +1,191 @@
+package domain
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// publishRateInterval はPublishRateを計測し直す最短の間隔です。
+// 管理APIとメトリクスのように複数の呼び出し元があっても、短い間隔の揺れで値が跳ねないようにします。
+const publishRateInterval = time.Second
+
+// PubSubStatsReporter は計測値を提供するPubSubが実装するインターフェースです。
+type PubSubStatsReporter interface {
+	PubSubStats() PubSubStats
+}
+
+// PubSubStats はPubSubの計測値のスナップショットです。
+type PubSubStats struct {
+	// Published・Delivered・Dropped は全トピックの累計です。購読者がいなくなったトピックの分も含みます。
+	Published uint64 `json:"published"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	// Topics は購読者のいるトピックをトピック名の順に並べたものです。
+	Topics []TopicStats `json:"topics"`
+	// Patterns はパターン購読をパターンの順に並べたものです。
+	Patterns []PatternStats `json:"patterns"`
+}
+
+// TopicStats は1つのトピックの計測値です。購読者がいる間だけ保持されます。
+type TopicStats struct {
+	Topic       Topic `json:"topic"`
+	Subscribers int   `json:"subscribers"`
+	// Published はトピックへのPublish数です。
+	Published uint64 `json:"published"`
+	// PublishRate は直近の1秒あたりのPublish数です。
+	PublishRate float64 `json:"publishRate"`
+	// Delivered は購読者のバッファに積まれたメッセージ数です。
+	Delivered uint64 `json:"delivered"`
+	// Dropped はバッファが満杯で購読者に届かなかったメッセージ数です。
+	Dropped uint64 `json:"dropped"`
+	// HighWaterMark は購読者のうち、未読メッセージが最も多く溜まったときの件数です。
+	HighWaterMark int `json:"highWaterMark"`
+}
+
+// PatternStats は同じパターンの購読をまとめた計測値です。
+type PatternStats struct {
+	Pattern     string `json:"pattern"`
+	Subscribers int    `json:"subscribers"`
+	Delivered   uint64 `json:"delivered"`
+	// Dropped はOverflowCoalesceで置き換えられたメッセージも含みます（SubscriptionStatsと同じ）。
+	Dropped       uint64 `json:"dropped"`
+	HighWaterMark int    `json:"highWaterMark"`
+}
+
+// topicEntry はトピックの購読者と計測値です。PubSubの実装がトピックごとに保持します。
+type topicEntry struct {
+	// subs のスライスは変更せずに差し替える（Publishがロック外で走査するため）。PubSubのロックで保護する
+	subs []*Subscription
+
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	rate      publishRate
+}
+
+func newTopicEntry(now time.Time) *topicEntry {
+	return &topicEntry{rate: publishRate{at: now}}
+}
+
+// deliver はsubsにメッセージを配送し、計測値を更新します。subsはPubSubのロックを取って読み出したe.subsです。
+func (e *topicEntry) deliver(ctx context.Context, subs []*Subscription, msg Message) {
+	e.published.Add(1)
+	var delivered, dropped uint64
+	for _, sub := range subs {
+		if ctx.Err() != nil {
+			break
+		}
+		if sub.Deliver(ctx, msg) {
+			delivered++
+		} else {
+			dropped++
+		}
+	}
+	e.delivered.Add(delivered)
+	e.dropped.Add(dropped)
+}
+
+// stats はトピックの計測値を返します。PubSubのロックを取って呼び出します。
+func (e *topicEntry) stats(topic Topic, now time.Time) TopicStats {
+	s := TopicStats{
+		Topic:       topic,
+		Subscribers: len(e.subs),
+		Published:   e.published.Load(),
+		Delivered:   e.delivered.Load(),
+		Dropped:     e.dropped.Load(),
+	}
+	s.PublishRate = e.rate.sample(s.Published, now)
+	for _, sub := range e.subs {
+		s.HighWaterMark = max(s.HighWaterMark, sub.Stats().HighWaterMark)
+	}
+	return s
+}
+
+// publishRate は前回の計測からのPublish数で1秒あたりのPublish数を求めます。
+type publishRate struct {
+	mu sync.Mutex
+	// at・count は前回の計測の時刻とPublish数です。初回はトピックの作成時刻と0です。
+	at       time.Time
+	count    uint64
+	rate     float64
+	measured bool
+}
+
+func (r *publishRate) sample(count uint64, now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := now.Sub(r.at)
+	if elapsed <= 0 {
+		return r.rate
+	}
+	// 一度も計測していなければ、間隔が短くても作成時からの平均を返す
+	if !r.measured || elapsed >= publishRateInterval {
+		r.rate = float64(count-r.count) / elapsed.Seconds()
+	}
+	if elapsed >= publishRateInterval {
+		r.at, r.count, r.measured = now, count, true
+	}
+	return r.rate
+}
+
+// topicTotals は購読者がいなくなり削除されたトピックの計測値の累計です。PubSubのロックで保護します。
+type topicTotals struct {
+	published uint64
+	delivered uint64
+	dropped   uint64
+}
+
+func (t *topicTotals) retire(e *topicEntry) {
+	t.published += e.published.Load()
+	t.delivered += e.delivered.Load()
+	t.dropped += e.dropped.Load()
+}
+
+// collectTopicStats はtopicsの計測値をstatsに加えます。PubSubのロックを取って呼び出します。
+func collectTopicStats(stats *PubSubStats, topics map[Topic]*topicEntry, retired topicTotals, now time.Time) {
+	stats.Published += retired.published
+	stats.Delivered += retired.delivered
+	stats.Dropped += retired.dropped
+	for topic, e := range topics {
+		s := e.stats(topic, now)
+		stats.Published += s.Published
+		stats.Delivered += s.Delivered
+		stats.Dropped += s.Dropped
+		stats.Topics = append(stats.Topics, s)
+	}
+}
+
+func sortTopicStats(stats []TopicStats) {
+	slices.SortFunc(stats, func(a, b TopicStats) int { return strings.Compare(string(a.Topic), string(b.Topic)) })
+}
+
+// stats はパターン購読の計測値をパターンごとにまとめて返します。
+func (ps *patternSubscribers) stats() []PatternStats {
+	ps.mu.RLock()
+	entries := ps.entries
+	ps.mu.RUnlock()
+
+	var stats []PatternStats
+	index := make(map[string]int)
+	for _, e := range entries {
+		pattern := e.pattern.String()
+		i, ok := index[pattern]
+		if !ok {
+			i = len(stats)
+			index[pattern] = i
+			stats = append(stats, PatternStats{Pattern: pattern})
+		}
+		sub := e.sub.Stats()
+		stats[i].Subscribers++
+		stats[i].Delivered += sub.Delivered
+		stats[i].Dropped += sub.Dropped
+		stats[i].HighWaterMark = max(stats[i].HighWaterMark, sub.HighWaterMark)
+	}
+	slices.SortFunc(stats, func(a, b PatternStats) int { return strings.Compare(a.Pattern, b.Pattern) })
+	return stats
+}
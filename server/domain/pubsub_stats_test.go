@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPubSubStats(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ps   interface {
+			PubSub
+			PubSubStatsReporter
+		}
+	}{
+		{"simple", NewSimplePubSub()},
+		{"sharded", NewShardedPubSub(4)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ps := tc.ps
+
+			// バッファ2の購読者は3件目以降を破棄する
+			small := ps.Subscribe(ctx, "room:a", SubscribeOptions{BufferSize: 2})
+			defer small.Close()
+			large := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+			defer large.Close()
+			other := ps.Subscribe(ctx, "room:b", SubscribeOptions{})
+			rooms, _ := ParseTopicPattern("room:*")
+			pattern := ps.SubscribePattern(ctx, rooms, SubscribeOptions{})
+			defer pattern.Close()
+
+			for range 4 {
+				ps.Publish(ctx, "room:a", Message{})
+			}
+			ps.Publish(ctx, "room:b", Message{})
+			ps.Publish(ctx, "room:nobody", Message{})
+
+			stats := ps.PubSubStats()
+			if len(stats.Topics) != 2 {
+				t.Fatalf("Topics = %+v, want room:a and room:b", stats.Topics)
+			}
+			a := stats.Topics[0]
+			if a.Topic != "room:a" || a.Subscribers != 2 || a.Published != 4 || a.Delivered != 6 || a.Dropped != 2 || a.HighWaterMark != 4 {
+				t.Errorf("room:a = %+v", a)
+			}
+			if a.PublishRate <= 0 {
+				t.Errorf("room:a PublishRate = %v, want > 0", a.PublishRate)
+			}
+			if b := stats.Topics[1]; b.Topic != "room:b" || b.Subscribers != 1 || b.Published != 1 || b.HighWaterMark != 1 {
+				t.Errorf("room:b = %+v", b)
+			}
+			if stats.Published != 5 || stats.Delivered != 7 || stats.Dropped != 2 {
+				t.Errorf("totals = %d/%d/%d, want 5/7/2", stats.Published, stats.Delivered, stats.Dropped)
+			}
+			if len(stats.Patterns) != 1 || stats.Patterns[0] != (PatternStats{Pattern: "room:*", Subscribers: 1, Delivered: 6, HighWaterMark: 6}) {
+				t.Errorf("Patterns = %+v", stats.Patterns)
+			}
+
+			// 購読者がいなくなったトピックは一覧から消えるが、累計には残る
+			other.Close()
+			stats = ps.PubSubStats()
+			if len(stats.Topics) != 1 || stats.Topics[0].Topic != "room:a" {
+				t.Errorf("Topics after close = %+v, want room:a only", stats.Topics)
+			}
+			if stats.Published != 5 || stats.Delivered != 7 || stats.Dropped != 2 {
+				t.Errorf("totals after close = %d/%d/%d, want 5/7/2", stats.Published, stats.Delivered, stats.Dropped)
+			}
+		})
+	}
+}
+
+func TestPublishRate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	r := publishRate{at: start}
+
+	// 初回は間隔が短くても作成時からの平均を返す
+	if got := r.sample(5, start.Add(500*time.Millisecond)); got != 10 {
+		t.Errorf("first sample = %v, want 10", got)
+	}
+	if got := r.sample(30, start.Add(2*time.Second)); got != 15 {
+		t.Errorf("sample after 2s = %v, want 15", got)
+	}
+	// 前回の計測から1秒経っていなければ前回の値のまま
+	if got := r.sample(100, start.Add(2500*time.Millisecond)); got != 15 {
+		t.Errorf("sample within interval = %v, want 15", got)
+	}
+	if got := r.sample(100, start.Add(4*time.Second)); got != 35 {
+		t.Errorf("sample after 4s = %v, want 35", got)
+	}
+}
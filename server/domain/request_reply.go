@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// DefaultRequestTimeout はRequestのctxに期限がない場合に返信を待つ時間です。
+const DefaultRequestTimeout = 5 * time.Second
+
+var (
+	// ErrNotRequest はReplyに渡したメッセージが返信先を持たない（Requestで送られていない）場合に返されるエラーです。
+	ErrNotRequest = errors.New("pub/sub: message is not a request")
+	// ErrNoReply は返信を受け取る前に返信の購読が終了した場合に返されるエラーです。
+	ErrNoReply = errors.New("pub/sub: request ended without reply")
+)
+
+// RequestHandler はServeRequestsで受け取ったリクエストを処理し、返信を返します。
+// reqのPayloadは呼び出し後にReleaseされるため、使い続ける場合はRetainします。
+type RequestHandler func(ctx context.Context, req Message) Message
+
+// NewCorrelationID はリクエストと返信を対応付けるIDを生成します。
+func NewCorrelationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// Request はtopicにmsgをPublishし、最初の返信を待って返します。
+//
+// リクエストごとに返信用のトピック（ReplyTopic）を購読してからPublishするため、
+// 購読を他のノードに伝えるPubSub（cluster.PubSubなど）でも返信を受け取れます。
+// 返信はctxの期限（ない場合はDefaultRequestTimeout）まで待ち、期限を過ぎた場合はctxのエラーを返します。
+// 購読者がいないトピックへのリクエストも期限まで待ちます。
+func Request(ctx context.Context, ps PubSub, topic Topic, msg Message) (Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	correlationID := NewCorrelationID()
+	replyTo := ReplyTopic(correlationID)
+	msg = msg.WithRouting(func(r *MessageRouting) {
+		r.CorrelationID = correlationID
+		r.ReplyTo = replyTo
+	})
+	sub := ps.Subscribe(ctx, replyTo, SubscribeOptions{BufferSize: 1})
+	defer sub.Close()
+
+	ps.Publish(ctx, topic, msg)
+	for {
+		select {
+		case reply, ok := <-sub.C():
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return Message{}, err
+				}
+				return Message{}, ErrNoReply
+			}
+			if reply.CorrelationID() != correlationID {
+				reply.Payload.Release()
+				continue
+			}
+			return reply, nil
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Reply はreqの返信先にreplyをPublishします。reqがRequestで送られたメッセージでない場合はErrNotRequestを返します。
+func Reply(ctx context.Context, ps PubSub, req Message, reply Message) error {
+	if req.ReplyTo() == "" {
+		return ErrNotRequest
+	}
+	reply = reply.WithRouting(func(r *MessageRouting) {
+		r.CorrelationID = req.CorrelationID()
+		r.ReplyTo = ""
+	})
+	ps.Publish(ctx, req.ReplyTo(), reply)
+	return nil
+}
+
+// ServeRequests はtopicを購読し、受け取ったリクエストをhandlerで処理して返信します。
+// リクエストは受け取った順に1つずつ処理し、返信先のないメッセージはhandlerを呼ばずに捨てます。
+// ctxがキャンセルされるまでブロックし、ctxのエラーを返します。
+func ServeRequests(ctx context.Context, ps PubSub, topic Topic, handler RequestHandler) error {
+	sub := ps.Subscribe(ctx, topic, SubscribeOptions{})
+	defer sub.Close()
+
+	for req := range sub.C() {
+		if req.ReplyTo() == "" {
+			req.Payload.Release()
+			continue
+		}
+		reply := handler(ctx, req)
+		req.Payload.Release()
+		_ = Reply(ctx, ps, req, reply)
+	}
+	return ctx.Err()
+}
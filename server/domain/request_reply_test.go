@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequest_Reply(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeRequests(ctx, ps, "room:a", func(_ context.Context, req Message) Message {
+			return Message{Data: append([]byte("re:"), req.Data...)}
+		})
+	}()
+	// ServeRequestsの購読が始まってからリクエストを送る
+	waitSubscribers(t, ps, "room:a", 1)
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+	defer reqCancel()
+	reply, err := Request(reqCtx, ps, "room:a", Message{Data: []byte("join")})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if string(reply.Data) != "re:join" || reply.CorrelationID() == "" || reply.ReplyTo() != "" {
+		t.Errorf("reply = %+v, want re:join with CorrelationID", reply)
+	}
+	// 返信の購読は終了している
+	if len(ps.PubSubStats().Topics) != 1 {
+		t.Errorf("topics = %+v, want only room:a", ps.PubSubStats().Topics)
+	}
+
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("ServeRequests = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRequest_Deadline(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := Request(ctx, ps, "room:nobody", Message{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRequest_IgnoresMismatchedReply(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx := context.Background()
+	requests := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	defer requests.Close()
+
+	go func() {
+		req := <-requests.C()
+		// 別のリクエストの返信は無視される
+		ps.Publish(ctx, req.ReplyTo(), Message{Routing: &MessageRouting{CorrelationID: "other"}, Data: []byte("wrong")})
+		_ = Reply(ctx, ps, req, Message{Data: []byte("right")})
+	}()
+	reply, err := Request(ctx, ps, "room:a", Message{})
+	if err != nil || string(reply.Data) != "right" {
+		t.Errorf("Request = (%q, %v), want right", reply.Data, err)
+	}
+}
+
+func TestReply_NotRequest(t *testing.T) {
+	if err := Reply(context.Background(), NewSimplePubSub(), Message{}, Message{}); !errors.Is(err, ErrNotRequest) {
+		t.Errorf("Reply = %v, want %v", err, ErrNotRequest)
+	}
+}
+
+func waitSubscribers(t *testing.T, ps *SimplePubSub, topic Topic, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		for _, s := range ps.PubSubStats().Topics {
+			if s.Topic == topic && s.Subscribers == n {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s does not have %d subscribers", topic, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -20,16 +21,40 @@ func (id RoomID) String() string {
 	return fmt.Sprintf("%x", id[:])
 }
 
-var ErrRoomBusy = errors.New("room control channel is full")
+// MarshalText はRoomIDを16進数文字列としてエンコードします
+func (id RoomID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// ParseRoomID は16進数文字列からRoomIDを生成します
+func ParseRoomID(s string) (RoomID, error) {
+	var id RoomID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return RoomID{}, ErrInvalidRoomID
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+var (
+	ErrRoomBusy      = errors.New("room control channel is full")
+	ErrInvalidRoomID = errors.New("invalid room id")
+)
 
 type Room struct {
-	ID       RoomID
-	sessions map[SessionID]struct{}
+	ID    RoomID
+	topic Topic
+	// multicast はメンバーのSessionEndpointが購読するトピックです
+	multicast Topic
+	// sessions は参加中のセッションと、その宛先トピックです
+	sessions map[SessionID]Topic
 
 	pubsub      PubSub
 	application Application // 外部からアプリケーションロジックを注入できる
 
 	sendCh chan roomSend
+	ctrlCh chan roomCtrl
 
 	tickInterval time.Duration
 }
@@ -37,34 +62,64 @@ type Room struct {
 func NewRoom(id RoomID, pubsub PubSub, application Application) *Room {
 	return &Room{
 		ID:           id,
-		sessions:     make(map[SessionID]struct{}),
+		topic:        RoomTopic(id),
+		multicast:    RoomMulticastTopic(id),
+		sessions:     make(map[SessionID]Topic),
 		pubsub:       pubsub,
 		application:  application,
 		sendCh:       make(chan roomSend, 1024),
+		ctrlCh:       make(chan roomCtrl, 64),
 		tickInterval: time.Second / 60,
 	}
 }
 
-func (r *Room) Broadcast(ctx context.Context, data []byte) {
-	for sessionID := range r.sessions {
-		topic := Topic("session:" + sessionID.String())
-		r.pubsub.Publish(ctx, topic, Message{Data: data})
+// Broadcast はdataをルームのメンバー全員に送ります。excludeのセッションには送りません。
+func (r *Room) Broadcast(ctx context.Context, data []byte, exclude ...SessionID) {
+	r.Multicast(ctx, NewSharedPayload(data, nil), exclude...)
+}
+
+// Multicast はpayloadをマルチキャストのトピックに1回Publishし、ルームのメンバー全員に送ります。
+// excludeのセッションには送りません。呼び出し元が持つpayloadの参照はMulticastが解放します。
+func (r *Room) Multicast(ctx context.Context, payload *SharedPayload, exclude ...SessionID) {
+	msg := Message{Data: payload.Bytes(), Payload: payload}
+	if len(exclude) > 0 {
+		msg.Routing = &MessageRouting{Exclude: exclude}
 	}
+	r.pubsub.Publish(ctx, r.multicast, msg)
+	payload.Release()
 }
 
 func (r *Room) SendTo(ctx context.Context, sessionID SessionID, data []byte) {
-	topic := Topic("session:" + sessionID.String())
+	topic, ok := r.sessions[sessionID]
+	if !ok {
+		// ルーム外のセッションにも送れるようにする（Kick直後など）
+		topic = SessionTopic(sessionID)
+	}
 	r.pubsub.Publish(ctx, topic, Message{Data: data})
 }
 
-func (r *Room) EnqueueBroadcast(ctx context.Context, data []byte) error {
-	return r.enqueueSend(ctx, roomSend{kind: roomSendBroadcast, data: data})
+func (r *Room) EnqueueBroadcast(ctx context.Context, data []byte, exclude ...SessionID) error {
+	return r.enqueueSend(ctx, roomSend{kind: roomSendBroadcast, data: data, exclude: exclude})
 }
 
 func (r *Room) EnqueueSendTo(ctx context.Context, sessionID SessionID, data []byte) error {
 	return r.enqueueSend(ctx, roomSend{kind: roomSendTo, sessionID: sessionID, data: data})
 }
 
+// Kick はセッションをルームから強制退出させます。
+// 対象セッションにはKickメッセージが送られ、SessionEndpoint側で接続が閉じられます。
+// 処理はRunのtick内で行われるため、呼び出しは任意のgoroutineから安全です。
+func (r *Room) Kick(ctx context.Context, sessionID SessionID, reason KickReason) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.ctrlCh <- roomCtrl{kind: roomCtrlKick, sessionID: sessionID, reason: reason}:
+		return nil
+	default:
+		return ErrRoomBusy
+	}
+}
+
 func (r *Room) enqueueSend(ctx context.Context, msg roomSend) error {
 	select {
 	case <-ctx.Done():
@@ -77,24 +132,43 @@ func (r *Room) enqueueSend(ctx context.Context, msg roomSend) error {
 }
 
 func (r *Room) Run(ctx context.Context) error {
-	// room宛のメッセージを購読
-	roomTopic := Topic("room:" + r.ID.String())
-	msgCh := r.pubsub.Subscribe(roomTopic)
-	defer r.pubsub.Unsubscribe(roomTopic, msgCh)
-
 	ticker := time.NewTicker(r.tickInterval)
 	defer ticker.Stop()
+	return r.RunWithTicks(ctx, ticker.C)
+}
+
+// RunWithTicks はticksを受信するたびに1tick分の処理を行います。
+// テストなどでtickを手動で進める場合に使用します。
+func (r *Room) RunWithTicks(ctx context.Context, ticks <-chan time.Time) error {
+	// room宛のメッセージを購読
+	sub := r.pubsub.Subscribe(ctx, r.topic, SubscribeOptions{})
+	defer sub.Close()
+	msgCh := sub.C()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-ticker.C:
+		case <-ticks:
+			// 制御要求を処理
+		CTRL_LOOP:
+			for {
+				select {
+				case ctrl := <-r.ctrlCh:
+					r.handleCtrl(ctx, ctrl)
+				default:
+					break CTRL_LOOP
+				}
+			}
 			// 受信メッセージを処理
 		RECEIVE_LOOP:
 			for {
 				select {
-				case msg := <-msgCh:
+				case msg, ok := <-msgCh:
+					// ctxのキャンセルで購読が終了した
+					if !ok {
+						return nil
+					}
 					// Roomの責務に関する処理
 					r.HandleMessage(ctx, msg)
 					// アプリケーションロジックが担当する
@@ -116,10 +190,14 @@ func (r *Room) Run(ctx context.Context) error {
 				}
 			}
 			// ApplicationのTick()を呼び出し、戻り値があればブロードキャスト
-			if data := r.application.Tick(ctx); data != nil {
-				if bytes, ok := data.([]byte); ok {
-					r.Broadcast(ctx, bytes)
+			switch data := r.application.Tick(ctx).(type) {
+			case []byte:
+				if data != nil {
+					r.Broadcast(ctx, data)
 				}
+			case *SharedPayload:
+				// プールから取ったバッファなど、送信後に再利用したい場合
+				r.Multicast(ctx, data)
 			}
 		}
 	}
@@ -128,19 +206,14 @@ func (r *Room) Run(ctx context.Context) error {
 // HandleMessage はPubSub経由で受信したメッセージを処理し、
 // Control/JoinならsessionsにセッションIDを追加、Control/Leaveなら削除する。
 func (r *Room) HandleMessage(ctx context.Context, msg Message) {
-	if len(msg.Data) < HeaderSize+PayloadHeaderSize {
+	// SessionEndpointが解析済みのヘッダーを使い、設定されていない場合のみDataを解析する
+	msg = msg.withHeaders()
+	if msg.DataType != DataTypeControl {
 		return
 	}
-	payloadHeader, err := ParsePayloadHeader(msg.Data[HeaderSize:])
-	if err != nil {
-		return
-	}
-	if payloadHeader.DataType != DataTypeControl {
-		return
-	}
-	switch ControlSubType(payloadHeader.SubType) {
+	switch ControlSubType(msg.SubType) {
 	case ControlSubTypeJoin:
-		r.sessions[msg.SessionID] = struct{}{}
+		r.sessions[msg.SessionID] = SessionTopic(msg.SessionID)
 		slog.InfoContext(ctx, "room: session added", "roomID", r.ID, "sessionID", msg.SessionID)
 	case ControlSubTypeLeave:
 		delete(r.sessions, msg.SessionID)
@@ -148,10 +221,29 @@ func (r *Room) HandleMessage(ctx context.Context, msg Message) {
 	}
 }
 
+func (r *Room) handleCtrl(ctx context.Context, ctrl roomCtrl) {
+	switch ctrl.kind {
+	case roomCtrlKick:
+		if _, ok := r.sessions[ctrl.sessionID]; !ok {
+			slog.WarnContext(ctx, "room: kick target not in room", "roomID", r.ID, "sessionID", ctrl.sessionID)
+			return
+		}
+		delete(r.sessions, ctrl.sessionID)
+		// アプリケーションには退出として通知する
+		leave := EncodeControlMessage(ctrl.sessionID, ControlSubTypeLeave, nil)
+		if err := r.application.HandleMessage(ctx, ctrl.sessionID, leave); err != nil {
+			slog.WarnContext(ctx, "room handle message failed", "err", err)
+		}
+		r.SendTo(ctx, ctrl.sessionID, EncodeKickMessage(ctrl.sessionID, ctrl.reason))
+		slog.InfoContext(ctx, "room: session kicked", "roomID", r.ID, "sessionID", ctrl.sessionID, "reason", ctrl.reason)
+	default:
+	}
+}
+
 func (r *Room) handleSendMessage(ctx context.Context, msg roomSend) {
 	switch msg.kind {
 	case roomSendBroadcast:
-		r.Broadcast(ctx, msg.data)
+		r.Broadcast(ctx, msg.data, msg.exclude...)
 	case roomSendTo:
 		r.SendTo(ctx, msg.sessionID, msg.data)
 	default:
@@ -6,11 +6,13 @@ const (
 	roomCtrlUnknown roomCtrlKind = iota
 	roomCtrlAdd
 	roomCtrlRemove
+	roomCtrlKick
 )
 
 type roomCtrl struct {
 	kind      roomCtrlKind
 	sessionID SessionID
+	reason    KickReason // roomCtrlKick のときのみ使用
 }
 
 type roomSendKind uint8
@@ -25,4 +27,5 @@ type roomSend struct {
 	kind      roomSendKind
 	sessionID SessionID
 	data      []byte
+	exclude   []SessionID // roomSendBroadcast のときのみ使用
 }
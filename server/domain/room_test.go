@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRoomID_RoundTrip(t *testing.T) {
+	id := RoomID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	parsed, err := ParseRoomID(id.String())
+	if err != nil {
+		t.Fatalf("ParseRoomID failed: %v", err)
+	}
+	if parsed != id {
+		t.Errorf("ParseRoomID = %v, want %v", parsed, id)
+	}
+
+	if _, err := ParseRoomID("zz"); err != ErrInvalidRoomID {
+		t.Errorf("expected ErrInvalidRoomID, got %v", err)
+	}
+}
+
+// TestRoom_Kick はKickでセッションが削除され、Kickメッセージが届くことを確認します。
+func TestRoom_Kick(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps := NewSimplePubSub()
+	room := NewRoom(RoomID{1}, ps, NewEchoApplication())
+	sessionID := NewSessionID()
+	room.sessions[sessionID] = SessionTopic(sessionID)
+
+	sessionTopic := SessionTopic(sessionID)
+	sub := ps.Subscribe(ctx, sessionTopic, SubscribeOptions{})
+	defer sub.Close()
+
+	if err := room.Kick(ctx, sessionID, KickReasonAdmin); err != nil {
+		t.Fatalf("Kick failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		room.Run(ctx)
+	}()
+
+	select {
+	case msg := <-sub.C():
+		if !IsControlMessage(msg.Data, ControlSubTypeKick) {
+			t.Fatalf("expected kick message, got %v", msg.Data)
+		}
+		payload, err := ParseKickPayload(msg.Data[HeaderSize+PayloadHeaderSize:])
+		if err != nil {
+			t.Fatalf("ParseKickPayload failed: %v", err)
+		}
+		if payload.Reason != KickReasonAdmin {
+			t.Errorf("Reason = %v, want %v", payload.Reason, KickReasonAdmin)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for kick message")
+	}
+
+	cancel()
+	<-done
+	if _, ok := room.sessions[sessionID]; ok {
+		t.Error("session still in room after kick")
+	}
+}
+
+// TestRoom_HandleMessage_Envelope はRoomがエンベロープのDataType/SubTypeで振り分け、
+// 設定されていない場合はDataを解析することを確認します。
+func TestRoom_HandleMessage_Envelope(t *testing.T) {
+	ctx := context.Background()
+	room := NewRoom(RoomID{1}, NewSimplePubSub(), NewEchoApplication())
+
+	// Dataを解析しなくてもエンベロープだけで参加できる
+	a := NewSessionID()
+	room.HandleMessage(ctx, Message{SessionID: a, DataType: DataTypeControl, SubType: uint8(ControlSubTypeJoin)})
+	if topic := room.sessions[a]; topic != SessionTopic(a) {
+		t.Errorf("sessions[a] = %q, want %q", topic, SessionTopic(a))
+	}
+
+	// エンベロープのないメッセージはDataから解析する
+	b := NewSessionID()
+	room.HandleMessage(ctx, Message{SessionID: b, Data: EncodeControlMessage(b, ControlSubTypeJoin, nil)})
+	if _, ok := room.sessions[b]; !ok {
+		t.Error("session b not added from raw control message")
+	}
+
+	room.HandleMessage(ctx, Message{SessionID: a, DataType: DataTypeControl, SubType: uint8(ControlSubTypeLeave)})
+	if _, ok := room.sessions[a]; ok {
+		t.Error("session a still in room after leave")
+	}
+}
+
+// TestRoom_Broadcast はブロードキャストがメンバーごとではなく、マルチキャストのトピックに1回だけPublishされることを確認します。
+func TestRoom_Broadcast(t *testing.T) {
+	ctx := context.Background()
+	ps := NewSimplePubSub()
+	room := NewRoom(RoomID{1}, ps, NewEchoApplication())
+	members := []SessionID{NewSessionID(), NewSessionID()}
+	for _, id := range members {
+		room.sessions[id] = SessionTopic(id)
+	}
+	multicast := ps.Subscribe(ctx, RoomMulticastTopic(room.ID), SubscribeOptions{})
+	defer multicast.Close()
+	direct := ps.Subscribe(ctx, SessionTopic(members[0]), SubscribeOptions{})
+	defer direct.Close()
+
+	room.Broadcast(ctx, []byte("state"), members[1])
+
+	msgs := receiveAll(t, multicast, 1)
+	if string(msgs[0].Data) != "state" || msgs[0].Payload == nil {
+		t.Errorf("multicast = %+v, want shared payload %q", msgs[0], "state")
+	}
+	if msgs[0].Excludes(members[0]) || !msgs[0].Excludes(members[1]) {
+		t.Errorf("Exclude = %v, want only %v", msgs[0].Exclude(), members[1])
+	}
+	select {
+	case msg := <-direct.C():
+		t.Errorf("broadcast published to session topic: %+v", msg)
+	default:
+	}
+}
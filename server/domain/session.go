@@ -9,6 +9,13 @@ import (
 
 type SessionID string
 
+const (
+	// DefaultSendQueueSize はセッションの送信キューのデフォルト容量です。
+	DefaultSendQueueSize = 1024
+	// sendQueueHighWatermarkRatio は送信キューを「高水位」とみなす充填率です。
+	sendQueueHighWatermarkRatio = 0.75
+)
+
 // NewSessionID は暗号学的に安全なSessionIDを生成する
 func NewSessionID() SessionID {
 	var b [16]byte
@@ -36,24 +43,42 @@ func (id SessionID) String() string { return string(id) }
 
 // Session は1接続の論理的な接続状態を表す構造体です。
 type Session struct {
-	id SessionID
+	id       SessionID
+	identity Identity
+	topic    Topic // 自分宛のメッセージのトピック（Publishのたびに作らないようにキャッシュする）
 
 	// activity
 	lastRead  atomic.Int64
 	lastWrite atomic.Int64
 	lastPong  atomic.Int64
 
-	// backpressure ( 未実装 )
-	//sendQ *BoundedQueue[[]byte] // bounded ring buffer
+	// backpressure
+	sendQ   *BoundedQueue[Outbound] // bounded ring buffer（高水位を超えた時刻も保持する）
+	dropped atomic.Uint64           // 送信キュー満杯で破棄したメッセージ数
 
 	// lifecycle
 	closed atomic.Bool
 }
 
 func NewSession() *Session {
+	return NewSessionWithIdentity(Identity{})
+}
+
+// NewSessionWithIdentity は認証済みユーザーの識別情報を持つSessionを作成します。
+func NewSessionWithIdentity(identity Identity) *Session {
+	return NewSessionWithID(NewSessionID(), identity)
+}
+
+// NewSessionWithID は指定したSessionIDでSessionを作成します。
+// 多重化接続のように、信頼できるクライアントがSessionIDを選ぶ場合にのみ使用します。
+func NewSessionWithID(id SessionID, identity Identity) *Session {
 	s := &Session{
-		id: NewSessionID(),
+		id:       id,
+		identity: identity,
+		topic:    SessionTopic(id),
+		sendQ:    NewBoundedQueue[Outbound](DefaultSendQueueSize),
 	}
+	s.sendQ.SetHighWatermark(int(float64(s.sendQ.Cap()) * sendQueueHighWatermarkRatio))
 	now := time.Now().UnixNano()
 	s.lastRead.Store(now)
 	s.lastWrite.Store(now)
@@ -61,6 +86,11 @@ func NewSession() *Session {
 	return s
 }
 
+// Topic はセッション宛のメッセージのトピックを返します。
+func (s *Session) Topic() Topic {
+	return s.topic
+}
+
 func (s *Session) TouchRead() {
 	s.lastRead.Store(time.Now().UnixNano())
 }
@@ -73,6 +103,69 @@ func (s *Session) TouchPong() {
 	s.lastPong.Store(time.Now().UnixNano())
 }
 
+// Outbound は送信キューに積まれた1件のデータです。
+type Outbound struct {
+	Data []byte
+	// Payload はDataを共有するバッファです。設定されている場合、送信後にReleaseします。
+	Payload *SharedPayload
+}
+
+// Enqueue は送信キューにデータを積みます。
+// キューが満杯の場合は破棄数を加算してfalseを返します。
+func (s *Session) Enqueue(data []byte) bool {
+	return s.push(Outbound{Data: data})
+}
+
+// EnqueueShared は共有バッファを送信キューに積みます。呼び出し元が持つpの参照はキューに引き渡され、
+// 送信後に解放されます。キューが満杯の場合はその場で解放してfalseを返します。
+func (s *Session) EnqueueShared(p *SharedPayload) bool {
+	if !s.push(Outbound{Data: p.Bytes(), Payload: p}) {
+		p.Release()
+		return false
+	}
+	return true
+}
+
+func (s *Session) push(out Outbound) bool {
+	if !s.sendQ.Push(out) {
+		s.dropped.Add(1)
+		return false
+	}
+	return true
+}
+
+// Dequeue は送信キューから次のデータを取り出します。Payloadが設定されている場合、送信後にReleaseします。
+func (s *Session) Dequeue() (Outbound, bool) {
+	return s.sendQ.Pop()
+}
+
+// SendReady は送信キューにデータが積まれたことを通知するチャネルを返します。
+func (s *Session) SendReady() <-chan struct{} {
+	return s.sendQ.Notify()
+}
+
+// SendQueueLen は送信キューに積まれているデータ数を返します。
+func (s *Session) SendQueueLen() int {
+	return s.sendQ.Len()
+}
+
+// DroppedMessages は送信キュー満杯により破棄されたメッセージ数を返します。
+func (s *Session) DroppedMessages() uint64 {
+	return s.dropped.Load()
+}
+
+// IsSlowConsumer は送信キューが高水位を超えた状態がthreshold以上続いているかを判定します。
+func (s *Session) IsSlowConsumer(threshold time.Duration) bool {
+	if threshold <= 0 {
+		return false
+	}
+	since := s.sendQ.HighSince()
+	if since.IsZero() {
+		return false
+	}
+	return time.Since(since) > threshold
+}
+
 func (s *Session) Close() bool {
 	if s.closed.CompareAndSwap(false, true) {
 		return true
@@ -113,6 +206,11 @@ func (s *Session) ID() SessionID {
 	return s.id
 }
 
+// Identity は接続時に認証されたユーザーの識別情報を返します。
+func (s *Session) Identity() Identity {
+	return s.identity
+}
+
 func (s *Session) IsClosed() bool {
 	return s.closed.Load()
 }
@@ -15,12 +15,33 @@ var (
 	ErrSessionAlreadyAttached = errors.New("session already has an attached connection")
 	// ErrSessionNotAttached はセッションに接続が紐付けられていない場合に返されるエラーです。
 	ErrSessionNotAttached = errors.New("session has no attached connection")
-	// ErrBackpressure は書き込みチャネルが満杯の場合に返されるエラーです。
-	ErrBackpressure = errors.New("write channel is full, apply backpressure")
+	// ErrBackpressure は送信キューが満杯の場合に返されるエラーです。
+	ErrBackpressure = errors.New("send queue is full, apply backpressure")
 	// ErrInitializationFailed はセッションエンドポイントの初期化に失敗した場合に返されるエラーです。
 	ErrInitializationFailed = errors.New("failed to initialize session endpoint")
 )
 
+const (
+	// idleTimeout は無通信とみなして切断するまでの時間です。
+	idleTimeout = 30 * time.Second
+	// slowConsumerTimeout は送信キューの高水位状態が続いた場合に切断するまでの時間です。
+	slowConsumerTimeout = 5 * time.Second
+	// kickWriteTimeout はKickメッセージの送信を待つ最大時間です。
+	kickWriteTimeout = 1 * time.Second
+	// subscriptionBufferSize はセッション宛とルームのマルチキャストの購読のバッファサイズです。
+	// subscribeLoopはすぐに送信キューへ移すため小さくてよく、チャネルのバッファは作成時に確保されるため
+	// DefaultChannelBufferのままではセッション数に比例してメモリを消費します。
+	subscriptionBufferSize = 64
+)
+
+// joinedRoom は参加中のルームと、そのトピックです。
+type joinedRoom struct {
+	id    RoomID
+	topic Topic
+	// multicast はルームのマルチキャストの購読です。退出すると終了します。
+	multicast *Subscription
+}
+
 type SessionEndpoint struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -29,16 +50,18 @@ type SessionEndpoint struct {
 	connection  *Connection
 	pubsub      PubSub
 	roomManager RoomManager
-	roomID      RoomID // 実行時にRoomManagerから取得
+	registry    *SessionRegistry
+	room        atomic.Pointer[joinedRoom] // 実行時にRoomManagerから取得
 
-	ctrlCh  chan endpointEvent // 制御用チャネル
-	writeCh chan []byte        // 書き込み用チャネル
+	ctrlCh chan endpointEvent // 制御用チャネル
+	// multicastCh は参加したルームのマルチキャストの購読をsubscribeLoopに渡します
+	multicastCh chan *Subscription
 
 	// lifecycle
 	closed atomic.Bool
 }
 
-func NewSessionEndpoint(session *Session, connection *Connection, pubsub PubSub, roomManager RoomManager) (*SessionEndpoint, error) {
+func NewSessionEndpoint(session *Session, connection *Connection, pubsub PubSub, roomManager RoomManager, registry *SessionRegistry) (*SessionEndpoint, error) {
 	if session == nil {
 		return nil, ErrInitializationFailed
 	}
@@ -51,6 +74,9 @@ func NewSessionEndpoint(session *Session, connection *Connection, pubsub PubSub,
 	if roomManager == nil {
 		return nil, ErrInitializationFailed
 	}
+	if registry == nil {
+		return nil, ErrInitializationFailed
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	se := &SessionEndpoint{
 		ctx:         ctx,
@@ -59,17 +85,22 @@ func NewSessionEndpoint(session *Session, connection *Connection, pubsub PubSub,
 		connection:  connection,
 		pubsub:      pubsub,
 		roomManager: roomManager,
+		registry:    registry,
 		ctrlCh:      make(chan endpointEvent, 16),
-		writeCh:     make(chan []byte, 1024),
+		multicastCh: make(chan *Subscription, 1),
 	}
 	return se, nil
 }
 
 func (se *SessionEndpoint) Run() error {
+	if err := se.registry.Register(se); err != nil {
+		return err
+	}
+	defer se.registry.Unregister(se)
+
 	// 自分宛のメッセージを購読
-	sessionTopic := Topic("session:" + se.session.ID().String())
-	msgCh := se.pubsub.Subscribe(sessionTopic)
-	defer se.pubsub.Unsubscribe(sessionTopic, msgCh)
+	sub := se.pubsub.Subscribe(se.ctx, se.session.Topic(), SubscribeOptions{BufferSize: subscriptionBufferSize})
+	defer sub.Close()
 
 	eg, ctx := errgroup.WithContext(se.ctx)
 	eg.Go(func() error {
@@ -85,7 +116,7 @@ func (se *SessionEndpoint) Run() error {
 		return nil
 	})
 	eg.Go(func() error {
-		se.subscribeLoop(ctx, msgCh)
+		se.subscribeLoop(ctx, sub.C())
 		return nil
 	})
 
@@ -101,13 +132,66 @@ func (se *SessionEndpoint) Run() error {
 	return nil
 }
 
-func (se *SessionEndpoint) Send(data []byte) error {
+// RoomID は現在参加中のルームIDを返します。未参加の場合はゼロ値を返します。
+func (se *SessionEndpoint) RoomID() RoomID {
+	if room := se.room.Load(); room != nil {
+		return room.id
+	}
+	return RoomID{}
+}
+
+// roomTopic は参加中のルームのトピックを返します。未参加の場合はfalseを返します。
+func (se *SessionEndpoint) roomTopic() (Topic, bool) {
+	if room := se.room.Load(); room != nil {
+		return room.topic, true
+	}
+	return "", false
+}
+
+// joinRoom はルームに参加し、ルームのマルチキャストを購読します。参加中のルームがあればその購読を終了します。
+func (se *SessionEndpoint) joinRoom(ctx context.Context, id RoomID) {
+	// Joinを受けたRoomがブロードキャストを始める前に購読しておく
+	sub := se.pubsub.Subscribe(se.ctx, RoomMulticastTopic(id), SubscribeOptions{BufferSize: subscriptionBufferSize})
+	if old := se.room.Swap(&joinedRoom{id: id, topic: RoomTopic(id), multicast: sub}); old != nil {
+		old.multicast.Close()
+	}
 	select {
-	case se.writeCh <- data:
-		return nil
-	default:
+	case se.multicastCh <- sub:
+	case <-ctx.Done():
+	}
+}
+
+// leaveRoom はルームから退出し、マルチキャストの購読を終了します。
+func (se *SessionEndpoint) leaveRoom() {
+	if old := se.room.Swap(nil); old != nil {
+		old.multicast.Close()
+	}
+}
+
+// Info はセッションの状態のスナップショットを返します。
+func (se *SessionEndpoint) Info() SessionInfo {
+	info := SessionInfo{
+		SessionID:    se.session.ID(),
+		Identity:     se.session.Identity(),
+		ConnectionID: se.connection.ConnectionID,
+		RemoteAddr:   se.connection.RemoteAddr,
+		ConnectedAt:  se.connection.ConnectedAt,
+		RoomID:       se.RoomID(),
+		Stats:        se.connection.Stats(),
+		SendQueueLen: se.session.SendQueueLen(),
+		Dropped:      se.session.DroppedMessages(),
+	}
+	if stats, ok := se.connection.TransportStats(); ok {
+		info.Transport = &stats
+	}
+	return info
+}
+
+func (se *SessionEndpoint) Send(data []byte) error {
+	if !se.session.Enqueue(data) {
 		return ErrBackpressure
 	}
+	return nil
 }
 
 func (se *SessionEndpoint) Close(ctx context.Context) {
@@ -115,7 +199,7 @@ func (se *SessionEndpoint) Close(ctx context.Context) {
 }
 
 func (se *SessionEndpoint) ForceClose() {
-	se.close()
+	se.close(CloseNormal)
 }
 
 // ownerLoop は論理セッションの状態を監視し、必要に応じて接続の管理を行います。
@@ -129,11 +213,24 @@ func (se *SessionEndpoint) ownerLoop(ctx context.Context) {
 		case ev := <-se.ctrlCh:
 			se.handleControlEvent(ctx, ev)
 		case <-ticker.C:
-			ok, reason := se.session.IsIdle(30 * time.Second)
+			if se.session.IsSlowConsumer(slowConsumerTimeout) {
+				slog.WarnContext(ctx, "slow consumer detected, closing session",
+					"sessionID", se.session.ID(),
+					"queueLen", se.session.SendQueueLen(),
+					"dropped", se.session.DroppedMessages(),
+				)
+				se.handleControlEvent(ctx, endpointEvent{
+					kind:   evClose,
+					reason: CloseSlowConsumer,
+				})
+				continue
+			}
+			ok, reason := se.session.IsIdle(idleTimeout)
 			if ok {
 				se.handleControlEvent(ctx, endpointEvent{
-					kind: evClose,
-					err:  errors.New(reason.String()),
+					kind:   evClose,
+					err:    errors.New(reason.String()),
+					reason: CloseIdle,
 				})
 			}
 		}
@@ -151,7 +248,7 @@ func (se *SessionEndpoint) readLoop(ctx context.Context) {
 				se.sendCtrlEvent(ctx, endpointEvent{kind: evReadError, err: err})
 				continue
 			}
-			se.handleData(ctx, data)
+			se.handleData(ctx, data, time.Now())
 		}
 	}
 }
@@ -161,47 +258,100 @@ func (se *SessionEndpoint) writeLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case data := <-se.writeCh:
-			err := se.connection.Write(ctx, data)
-			if err != nil {
-				se.sendCtrlEvent(ctx, endpointEvent{kind: evWriteError, err: err})
-				continue
+		case <-se.session.SendReady():
+			for {
+				out, ok := se.session.Dequeue()
+				if !ok {
+					break
+				}
+				err := se.connection.Write(ctx, out.Data)
+				// Transportは書き込み後にバッファを保持しないため、結果にかかわらず解放できる
+				out.Payload.Release()
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					se.sendCtrlEvent(ctx, endpointEvent{kind: evWriteError, err: err})
+					continue
+				}
+				se.session.TouchWrite()
 			}
-			se.session.TouchWrite()
 		}
 	}
 }
 
-// subscribeLoop はpubsubからのメッセージをwriteChに転送します。
+// subscribeLoop はpubsubからのメッセージを送信キューに転送します。
+// 自分宛のメッセージに加え、参加中のルームのマルチキャストも転送します。
 func (se *SessionEndpoint) subscribeLoop(ctx context.Context, msgCh <-chan Message) {
+	var multicastCh <-chan Message // 未参加の間はnil
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case sub := <-se.multicastCh:
+			multicastCh = sub.C()
+		case msg, ok := <-multicastCh:
+			if !ok {
+				// 退出した。次の参加まで待つ
+				multicastCh = nil
+				continue
+			}
+			se.forward(msg)
 		case msg, ok := <-msgCh:
 			if !ok {
 				return
 			}
-			select {
-			case se.writeCh <- msg.Data:
-				// 送信成功
-			default:
-				slog.Warn("subscribeLoop: writeCh full, message dropped", "sessionID", se.session.ID())
+			if IsControlMessage(msg.Data, ControlSubTypeKick) {
+				se.sendCtrlEvent(ctx, endpointEvent{kind: evKick, data: msg.Data})
+				continue
 			}
+			se.forward(msg)
 		}
 	}
 }
 
-func (se *SessionEndpoint) close() {
+// forward はメッセージを送信キューに積みます。Excludeに自分が含まれる場合は破棄します。
+func (se *SessionEndpoint) forward(msg Message) {
+	if msg.Excludes(se.session.ID()) {
+		msg.Payload.Release()
+		return
+	}
+	var ok bool
+	if msg.Payload != nil {
+		ok = se.session.EnqueueShared(msg.Payload)
+	} else {
+		ok = se.session.Enqueue(msg.Data)
+	}
+	if !ok {
+		slog.Warn("subscribeLoop: send queue full, message dropped",
+			"sessionID", se.session.ID(),
+			"dropped", se.session.DroppedMessages(),
+		)
+	}
+}
+
+func (se *SessionEndpoint) close(reason CloseReason) {
 	if !se.closed.CompareAndSwap(false, true) {
 		return
 	}
 	se.cancel()
 	se.session.Close()
-	se.connection.Close()
+	se.connection.CloseWithReason(reason)
 }
 
-func (se *SessionEndpoint) handleData(ctx context.Context, data []byte) {
+// kick はKickメッセージを送信キューを経由せず直接書き込み、接続を閉じます。
+func (se *SessionEndpoint) kick(ctx context.Context, data []byte) {
+	writeCtx, cancel := context.WithTimeout(ctx, kickWriteTimeout)
+	defer cancel()
+	if err := se.connection.Write(writeCtx, data); err != nil {
+		slog.WarnContext(ctx, "failed to write kick message", "sessionID", se.session.ID(), "err", err)
+	}
+	slog.InfoContext(ctx, "session kicked", "sessionID", se.session.ID(), "roomID", se.RoomID())
+	se.leaveRoom()
+	se.close(CloseKicked)
+}
+
+func (se *SessionEndpoint) handleData(ctx context.Context, data []byte, receivedAt time.Time) {
 	header, err := ParseHeader(data)
 	if err != nil {
 		slog.WarnContext(ctx, "failed to parse header", "err", err)
@@ -218,26 +368,33 @@ func (se *SessionEndpoint) handleData(ctx context.Context, data []byte) {
 		return
 	}
 
+	// 解析済みのヘッダーを載せ、Room側で再度解析しなくて済むようにする
+	msg := Message{
+		SessionID:  se.session.ID(),
+		DataType:   payloadHeader.DataType,
+		SubType:    payloadHeader.SubType,
+		Seq:        header.Seq,
+		ReceivedAt: receivedAt,
+		Data:       data,
+	}
 	switch payloadHeader.DataType {
 	case DataTypeControl:
-		se.handleControlMessage(ctx, ControlSubType(payloadHeader.SubType), data)
+		se.handleControlMessage(ctx, msg)
 		return
 	default:
 		// データメッセージをroom topicに転送
-		if se.roomID.IsEmpty() {
+		roomTopic, ok := se.roomTopic()
+		if !ok {
 			slog.WarnContext(ctx, "received data message before joining a room", "sessionID", se.session.ID())
 			return
 		}
-		roomTopic := Topic("room:" + se.roomID.String())
-		se.pubsub.Publish(ctx, roomTopic, Message{
-			SessionID: se.session.ID(),
-			Data:      data,
-		})
+		se.pubsub.Publish(ctx, roomTopic, msg)
 	}
 }
 
-func (se *SessionEndpoint) handleControlMessage(ctx context.Context, subType ControlSubType, data []byte) {
-	switch subType {
+func (se *SessionEndpoint) handleControlMessage(ctx context.Context, msg Message) {
+	data := msg.Data
+	switch ControlSubType(msg.SubType) {
 	case ControlSubTypeJoin:
 		payload, err := ParseJoinPayload(data[HeaderSize+PayloadHeaderSize:])
 		if err != nil {
@@ -255,21 +412,25 @@ func (se *SessionEndpoint) handleControlMessage(ctx context.Context, subType Con
 			roomID = defaultRoomID
 			slog.DebugContext(ctx, "auto-assigned room", "sessionID", se.session.ID(), "roomID", roomID)
 		}
-		se.roomID = roomID
-		slog.InfoContext(ctx, "session joined room", "sessionID", se.session.ID(), "roomID", se.roomID)
+		se.joinRoom(ctx, roomID)
+		slog.InfoContext(ctx, "session joined room", "sessionID", se.session.ID(), "roomID", roomID)
 		// room topicにJoinメッセージをpublish（Room.HandleMessageでsessions追加）
-		roomTopic := Topic("room:" + se.roomID.String())
-		se.pubsub.Publish(ctx, roomTopic, Message{SessionID: se.session.ID(), Data: data})
+		roomTopic, _ := se.roomTopic()
+		se.pubsub.Publish(ctx, roomTopic, msg)
 	case ControlSubTypeLeave:
-		if se.roomID.IsEmpty() {
+		roomID := se.RoomID()
+		roomTopic, ok := se.roomTopic()
+		if !ok {
 			slog.WarnContext(ctx, "session not in any room, cannot leave", "sessionID", se.session.ID())
 			return
 		}
 		// room topicにLeaveメッセージをpublish（Room.HandleMessageでsessions削除）
-		roomTopic := Topic("room:" + se.roomID.String())
-		se.pubsub.Publish(ctx, roomTopic, Message{SessionID: se.session.ID(), Data: data})
-		slog.InfoContext(ctx, "session left room", "sessionID", se.session.ID(), "roomID", se.roomID)
-		se.roomID = RoomID{}
+		se.pubsub.Publish(ctx, roomTopic, msg)
+		slog.InfoContext(ctx, "session left room", "sessionID", se.session.ID(), "roomID", roomID)
+		se.leaveRoom()
+	case ControlSubTypeKick:
+		// Kickはサーバー発のメッセージであり、クライアントからは受け付けない
+		slog.WarnContext(ctx, "client-sent kick rejected", "sessionID", se.session.ID())
 	}
 }
 
@@ -277,13 +438,18 @@ func (se *SessionEndpoint) handleControlMessage(ctx context.Context, subType Con
 func (se *SessionEndpoint) handleControlEvent(ctx context.Context, ev endpointEvent) {
 	switch ev.kind {
 	case evClose:
-		se.close()
+		se.close(ev.reason)
+	case evKick:
+		se.kick(ctx, ev.data)
 	case evPong:
 		se.session.TouchPong()
 	case evReadError:
-		return
+		// 読み取りエラーは接続断とみなし、接続を閉じる
+		slog.DebugContext(ctx, "read error, closing session", "sessionID", se.session.ID(), "err", ev.err)
+		se.close(CloseNormal)
 	case evWriteError:
-		return
+		// 書き込みに失敗したフレームは破棄して送信を続ける（接続断は読み取りエラーで検知する）
+		slog.WarnContext(ctx, "write error, frame dropped", "sessionID", se.session.ID(), "err", ev.err)
 	case evDispatchError:
 		return
 
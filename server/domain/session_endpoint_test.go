@@ -1,7 +1,9 @@
 package domain_test
 
 import (
+	"io"
 	"testing"
+	"time"
 
 	domain "withered/server/domain"
 	"withered/server/domain/mocks"
@@ -15,11 +17,11 @@ func TestNewSessionEndpoint_InitializesDefaults(t *testing.T) {
 
 	s := domain.NewSession()
 	tr := mocks.NewMockTransport(ctrl)
-	c := domain.NewConnection(s.ID(), tr)
+	c := domain.NewConnection(s.ID(), tr, "")
 	ps := mocks.NewMockPubSub(ctrl)
 	rm := mocks.NewMockRoomManager(ctrl)
 
-	se, err := domain.NewSessionEndpoint(s, c, ps, rm)
+	se, err := domain.NewSessionEndpoint(s, c, ps, rm, domain.NewSessionRegistry())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -27,3 +29,41 @@ func TestNewSessionEndpoint_InitializesDefaults(t *testing.T) {
 		t.Fatalf("endpoint is nil")
 	}
 }
+
+// トランスポートの読み取りエラーでセッションが閉じられ、Runが終了することを確認
+func TestSessionEndpoint_ReadErrorClosesSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tr := mocks.NewMockTransport(ctrl)
+	tr.EXPECT().Write(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	tr.EXPECT().Read(gomock.Any()).Return(nil, io.ErrUnexpectedEOF).AnyTimes()
+	closed := make(chan int32, 1)
+	tr.EXPECT().Close(gomock.Any(), gomock.Any()).DoAndReturn(func(code int32, _ string) error {
+		closed <- code
+		return nil
+	})
+
+	s := domain.NewSession()
+	c := domain.NewConnection(s.ID(), tr, "")
+	se, err := domain.NewSessionEndpoint(s, c, domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), domain.NewSessionRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- se.Run() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after read error")
+	}
+	select {
+	case code := <-closed:
+		if code != domain.CloseCodeNormal {
+			t.Errorf("close code = %d, want %d", code, domain.CloseCodeNormal)
+		}
+	default:
+		t.Error("transport was not closed")
+	}
+}
@@ -0,0 +1,152 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound はレジストリに該当するセッションが存在しない場合に返されるエラーです。
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionAlreadyRegistered は同じSessionIDが既に登録されている場合に返されるエラーです。
+	ErrSessionAlreadyRegistered = errors.New("session already registered")
+)
+
+// SessionInfo は稼働中セッションの状態のスナップショットです。
+type SessionInfo struct {
+	SessionID    SessionID    `json:"sessionId"`
+	Identity     Identity     `json:"identity"`
+	ConnectionID ConnectionID `json:"connectionId"`
+	RemoteAddr   string       `json:"remoteAddr"`
+	ConnectedAt  time.Time    `json:"connectedAt"`
+	RoomID       RoomID       `json:"roomId"`
+	// Stats はSessionEndpointから見た送受信のメッセージ数・バイト数です。常に設定されます。
+	Stats        ConnectionStats `json:"stats"`
+	SendQueueLen int             `json:"sendQueueLen"`
+	Dropped      uint64          `json:"dropped"`
+	// Transport は書き込みの所要時間などを含む詳細な計測値です。トランスポートが計測されている場合のみ設定されます。
+	Transport *TransportStats `json:"transport,omitempty"`
+}
+
+// SessionRegistry は稼働中のSessionEndpointを管理するレジストリです。
+// SessionEndpointはRun開始時に登録され、終了時に登録解除されます。
+type SessionRegistry struct {
+	mu        sync.RWMutex
+	endpoints map[SessionID]*SessionEndpoint
+}
+
+// NewSessionRegistry は新しいSessionRegistryを作成します。
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		endpoints: make(map[SessionID]*SessionEndpoint),
+	}
+}
+
+// Register はSessionEndpointを登録します。
+func (r *SessionRegistry) Register(se *SessionEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := se.session.ID()
+	if _, ok := r.endpoints[id]; ok {
+		return ErrSessionAlreadyRegistered
+	}
+	r.endpoints[id] = se
+	return nil
+}
+
+// Unregister はSessionEndpointの登録を解除します。
+// 同じSessionIDで別のSessionEndpointが登録されている場合は何もしません。
+func (r *SessionRegistry) Unregister(se *SessionEndpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := se.session.ID()
+	if r.endpoints[id] == se {
+		delete(r.endpoints, id)
+	}
+}
+
+// Get はSessionIDに対応するSessionEndpointを返します。
+func (r *SessionRegistry) Get(id SessionID) (*SessionEndpoint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	se, ok := r.endpoints[id]
+	return se, ok
+}
+
+// Count は登録中のセッション数を返します。
+func (r *SessionRegistry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.endpoints)
+}
+
+// Info はSessionIDに対応するセッションの状態を返します。
+func (r *SessionRegistry) Info(id SessionID) (SessionInfo, bool) {
+	se, ok := r.Get(id)
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return se.Info(), true
+}
+
+// List は登録中の全セッションの状態を接続時刻順で返します。
+func (r *SessionRegistry) List() []SessionInfo {
+	endpoints := r.snapshot()
+	infos := make([]SessionInfo, 0, len(endpoints))
+	for _, se := range endpoints {
+		infos = append(infos, se.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// Send は指定したセッションにデータを送信します。
+func (r *SessionRegistry) Send(id SessionID, data []byte) error {
+	se, ok := r.Get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return se.Send(data)
+}
+
+// Close は指定したセッションを閉じます。
+func (r *SessionRegistry) Close(ctx context.Context, id SessionID) error {
+	se, ok := r.Get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	se.Close(ctx)
+	return nil
+}
+
+// CloseAll は登録中の全セッションを閉じます。
+// ctxがキャンセルされた場合、残りのセッションは強制的に閉じられます。
+func (r *SessionRegistry) CloseAll(ctx context.Context) {
+	for _, se := range r.snapshot() {
+		if ctx.Err() != nil {
+			se.ForceClose()
+			continue
+		}
+		se.Close(ctx)
+	}
+}
+
+// snapshot はロック外で操作するために登録中のSessionEndpointを複製します。
+func (r *SessionRegistry) snapshot() []*SessionEndpoint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	endpoints := make([]*SessionEndpoint, 0, len(r.endpoints))
+	for _, se := range r.endpoints {
+		endpoints = append(endpoints, se)
+	}
+	return endpoints
+}
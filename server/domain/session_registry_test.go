@@ -0,0 +1,89 @@
+package domain_test
+
+import (
+	"testing"
+
+	"go.uber.org/mock/gomock"
+	domain "withered/server/domain"
+	"withered/server/domain/mocks"
+)
+
+func newTestEndpoint(t *testing.T, ctrl *gomock.Controller, registry *domain.SessionRegistry) *domain.SessionEndpoint {
+	t.Helper()
+	s := domain.NewSession()
+	c := domain.NewConnection(s.ID(), mocks.NewMockTransport(ctrl), "127.0.0.1:12345")
+	se, err := domain.NewSessionEndpoint(s, c, mocks.NewMockPubSub(ctrl), mocks.NewMockRoomManager(ctrl), registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return se
+}
+
+func TestSessionRegistry_RegisterAndLookup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	registry := domain.NewSessionRegistry()
+	se := newTestEndpoint(t, ctrl, registry)
+	id := se.Info().SessionID
+
+	if err := registry.Register(se); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := registry.Register(se); err != domain.ErrSessionAlreadyRegistered {
+		t.Errorf("expected ErrSessionAlreadyRegistered, got %v", err)
+	}
+	if registry.Count() != 1 {
+		t.Errorf("Count = %d, want 1", registry.Count())
+	}
+
+	got, ok := registry.Get(id)
+	if !ok || got != se {
+		t.Fatalf("Get(%s) = (%v, %v), want registered endpoint", id, got, ok)
+	}
+	info, ok := registry.Info(id)
+	if !ok {
+		t.Fatalf("Info(%s) not found", id)
+	}
+	if info.RemoteAddr != "127.0.0.1:12345" {
+		t.Errorf("RemoteAddr = %q, want %q", info.RemoteAddr, "127.0.0.1:12345")
+	}
+	if info.ConnectedAt.IsZero() {
+		t.Error("ConnectedAt is zero")
+	}
+
+	registry.Unregister(se)
+	if registry.Count() != 0 {
+		t.Errorf("Count = %d after Unregister, want 0", registry.Count())
+	}
+	if _, ok := registry.Get(id); ok {
+		t.Error("Get succeeded after Unregister")
+	}
+}
+
+func TestSessionRegistry_List(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	registry := domain.NewSessionRegistry()
+
+	for i := 0; i < 3; i++ {
+		if err := registry.Register(newTestEndpoint(t, ctrl, registry)); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+	}
+
+	infos := registry.List()
+	if len(infos) != 3 {
+		t.Fatalf("List length = %d, want 3", len(infos))
+	}
+	for i := 1; i < len(infos); i++ {
+		if infos[i].ConnectedAt.Before(infos[i-1].ConnectedAt) {
+			t.Errorf("List not sorted by ConnectedAt at %d", i)
+		}
+	}
+}
+
+func TestSessionRegistry_SendNotFound(t *testing.T) {
+	registry := domain.NewSessionRegistry()
+
+	if err := registry.Send(domain.NewSessionID(), []byte{0}); err != domain.ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 // TestNewSession_InitializesTimestamps は NewSession がタイムスタンプを初期化することを確認します。
 func TestNewSession_InitializesTimestamps(t *testing.T) {
@@ -16,3 +19,48 @@ func TestNewSession_InitializesTimestamps(t *testing.T) {
 		t.Errorf("lastPong is not initialized")
 	}
 }
+
+// TestSession_EnqueueCountsDrops は送信キュー満杯時に破棄数が加算されることを確認します。
+func TestSession_EnqueueCountsDrops(t *testing.T) {
+	s := NewSession()
+
+	for i := 0; i < DefaultSendQueueSize; i++ {
+		if !s.Enqueue([]byte{byte(i)}) {
+			t.Fatalf("Enqueue failed at %d", i)
+		}
+	}
+	if s.Enqueue([]byte{0}) {
+		t.Fatal("Enqueue succeeded on full queue")
+	}
+	if got := s.DroppedMessages(); got != 1 {
+		t.Errorf("DroppedMessages = %d, want 1", got)
+	}
+}
+
+// TestSession_IsSlowConsumer は高水位状態の継続時間で遅い消費者を判定することを確認します。
+func TestSession_IsSlowConsumer(t *testing.T) {
+	s := NewSession()
+
+	for i := 0; i < DefaultSendQueueSize; i++ {
+		s.Enqueue([]byte{0})
+	}
+	if s.IsSlowConsumer(time.Hour) {
+		t.Error("IsSlowConsumer = true before threshold elapsed")
+	}
+
+	// 高水位に達した時刻を過去にずらす
+	s.sendQ.mu.Lock()
+	s.sendQ.highSince = time.Now().Add(-time.Minute)
+	s.sendQ.mu.Unlock()
+	if !s.IsSlowConsumer(time.Second) {
+		t.Error("IsSlowConsumer = false after threshold elapsed")
+	}
+
+	// キューが高水位を下回ったら解除される
+	for s.SendQueueLen() > 0 {
+		s.Dequeue()
+	}
+	if s.IsSlowConsumer(time.Second) {
+		t.Error("IsSlowConsumer = true after queue drained")
+	}
+}
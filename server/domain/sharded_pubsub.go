@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"context"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultShardCount はNewShardedPubSubでシャード数を指定しなかった場合のシャード数です。
+const DefaultShardCount = 64
+
+// ShardedPubSub はトピックのハッシュでシャードに分割したインメモリのPubSub実装です。
+//
+// 各シャードは独立したロックと購読者一覧を持つため、異なるトピックへのPublishが
+// 1つのロックのキャッシュラインを奪い合うことはありません。購読者のスライスは変更せずに差し替える
+// （copy-on-write）ため、Publishはロックを短時間だけ取り、配送はロック外で行います。
+type ShardedPubSub struct {
+	seed     maphash.Seed
+	mask     uint64
+	shards   []pubsubShard
+	patterns patternSubscribers
+}
+
+type pubsubShard struct {
+	mu      sync.RWMutex
+	topics  map[Topic]*topicEntry
+	retired topicTotals
+	// 隣接するシャードのロックが同じキャッシュラインに載らないようにする
+	_ [64]byte
+}
+
+// NewShardedPubSub はshards個のシャードを持つShardedPubSubを作成します。
+// shardsは2の累乗に切り上げられ、0以下の場合はDefaultShardCountを使います。
+func NewShardedPubSub(shards int) *ShardedPubSub {
+	if shards <= 0 {
+		shards = DefaultShardCount
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	p := &ShardedPubSub{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(n - 1),
+		shards: make([]pubsubShard, n),
+	}
+	for i := range p.shards {
+		p.shards[i].topics = make(map[Topic]*topicEntry)
+	}
+	return p
+}
+
+func (p *ShardedPubSub) shard(topic Topic) *pubsubShard {
+	return &p.shards[maphash.String(p.seed, string(topic))&p.mask]
+}
+
+// Subscribe はトピックを購読します。
+func (p *ShardedPubSub) Subscribe(ctx context.Context, topic Topic, opts SubscribeOptions) *Subscription {
+	s := p.shard(topic)
+	sub := NewSubscription(ctx, topic, opts, s.remove)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// ctxが既にキャンセルされていた場合、登録前に終了している（closedを参照）
+	if sub.closed() {
+		return sub
+	}
+	e, ok := s.topics[topic]
+	if !ok {
+		e = newTopicEntry(time.Now())
+		s.topics[topic] = e
+	}
+	e.subs = append(e.subs[:len(e.subs):len(e.subs)], sub)
+	return sub
+}
+
+// remove は終了した購読を購読者の一覧から取り除きます。
+func (s *pubsubShard) remove(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topic := sub.Topic()
+	e, ok := s.topics[topic]
+	if !ok {
+		return
+	}
+	i := slices.Index(e.subs, sub)
+	if i < 0 {
+		return
+	}
+	// 購読者がいなくなったらトピックを削除し、計測値は累計に移す
+	if len(e.subs) == 1 {
+		delete(s.topics, topic)
+		s.retired.retire(e)
+		return
+	}
+	e.subs = slices.Delete(slices.Clone(e.subs), i, i+1)
+}
+
+// SubscribePattern はpatternにマッチする全てのトピックを購読します。
+func (p *ShardedPubSub) SubscribePattern(ctx context.Context, pattern TopicPattern, opts SubscribeOptions) *Subscription {
+	return p.patterns.subscribe(ctx, pattern, opts)
+}
+
+// Publish はトピックにメッセージを配信します。
+// 配送はbest-effort: バッファが満杯の購読者へは各購読のOverflowPolicyに従って配送し、継続します。
+// 配信中に終了した購読者には配送されません。
+func (p *ShardedPubSub) Publish(ctx context.Context, topic Topic, msg Message) {
+	msg.Topic = topic
+	s := p.shard(topic)
+	s.mu.RLock()
+	e := s.topics[topic]
+	var subs []*Subscription
+	if e != nil {
+		subs = e.subs
+	}
+	s.mu.RUnlock()
+
+	if e != nil {
+		e.deliver(ctx, subs, msg)
+	}
+	p.patterns.publish(ctx, topic, msg)
+}
+
+// PubSubStats はトピックごとの購読者数と配送数を返します。シャードごとにロックを取るため、
+// シャードをまたいだ値は同一時点のものとは限りません。
+func (p *ShardedPubSub) PubSubStats() PubSubStats {
+	var stats PubSubStats
+	now := time.Now()
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.mu.RLock()
+		collectTopicStats(&stats, s.topics, s.retired, now)
+		s.mu.RUnlock()
+	}
+	sortTopicStats(stats.Topics)
+	stats.Patterns = p.patterns.stats()
+	return stats
+}
@@ -0,0 +1,236 @@
+package domain
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedPubSub_PublishSubscribe(t *testing.T) {
+	ps := NewShardedPubSub(4)
+	ctx := context.Background()
+
+	subs := make([]*Subscription, 0, 16)
+	for i := range 16 {
+		sub := ps.Subscribe(ctx, Topic("room:"+string(rune('a'+i))), SubscribeOptions{})
+		defer sub.Close()
+		subs = append(subs, sub)
+	}
+	// 同じトピックの2人目の購読者
+	second := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	defer second.Close()
+
+	ps.Publish(ctx, "room:a", Message{Data: []byte("hello")})
+
+	for _, sub := range []*Subscription{subs[0], second} {
+		select {
+		case msg := <-sub.C():
+			if string(msg.Data) != "hello" {
+				t.Errorf("Data = %q, want %q", msg.Data, "hello")
+			}
+		default:
+			t.Fatal("message was not delivered")
+		}
+	}
+	for _, sub := range subs[1:] {
+		if got := sub.Stats().Delivered; got != 0 {
+			t.Errorf("%s: Delivered = %d, want 0", sub.Topic(), got)
+		}
+	}
+}
+
+func TestShardedPubSub_CloseRemovesTopic(t *testing.T) {
+	ps := NewShardedPubSub(0)
+	if len(ps.shards) != DefaultShardCount {
+		t.Errorf("shards = %d, want %d", len(ps.shards), DefaultShardCount)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	b := ps.Subscribe(context.Background(), "room:a", SubscribeOptions{})
+	cancel()
+	<-a.Done()
+	if n := len(ps.shard("room:a").topics["room:a"].subs); n != 1 {
+		t.Errorf("subscribers = %d, want 1", n)
+	}
+	b.Close()
+	if _, ok := ps.shard("room:a").topics["room:a"]; ok {
+		t.Error("topic was not removed after the last subscriber left")
+	}
+
+	// キャンセル済みのctxで購読した場合は登録されない
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	<-sub.Done()
+	if _, ok := ps.shard("room:a").topics["room:a"]; ok {
+		t.Error("subscription with cancelled context was registered")
+	}
+}
+
+// TestShardedPubSub_ConcurrentPublishAndClose はPublishと購読の変更が並行しても
+// 閉じたチャネルへの送信が起きないことを確認します。-raceで実行してください。
+func TestShardedPubSub_ConcurrentPublishAndClose(t *testing.T) {
+	ps := NewShardedPubSub(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	topics := []Topic{"room:a", "room:b", "room:c"}
+	var wg sync.WaitGroup
+	for i := range 6 {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				ps.Publish(ctx, topics[i%len(topics)], Message{})
+			}
+		})
+	}
+
+	var subs sync.WaitGroup
+	for i := range 300 {
+		subs.Go(func() {
+			sub := ps.Subscribe(ctx, topics[i%len(topics)], SubscribeOptions{BufferSize: 4})
+			go sub.Close()
+			for range sub.C() {
+			}
+		})
+	}
+	subs.Wait()
+	cancel()
+	wg.Wait()
+
+	for i := range ps.shards {
+		ps.shards[i].mu.RLock()
+		n := len(ps.shards[i].topics)
+		ps.shards[i].mu.RUnlock()
+		if n != 0 {
+			t.Errorf("shard %d: topics = %d, want 0", i, n)
+		}
+	}
+}
+
+const (
+	benchSessions = 10000
+	benchRooms    = 100
+)
+
+// benchmarkRoomBroadcast はbenchSessions人がbenchRooms個のルームに均等に参加している状態で、
+// ルームのブロードキャスト（メンバーごとのsession:トピックへのPublish）を並行に行います。
+// goroutineのスケジューリングの影響を除くため、受信側のドレインもPublishした側で行います。
+func benchmarkRoomBroadcast(b *testing.B, ps PubSub) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	topics := make([]Topic, benchSessions)
+	subs := make([]*Subscription, benchSessions)
+	for i := range topics {
+		topics[i] = SessionTopic(NewSessionID())
+		subs[i] = ps.Subscribe(ctx, topics[i], SubscribeOptions{})
+	}
+	members := benchSessions / benchRooms
+	msg := Message{Data: make([]byte, 64)}
+	var next atomic.Uint64
+
+	// 購読の登録で生じたゴミを計測前に回収しておく
+	runtime.GC()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			room := int(next.Add(1) % benchRooms)
+			from, to := room*members, (room+1)*members
+			for _, topic := range topics[from:to] {
+				ps.Publish(ctx, topic, msg)
+			}
+			for _, sub := range subs[from:to] {
+				drain(sub)
+			}
+		}
+	})
+}
+
+func drain(sub *Subscription) {
+	for {
+		select {
+		case <-sub.C():
+		default:
+			return
+		}
+	}
+}
+
+func BenchmarkPubSub_RoomBroadcast(b *testing.B) {
+	b.Run("simple", func(b *testing.B) { benchmarkRoomBroadcast(b, NewSimplePubSub()) })
+	b.Run("sharded", func(b *testing.B) { benchmarkRoomBroadcast(b, NewShardedPubSub(DefaultShardCount)) })
+}
+
+// benchmarkRoomMulticast はbenchmarkRoomBroadcastと同じ構成で、メンバーがルームのマルチキャストを購読し、
+// 共有バッファを1回Publishしてブロードキャストします。
+func benchmarkRoomMulticast(b *testing.B, ps PubSub) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rooms := make([]Topic, benchRooms)
+	for i := range rooms {
+		rooms[i] = RoomMulticastTopic(RoomID{byte(i), byte(i >> 8)})
+	}
+	members := benchSessions / benchRooms
+	subs := make([]*Subscription, benchSessions)
+	for i := range subs {
+		subs[i] = ps.Subscribe(ctx, rooms[i/members], SubscribeOptions{})
+	}
+	data := make([]byte, 64)
+	var next atomic.Uint64
+
+	runtime.GC()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			room := int(next.Add(1) % benchRooms)
+			payload := NewSharedPayload(data, nil)
+			ps.Publish(ctx, rooms[room], Message{Data: data, Payload: payload})
+			payload.Release()
+			for _, sub := range subs[room*members : (room+1)*members] {
+				drainReleasing(sub)
+			}
+		}
+	})
+}
+
+func drainReleasing(sub *Subscription) {
+	for {
+		select {
+		case msg := <-sub.C():
+			msg.Payload.Release()
+		default:
+			return
+		}
+	}
+}
+
+func BenchmarkPubSub_RoomMulticast(b *testing.B) {
+	b.Run("simple", func(b *testing.B) { benchmarkRoomMulticast(b, NewSimplePubSub()) })
+	b.Run("sharded", func(b *testing.B) { benchmarkRoomMulticast(b, NewShardedPubSub(DefaultShardCount)) })
+}
+
+// benchmarkSubscribeClose は購読の追加・削除のコストを測ります。
+func benchmarkSubscribeClose(b *testing.B, ps PubSub) {
+	ctx := context.Background()
+	for range benchSessions {
+		ps.Subscribe(ctx, SessionTopic(NewSessionID()), SubscribeOptions{BufferSize: 1})
+	}
+	topic := SessionTopic(NewSessionID())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		ps.Subscribe(ctx, topic, SubscribeOptions{BufferSize: 1}).Close()
+	}
+}
+
+func BenchmarkPubSub_SubscribeClose(b *testing.B) {
+	b.Run("simple", func(b *testing.B) { benchmarkSubscribeClose(b, NewSimplePubSub()) })
+	b.Run("sharded", func(b *testing.B) { benchmarkSubscribeClose(b, NewShardedPubSub(DefaultShardCount)) })
+}
@@ -0,0 +1,56 @@
+package domain
+
+import "sync/atomic"
+
+// SharedPayload は複数のセッションに同じ内容を送るための参照カウント付きバッファです。
+//
+// 作成時の参照は作成者が持ちます。PubSubは購読者のバッファに積むたびにRetainし、
+// 受信者は送信し終えたとき（または破棄するとき）にReleaseします。最後の参照がReleaseされると
+// releaseが呼ばれるため、エンコード済みのバッファをプールに戻して再利用できます。
+// Releaseされなかった場合もメモリはGCで回収され、バッファが再利用されないだけです。
+//
+// Transport.Writeは書き込み後にバッファを保持しない（必要なら複製する）ため、
+// 送信後にReleaseして構いません。nilのSharedPayloadに対するRetain・Releaseは何もしません。
+type SharedPayload struct {
+	data    []byte
+	refs    atomic.Int32
+	release func([]byte)
+}
+
+// NewSharedPayload は参照数1のSharedPayloadを作成します。releaseがnilの場合はバッファを再利用しません。
+func NewSharedPayload(data []byte, release func([]byte)) *SharedPayload {
+	p := &SharedPayload{data: data, release: release}
+	p.refs.Store(1)
+	return p
+}
+
+// Bytes はバッファを返します。Releaseした後に使ってはいけません。
+func (p *SharedPayload) Bytes() []byte {
+	if p == nil {
+		return nil
+	}
+	return p.data
+}
+
+// Retain は参照を1つ増やします。
+func (p *SharedPayload) Retain() {
+	if p == nil {
+		return
+	}
+	p.refs.Add(1)
+}
+
+// Release は参照を1つ減らし、最後の参照であればreleaseを呼びます。
+func (p *SharedPayload) Release() {
+	if p == nil {
+		return
+	}
+	switch n := p.refs.Add(-1); {
+	case n == 0:
+		if p.release != nil {
+			p.release(p.data)
+		}
+	case n < 0:
+		panic("domain: SharedPayload released more times than retained")
+	}
+}
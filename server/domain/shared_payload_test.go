@@ -0,0 +1,37 @@
+package domain
+
+import "testing"
+
+func TestSharedPayload_ReleaseOnLastRef(t *testing.T) {
+	data := []byte("state")
+	var released [][]byte
+	p := NewSharedPayload(data, func(b []byte) { released = append(released, b) })
+
+	p.Retain()
+	p.Retain()
+	p.Release()
+	p.Release()
+	if len(released) != 0 {
+		t.Fatal("released while references remain")
+	}
+	p.Release()
+	if len(released) != 1 || &released[0][0] != &data[0] {
+		t.Fatalf("released = %v, want the original buffer once", released)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("over-release did not panic")
+		}
+	}()
+	p.Release()
+}
+
+func TestSharedPayload_Nil(t *testing.T) {
+	var p *SharedPayload
+	p.Retain()
+	p.Release()
+	if p.Bytes() != nil {
+		t.Error("Bytes() of nil payload is not nil")
+	}
+}
@@ -2,76 +2,99 @@ package domain
 
 import (
 	"context"
-	"log/slog"
+	"slices"
 	"sync"
-)
-
-const (
-	// DefaultChannelBuffer はSubscribeで作成されるチャネルのデフォルトバッファサイズです。
-	DefaultChannelBuffer = 1024
+	"time"
 )
 
 // SimplePubSub はインメモリのPubSub実装です。
 type SimplePubSub struct {
-	mu          sync.RWMutex
-	subscribers map[Topic][]chan Message
+	mu       sync.RWMutex
+	topics   map[Topic]*topicEntry
+	retired  topicTotals
+	patterns patternSubscribers
 }
 
 // NewSimplePubSub は新しいSimplePubSubを作成します。
 func NewSimplePubSub() *SimplePubSub {
 	return &SimplePubSub{
-		subscribers: make(map[Topic][]chan Message),
+		topics: make(map[Topic]*topicEntry),
 	}
 }
 
-// Subscribe はトピックを購読し、メッセージを受信するチャネルを返します。
-func (p *SimplePubSub) Subscribe(topic Topic) <-chan Message {
+// Subscribe はトピックを購読します。
+func (p *SimplePubSub) Subscribe(ctx context.Context, topic Topic, opts SubscribeOptions) *Subscription {
+	sub := NewSubscription(ctx, topic, opts, p.remove)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-
-	ch := make(chan Message, DefaultChannelBuffer)
-	p.subscribers[topic] = append(p.subscribers[topic], ch)
-	return ch
+	// ctxが既にキャンセルされていた場合、登録前に終了している（closedを参照）
+	if sub.closed() {
+		return sub
+	}
+	e, ok := p.topics[topic]
+	if !ok {
+		e = newTopicEntry(time.Now())
+		p.topics[topic] = e
+	}
+	e.subs = append(e.subs[:len(e.subs):len(e.subs)], sub)
+	return sub
 }
 
-// Unsubscribe は購読を解除します。
-func (p *SimplePubSub) Unsubscribe(topic Topic, ch <-chan Message) {
+// remove は終了した購読を購読者の一覧から取り除きます。
+func (p *SimplePubSub) remove(sub *Subscription) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	subs := p.subscribers[topic]
-	for i, sub := range subs {
-		if sub == ch {
-			// チャネルを閉じる
-			close(sub)
-			// スライスから削除
-			p.subscribers[topic] = append(subs[:i], subs[i+1:]...)
-			break
-		}
+	topic := sub.Topic()
+	e, ok := p.topics[topic]
+	if !ok {
+		return
 	}
-
-	// 購読者がいなくなったらトピックを削除
-	if len(p.subscribers[topic]) == 0 {
-		delete(p.subscribers, topic)
+	i := slices.Index(e.subs, sub)
+	if i < 0 {
+		return
+	}
+	// 購読者がいなくなったらトピックを削除し、計測値は累計に移す
+	if len(e.subs) == 1 {
+		delete(p.topics, topic)
+		p.retired.retire(e)
+		return
 	}
+	e.subs = slices.Delete(slices.Clone(e.subs), i, i+1)
+}
+
+// SubscribePattern はpatternにマッチする全てのトピックを購読します。
+func (p *SimplePubSub) SubscribePattern(ctx context.Context, pattern TopicPattern, opts SubscribeOptions) *Subscription {
+	return p.patterns.subscribe(ctx, pattern, opts)
 }
 
 // Publish はトピックにメッセージを配信します。
-// 配送はbest-effort: チャネルが満杯の購読者はスキップして継続します。
+// 配送はbest-effort: バッファが満杯の購読者へは各購読のOverflowPolicyに従って配送し、継続します。
+// 配信中に終了した購読者には配送されません。
 func (p *SimplePubSub) Publish(ctx context.Context, topic Topic, msg Message) {
+	msg.Topic = topic
 	p.mu.RLock()
-	subs := p.subscribers[topic]
+	e := p.topics[topic]
+	var subs []*Subscription
+	if e != nil {
+		subs = e.subs
+	}
 	p.mu.RUnlock()
 
-	for _, ch := range subs {
-		select {
-		case <-ctx.Done():
-			return
-		case ch <- msg:
-			// 送信成功
-		default:
-			// チャネルが満杯の場合はスキップしてログ出力
-			slog.Warn("pub/sub: channel full, message dropped", "topic", topic)
-		}
+	if e != nil {
+		e.deliver(ctx, subs, msg)
 	}
+	p.patterns.publish(ctx, topic, msg)
+}
+
+// PubSubStats はトピックごとの購読者数と配送数を返します。
+func (p *SimplePubSub) PubSubStats() PubSubStats {
+	var stats PubSubStats
+	p.mu.RLock()
+	collectTopicStats(&stats, p.topics, p.retired, time.Now())
+	p.mu.RUnlock()
+	sortTopicStats(stats.Topics)
+	stats.Patterns = p.patterns.stats()
+	return stats
 }
@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSimplePubSub_PublishSubscribe(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx := context.Background()
+
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	defer sub.Close()
+	other := ps.Subscribe(ctx, "room:b", SubscribeOptions{})
+	defer other.Close()
+
+	ps.Publish(ctx, "room:a", Message{Data: []byte("hello")})
+
+	select {
+	case msg := <-sub.C():
+		if string(msg.Data) != "hello" {
+			t.Errorf("Data = %q, want %q", msg.Data, "hello")
+		}
+	default:
+		t.Fatal("message was not delivered")
+	}
+	select {
+	case msg := <-other.C():
+		t.Fatalf("unexpected message on other topic: %v", msg)
+	default:
+	}
+	if got := sub.Stats(); got.Delivered != 1 || got.Dropped != 0 {
+		t.Errorf("Stats = %+v, want Delivered=1 Dropped=0", got)
+	}
+}
+
+func TestSimplePubSub_CloseRemovesSubscriber(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx := context.Background()
+
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	sub.Close()
+	sub.Close() // 2回目は何もしない
+
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("channel is not closed")
+	}
+	if _, ok := ps.topics["room:a"]; ok {
+		t.Error("topic was not removed after Close")
+	}
+	if sub.Deliver(context.Background(), Message{}) {
+		t.Error("Deliver after Close succeeded")
+	}
+	ps.Publish(ctx, "room:a", Message{})
+}
+
+func TestSimplePubSub_ContextCancelEndsSubscription(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sub := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	cancel()
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed on context cancel")
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("channel is not closed")
+	}
+	ps.mu.RLock()
+	_, ok := ps.topics["room:a"]
+	ps.mu.RUnlock()
+	if ok {
+		t.Error("topic was not removed after the last subscriber left")
+	}
+
+	// キャンセル済みのctxで購読した場合は登録されない
+	sub = ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+	<-sub.Done()
+	ps.mu.RLock()
+	_, ok = ps.topics["room:a"]
+	ps.mu.RUnlock()
+	if ok {
+		t.Error("subscription with cancelled context was registered")
+	}
+}
+
+func TestSubscription_DropWhenFull(t *testing.T) {
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 2}, nil)
+	defer sub.Close()
+
+	for range 5 {
+		sub.Deliver(context.Background(), Message{})
+	}
+	if got := sub.Stats(); got.Delivered != 2 || got.Dropped != 3 {
+		t.Errorf("Stats = %+v, want Delivered=2 Dropped=3", got)
+	}
+}
+
+func TestSubscription_BufferedMessagesReadableAfterClose(t *testing.T) {
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 4}, nil)
+	sub.Deliver(context.Background(), Message{Data: []byte{1}})
+	sub.Deliver(context.Background(), Message{Data: []byte{2}})
+	sub.Close()
+
+	var got []byte
+	for msg := range sub.C() {
+		got = append(got, msg.Data...)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("received %v, want [1 2]", got)
+	}
+}
+
+// TestSimplePubSub_ConcurrentPublishAndClose はPublishと購読の終了が並行しても
+// 閉じたチャネルへの送信が起きないことを確認します。-raceで実行してください。
+func TestSimplePubSub_ConcurrentPublishAndClose(t *testing.T) {
+	ps := NewSimplePubSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const topic Topic = "room:stress"
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				ps.Publish(ctx, topic, Message{Data: []byte("x")})
+			}
+		})
+	}
+
+	var subs sync.WaitGroup
+	for i := range 200 {
+		subs.Go(func() {
+			subCtx, subCancel := context.WithCancel(ctx)
+			defer subCancel()
+			sub := ps.Subscribe(subCtx, topic, SubscribeOptions{})
+			// 半分はClose、半分はctxのキャンセルで終了させる
+			if i%2 == 0 {
+				sub.Close()
+			} else {
+				subCancel()
+			}
+			for range sub.C() {
+			}
+		})
+	}
+	subs.Wait()
+	cancel()
+	wg.Wait()
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if n := len(ps.topics); n != 0 {
+		t.Errorf("topics = %d, want 0", n)
+	}
+}
+
+// TestSubscription_ConcurrentDeliverAndClose は同じ購読へのDeliverとCloseの競合を繰り返し確認します。
+func TestSubscription_ConcurrentDeliverAndClose(t *testing.T) {
+	for range 500 {
+		sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 64}, nil)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				for range 10 {
+					sub.Deliver(context.Background(), Message{})
+				}
+			})
+		}
+		wg.Go(sub.Close)
+		wg.Wait()
+
+		if stats := sub.Stats(); stats.Dropped != 0 || stats.Delivered > 40 {
+			t.Fatalf("Stats = %+v, want Dropped=0 Delivered<=40", stats)
+		}
+		if _, ok := <-sub.Done(); ok {
+			t.Fatal("done is not closed")
+		}
+	}
+}
+
+// TestPubSub_SubscribeCanceledContext はキャンセル済みのctxで購読した場合に、
+// 終了した購読が購読者の一覧に残らないことを確認します。
+func TestPubSub_SubscribeCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pattern, _ := ParseTopicPattern("room:*")
+
+	for _, tc := range []struct {
+		name string
+		ps   interface {
+			PubSub
+			PubSubStatsReporter
+		}
+	}{
+		{"simple", NewSimplePubSub()},
+		{"sharded", NewShardedPubSub(4)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for range 1000 {
+				sub := tc.ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+				psub := tc.ps.SubscribePattern(ctx, pattern, SubscribeOptions{})
+				<-sub.Done()
+				<-psub.Done()
+			}
+			if stats := tc.ps.PubSubStats(); len(stats.Topics) != 0 || len(stats.Patterns) != 0 {
+				t.Errorf("stats = %+v, want no topics or patterns", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultChannelBuffer はSubscribeで作成されるチャネルのデフォルトバッファサイズです。
+	DefaultChannelBuffer = 1024
+	// DefaultBlockTimeout はOverflowBlockで満杯のバッファが空くのを待つデフォルトの時間です。
+	DefaultBlockTimeout = 10 * time.Millisecond
+)
+
+// OverflowPolicy は購読のバッファが満杯のときの振る舞いです。
+type OverflowPolicy uint8
+
+const (
+	// OverflowDropNewest は新しいメッセージを破棄します（デフォルト）。
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest はバッファの最も古いメッセージを破棄して新しいメッセージを積みます。
+	OverflowDropOldest
+	// OverflowBlock はBlockTimeoutまでバッファが空くのを待ち、空かなければ新しいメッセージを破棄します。
+	// 待っている間はPublishが戻らないため、同じトピックの他の購読者への配送も遅れます。
+	OverflowBlock
+	// OverflowCoalesce は同じキーの未読メッセージを新しいメッセージで置き換えます。
+	// 位置や状態のように最新の値だけが意味を持つメッセージに使います。
+	OverflowCoalesce
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropNewest:
+		return "drop newest"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowCoalesce:
+		return "coalesce"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
+// SubscribeOptions は購読ごとの設定です。ゼロ値はデフォルト設定を表します。
+type SubscribeOptions struct {
+	// BufferSize は未読メッセージを保持できる件数です。0以下の場合はDefaultChannelBufferを使います。
+	BufferSize int
+	// Overflow はバッファが満杯のときの振る舞いです。
+	Overflow OverflowPolicy
+	// BlockTimeout はOverflowBlockで待つ時間です。0以下の場合はDefaultBlockTimeoutを使います。
+	BlockTimeout time.Duration
+	// CoalesceKey はOverflowCoalesceで同一とみなすキーを返します。nilの場合は送信元のSessionIDを使います。
+	CoalesceKey func(Message) string
+	// Start は購読を開始する位置です。ログを残すトピック（LogPubSub）でのみ有効で、
+	// 過去のメッセージを受け取ってから新しいメッセージを受け取ります。
+	Start StartPosition
+}
+
+func (o SubscribeOptions) withDefaults() SubscribeOptions {
+	if o.BufferSize <= 0 {
+		o.BufferSize = DefaultChannelBuffer
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = DefaultBlockTimeout
+	}
+	if o.CoalesceKey == nil {
+		o.CoalesceKey = func(msg Message) string { return msg.SessionID.String() }
+	}
+	return o
+}
+
+// SubscriptionStats は購読ごとの配送数のスナップショットです。
+type SubscriptionStats struct {
+	// Delivered はチャネルに積まれたメッセージ数です。
+	Delivered uint64 `json:"delivered"`
+	// Dropped は破棄したメッセージ数です。OverflowCoalesceで置き換えられたメッセージも含みます。
+	Dropped uint64 `json:"dropped"`
+	// HighWaterMark は未読メッセージが最も多く溜まったときの件数です。
+	HighWaterMark int `json:"highWaterMark"`
+}
+
+// Subscription はPubSubの1つの購読を表すハンドルです。
+//
+// 購読が終了するとCのチャネルは閉じられます。DeliverとCloseは任意のgoroutineから並行に呼び出せ、
+// 閉じたチャネルへの送信は起こりません。
+type Subscription struct {
+	topic Topic
+	opts  SubscribeOptions
+	ch    chan Message
+
+	// mu はチャネルへの送信（RLock）とチャネルのクローズ（Lock）を排他します。stopもmuで保護します。
+	mu        sync.RWMutex
+	closeOnce sync.Once
+	// closing はCloseが始まると（onCloseより先に）trueになる。PubSubが登録の可否を判定するために使う
+	closing atomic.Bool
+	done    chan struct{}
+	stop    func() bool
+	onClose func(*Subscription)
+
+	// OverflowCoalesceの未読メッセージ。pumpがキーの到着順にchへ送る
+	pendingMu sync.Mutex
+	pending   map[string]Message
+	order     []string
+	// pendingClosed はCloseがpendingを破棄した後にtrueになり、以降のcoalesceは積まない
+	pendingClosed bool
+	notify        chan struct{}
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	highWater atomic.Int64
+}
+
+// NewSubscription はoptsに従ってメッセージを保持するSubscriptionを作成します。
+// PubSubの実装が使用します。onCloseは購読の終了時に一度だけ呼ばれ、購読者の一覧から取り除くために使います。
+// ctxがキャンセルされると購読は自動的に終了します。
+func NewSubscription(ctx context.Context, topic Topic, opts SubscribeOptions, onClose func(*Subscription)) *Subscription {
+	opts = opts.withDefaults()
+	s := &Subscription{
+		topic:   topic,
+		opts:    opts,
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+	if opts.Overflow == OverflowCoalesce {
+		// 未読はpendingに保持し、置き換えられるようにする
+		s.ch = make(chan Message)
+		s.pending = make(map[string]Message)
+		s.notify = make(chan struct{}, 1)
+		go s.pump()
+	} else {
+		s.ch = make(chan Message, opts.BufferSize)
+	}
+	// ctxがキャンセル済みの場合、AfterFuncのCloseはstopの代入より先に走りうる
+	s.mu.Lock()
+	s.stop = context.AfterFunc(ctx, s.Close)
+	s.mu.Unlock()
+	return s
+}
+
+// Topic は購読しているトピックを返します。
+func (s *Subscription) Topic() Topic {
+	return s.topic
+}
+
+// Options は購読の設定を返します。
+func (s *Subscription) Options() SubscribeOptions {
+	return s.opts
+}
+
+// C はメッセージを受信するチャネルを返します。購読が終了すると閉じられます。
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Done は購読が終了すると閉じられるチャネルを返します。閉じた時点でPubSubの購読者からは取り除かれています。
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// closed はCloseが呼ばれたかどうかを返します。Doneと異なり、onCloseの実行中からtrueになります。
+//
+// PubSubはSubscribeで購読者の一覧のロックを取ってからこれを確かめ、falseの場合のみ登録します。
+// ctxがキャンセル済みでCloseが並行に走っても、onCloseはロックを待って登録された購読を取り除くため、
+// 終了した購読が一覧に残ることはありません。
+func (s *Subscription) closed() bool {
+	return s.closing.Load()
+}
+
+// Stats は配送数のスナップショットを返します。
+func (s *Subscription) Stats() SubscriptionStats {
+	return SubscriptionStats{
+		Delivered:     s.delivered.Load(),
+		Dropped:       s.dropped.Load(),
+		HighWaterMark: int(s.highWater.Load()),
+	}
+}
+
+// observeDepth は未読メッセージの件数を記録し、最大値を更新します。
+func (s *Subscription) observeDepth(n int) {
+	for {
+		cur := s.highWater.Load()
+		if int64(n) <= cur || s.highWater.CompareAndSwap(cur, int64(n)) {
+			return
+		}
+	}
+}
+
+// Deliver はメッセージをOverflowPolicyに従って積みます。
+// 積めた場合にtrueを返します。終了済みの購読に対しては何もせずfalseを返します。
+// OverflowBlockの場合のみ、BlockTimeoutかctxのキャンセルまでブロックします。
+// msg.Payloadは積めた場合にRetainし、積めなかった場合や後で破棄した場合はReleaseします。
+func (s *Subscription) Deliver(ctx context.Context, msg Message) bool {
+	msg.Payload.Retain()
+	if !s.deliver(ctx, msg) {
+		msg.Payload.Release()
+		return false
+	}
+	return true
+}
+
+func (s *Subscription) deliver(ctx context.Context, msg Message) bool {
+	if s.opts.Overflow == OverflowCoalesce {
+		return s.coalesce(msg)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.ch <- msg:
+		s.delivered.Add(1)
+		s.observeDepth(len(s.ch))
+		return true
+	default:
+	}
+
+	switch s.opts.Overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-s.ch:
+				old.Payload.Release()
+				s.drop()
+			default:
+			}
+			select {
+			case s.ch <- msg:
+				s.delivered.Add(1)
+				s.observeDepth(len(s.ch))
+				return true
+			default:
+				// 他のDeliverに空きを取られた
+			}
+		}
+	case OverflowBlock:
+		timer := time.NewTimer(s.opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- msg:
+			s.delivered.Add(1)
+			s.observeDepth(len(s.ch))
+			return true
+		case <-s.done:
+			return false
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+	s.drop()
+	return false
+}
+
+func (s *Subscription) drop() {
+	s.dropped.Add(1)
+	slog.Warn("pub/sub: subscription buffer full, message dropped", "topic", s.topic, "policy", s.opts.Overflow)
+}
+
+func (s *Subscription) coalesce(msg Message) bool {
+	key := s.opts.CoalesceKey(msg)
+
+	s.pendingMu.Lock()
+	if s.pendingClosed {
+		s.pendingMu.Unlock()
+		return false
+	}
+	if old, ok := s.pending[key]; ok {
+		// 置き換えは想定された動作のためログは出さない
+		s.pending[key] = msg
+		s.pendingMu.Unlock()
+		old.Payload.Release()
+		s.dropped.Add(1)
+		return true
+	}
+	if len(s.order) >= s.opts.BufferSize {
+		s.pendingMu.Unlock()
+		s.drop()
+		return false
+	}
+	s.pending[key] = msg
+	s.order = append(s.order, key)
+	s.observeDepth(len(s.order))
+	s.pendingMu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pump はOverflowCoalesceの未読メッセージを到着順にチャネルへ送ります。購読が終了すると戻ります。
+func (s *Subscription) pump() {
+	for {
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+		for {
+			s.pendingMu.Lock()
+			if len(s.order) == 0 {
+				s.pendingMu.Unlock()
+				break
+			}
+			key := s.order[0]
+			s.order = s.order[1:]
+			msg := s.pending[key]
+			delete(s.pending, key)
+			s.pendingMu.Unlock()
+
+			if !s.send(msg) {
+				msg.Payload.Release()
+				return
+			}
+		}
+	}
+}
+
+func (s *Subscription) send(msg Message) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.ch <- msg:
+		s.delivered.Add(1)
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Close は購読を終了し、チャネルを閉じます。複数回呼び出しても安全です。
+// 既にチャネルに積まれたメッセージは、Close後もCから読み出せます。
+// OverflowCoalesceでチャネルに送られる前のメッセージは破棄し、Payloadを解放します。
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.closing.Store(true)
+		// Doneが閉じた時点で購読者の一覧から取り除かれているようにする
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+		close(s.done)
+		s.mu.Lock()
+		close(s.ch)
+		stop := s.stop
+		s.mu.Unlock()
+		stop()
+		s.releasePending()
+	})
+}
+
+// releasePending はOverflowCoalesceでチャネルに送られる前のメッセージを破棄し、以降は積まないようにします。
+func (s *Subscription) releasePending() {
+	s.pendingMu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.order = nil
+	s.pendingClosed = true
+	s.pendingMu.Unlock()
+	for _, msg := range pending {
+		msg.Payload.Release()
+	}
+}
@@ -0,0 +1,225 @@
+package domain
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receiveAll(t *testing.T, sub *Subscription, n int) []Message {
+	t.Helper()
+	var msgs []Message
+	for range n {
+		select {
+		case msg := <-sub.C():
+			msgs = append(msgs, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d messages, want %d", len(msgs), n)
+		}
+	}
+	return msgs
+}
+
+// waitPumped はOverflowCoalesceの未読がpumpに全て取り出されるまで待ちます。
+func waitPumped(t *testing.T, sub *Subscription) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sub.pendingMu.Lock()
+		n := len(sub.order)
+		sub.pendingMu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %d, want 0", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscription_DropOldest(t *testing.T) {
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 2, Overflow: OverflowDropOldest}, nil)
+	defer sub.Close()
+
+	for i := range 5 {
+		if !sub.Deliver(context.Background(), Message{Data: []byte{byte(i)}}) {
+			t.Fatalf("Deliver(%d) failed", i)
+		}
+	}
+	msgs := receiveAll(t, sub, 2)
+	if msgs[0].Data[0] != 3 || msgs[1].Data[0] != 4 {
+		t.Errorf("received %v, %v, want 3, 4", msgs[0].Data, msgs[1].Data)
+	}
+	if got := sub.Stats(); got.Delivered != 5 || got.Dropped != 3 {
+		t.Errorf("Stats = %+v, want Delivered=5 Dropped=3", got)
+	}
+}
+
+func TestSubscription_BlockWaitsForSpace(t *testing.T) {
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 1, Overflow: OverflowBlock, BlockTimeout: time.Second}, nil)
+	defer sub.Close()
+
+	sub.Deliver(context.Background(), Message{Data: []byte{1}})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-sub.C()
+	}()
+	if !sub.Deliver(context.Background(), Message{Data: []byte{2}}) {
+		t.Fatal("Deliver did not wait for space")
+	}
+	if got := sub.Stats(); got.Delivered != 2 || got.Dropped != 0 {
+		t.Errorf("Stats = %+v, want Delivered=2 Dropped=0", got)
+	}
+}
+
+func TestSubscription_BlockTimeout(t *testing.T) {
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 1, Overflow: OverflowBlock, BlockTimeout: 10 * time.Millisecond}, nil)
+	defer sub.Close()
+
+	sub.Deliver(context.Background(), Message{})
+	start := time.Now()
+	if sub.Deliver(context.Background(), Message{}) {
+		t.Fatal("Deliver succeeded on full buffer")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("Deliver returned after %v, want at least BlockTimeout", elapsed)
+	}
+	if got := sub.Stats(); got.Dropped != 1 {
+		t.Errorf("Dropped = %d, want 1", got.Dropped)
+	}
+
+	// ctxのキャンセルとCloseは待機を打ち切る
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sub.Deliver(ctx, Message{}) {
+		t.Error("Deliver succeeded with cancelled context")
+	}
+	sub.opts.BlockTimeout = time.Minute
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sub.Close()
+	}()
+	if sub.Deliver(context.Background(), Message{}) {
+		t.Error("Deliver succeeded after Close")
+	}
+}
+
+func TestSubscription_Coalesce(t *testing.T) {
+	key := func(msg Message) string { return string(msg.Data[:1]) }
+	sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 2, Overflow: OverflowCoalesce, CoalesceKey: key}, nil)
+	defer sub.Close()
+
+	// a1はpumpが取り出して送信待ちになるため、以降のaで置き換えられない
+	sub.Deliver(context.Background(), Message{Data: []byte("a1")})
+	waitPumped(t, sub)
+	for _, data := range []string{"b1", "a2", "b2", "a3", "c1"} {
+		sub.Deliver(context.Background(), Message{Data: []byte(data)})
+	}
+
+	var got []string
+	for _, msg := range receiveAll(t, sub, 3) {
+		got = append(got, string(msg.Data))
+	}
+	// b1とa2は置き換えられ、c1はバッファが満杯のため破棄される
+	want := []string{"a1", "b2", "a3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("received %v, want %v", got, want)
+		}
+	}
+	// Closeはpumpの送信が終わるのを待つため、以降のStatsは確定している
+	sub.Close()
+	if got := sub.Stats(); got.Delivered != 3 || got.Dropped != 3 {
+		t.Errorf("Stats = %+v, want Delivered=3 Dropped=3", got)
+	}
+}
+
+func TestSubscription_CoalesceConcurrentClose(t *testing.T) {
+	for range 200 {
+		sub := NewSubscription(context.Background(), "room:a", SubscribeOptions{BufferSize: 4, Overflow: OverflowCoalesce}, nil)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				for i := range 20 {
+					sub.Deliver(context.Background(), Message{SessionID: SessionIDFromBytes([16]byte{byte(i % 8)})})
+				}
+			})
+		}
+		wg.Go(func() {
+			for range sub.C() {
+			}
+		})
+		wg.Go(sub.Close)
+		wg.Wait()
+	}
+}
+
+// TestSubscription_PayloadRefs は積んだメッセージの共有バッファをRetainし、
+// 破棄・置き換えたメッセージの分をReleaseすることを確認します。
+func TestSubscription_PayloadRefs(t *testing.T) {
+	ctx := context.Background()
+	deliver := func(sub *Subscription) *SharedPayload {
+		p := NewSharedPayload([]byte{1}, nil)
+		sub.Deliver(ctx, Message{Data: p.Bytes(), Payload: p})
+		return p
+	}
+	// 作成時の参照を除いた、購読が持つ参照の数
+	held := func(p *SharedPayload) int32 { return p.refs.Load() - 1 }
+
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			sub := NewSubscription(ctx, "room:a", SubscribeOptions{BufferSize: 1, Overflow: policy}, nil)
+			defer sub.Close()
+
+			first, second := deliver(sub), deliver(sub)
+			kept, dropped := first, second
+			if policy == OverflowDropOldest {
+				kept, dropped = second, first
+			}
+			if held(kept) != 1 || held(dropped) != 0 {
+				t.Errorf("held refs = kept %d, dropped %d, want 1, 0", held(kept), held(dropped))
+			}
+		})
+	}
+
+	t.Run("coalesce", func(t *testing.T) {
+		sub := NewSubscription(ctx, "room:a", SubscribeOptions{Overflow: OverflowCoalesce}, nil)
+		defer sub.Close()
+
+		// 1件目はpumpがチャネルへ送ろうとして待つ
+		sending := deliver(sub)
+		waitPumped(t, sub)
+		replaced := deliver(sub)
+		pending := deliver(sub)
+		if held(sending) != 1 || held(replaced) != 0 || held(pending) != 1 {
+			t.Errorf("held refs = sending %d, replaced %d, pending %d, want 1, 0, 1", held(sending), held(replaced), held(pending))
+		}
+	})
+
+	t.Run("coalesce close", func(t *testing.T) {
+		sub := NewSubscription(ctx, "room:a", SubscribeOptions{Overflow: OverflowCoalesce}, nil)
+
+		sending := deliver(sub)
+		waitPumped(t, sub)
+		pending := deliver(sub)
+		sub.Close()
+		// チャネルに送られる前のメッセージはCloseで解放される
+		if held(pending) != 0 {
+			t.Errorf("held refs of pending = %d, want 0", held(pending))
+		}
+		// Close後に積もうとしたメッセージは保持しない
+		if late := deliver(sub); held(late) != 0 {
+			t.Errorf("held refs after Close = %d, want 0", held(late))
+		}
+		// pumpが送信できなかったメッセージはpumpが解放する
+		deadline := time.Now().Add(time.Second)
+		for held(sending) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("held refs of sending = %d, want 0", held(sending))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
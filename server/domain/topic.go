@@ -0,0 +1,73 @@
+package domain
+
+import "strings"
+
+// トピック名の接頭辞
+const (
+	sessionTopicPrefix   = "session:"
+	roomTopicPrefix      = "room:"
+	multicastTopicPrefix = "multicast:"
+	replyTopicPrefix     = "reply:"
+)
+
+// SessionTopic はセッション宛のメッセージのトピックを返します。
+// 文字列の連結でアロケーションが起こるため、Publishのたびではなくセッションごとに一度だけ作って使い回します。
+func SessionTopic(id SessionID) Topic {
+	return Topic(sessionTopicPrefix + string(id))
+}
+
+// RoomTopic はルーム宛のメッセージのトピックを返します。
+// SessionTopicと同じく、ルームごとに一度だけ作って使い回します。
+func RoomTopic(id RoomID) Topic {
+	return Topic(roomTopicPrefix + id.String())
+}
+
+// RoomMulticastTopic はルームのメンバー全員に届けるマルチキャストのトピックを返します。
+// 参加中のSessionEndpointが購読するため、1回のPublishでメンバー全員に配送されます。
+// RoomTopic（ルーム宛）とは別のトピックで、"room:*"のパターンにはマッチしません。
+func RoomMulticastTopic(id RoomID) Topic {
+	return Topic(multicastTopicPrefix + id.String())
+}
+
+// ReplyTopic はリクエストへの返信を受け取るトピックを返します。リクエストごとに作られ、
+// Requestが返信を待つ間だけ購読します。
+func ReplyTopic(correlationID string) Topic {
+	return Topic(replyTopicPrefix + correlationID)
+}
+
+// SessionID はSessionTopicで作られたトピックのセッションIDを返します。
+func (t Topic) SessionID() (SessionID, bool) {
+	id, ok := strings.CutPrefix(string(t), sessionTopicPrefix)
+	if !ok || id == "" {
+		return "", false
+	}
+	return SessionID(id), true
+}
+
+// RoomID はRoomTopicで作られたトピックのルームIDを返します。
+func (t Topic) RoomID() (RoomID, bool) {
+	s, ok := strings.CutPrefix(string(t), roomTopicPrefix)
+	if !ok {
+		return RoomID{}, false
+	}
+	id, err := ParseRoomID(s)
+	if err != nil {
+		return RoomID{}, false
+	}
+	return id, true
+}
+
+// TopicKinds はTopic.Kindが返す種類の一覧です。
+var TopicKinds = []string{"session", "room", "multicast", "reply", "other"}
+
+// Kind はトピックの種類を接頭辞から判定して返します。
+// このパッケージの関数で作られていないトピックは"other"です。
+// 種類の数は固定のため、トピック名の代わりにメトリクスのラベルなどに使えます。
+func (t Topic) Kind() string {
+	for _, prefix := range []string{sessionTopicPrefix, roomTopicPrefix, multicastTopicPrefix, replyTopicPrefix} {
+		if strings.HasPrefix(string(t), prefix) {
+			return strings.TrimSuffix(prefix, ":")
+		}
+	}
+	return "other"
+}
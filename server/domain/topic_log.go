@@ -0,0 +1,191 @@
+package domain
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogMaxEntries はLogRetentionでMaxEntriesを指定しなかった場合に保持する件数です。
+	DefaultLogMaxEntries = 4096
+)
+
+// StartPosition は購読を開始する位置です。ゼロ値はStartLatest（これからPublishされるメッセージのみ）です。
+// ログを残していないトピックでは常にStartLatestとして扱われます。
+type StartPosition struct {
+	kind   startKind
+	offset uint64
+	time   time.Time
+}
+
+type startKind uint8
+
+const (
+	startLatest startKind = iota
+	startOffset
+	startTime
+)
+
+// StartLatest はこれからPublishされるメッセージだけを受け取る開始位置です。
+func StartLatest() StartPosition {
+	return StartPosition{}
+}
+
+// StartAtOffset はオフセットがoffset以上のメッセージから受け取る開始位置です。
+// 保持期間を過ぎたオフセットを指定した場合は、保持している最も古いメッセージから受け取ります。
+func StartAtOffset(offset uint64) StartPosition {
+	return StartPosition{kind: startOffset, offset: offset}
+}
+
+// StartAtTime はt以降にログに追記されたメッセージから受け取る開始位置です。
+func StartAtTime(t time.Time) StartPosition {
+	return StartPosition{kind: startTime, time: t}
+}
+
+// IsLatest は開始位置がStartLatestかどうかを返します。
+func (p StartPosition) IsLatest() bool {
+	return p.kind == startLatest
+}
+
+// includes はオフセットoffset・追記時刻atのメッセージが開始位置以降かどうかを返します。
+func (p StartPosition) includes(offset uint64, at time.Time) bool {
+	switch p.kind {
+	case startOffset:
+		return offset >= p.offset
+	case startTime:
+		return !at.Before(p.time)
+	default:
+		return false
+	}
+}
+
+// LogRetention はトピックのログを保持する範囲です。いずれかを超えた古いメッセージから削除されます。
+type LogRetention struct {
+	// MaxEntries は保持する件数です。0以下の場合はDefaultLogMaxEntriesを使います。
+	MaxEntries int
+	// MaxAge は保持する期間です。0以下の場合は期間では削除しません。
+	MaxAge time.Duration
+}
+
+func (r LogRetention) withDefaults() LogRetention {
+	if r.MaxEntries <= 0 {
+		r.MaxEntries = DefaultLogMaxEntries
+	}
+	return r
+}
+
+// retained はheadまで追記済みのログで、オフセットoffset・追記時刻atのメッセージが保持範囲内かどうかを返します。
+func (r LogRetention) retained(offset, head uint64, at, now time.Time) bool {
+	if head-offset >= uint64(r.MaxEntries) {
+		return false
+	}
+	return r.MaxAge <= 0 || now.Sub(at) <= r.MaxAge
+}
+
+// TopicLog は1つのトピックに追記されたメッセージのログです。LogPubSubが使います。
+// 実装は並行に呼び出されても安全でなければなりません。
+type TopicLog interface {
+	// Append はmsgを追記し、割り当てたオフセットを返します。オフセットは1から単調に増加します。
+	// msg.Dataは複製して保持し、msg.PayloadとExcludeは保持しません。
+	Append(msg Message, at time.Time) (uint64, error)
+	// Read はstartの位置以降に保持しているメッセージを古い順にfnに渡します。Offsetは設定済みです。
+	// 呼び出し時点で追記済みのメッセージまでを渡し、fnがfalseを返すと中断します。
+	Read(start StartPosition, fn func(Message) bool) error
+	// Close はログを閉じます。
+	Close() error
+}
+
+// logEntry はログの1件です。
+type logEntry struct {
+	at  time.Time
+	msg Message
+}
+
+// minMemoryLogCapacity はmemoryTopicLogが最初に確保するリングバッファの大きさです。
+const minMemoryLogCapacity = 16
+
+// memoryTopicLog はリングバッファでメッセージを保持するTopicLogです。
+// リングバッファは追記に合わせてMaxEntriesまで広げるため、メッセージの少ないトピックは小さいままです。
+type memoryTopicLog struct {
+	retention LogRetention
+
+	mu      sync.RWMutex
+	entries []logEntry // リングバッファ
+	start   int        // 最も古い要素の位置
+	size    int
+	next    uint64 // 次に割り当てるオフセット
+}
+
+// NewMemoryTopicLog はretentionの範囲でメッセージをメモリに保持するTopicLogを作成します。
+func NewMemoryTopicLog(retention LogRetention) TopicLog {
+	retention = retention.withDefaults()
+	return &memoryTopicLog{
+		retention: retention,
+		next:      1,
+	}
+}
+
+func (l *memoryTopicLog) Append(msg Message, at time.Time) (uint64, error) {
+	msg.Data = bytes.Clone(msg.Data)
+	msg.Payload = nil
+	if len(msg.Exclude()) > 0 {
+		msg = msg.WithRouting(func(r *MessageRouting) { r.Exclude = nil })
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	msg.Offset = l.next
+	l.next++
+	if l.size == len(l.entries) {
+		if len(l.entries) < l.retention.MaxEntries {
+			l.grow()
+		} else {
+			// 満杯なら最も古いものを上書きする
+			l.start = (l.start + 1) % len(l.entries)
+			l.size--
+		}
+	}
+	l.entries[(l.start+l.size)%len(l.entries)] = logEntry{at: at, msg: msg}
+	l.size++
+	return msg.Offset, nil
+}
+
+// grow はリングバッファを倍（MaxEntriesまで）に広げ、古い順に詰め直します。
+func (l *memoryTopicLog) grow() {
+	entries := make([]logEntry, min(max(2*len(l.entries), minMemoryLogCapacity), l.retention.MaxEntries))
+	for i := range l.size {
+		entries[i] = l.entries[(l.start+i)%len(l.entries)]
+	}
+	l.entries = entries
+	l.start = 0
+}
+
+func (l *memoryTopicLog) Read(start StartPosition, fn func(Message) bool) error {
+	if start.IsLatest() {
+		return nil
+	}
+	now := time.Now()
+	// fnがPublishを待たせないよう、ロック外で呼ぶ
+	l.mu.RLock()
+	head := l.next - 1
+	var msgs []Message
+	for i := range l.size {
+		e := l.entries[(l.start+i)%len(l.entries)]
+		if start.includes(e.msg.Offset, e.at) && l.retention.retained(e.msg.Offset, head, e.at, now) {
+			msgs = append(msgs, e.msg)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, msg := range msgs {
+		if !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *memoryTopicLog) Close() error {
+	return nil
+}
@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readOffsets はlogからstart以降のメッセージのオフセットを読み出します。
+func readOffsets(t *testing.T, log TopicLog, start StartPosition) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	if err := log.Read(start, func(msg Message) bool {
+		offsets = append(offsets, msg.Offset)
+		return true
+	}); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return offsets
+}
+
+func appendN(t *testing.T, log TopicLog, n int, at time.Time) {
+	t.Helper()
+	for range n {
+		if _, err := log.Append(Message{Data: []byte("x")}, at); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+func equalOffsets(a []uint64, first, last uint64) bool {
+	if uint64(len(a)) != last-first+1 {
+		return false
+	}
+	for i, o := range a {
+		if o != first+uint64(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryTopicLog_Retention(t *testing.T) {
+	log := NewMemoryTopicLog(LogRetention{MaxEntries: 3})
+	now := time.Now()
+	appendN(t, log, 5, now)
+
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 3, 5) {
+		t.Errorf("offsets = %v, want 3..5", got)
+	}
+	if got := readOffsets(t, log, StartAtOffset(4)); !equalOffsets(got, 4, 5) {
+		t.Errorf("offsets from 4 = %v, want 4..5", got)
+	}
+	if got := readOffsets(t, log, StartLatest()); len(got) != 0 {
+		t.Errorf("offsets from latest = %v, want none", got)
+	}
+}
+
+func TestMemoryTopicLog_GrowsLazily(t *testing.T) {
+	log := NewMemoryTopicLog(LogRetention{MaxEntries: 100})
+	ml := log.(*memoryTopicLog)
+	if len(ml.entries) != 0 {
+		t.Fatalf("initial capacity = %d, want 0", len(ml.entries))
+	}
+	now := time.Now()
+	appendN(t, log, 5, now)
+	if len(ml.entries) != minMemoryLogCapacity {
+		t.Errorf("capacity after 5 = %d, want %d", len(ml.entries), minMemoryLogCapacity)
+	}
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 1, 5) {
+		t.Errorf("offsets = %v, want 1..5", got)
+	}
+
+	// 広げた後も古い順に読め、MaxEntriesを超えると古いものから上書きされる
+	appendN(t, log, 150, now)
+	if len(ml.entries) != 100 {
+		t.Errorf("capacity after 155 = %d, want 100", len(ml.entries))
+	}
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 56, 155) {
+		t.Errorf("offsets = %v, want 56..155", got)
+	}
+}
+
+func TestMemoryTopicLog_MaxAgeAndStartTime(t *testing.T) {
+	log := NewMemoryTopicLog(LogRetention{MaxAge: time.Minute})
+	now := time.Now()
+	appendN(t, log, 2, now.Add(-2*time.Minute)) // 保持期間外
+	appendN(t, log, 2, now.Add(-30*time.Second))
+	appendN(t, log, 2, now)
+
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 3, 6) {
+		t.Errorf("offsets = %v, want 3..6", got)
+	}
+	if got := readOffsets(t, log, StartAtTime(now.Add(-time.Second))); !equalOffsets(got, 5, 6) {
+		t.Errorf("offsets from time = %v, want 5..6", got)
+	}
+}
+
+func TestMemoryTopicLog_ClonesData(t *testing.T) {
+	log := NewMemoryTopicLog(LogRetention{})
+	data := []byte("abc")
+	payload := NewSharedPayload(data, nil)
+	if _, err := log.Append(Message{Data: data, Payload: payload, Routing: &MessageRouting{Exclude: []SessionID{"s"}}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'z'
+
+	_ = log.Read(StartAtOffset(0), func(msg Message) bool {
+		if string(msg.Data) != "abc" || msg.Payload != nil || msg.Routing != nil {
+			t.Errorf("msg = %+v, want cloned Data without Payload/Exclude", msg)
+		}
+		return true
+	})
+}
+
+func TestFileTopicLog_RoundTripAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenFileTopicLog(dir, LogRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receivedAt := time.Unix(100, 5)
+	want := Message{SessionID: "sess", DataType: DataTypeActor, SubType: 2, Seq: 7, ReceivedAt: receivedAt, Routing: &MessageRouting{Origin: "node-a"}, Data: []byte("hello")}
+	if offset, err := log.Append(want, time.Now()); err != nil || offset != 1 {
+		t.Fatalf("Append = %d, %v, want 1", offset, err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 開き直すと続きのオフセットから追記する
+	log, err = OpenFileTopicLog(dir, LogRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if offset, err := log.Append(Message{Data: []byte("next")}, time.Now()); err != nil || offset != 2 {
+		t.Fatalf("Append after reopen = %d, %v, want 2", offset, err)
+	}
+
+	var got []Message
+	_ = log.Read(StartAtOffset(1), func(msg Message) bool {
+		got = append(got, msg)
+		return true
+	})
+	if len(got) != 2 {
+		t.Fatalf("read %d messages, want 2", len(got))
+	}
+	m := got[0]
+	if m.Offset != 1 || m.SessionID != want.SessionID || m.DataType != want.DataType || m.SubType != want.SubType ||
+		m.Seq != want.Seq || !m.ReceivedAt.Equal(receivedAt) || m.Origin() != want.Origin() || string(m.Data) != "hello" {
+		t.Errorf("msg = %+v, want %+v", m, want)
+	}
+	if got[1].Offset != 2 || string(got[1].Data) != "next" || !got[1].ReceivedAt.IsZero() {
+		t.Errorf("second msg = %+v", got[1])
+	}
+}
+
+func TestFileTopicLog_TruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenFileTopicLog(dir, LogRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, log, 2, time.Now())
+	log.Close()
+
+	// 書きかけのレコードを模して末尾に途中までのレコードを足す
+	path := filepath.Join(dir, "00000000000000000001.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{50, 0, 0, 0, 3, 0})
+	f.Close()
+
+	log, err = OpenFileTopicLog(dir, LogRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if offset, err := log.Append(Message{}, time.Now()); err != nil || offset != 3 {
+		t.Fatalf("Append = %d, %v, want 3", offset, err)
+	}
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 1, 3) {
+		t.Errorf("offsets = %v, want 1..3", got)
+	}
+}
+
+func TestFileTopicLog_SegmentRotationAndTrim(t *testing.T) {
+	dir := t.TempDir()
+	opened, err := OpenFileTopicLog(dir, LogRetention{MaxEntries: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := opened.(*fileTopicLog)
+	defer log.Close()
+	// 1セグメントに2件ずつ入る大きさにする
+	rec, _ := encodeLogRecord(1, time.Now(), Message{Data: []byte("x")})
+	log.segmentBytes = int64(2 * len(rec))
+
+	appendN(t, log, 10, time.Now())
+
+	if got := readOffsets(t, log, StartAtOffset(1)); !equalOffsets(got, 6, 10) {
+		t.Errorf("offsets = %v, want 6..10", got)
+	}
+	if got := readOffsets(t, log, StartAtOffset(8)); !equalOffsets(got, 8, 10) {
+		t.Errorf("offsets from 8 = %v, want 8..10", got)
+	}
+	// 全て保持範囲外になったセグメント（1〜4）は削除される
+	names, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(names) != 3 {
+		t.Errorf("segments = %v, want 3 (5-6, 7-8, 9-10)", names)
+	}
+}
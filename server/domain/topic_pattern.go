@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidTopicPattern はトピックパターンの構文が不正な場合に返されるエラーです。
+var ErrInvalidTopicPattern = errors.New("pub/sub: invalid topic pattern")
+
+// TopicPattern は複数のトピックにマッチするパターンです。
+//
+// 構文はpath.Matchのglobです（"*"は任意の文字列、"?"は任意の1文字、"[...]"は文字クラス）。
+// トピックに"/"は含まれないため、"*"は":"も含めて任意の文字列にマッチします。
+// 末尾の"*"以外にメタ文字を含まないパターン（"room:*"など）は前方一致として高速に判定します。
+type TopicPattern struct {
+	raw string
+	// prefix は前方一致で判定できる場合の接頭辞です。globの場合は使いません。
+	prefix string
+	glob   bool
+}
+
+// ParseTopicPattern はパターン文字列を解析します。
+func ParseTopicPattern(s string) (TopicPattern, error) {
+	if s == "" {
+		return TopicPattern{}, ErrInvalidTopicPattern
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return TopicPattern{}, ErrInvalidTopicPattern
+	}
+	if prefix, ok := strings.CutSuffix(s, "*"); ok && !hasGlobMeta(prefix) {
+		return TopicPattern{raw: s, prefix: prefix}, nil
+	}
+	return TopicPattern{raw: s, glob: true}, nil
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// String はパターン文字列を返します。
+func (p TopicPattern) String() string {
+	return p.raw
+}
+
+// Match はtopicがパターンにマッチするかを返します。
+func (p TopicPattern) Match(topic Topic) bool {
+	if !p.glob {
+		return strings.HasPrefix(string(topic), p.prefix)
+	}
+	ok, _ := path.Match(p.raw, string(topic))
+	return ok
+}
+
+// patternSubscribers はパターン購読者の一覧です。PubSubの実装が埋め込んで使います。
+// ゼロ値で使用でき、パターン購読者がいない間のpublishは1回のアトミックな読み出しだけで済みます。
+type patternSubscribers struct {
+	// count はentriesの件数です。Publishがロックを取らずに購読者の有無を判定するために使います。
+	count atomic.Int32
+
+	mu sync.RWMutex
+	// entries のスライスは変更せずに差し替える（publishがロック外で走査するため）
+	entries []patternEntry
+}
+
+type patternEntry struct {
+	pattern TopicPattern
+	sub     *Subscription
+}
+
+func (ps *patternSubscribers) subscribe(ctx context.Context, pattern TopicPattern, opts SubscribeOptions) *Subscription {
+	sub := NewSubscription(ctx, Topic(pattern.String()), opts, ps.remove)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	// ctxが既にキャンセルされていた場合、登録前に終了している（closedを参照）
+	if sub.closed() {
+		return sub
+	}
+	ps.entries = append(ps.entries[:len(ps.entries):len(ps.entries)], patternEntry{pattern: pattern, sub: sub})
+	ps.count.Store(int32(len(ps.entries)))
+	return sub
+}
+
+func (ps *patternSubscribers) remove(sub *Subscription) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	i := slices.IndexFunc(ps.entries, func(e patternEntry) bool { return e.sub == sub })
+	if i < 0 {
+		return
+	}
+	ps.entries = slices.Delete(slices.Clone(ps.entries), i, i+1)
+	ps.count.Store(int32(len(ps.entries)))
+}
+
+// publish はtopicにマッチするパターン購読者にメッセージを配送します。
+func (ps *patternSubscribers) publish(ctx context.Context, topic Topic, msg Message) {
+	if ps.count.Load() == 0 {
+		return
+	}
+	ps.mu.RLock()
+	entries := ps.entries
+	ps.mu.RUnlock()
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if e.pattern.Match(topic) {
+			e.sub.Deliver(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseTopicPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   Topic
+		want    bool
+	}{
+		{"room:*", "room:0123", true},
+		{"room:*", "room:", true},
+		{"room:*", "session:0123", false},
+		{"*", "session:0123", true},
+		{"session:*", "session:a:b", true},
+		{"room:????", "room:0123", true},
+		{"room:????", "room:01234", false},
+		{"*:0123", "room:0123", true},
+		{"*:0123", "session:0123", true},
+		{"[rs]*:0123", "session:0123", true},
+		{"room:0123", "room:0123", true},
+		{"room:0123", "room:01234", false},
+	}
+	for _, tt := range tests {
+		p, err := ParseTopicPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseTopicPattern(%q) failed: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.topic); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "room:[", `room:\`} {
+		if _, err := ParseTopicPattern(bad); !errors.Is(err, ErrInvalidTopicPattern) {
+			t.Errorf("ParseTopicPattern(%q) error = %v, want %v", bad, err, ErrInvalidTopicPattern)
+		}
+	}
+}
+
+func TestPubSub_SubscribePattern(t *testing.T) {
+	for name, ps := range map[string]PubSub{
+		"simple":  NewSimplePubSub(),
+		"sharded": NewShardedPubSub(4),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rooms, _ := ParseTopicPattern("room:*")
+			sub := ps.SubscribePattern(ctx, rooms, SubscribeOptions{})
+			exact := ps.Subscribe(ctx, "room:a", SubscribeOptions{})
+			defer exact.Close()
+
+			ps.Publish(ctx, "room:a", Message{Data: []byte{1}})
+			ps.Publish(ctx, "session:x", Message{Data: []byte{2}})
+			ps.Publish(ctx, "room:b", Message{Data: []byte{3}})
+
+			for _, want := range []Topic{"room:a", "room:b"} {
+				select {
+				case msg := <-sub.C():
+					if msg.Topic != want {
+						t.Errorf("Topic = %q, want %q", msg.Topic, want)
+					}
+				default:
+					t.Fatalf("message on %q was not delivered", want)
+				}
+			}
+			select {
+			case msg := <-sub.C():
+				t.Fatalf("unexpected message on %q", msg.Topic)
+			default:
+			}
+			if msg := <-exact.C(); msg.Topic != "room:a" {
+				t.Errorf("exact Topic = %q, want %q", msg.Topic, "room:a")
+			}
+
+			sub.Close()
+			ps.Publish(ctx, "room:a", Message{})
+			if got := sub.Stats().Delivered; got != 2 {
+				t.Errorf("Delivered = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestPatternSubscribers_CountTracksEntries(t *testing.T) {
+	var ps patternSubscribers
+	ctx, cancel := context.WithCancel(context.Background())
+	p, _ := ParseTopicPattern("room:*")
+
+	a := ps.subscribe(ctx, p, SubscribeOptions{})
+	b := ps.subscribe(context.Background(), p, SubscribeOptions{})
+	if n := ps.count.Load(); n != 2 {
+		t.Fatalf("count = %d, want 2", n)
+	}
+	cancel()
+	<-a.Done()
+	b.Close()
+	if n := ps.count.Load(); n != 0 {
+		t.Errorf("count = %d, want 0", n)
+	}
+
+	// キャンセル済みのctxで購読した場合は登録されないか、すぐに取り除かれる
+	<-ps.subscribe(ctx, p, SubscribeOptions{}).Done()
+	if n := ps.count.Load(); n != 0 {
+		t.Errorf("count = %d, want 0", n)
+	}
+}
+
+// BenchmarkSimplePubSub_PublishExact はパターン購読者の有無による完全一致のPublishのコストを比較します。
+func BenchmarkSimplePubSub_PublishExact(b *testing.B) {
+	run := func(b *testing.B, patterns int) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ps := NewSimplePubSub()
+		sub := ps.Subscribe(ctx, "session:a", SubscribeOptions{})
+		p, _ := ParseTopicPattern("room:*")
+		for range patterns {
+			ps.SubscribePattern(ctx, p, SubscribeOptions{})
+		}
+		msg := Message{Data: make([]byte, 64)}
+
+		b.ReportAllocs()
+		for b.Loop() {
+			ps.Publish(ctx, "session:a", msg)
+			<-sub.C()
+		}
+	}
+	b.Run("no-patterns", func(b *testing.B) { run(b, 0) })
+	b.Run("10-patterns", func(b *testing.B) { run(b, 10) })
+}
@@ -0,0 +1,56 @@
+package domain
+
+import "testing"
+
+func TestSessionTopic_RoundTrip(t *testing.T) {
+	id := NewSessionID()
+	topic := SessionTopic(id)
+
+	got, ok := topic.SessionID()
+	if !ok || got != id {
+		t.Errorf("SessionID() = (%q, %v), want (%q, true)", got, ok, id)
+	}
+	if _, ok := topic.RoomID(); ok {
+		t.Errorf("RoomID() succeeded for session topic %q", topic)
+	}
+}
+
+func TestRoomTopic_RoundTrip(t *testing.T) {
+	id := RoomID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	topic := RoomTopic(id)
+
+	got, ok := topic.RoomID()
+	if !ok || got != id {
+		t.Errorf("RoomID() = (%v, %v), want (%v, true)", got, ok, id)
+	}
+	if _, ok := topic.SessionID(); ok {
+		t.Errorf("SessionID() succeeded for room topic %q", topic)
+	}
+	for _, bad := range []Topic{"room:zz", "session:", "lobby"} {
+		if _, ok := bad.RoomID(); ok {
+			t.Errorf("RoomID() succeeded for %q", bad)
+		}
+		if _, ok := bad.SessionID(); ok {
+			t.Errorf("SessionID() succeeded for %q", bad)
+		}
+	}
+}
+
+func TestTopic_Kind(t *testing.T) {
+	id := RoomID{1}
+	for _, tt := range []struct {
+		topic Topic
+		want  string
+	}{
+		{SessionTopic(NewSessionID()), "session"},
+		{RoomTopic(id), "room"},
+		{RoomMulticastTopic(id), "multicast"},
+		{ReplyTopic(NewCorrelationID()), "reply"},
+		{"lobby", "other"},
+		{"rooms:a", "other"},
+	} {
+		if got := tt.topic.Kind(); got != tt.want {
+			t.Errorf("%q.Kind() = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,14 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+// ErrTransportClosed は閉じられたTransportを操作した場合のエラーです。
+// 各アダプターは自身のエラーでこれをラップし、呼び出し側はerrors.Isで種類によらず判定できます。
+var ErrTransportClosed = errors.New("transport closed")
+
 //go:generate go tool mockgen -destination=./mocks/transport_mock.go -package=mocks . Transport
 
 // Transport は Conn（物理接続）が依存するI/O境界です。
@@ -12,3 +18,63 @@ type Transport interface {
 	Write(ctx context.Context, data []byte) error
 	Close(code int32, reason string) error
 }
+
+// TransportStatsReporter は計測値を提供するTransportが実装するインターフェースです。
+type TransportStatsReporter interface {
+	TransportStats() TransportStats
+}
+
+// TransportStats はトランスポート層の計測値のスナップショットです。
+// Inは受信（Read）、Outは送信（Write）方向を表します。
+type TransportStats struct {
+	FramesIn     uint64           `json:"framesIn"`
+	FramesOut    uint64           `json:"framesOut"`
+	BytesIn      uint64           `json:"bytesIn"`
+	BytesOut     uint64           `json:"bytesOut"`
+	ReadErrors   TransportErrors  `json:"readErrors"`
+	WriteErrors  TransportErrors  `json:"writeErrors"`
+	WriteLatency LatencyHistogram `json:"writeLatency"`
+	LastRead     time.Time        `json:"lastRead"`
+	LastWrite    time.Time        `json:"lastWrite"`
+}
+
+// LastActivity は最後に送受信した時刻を返します。
+func (s TransportStats) LastActivity() time.Time {
+	if s.LastWrite.After(s.LastRead) {
+		return s.LastWrite
+	}
+	return s.LastRead
+}
+
+// TransportErrors はI/Oエラーを種類ごとに数えたものです。
+type TransportErrors struct {
+	// Timeout は期限切れで失敗した回数です。
+	Timeout uint64 `json:"timeout"`
+	// Canceled はコンテキストのキャンセルで中断された回数です。
+	Canceled uint64 `json:"canceled"`
+	// Closed は接続が閉じられていたために失敗した回数です。
+	Closed uint64 `json:"closed"`
+	// Other はそれ以外のエラーの回数です。
+	Other uint64 `json:"other"`
+}
+
+// Total はエラーの合計回数を返します。
+func (e TransportErrors) Total() uint64 {
+	return e.Timeout + e.Canceled + e.Closed + e.Other
+}
+
+// LatencyHistogram は所要時間の累積ヒストグラムです。
+type LatencyHistogram struct {
+	// Buckets は上限の昇順に並んだバケットです。各バケットの件数は上限以下の観測数の累積です。
+	Buckets []LatencyBucket `json:"buckets"`
+	// Count は上限を超えたものも含む観測数です。
+	Count uint64 `json:"count"`
+	// Sum は観測値の合計です。
+	Sum time.Duration `json:"sumNs"`
+}
+
+// LatencyBucket はLatencyHistogramの1つのバケットです。
+type LatencyBucket struct {
+	UpperBound time.Duration `json:"leNs"`
+	Count      uint64        `json:"count"`
+}
@@ -1,46 +1,135 @@
 package handler
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 
 	"withered/server/adapter/websocket"
+	"withered/server/auth"
 	"withered/server/domain"
 
 	"github.com/coder/websocket"
 )
 
+// UpgradeOptions はWebSocketアップグレード時のポリシーです。
+type UpgradeOptions struct {
+	// AllowedOrigins は許可するOriginのホストパターン（path.Match形式）です。
+	// 空の場合はリクエストのHostと同一のOriginのみ許可します。
+	AllowedOrigins []string
+	// Subprotocol はクライアントに要求するサブプロトコルです。空の場合は要求しません。
+	Subprotocol string
+	// ReadLimit は1メッセージの最大読み込みサイズ（バイト）です。
+	ReadLimit int64
+	// Compression はpermessage-deflateによる圧縮を有効にします。
+	Compression bool
+	// MultiplexSubprotocol は多重化接続を要求するサブプロトコルです。空の場合は多重化を受け付けません。
+	// 多重化はdomain.RoleMultiplexを持つ認証済みの接続にのみ許可されます。
+	MultiplexSubprotocol string
+}
+
+// DefaultUpgradeOptions はプロトコルに合わせたデフォルトのUpgradeOptionsを返します。
+func DefaultUpgradeOptions() UpgradeOptions {
+	return UpgradeOptions{
+		Subprotocol:          adapterwebsocker.Subprotocol,
+		ReadLimit:            domain.MaxFrameSize,
+		MultiplexSubprotocol: adapterwebsocker.MultiplexSubprotocol,
+	}
+}
+
 type AcceptHandler struct {
-	pubsub      domain.PubSub
-	roomManager domain.RoomManager
+	runner *EndpointRunner
+	auth   auth.Authenticator
+	opts   UpgradeOptions
 }
 
-func NewAcceptHandler(pubsub domain.PubSub, roomManager domain.RoomManager) *AcceptHandler {
-	return &AcceptHandler{pubsub: pubsub, roomManager: roomManager}
+func NewAcceptHandler(runner *EndpointRunner, authenticator auth.Authenticator, opts UpgradeOptions) *AcceptHandler {
+	return &AcceptHandler{runner: runner, auth: authenticator, opts: opts}
 }
 
 func (h *AcceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		InsecureSkipVerify: true, // 開発用: Origin チェックをスキップ
-	})
+	// アップグレード前に認証し、失敗した場合はHTTPステータスで拒否する
+	identity, err := h.auth.Authenticate(r)
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to accept", "err", err)
+		slog.WarnContext(ctx, "authentication failed", "remoteAddr", r.RemoteAddr, "err", err)
+		writeAuthError(w, err)
 		return
 	}
 
-	session := domain.NewSession()
-	transport := adapterwebsocker.NewTransportFrom(conn)
-	connection := domain.NewConnection(session.ID(), transport)
-	endpoint, err := domain.NewSessionEndpoint(session, connection, h.pubsub, h.roomManager)
+	multiplexed := h.opts.MultiplexSubprotocol != "" && offersSubprotocol(r, h.opts.MultiplexSubprotocol)
+	if multiplexed && !identity.HasRole(domain.RoleMultiplex) {
+		slog.WarnContext(ctx, "multiplex not allowed", "remoteAddr", r.RemoteAddr, "userID", identity.UserID)
+		http.Error(w, "multiplex not allowed", http.StatusForbidden)
+		return
+	}
+	if !multiplexed && h.opts.Subprotocol != "" && !offersSubprotocol(r, h.opts.Subprotocol) {
+		slog.WarnContext(ctx, "subprotocol not offered", "remoteAddr", r.RemoteAddr, "required", h.opts.Subprotocol)
+		http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+		return
+	}
+
+	acceptOpts := &websocket.AcceptOptions{
+		OriginPatterns:  h.opts.AllowedOrigins,
+		CompressionMode: websocket.CompressionDisabled,
+	}
+	switch {
+	case multiplexed:
+		acceptOpts.Subprotocols = []string{h.opts.MultiplexSubprotocol}
+	case h.opts.Subprotocol != "":
+		acceptOpts.Subprotocols = []string{h.opts.Subprotocol}
+	}
+	if h.opts.Compression {
+		acceptOpts.CompressionMode = websocket.CompressionNoContextTakeover
+	}
+	// Originが許可されていない場合、Acceptが403を返す
+	conn, err := websocket.Accept(w, r, acceptOpts)
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to create session endpoint", "err", err)
+		slog.ErrorContext(ctx, "failed to accept", "err", err)
 		return
 	}
-	slog.DebugContext(ctx, "accepted new connection", "session_id", session.ID())
-	err = endpoint.Run()
+	if h.opts.ReadLimit > 0 {
+		conn.SetReadLimit(h.opts.ReadLimit)
+	}
+
+	transport := adapterwebsocker.NewTransportFrom(conn)
+	if multiplexed {
+		err = h.runner.RunMultiplexed(ctx, identity, transport, r.RemoteAddr)
+	} else {
+		err = h.runner.Run(ctx, identity, transport, r.RemoteAddr)
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to run session endpoint", "err", err)
 		return
 	}
 }
+
+// offersSubprotocol はクライアントがSec-WebSocket-Protocolで指定のサブプロトコルを提示しているかを判定します。
+func offersSubprotocol(r *http.Request, subprotocol string) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		offered := strings.Split(header, ",")
+		for i := range offered {
+			offered[i] = strings.TrimSpace(offered[i])
+		}
+		if slices.Contains(offered, subprotocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAuthError は認証エラーをHTTPステータスに変換して返します。
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNoCredentials):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="withered"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenExpired):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="withered", error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		http.Error(w, "authentication unavailable", http.StatusInternalServerError)
+	}
+}
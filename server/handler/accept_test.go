@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"withered/server/auth"
+	"withered/server/domain"
+
+	"github.com/coder/websocket"
+)
+
+func newTestServer(t *testing.T, authenticator auth.Authenticator, opts UpgradeOptions) *httptest.Server {
+	t.Helper()
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), domain.NewSessionRegistry())
+	h := NewAcceptHandler(runner, authenticator, opts)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, url string, opts *websocket.DialOptions) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return websocket.Dial(ctx, "ws"+strings.TrimPrefix(url, "http"), opts)
+}
+
+func TestAcceptHandler_NegotiatesSubprotocol(t *testing.T) {
+	srv := newTestServer(t, auth.Anonymous{}, DefaultUpgradeOptions())
+
+	conn, _, err := dial(t, srv.URL, &websocket.DialOptions{Subprotocols: []string{"withered.v1"}})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	if conn.Subprotocol() != "withered.v1" {
+		t.Errorf("Subprotocol = %q, want %q", conn.Subprotocol(), "withered.v1")
+	}
+}
+
+func TestAcceptHandler_RejectsUpgrade(t *testing.T) {
+	a := auth.NewHMACAuthenticator([]byte("test-key"))
+	token, _ := a.Sign(auth.Claims{Subject: "user-1"})
+
+	tests := []struct {
+		name   string
+		auth   auth.Authenticator
+		opts   *websocket.DialOptions
+		status int
+	}{
+		{
+			name:   "missing subprotocol",
+			auth:   auth.Anonymous{},
+			opts:   &websocket.DialOptions{},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "disallowed origin",
+			auth: auth.Anonymous{},
+			opts: &websocket.DialOptions{
+				Subprotocols: []string{"withered.v1"},
+				HTTPHeader:   http.Header{"Origin": []string{"http://evil.example.com"}},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no credentials",
+			auth:   a,
+			opts:   &websocket.DialOptions{Subprotocols: []string{"withered.v1"}},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "invalid token",
+			auth: a,
+			opts: &websocket.DialOptions{
+				Subprotocols: []string{"withered.v1"},
+				HTTPHeader:   http.Header{"Authorization": []string{"Bearer " + token + "x"}},
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, tt.auth, DefaultUpgradeOptions())
+			conn, resp, err := dial(t, srv.URL, tt.opts)
+			if err == nil {
+				conn.CloseNow()
+				t.Fatal("Dial succeeded, want rejection")
+			}
+			if resp == nil || resp.StatusCode != tt.status {
+				t.Errorf("status = %v, want %d", resp, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"withered/server/domain"
+)
+
+// AdminHandler はサーバー管理用のHTTP APIを提供します。
+type AdminHandler struct {
+	registry *domain.SessionRegistry
+	rooms    map[domain.RoomID]*domain.Room
+	pubsub   domain.PubSubStatsReporter
+}
+
+// NewAdminHandler はAdminHandlerを作成します。
+// pubsubがnilの場合、PubSubの計測値のAPIは501を返します。
+func NewAdminHandler(registry *domain.SessionRegistry, rooms []*domain.Room, pubsub domain.PubSubStatsReporter) *AdminHandler {
+	m := make(map[domain.RoomID]*domain.Room, len(rooms))
+	for _, room := range rooms {
+		m[room.ID] = room
+	}
+	return &AdminHandler{registry: registry, rooms: m, pubsub: pubsub}
+}
+
+// sessionList はセッション一覧APIのレスポンスボディです。
+type sessionList struct {
+	Count    int                  `json:"count"`
+	Sessions []domain.SessionInfo `json:"sessions"`
+}
+
+// ListSessions は GET /admin/sessions を処理し、稼働中のセッション一覧を返します。
+func (h *AdminHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := h.registry.List()
+	writeJSON(w, http.StatusOK, sessionList{Count: len(sessions), Sessions: sessions})
+}
+
+// GetSession は GET /admin/sessions/{sessionID} を処理し、セッションの状態を返します。
+func (h *AdminHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	info, ok := h.registry.Info(domain.SessionID(r.PathValue("sessionID")))
+	if !ok {
+		http.Error(w, domain.ErrSessionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// CloseSession は DELETE /admin/sessions/{sessionID} を処理し、セッションを閉じます。
+func (h *AdminHandler) CloseSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionID := domain.SessionID(r.PathValue("sessionID"))
+	if err := h.registry.Close(ctx, sessionID); err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "admin: session closed", "sessionID", sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kickRequest は強制退出APIのリクエストボディです。
+type kickRequest struct {
+	SessionID string `json:"sessionId"`
+	Reason    uint8  `json:"reason"`
+}
+
+// Kick は POST /admin/rooms/{roomID}/kick を処理し、セッションをルームから強制退出させます。
+func (h *AdminHandler) Kick(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	roomID, err := domain.ParseRoomID(r.PathValue("roomID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	room, ok := h.rooms[roomID]
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+
+	var req kickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.SessionID == "" {
+		http.Error(w, "sessionId is required", http.StatusBadRequest)
+		return
+	}
+	reason := domain.KickReason(req.Reason)
+	if reason == domain.KickReasonUnspecified {
+		reason = domain.KickReasonAdmin
+	}
+
+	if err := room.Kick(ctx, domain.SessionID(req.SessionID), reason); err != nil {
+		if errors.Is(err, domain.ErrRoomBusy) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "admin: kick requested", "roomID", roomID, "sessionID", req.SessionID, "reason", reason)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// PubSubStats は GET /admin/pubsub を処理し、トピックごとの購読者数と配送数を返します。
+// クエリのprefixを指定すると、その接頭辞を持つトピックとパターンだけを返します（例: ?prefix=room:）。
+func (h *AdminHandler) PubSubStats(w http.ResponseWriter, r *http.Request) {
+	if h.pubsub == nil {
+		http.Error(w, "pub/sub stats not available", http.StatusNotImplemented)
+		return
+	}
+	stats := h.pubsub.PubSubStats()
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		topics := stats.Topics[:0]
+		for _, t := range stats.Topics {
+			if strings.HasPrefix(string(t.Topic), prefix) {
+				topics = append(topics, t)
+			}
+		}
+		stats.Topics = topics
+		patterns := stats.Patterns[:0]
+		for _, p := range stats.Patterns {
+			if strings.HasPrefix(p.Pattern, prefix) {
+				patterns = append(patterns, p)
+			}
+		}
+		stats.Patterns = patterns
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "err", err)
+	}
+}
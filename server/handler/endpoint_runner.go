@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"withered/server/domain"
+	"withered/server/transport/multiplex"
+)
+
+// ErrMultiplexNotAllowed は多重化接続のロールを持たない接続が多重化を要求した場合に返されるエラーです。
+var ErrMultiplexNotAllowed = errors.New("multiplexed connection not allowed")
+
+// closeCodePolicyViolation はポリシー違反で接続を閉じる場合のクローズコードです。
+const closeCodePolicyViolation int32 = 1008
+
+// EndpointRunner はトランスポートごとにSession/Connection/SessionEndpointを構築して実行します。
+// WebSocket・TCPなどトランスポートの種類によらず同じライフサイクルで接続を扱うために使用します。
+type EndpointRunner struct {
+	pubsub      domain.PubSub
+	roomManager domain.RoomManager
+	registry    *domain.SessionRegistry
+	middlewares []TransportMiddleware
+}
+
+// TransportMiddleware はSessionEndpointに渡す前のトランスポートをラップします。
+type TransportMiddleware func(domain.Transport) domain.Transport
+
+func NewEndpointRunner(pubsub domain.PubSub, roomManager domain.RoomManager, registry *domain.SessionRegistry) *EndpointRunner {
+	return &EndpointRunner{pubsub: pubsub, roomManager: roomManager, registry: registry}
+}
+
+// Use はトランスポートに適用するミドルウェアを追加します。先に追加したものほど内側になります。
+// 接続の受け付けを開始する前に呼び出す必要があります。
+func (r *EndpointRunner) Use(mw ...TransportMiddleware) {
+	r.middlewares = append(r.middlewares, mw...)
+}
+
+// Run はSessionEndpointを構築し、接続が終了するまでブロックします。
+// ctxがキャンセルされた場合はSessionEndpointを強制終了します。
+func (r *EndpointRunner) Run(ctx context.Context, identity domain.Identity, transport domain.Transport, remoteAddr string) error {
+	return r.runSession(ctx, domain.NewSessionWithIdentity(identity), r.wrap(transport), remoteAddr)
+}
+
+// RunMultiplexed は1つのトランスポートで複数のセッションを多重化して実行し、接続が終了するまでブロックします。
+// 各論理セッションは通常の接続と同じライフサイクルで独立に実行されます。
+// identityがdomain.RoleMultiplexを持たない場合はErrMultiplexNotAllowedを返します。
+func (r *EndpointRunner) RunMultiplexed(ctx context.Context, identity domain.Identity, transport domain.Transport, remoteAddr string) error {
+	if !identity.HasRole(domain.RoleMultiplex) {
+		_ = transport.Close(closeCodePolicyViolation, "multiplex not allowed")
+		return ErrMultiplexNotAllowed
+	}
+	transport = r.wrap(transport)
+	demux := multiplex.NewDemux(transport, multiplex.DefaultOptions(), func(ctx context.Context, session domain.Transport, sessionID domain.SessionID) {
+		if err := r.runSession(ctx, domain.NewSessionWithID(sessionID, identity), session, remoteAddr); err != nil {
+			slog.ErrorContext(ctx, "failed to run multiplexed session", "session_id", sessionID, "err", err)
+		}
+	})
+	slog.DebugContext(ctx, "accepted multiplexed connection", "user_id", identity.UserID, "remote_addr", remoteAddr)
+	err := demux.Run(ctx)
+	_ = transport.Close(domain.CloseCodeNormal, "")
+	return err
+}
+
+func (r *EndpointRunner) wrap(transport domain.Transport) domain.Transport {
+	for _, mw := range r.middlewares {
+		transport = mw(transport)
+	}
+	return transport
+}
+
+func (r *EndpointRunner) runSession(ctx context.Context, session *domain.Session, transport domain.Transport, remoteAddr string) error {
+	identity := session.Identity()
+	connection := domain.NewConnection(session.ID(), transport, remoteAddr)
+	endpoint, err := domain.NewSessionEndpoint(session, connection, r.pubsub, r.roomManager, r.registry)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, endpoint.ForceClose)
+	defer stop()
+
+	slog.DebugContext(ctx, "accepted new connection", "session_id", session.ID(), "user_id", identity.UserID, "remote_addr", remoteAddr)
+	return endpoint.Run()
+}
+
+// HandleTransport はRunを匿名の識別情報で実行し、エラーをログに出力します。
+// 認証を伴わないトランスポート（TCP等）のハンドラとして使用します。
+func (r *EndpointRunner) HandleTransport(ctx context.Context, transport domain.Transport, remoteAddr string) {
+	if err := r.Run(ctx, domain.Identity{}, transport, remoteAddr); err != nil {
+		slog.ErrorContext(ctx, "failed to run session endpoint", "err", err)
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	adaptertcp "withered/server/adapter/tcp"
+	"withered/server/domain"
+	"withered/server/transport/memtest"
+	"withered/server/transport/multiplex"
+)
+
+// TestEndpointRunner_TCP はTCP接続が同じSessionEndpointのライフサイクルで処理されることを確認します。
+func TestEndpointRunner_TCP(t *testing.T) {
+	registry := domain.NewSessionRegistry()
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), registry)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := adaptertcp.NewServer("", adaptertcp.DefaultOptions(), runner.HandleTransport)
+	go srv.ServeListener(ln)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	client, err := adaptertcp.Dial(ctx, ln.Addr().String(), adaptertcp.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close(1000, "")
+
+	// 接続直後にセッションID通知が届く
+	data, err := client.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !domain.IsControlMessage(data, domain.ControlSubTypeAssign) {
+		t.Fatalf("expected assign message, got %v", data)
+	}
+	header, err := domain.ParseHeader(data)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if _, ok := registry.Info(domain.SessionIDFromBytes(header.SessionID)); !ok {
+		t.Error("session not registered")
+	}
+
+	// 切断するとレジストリから削除される
+	client.Close(1000, "")
+	deadline := time.Now().Add(time.Second)
+	for registry.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session still registered after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestEndpointRunner_Multiplexed は1接続で多重化した論理セッションが、独立したセッションとしてルームに参加できることを確認します。
+func TestEndpointRunner_Multiplexed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	roomID := domain.RoomID{1}
+	pubsub := domain.NewSimplePubSub()
+	registry := domain.NewSessionRegistry()
+	room := domain.NewRoom(roomID, pubsub, domain.NewEchoApplication())
+	go room.Run(ctx)
+	runner := NewEndpointRunner(pubsub, domain.NewSimpleRoomManager(roomID), registry)
+
+	clientSide, serverSide := memtest.Pipe(memtest.Options{Buffer: 256})
+	identity := domain.Identity{UserID: "bot-farm", Roles: []string{domain.RoleMultiplex}}
+	done := make(chan error, 1)
+	go func() { done <- runner.RunMultiplexed(ctx, identity, serverSide, "memtest") }()
+	client := multiplex.NewClient(clientSide)
+
+	const n = 3
+	sessions := make([]*multiplex.ClientSession, n)
+	for i := range sessions {
+		s, err := client.Open(ctx)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		data, err := s.Read(ctx)
+		if err != nil || !domain.IsControlMessage(data, domain.ControlSubTypeAssign) {
+			t.Fatalf("session %d: Read = (%v, %v), want assign", i, data, err)
+		}
+		join := domain.JoinPayload{}
+		s.Write(ctx, domain.EncodeControlMessage(s.ID, domain.ControlSubTypeJoin, join.Encode()))
+		sessions[i] = s
+	}
+	if registry.Count() != n {
+		t.Errorf("registry.Count = %d, want %d", registry.Count(), n)
+	}
+
+	// Joinが全てルームに反映されるまで入力を送り続け、全セッションがブロードキャストを受け取ることを確認する
+	sender := sessions[0]
+	input := domain.InputPayload{KeyMask: 0x01}
+	header := domain.Header{Version: 1, SessionID: sender.ID.Bytes(), Length: domain.PayloadHeaderSize + domain.InputPayloadSize}
+	frame := append(header.Encode(), (&domain.PayloadHeader{DataType: domain.DataTypeInput}).Encode()...)
+	frame = append(frame, input.Encode()...)
+	for i, s := range sessions {
+		for {
+			sender.Write(ctx, frame)
+			readCtx, cancelRead := context.WithTimeout(ctx, 50*time.Millisecond)
+			data, err := s.Read(readCtx)
+			cancelRead()
+			if err == nil && len(data) > domain.HeaderSize && domain.DataType(data[domain.HeaderSize]) == domain.DataTypeInput {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("session %d did not receive broadcast", i)
+			}
+		}
+	}
+
+	// 論理セッションごとの送受信量がレジストリから得られる
+	for i, s := range sessions {
+		info, ok := registry.Info(s.ID)
+		if !ok {
+			t.Fatalf("session %d not registered", i)
+		}
+		if info.Stats.MessagesIn == 0 || info.Stats.MessagesOut < 2 || info.Stats.BytesOut == 0 {
+			t.Errorf("session %d: Stats = %+v, want join received and assign/broadcast sent", i, info.Stats)
+		}
+	}
+
+	// 論理セッションを閉じても他のセッションは続く
+	sessions[1].Close(domain.CloseCodeNormal, "")
+	deadline := time.Now().Add(time.Second)
+	for registry.Count() != n-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("registry.Count = %d after closing one session, want %d", registry.Count(), n-1)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 物理接続を閉じると全セッションが終了する
+	client.Close()
+	if err := <-done; err == nil {
+		t.Error("RunMultiplexed returned nil after physical close")
+	}
+	if registry.Count() != 0 {
+		t.Errorf("registry.Count = %d after physical close, want 0", registry.Count())
+	}
+}
+
+func TestEndpointRunner_MultiplexedRequiresRole(t *testing.T) {
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), domain.NewSessionRegistry())
+	clientSide, serverSide := memtest.Pipe(memtest.Options{Buffer: 1})
+
+	err := runner.RunMultiplexed(context.Background(), domain.Identity{UserID: "user-1"}, serverSide, "memtest")
+	if !errors.Is(err, ErrMultiplexNotAllowed) {
+		t.Errorf("RunMultiplexed error = %v, want %v", err, ErrMultiplexNotAllowed)
+	}
+	if code, _, ok := clientSide.CloseStatus(); !ok || code != closeCodePolicyViolation {
+		t.Errorf("close code = %d, want %d", code, closeCodePolicyViolation)
+	}
+}
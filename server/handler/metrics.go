@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"withered/server/domain"
+)
+
+// MetricsHandler はPrometheusのテキスト形式でサーバーの計測値を公開します。
+type MetricsHandler struct {
+	registry  *domain.SessionRegistry
+	transport domain.TransportStatsReporter
+	pubsub    domain.PubSubStatsReporter
+}
+
+// NewMetricsHandler はMetricsHandlerを作成します。
+// transport・pubsubがnilの場合、それぞれの計測値は出力しません。
+func NewMetricsHandler(registry *domain.SessionRegistry, transport domain.TransportStatsReporter, pubsub domain.PubSubStatsReporter) *MetricsHandler {
+	return &MetricsHandler{registry: registry, transport: transport, pubsub: pubsub}
+}
+
+// ServeHTTP は GET /metrics を処理します。
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeGauge(bw, "withered_sessions", "Number of active sessions.", float64(h.registry.Count()))
+	if h.transport != nil {
+		writeTransportMetrics(bw, h.transport.TransportStats())
+	}
+	if h.pubsub != nil {
+		writePubSubMetrics(bw, h.pubsub.PubSubStats())
+	}
+	if err := bw.Flush(); err != nil {
+		slog.DebugContext(r.Context(), "failed to write metrics", "err", err)
+	}
+}
+
+func writeTransportMetrics(w io.Writer, s domain.TransportStats) {
+	writeHeader(w, "withered_transport_frames_total", "counter", "Frames moved by transports.")
+	fmt.Fprintf(w, "withered_transport_frames_total{direction=\"in\"} %d\n", s.FramesIn)
+	fmt.Fprintf(w, "withered_transport_frames_total{direction=\"out\"} %d\n", s.FramesOut)
+
+	writeHeader(w, "withered_transport_bytes_total", "counter", "Bytes moved by transports.")
+	fmt.Fprintf(w, "withered_transport_bytes_total{direction=\"in\"} %d\n", s.BytesIn)
+	fmt.Fprintf(w, "withered_transport_bytes_total{direction=\"out\"} %d\n", s.BytesOut)
+
+	writeHeader(w, "withered_transport_errors_total", "counter", "Transport I/O errors by class.")
+	for _, e := range []struct {
+		direction string
+		errors    domain.TransportErrors
+	}{{"in", s.ReadErrors}, {"out", s.WriteErrors}} {
+		fmt.Fprintf(w, "withered_transport_errors_total{direction=%q,class=\"timeout\"} %d\n", e.direction, e.errors.Timeout)
+		fmt.Fprintf(w, "withered_transport_errors_total{direction=%q,class=\"canceled\"} %d\n", e.direction, e.errors.Canceled)
+		fmt.Fprintf(w, "withered_transport_errors_total{direction=%q,class=\"closed\"} %d\n", e.direction, e.errors.Closed)
+		fmt.Fprintf(w, "withered_transport_errors_total{direction=%q,class=\"other\"} %d\n", e.direction, e.errors.Other)
+	}
+
+	writeHeader(w, "withered_transport_write_seconds", "histogram", "Time spent in transport writes.")
+	for _, b := range s.WriteLatency.Buckets {
+		fmt.Fprintf(w, "withered_transport_write_seconds_bucket{le=\"%g\"} %d\n", b.UpperBound.Seconds(), b.Count)
+	}
+	fmt.Fprintf(w, "withered_transport_write_seconds_bucket{le=\"+Inf\"} %d\n", s.WriteLatency.Count)
+	fmt.Fprintf(w, "withered_transport_write_seconds_sum %g\n", s.WriteLatency.Sum.Seconds())
+	fmt.Fprintf(w, "withered_transport_write_seconds_count %d\n", s.WriteLatency.Count)
+
+	if last := s.LastActivity(); !last.IsZero() {
+		writeGauge(w, "withered_transport_last_activity_seconds", "Unix time of the last transport read or write.", float64(last.UnixNano())/1e9)
+	}
+}
+
+// writePubSubMetrics はPubSubの計測値を出力します。
+// セッション宛や返信用のトピックは接続数やリクエスト数だけ増えるため、トピック名はラベルにせず
+// 種類（Topic.Kind）ごとに集計します。トピックごとの値は/admin/pubsubで確認します。
+func writePubSubMetrics(w io.Writer, s domain.PubSubStats) {
+	writeHeader(w, "withered_pubsub_messages_total", "counter", "Pub/sub messages by result.")
+	fmt.Fprintf(w, "withered_pubsub_messages_total{result=\"published\"} %d\n", s.Published)
+	fmt.Fprintf(w, "withered_pubsub_messages_total{result=\"delivered\"} %d\n", s.Delivered)
+	fmt.Fprintf(w, "withered_pubsub_messages_total{result=\"dropped\"} %d\n", s.Dropped)
+
+	subscribers := 0
+	kinds := make(map[string]*topicKindStats)
+	for _, t := range s.Topics {
+		subscribers += t.Subscribers
+		k := kinds[t.Topic.Kind()]
+		if k == nil {
+			k = &topicKindStats{}
+			kinds[t.Topic.Kind()] = k
+		}
+		k.add(t)
+	}
+	writeGauge(w, "withered_pubsub_topics", "Number of topics with subscribers.", float64(len(s.Topics)))
+	writeGauge(w, "withered_pubsub_subscribers", "Number of topic subscriptions.", float64(subscribers))
+	if len(kinds) == 0 {
+		return
+	}
+
+	writeHeader(w, "withered_pubsub_kind_topics", "gauge", "Topics with subscribers per topic kind.")
+	forEachKind(kinds, func(kind string, k *topicKindStats) {
+		fmt.Fprintf(w, "withered_pubsub_kind_topics{kind=%q} %d\n", kind, k.topics)
+	})
+	writeHeader(w, "withered_pubsub_kind_subscribers", "gauge", "Subscribers per topic kind.")
+	forEachKind(kinds, func(kind string, k *topicKindStats) {
+		fmt.Fprintf(w, "withered_pubsub_kind_subscribers{kind=%q} %d\n", kind, k.subscribers)
+	})
+	writeHeader(w, "withered_pubsub_kind_messages", "gauge", "Pub/sub messages of current topics per topic kind by result.")
+	forEachKind(kinds, func(kind string, k *topicKindStats) {
+		fmt.Fprintf(w, "withered_pubsub_kind_messages{kind=%q,result=\"published\"} %d\n", kind, k.published)
+		fmt.Fprintf(w, "withered_pubsub_kind_messages{kind=%q,result=\"delivered\"} %d\n", kind, k.delivered)
+		fmt.Fprintf(w, "withered_pubsub_kind_messages{kind=%q,result=\"dropped\"} %d\n", kind, k.dropped)
+	})
+	writeHeader(w, "withered_pubsub_kind_publish_rate", "gauge", "Recent publishes per second per topic kind.")
+	forEachKind(kinds, func(kind string, k *topicKindStats) {
+		fmt.Fprintf(w, "withered_pubsub_kind_publish_rate{kind=%q} %g\n", kind, k.publishRate)
+	})
+	writeHeader(w, "withered_pubsub_kind_high_water_mark", "gauge", "Largest subscriber backlog seen per topic kind.")
+	forEachKind(kinds, func(kind string, k *topicKindStats) {
+		fmt.Fprintf(w, "withered_pubsub_kind_high_water_mark{kind=%q} %d\n", kind, k.highWaterMark)
+	})
+}
+
+// topicKindStats はトピックの種類ごとに集計したTopicStatsです。
+type topicKindStats struct {
+	topics        int
+	subscribers   int
+	published     uint64
+	delivered     uint64
+	dropped       uint64
+	publishRate   float64
+	highWaterMark int
+}
+
+func (k *topicKindStats) add(t domain.TopicStats) {
+	k.topics++
+	k.subscribers += t.Subscribers
+	k.published += t.Published
+	k.delivered += t.Delivered
+	k.dropped += t.Dropped
+	k.publishRate += t.PublishRate
+	k.highWaterMark = max(k.highWaterMark, t.HighWaterMark)
+}
+
+// forEachKind はdomain.TopicKindsの順にkindsを走査し、出力の順序を安定させます。
+func forEachKind(kinds map[string]*topicKindStats, fn func(kind string, k *topicKindStats)) {
+	for _, kind := range domain.TopicKinds {
+		if k, ok := kinds[kind]; ok {
+			fn(kind, k)
+		}
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	writeHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
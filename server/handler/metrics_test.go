@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+)
+
+type staticTransportStats domain.TransportStats
+
+func (s staticTransportStats) TransportStats() domain.TransportStats { return domain.TransportStats(s) }
+
+func TestMetricsHandler(t *testing.T) {
+	stats := staticTransportStats{
+		FramesIn:    3,
+		BytesOut:    120,
+		WriteErrors: domain.TransportErrors{Timeout: 2},
+		WriteLatency: domain.LatencyHistogram{
+			Buckets: []domain.LatencyBucket{{UpperBound: time.Millisecond, Count: 4}},
+			Count:   5,
+			Sum:     10 * time.Millisecond,
+		},
+		LastWrite: time.Unix(1700000000, 0),
+	}
+	h := NewMetricsHandler(domain.NewSessionRegistry(), stats, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"withered_sessions 0\n",
+		`withered_transport_frames_total{direction="in"} 3`,
+		`withered_transport_bytes_total{direction="out"} 120`,
+		`withered_transport_errors_total{direction="out",class="timeout"} 2`,
+		`withered_transport_write_seconds_bucket{le="0.001"} 4`,
+		`withered_transport_write_seconds_bucket{le="+Inf"} 5`,
+		"withered_transport_write_seconds_sum 0.01\n",
+		"withered_transport_last_activity_seconds 1.7e+09\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsHandler_WithoutTransport(t *testing.T) {
+	h := NewMetricsHandler(domain.NewSessionRegistry(), nil, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "withered_transport_") {
+		t.Errorf("transport metrics written without reporter:\n%s", rec.Body.String())
+	}
+}
+
+type staticPubSubStats domain.PubSubStats
+
+func (s staticPubSubStats) PubSubStats() domain.PubSubStats { return domain.PubSubStats(s) }
+
+func TestMetricsHandler_PubSub(t *testing.T) {
+	sessionID := domain.NewSessionID()
+	replyID := domain.NewCorrelationID()
+	stats := staticPubSubStats{
+		Published: 10,
+		Delivered: 18,
+		Dropped:   2,
+		Topics: []domain.TopicStats{
+			{Topic: "room:a", Subscribers: 2, Published: 9, PublishRate: 1.5, Delivered: 16, Dropped: 2, HighWaterMark: 7},
+			{Topic: "room:b", Subscribers: 1, Published: 3, PublishRate: 0.5, Delivered: 3, HighWaterMark: 2},
+			{Topic: domain.SessionTopic(sessionID), Subscribers: 1, Published: 1, Delivered: 1},
+			{Topic: domain.ReplyTopic(replyID), Subscribers: 1},
+		},
+	}
+	h := NewMetricsHandler(domain.NewSessionRegistry(), nil, stats)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`withered_pubsub_messages_total{result="published"} 10`,
+		`withered_pubsub_messages_total{result="dropped"} 2`,
+		"withered_pubsub_topics 4\n",
+		"withered_pubsub_subscribers 5\n",
+		`withered_pubsub_kind_topics{kind="room"} 2`,
+		`withered_pubsub_kind_topics{kind="session"} 1`,
+		`withered_pubsub_kind_subscribers{kind="room"} 3`,
+		`withered_pubsub_kind_subscribers{kind="reply"} 1`,
+		`withered_pubsub_kind_messages{kind="room",result="published"} 12`,
+		`withered_pubsub_kind_messages{kind="room",result="dropped"} 2`,
+		`withered_pubsub_kind_publish_rate{kind="room"} 2`,
+		`withered_pubsub_kind_high_water_mark{kind="room"} 7`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q\n%s", want, body)
+		}
+	}
+	// トピック名はラベルに含めない
+	for _, topic := range []string{"room:a", sessionID.String(), replyID} {
+		if strings.Contains(body, topic) {
+			t.Errorf("topic %q exported as a label:\n%s", topic, body)
+		}
+	}
+	if strings.Contains(body, `kind="multicast"`) {
+		t.Errorf("kind without topics exported:\n%s", body)
+	}
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"withered/server/adapter/httpstream"
+	"withered/server/auth"
+)
+
+// StreamHandler はWebSocketを利用できないネットワーク向けのHTTPフォールバックを提供します。
+// 下りフレームはSSEまたはロングポーリング、上りフレームはPOSTで送受信します。
+//
+//	POST   /stream             接続を作成し、IDを返す（認証はここでのみ行う）
+//	GET    /stream/{id}/events SSEで下りフレームを受信する
+//	GET    /stream/{id}/poll   ロングポーリングで下りフレームを受信する
+//	POST   /stream/{id}/send   上りフレームを送信する
+//	DELETE /stream/{id}        接続を終了する
+type StreamHandler struct {
+	runner         *EndpointRunner
+	auth           auth.Authenticator
+	hub            *adapterhttpstream.Hub
+	allowedOrigins []string
+}
+
+// StreamOpenResponse はPOST /streamの応答です。
+type StreamOpenResponse struct {
+	ID string `json:"id"`
+}
+
+func NewStreamHandler(runner *EndpointRunner, authenticator auth.Authenticator, hub *adapterhttpstream.Hub, allowedOrigins []string) *StreamHandler {
+	return &StreamHandler{runner: runner, auth: authenticator, hub: hub, allowedOrigins: allowedOrigins}
+}
+
+// Open は接続を作成し、SessionEndpointを起動します。
+func (h *StreamHandler) Open(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.allowCORS(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	identity, err := h.auth.Authenticate(r)
+	if err != nil {
+		slog.WarnContext(ctx, "authentication failed", "remoteAddr", r.RemoteAddr, "err", err)
+		writeAuthError(w, err)
+		return
+	}
+
+	transport := h.hub.Create()
+	// 接続はリクエストより長く続くため、リクエストのctxから切り離して実行する
+	go func() {
+		runCtx := context.WithoutCancel(ctx)
+		if err := h.runner.Run(runCtx, identity, transport, r.RemoteAddr); err != nil {
+			slog.ErrorContext(runCtx, "failed to run session endpoint", "err", err)
+		}
+	}()
+	writeJSON(w, http.StatusCreated, StreamOpenResponse{ID: transport.ID()})
+}
+
+// Events はSSEで下りフレームを送ります。フレームはbase64で "frame" イベントとして、
+// サーバーが接続を閉じた場合はクローズ情報をJSONで "close" イベントとして送ります。
+func (h *StreamHandler) Events(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	transport, ok := h.transport(w, r)
+	if !ok {
+		return
+	}
+	ctx, detach := transport.Attach(r.Context())
+	defer detach()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := h.hub.Options().HeartbeatInterval
+	for {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if heartbeat > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, heartbeat)
+		}
+		data, err := transport.Next(waitCtx)
+		cancel()
+
+		var closeErr *adapterhttpstream.CloseError
+		switch {
+		case err == nil:
+			_, err = w.Write([]byte("event: frame\ndata: " + base64.StdEncoding.EncodeToString(data) + "\n\n"))
+		case errors.As(err, &closeErr):
+			payload, _ := json.Marshal(closeErr)
+			_, _ = w.Write([]byte("event: close\ndata: " + string(payload) + "\n\n"))
+			_ = rc.Flush()
+			return
+		case ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded):
+			_, err = w.Write([]byte(": ping\n\n"))
+		default:
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Poll はロングポーリングで下りフレームを返します。
+// フレームが届くまで最大PollTimeout待ち、届いたフレームを長さプレフィックス付きで連結して返します。
+// フレームがない場合は204、サーバーが接続を閉じた場合は410とクローズ情報を返します。
+func (h *StreamHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	transport, ok := h.transport(w, r)
+	if !ok {
+		return
+	}
+	ctx, detach := transport.Attach(r.Context())
+	defer detach()
+
+	opts := h.hub.Options()
+	waitCtx, cancel := context.WithTimeout(ctx, opts.PollTimeout)
+	defer cancel()
+
+	data, err := transport.Next(waitCtx)
+	var closeErr *adapterhttpstream.CloseError
+	switch {
+	case err == nil:
+	case errors.As(err, &closeErr):
+		writeJSON(w, http.StatusGone, closeErr)
+		return
+	default:
+		// 待ち時間を過ぎたか、新しい受信者に置き換えられた
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body := adapterhttpstream.AppendFrame(nil, data)
+	for len(body) < opts.MaxBatchSize {
+		data, ok := transport.TryNext()
+		if !ok {
+			break
+		}
+		body = adapterhttpstream.AppendFrame(body, data)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(body)
+}
+
+// Send はPOSTされた上りフレームをSessionEndpointに渡します。
+func (h *StreamHandler) Send(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	transport, ok := h.transport(w, r)
+	if !ok {
+		return
+	}
+	opts := h.hub.Options()
+	body := http.MaxBytesReader(w, r.Body, int64(opts.MaxBatchSize))
+	frames, err := adapterhttpstream.ReadFrames(body, opts.MaxFrameSize)
+	if err != nil {
+		http.Error(w, "invalid frames", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	for _, frame := range frames {
+		if err := transport.Deliver(ctx, frame); err != nil {
+			if errors.Is(err, adapterhttpstream.ErrTransportClosed) {
+				http.Error(w, "stream closed", http.StatusGone)
+			} else {
+				http.Error(w, "stream busy", http.StatusServiceUnavailable)
+			}
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Close はクライアントからの接続の終了を受け付けます。
+func (h *StreamHandler) Close(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	transport, ok := h.transport(w, r)
+	if !ok {
+		return
+	}
+	transport.PeerClose()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Preflight はブラウザのCORSプリフライトに応答します。
+func (h *StreamHandler) Preflight(w http.ResponseWriter, r *http.Request) {
+	if !h.allowCORS(w, r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *StreamHandler) transport(w http.ResponseWriter, r *http.Request) (*adapterhttpstream.Transport, bool) {
+	transport, ok := h.hub.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "stream not found", http.StatusNotFound)
+		return nil, false
+	}
+	return transport, true
+}
+
+// allowCORS はOriginが許可されている場合にCORSヘッダーを付与します。
+func (h *StreamHandler) allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !originAllowed(r, h.allowedOrigins) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	return true
+}
+
+// originAllowed はリクエストのOriginが許可されているかを返します。Originのないリクエストは許可します。
+// Originの判定はWebSocketのOriginPatternsと同じく、ホストに対するpath.Matchで行い、
+// リクエストのHostと同一のOriginは常に許可します。
+func originAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, pattern := range allowedOrigins {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(u.Host)); matched {
+			return true
+		}
+	}
+	return false
+}
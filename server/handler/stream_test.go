@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"withered/server/adapter/httpstream"
+	"withered/server/auth"
+	"withered/server/domain"
+	"withered/server/transport/instrument"
+)
+
+func newStreamServer(t *testing.T, authenticator auth.Authenticator) (*httptest.Server, *domain.SessionRegistry) {
+	t.Helper()
+	registry := domain.NewSessionRegistry()
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), registry)
+	runner.Use(instrument.NewCollector().Middleware())
+	h := NewStreamHandler(runner, authenticator, adapterhttpstream.NewHub(adapterhttpstream.DefaultOptions()), []string{"allowed.example"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /stream", h.Open)
+	mux.HandleFunc("GET /stream/{id}/events", h.Events)
+	mux.HandleFunc("GET /stream/{id}/poll", h.Poll)
+	mux.HandleFunc("POST /stream/{id}/send", h.Send)
+	mux.HandleFunc("DELETE /stream/{id}", h.Close)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, registry
+}
+
+func openStream(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	res, err := http.Post(srv.URL+"/stream", "", nil)
+	if err != nil {
+		t.Fatalf("POST /stream failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /stream status = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	var body StreamOpenResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body.ID
+}
+
+func deleteStream(t *testing.T, srv *httptest.Server, id string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/stream/"+id, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	res.Body.Close()
+}
+
+// readEvent はSSEのイベントを1つ読み、イベント名とデータを返します（コメント行は読み飛ばす）。
+func readEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamHandler_SSE(t *testing.T) {
+	srv, registry := newStreamServer(t, auth.Anonymous{})
+	id := openStream(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream/"+id+"/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	events := bufio.NewReader(res.Body)
+
+	event, data := readEvent(t, events)
+	frame, _ := base64.StdEncoding.DecodeString(data)
+	if event != "frame" || !domain.IsControlMessage(frame, domain.ControlSubTypeAssign) {
+		t.Fatalf("first event = %s %x, want assign frame", event, frame)
+	}
+
+	// 上りフレームがSessionEndpointに届く
+	header, _ := domain.ParseHeader(frame)
+	sessionID := domain.SessionIDFromBytes(header.SessionID)
+	body := adapterhttpstream.AppendFrame(nil, domain.EncodeControlMessage(sessionID, domain.ControlSubTypePing, nil))
+	sendRes, err := http.Post(srv.URL+"/stream/"+id+"/send", "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST send failed: %v", err)
+	}
+	sendRes.Body.Close()
+	if sendRes.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST send status = %d, want %d", sendRes.StatusCode, http.StatusNoContent)
+	}
+	for {
+		info, ok := registry.Info(sessionID)
+		if !ok {
+			t.Fatal("session not registered")
+		}
+		if info.Transport != nil && info.Transport.FramesIn >= 1 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("upstream frame did not reach the session")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// サーバーからの送信がSSEで届く
+	if err := registry.Send(sessionID, []byte{0xAB}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	event, data = readEvent(t, events)
+	if event != "frame" || data != base64.StdEncoding.EncodeToString([]byte{0xAB}) {
+		t.Fatalf("event = %s %s, want sent frame", event, data)
+	}
+
+	deleteStream(t, srv, id)
+	if event, _ := readEvent(t, events); event != "close" {
+		t.Errorf("event = %s, want close", event)
+	}
+}
+
+func TestStreamHandler_LongPoll(t *testing.T) {
+	srv, _ := newStreamServer(t, auth.Anonymous{})
+	id := openStream(t, srv)
+
+	res, err := http.Get(srv.URL + "/stream/" + id + "/poll")
+	if err != nil {
+		t.Fatalf("GET poll failed: %v", err)
+	}
+	frames, err := adapterhttpstream.ReadFrames(res.Body, domain.MaxFrameSize)
+	res.Body.Close()
+	if err != nil || len(frames) == 0 || !domain.IsControlMessage(frames[0], domain.ControlSubTypeAssign) {
+		t.Fatalf("poll = (%x, %v), want assign frame", frames, err)
+	}
+
+	deleteStream(t, srv, id)
+	res, err = http.Get(srv.URL + "/stream/" + id + "/poll")
+	if err != nil {
+		t.Fatalf("GET poll failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusGone {
+		t.Errorf("poll after close status = %d, want %d", res.StatusCode, http.StatusGone)
+	}
+}
+
+func TestStreamHandler_RejectsOpen(t *testing.T) {
+	a := auth.NewHMACAuthenticator([]byte("test-key"))
+
+	tests := []struct {
+		name   string
+		auth   auth.Authenticator
+		origin string
+		status int
+	}{
+		{name: "disallowed origin", auth: auth.Anonymous{}, origin: "http://evil.example", status: http.StatusForbidden},
+		{name: "missing token", auth: a, origin: "http://allowed.example", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newStreamServer(t, tt.auth)
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/stream", nil)
+			req.Header.Set("Origin", tt.origin)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST /stream failed: %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestStreamHandler_UnknownStream(t *testing.T) {
+	srv, _ := newStreamServer(t, auth.Anonymous{})
+	res, err := http.Get(srv.URL + "/stream/unknown/poll")
+	if err != nil {
+		t.Fatalf("GET poll failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"withered/server/auth"
+	"withered/server/domain"
+)
+
+// WebTransportUpgrader はWebTransportのCONNECTリクエストを受け入れてTransportを返します。
+type WebTransportUpgrader interface {
+	Upgrade(w http.ResponseWriter, r *http.Request) (domain.Transport, error)
+}
+
+// WebTransportHandler はWebTransportのセッション確立要求を処理します。
+type WebTransportHandler struct {
+	runner   *EndpointRunner
+	auth     auth.Authenticator
+	upgrader WebTransportUpgrader
+	// allowedOrigins は許可するOriginのホストパターン（path.Match形式）です。UpgradeOptions.AllowedOriginsと同じ値を使います。
+	allowedOrigins []string
+}
+
+func NewWebTransportHandler(runner *EndpointRunner, authenticator auth.Authenticator, upgrader WebTransportUpgrader, allowedOrigins []string) *WebTransportHandler {
+	return &WebTransportHandler{runner: runner, auth: authenticator, upgrader: upgrader, allowedOrigins: allowedOrigins}
+}
+
+func (h *WebTransportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	// 匿名モードでも任意のWebページから接続されないよう、WebSocket・HTTPストリームと同じOriginの許可リストで判定する
+	if !originAllowed(r, h.allowedOrigins) {
+		slog.WarnContext(ctx, "webtransport origin not allowed", "remoteAddr", r.RemoteAddr, "origin", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	// ブラウザのWebTransportはヘッダーを指定できないため、トークンはクエリパラメータで渡される
+	identity, err := h.auth.Authenticate(r)
+	if err != nil {
+		slog.WarnContext(ctx, "authentication failed", "remoteAddr", r.RemoteAddr, "err", err)
+		writeAuthError(w, err)
+		return
+	}
+
+	transport, err := h.upgrader.Upgrade(w, r)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to accept webtransport session", "remoteAddr", r.RemoteAddr, "err", err)
+		return
+	}
+	if err := h.runner.Run(ctx, identity, transport, r.RemoteAddr); err != nil {
+		slog.ErrorContext(ctx, "failed to run session endpoint", "err", err)
+	}
+}
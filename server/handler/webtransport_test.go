@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	adapterwebtransport "withered/server/adapter/webtransport"
+	"withered/server/auth"
+	"withered/server/domain"
+)
+
+// TestWebTransportHandler は認証済みのWebTransportセッションが通常の接続と同じく実行されることを確認します。
+func TestWebTransportHandler(t *testing.T) {
+	a := auth.NewHMACAuthenticator([]byte("test-key"))
+	token, _ := a.Sign(auth.Claims{Subject: "user-1"})
+	registry := domain.NewSessionRegistry()
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), registry)
+
+	cert, err := adapterwebtransport.GenerateSelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatalf("GenerateSelfSigned failed: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	srv := adapterwebtransport.NewServer("", &tls.Config{Certificates: []tls.Certificate{cert}}, adapterwebtransport.DefaultServerOptions(), mux)
+	mux.Handle("/wt", NewWebTransportHandler(runner, a, srv, nil))
+	go srv.ServePacketConn(pc)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	clientTLS := &tls.Config{RootCAs: roots}
+	endpoint := "https://" + pc.LocalAddr().String() + "/wt"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := adapterwebtransport.Dial(ctx, endpoint, clientTLS, nil, adapterwebtransport.DefaultOptions()); err == nil {
+		t.Fatal("Dial without token succeeded")
+	}
+
+	client, err := adapterwebtransport.Dial(ctx, endpoint+"?token="+url.QueryEscape(token), clientTLS, nil, adapterwebtransport.DefaultOptions())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close(domain.CloseCodeNormal, "")
+	data, err := client.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !domain.IsControlMessage(data, domain.ControlSubTypeAssign) {
+		t.Fatalf("expected assign message, got %v", data)
+	}
+	header, _ := domain.ParseHeader(data)
+	info, ok := registry.Info(domain.SessionIDFromBytes(header.SessionID))
+	if !ok || info.Identity.UserID != "user-1" {
+		t.Errorf("registered session = (%+v, %v), want user-1", info.Identity, ok)
+	}
+}
+
+// recordingUpgrader はUpgradeが呼ばれたかを記録し、常に失敗するWebTransportUpgraderです。
+type recordingUpgrader struct {
+	called bool
+}
+
+func (u *recordingUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (domain.Transport, error) {
+	u.called = true
+	http.Error(w, "upgrade failed", http.StatusBadRequest)
+	return nil, errors.New("upgrade failed")
+}
+
+// TestWebTransportHandler_Origin は許可リストにないOriginのセッション確立要求を拒否することを確認します。
+func TestWebTransportHandler_Origin(t *testing.T) {
+	runner := NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), domain.NewSessionRegistry())
+	for _, tt := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://game.example.com", true},
+		{"https://evil.example.com", false},
+		{"https://example.test", true}, // Hostと同一
+	} {
+		upgrader := &recordingUpgrader{}
+		h := NewWebTransportHandler(runner, auth.Anonymous{}, upgrader, []string{"game.example.com"})
+		req := httptest.NewRequest(http.MethodConnect, "https://example.test/wt", nil)
+		req.Host = "example.test"
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if upgrader.called != tt.allowed {
+			t.Errorf("origin %q: upgraded = %v, want %v", tt.origin, upgrader.called, tt.allowed)
+		}
+		if !tt.allowed && rec.Code != http.StatusForbidden {
+			t.Errorf("origin %q: status = %d, want %d", tt.origin, rec.Code, http.StatusForbidden)
+		}
+	}
+}
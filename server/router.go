@@ -3,12 +3,40 @@ package server
 import (
 	"net/http"
 
+	"withered/server/adapter/httpstream"
+	"withered/server/auth"
 	"withered/server/domain"
 	"withered/server/handler"
 )
 
-func Route(pubsub domain.PubSub, roomManager domain.RoomManager) *http.ServeMux {
+// Route はクライアント向けのルーティングを返します。管理APIとメトリクスは含みません（AdminRouteを参照）。
+func Route(runner *handler.EndpointRunner, authenticator auth.Authenticator, upgradeOpts handler.UpgradeOptions, streamHub *adapterhttpstream.Hub) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.Handle("/ws", handler.NewAcceptHandler(pubsub, roomManager))
+	mux.Handle("/ws", handler.NewAcceptHandler(runner, authenticator, upgradeOpts))
+
+	// WebSocketが使えない環境向けのフォールバック
+	stream := handler.NewStreamHandler(runner, authenticator, streamHub, upgradeOpts.AllowedOrigins)
+	mux.HandleFunc("POST /stream", stream.Open)
+	mux.HandleFunc("GET /stream/{id}/events", stream.Events)
+	mux.HandleFunc("GET /stream/{id}/poll", stream.Poll)
+	mux.HandleFunc("POST /stream/{id}/send", stream.Send)
+	mux.HandleFunc("DELETE /stream/{id}", stream.Close)
+	mux.HandleFunc("OPTIONS /stream", stream.Preflight)
+	mux.HandleFunc("OPTIONS /stream/", stream.Preflight)
+	return mux
+}
+
+// AdminRoute は管理APIとメトリクスのルーティングを返します。
+// 認証を行わないため、クライアント向けとは別の、外部から到達できないアドレスで公開します。
+func AdminRoute(registry *domain.SessionRegistry, transportStats domain.TransportStatsReporter, pubsubStats domain.PubSubStatsReporter, rooms []*domain.Room) *http.ServeMux {
+	mux := http.NewServeMux()
+	admin := handler.NewAdminHandler(registry, rooms, pubsubStats)
+	mux.HandleFunc("GET /admin/sessions", admin.ListSessions)
+	mux.HandleFunc("GET /admin/sessions/{sessionID}", admin.GetSession)
+	mux.HandleFunc("DELETE /admin/sessions/{sessionID}", admin.CloseSession)
+	mux.HandleFunc("POST /admin/rooms/{roomID}/kick", admin.Kick)
+	mux.HandleFunc("GET /admin/pubsub", admin.PubSubStats)
+
+	mux.Handle("GET /metrics", handler.NewMetricsHandler(registry, transportStats, pubsubStats))
 	return mux
 }
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	adapterhttpstream "withered/server/adapter/httpstream"
+	"withered/server/auth"
+	"withered/server/domain"
+	"withered/server/handler"
+)
+
+// TestRoute_NoAdmin はクライアント向けのルーティングから管理APIとメトリクスに到達できないことを確認します。
+func TestRoute_NoAdmin(t *testing.T) {
+	registry := domain.NewSessionRegistry()
+	runner := handler.NewEndpointRunner(domain.NewSimplePubSub(), domain.NewSimpleRoomManager(domain.RoomID{1}), registry)
+	hub := adapterhttpstream.NewHub(adapterhttpstream.DefaultOptions())
+	mux := Route(runner, auth.Anonymous{}, handler.DefaultUpgradeOptions(), hub)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/admin/rooms/00000000000000000000000000000001/kick", nil),
+		httptest.NewRequest(http.MethodGet, "/admin/sessions", nil),
+		httptest.NewRequest(http.MethodDelete, "/admin/sessions/x", nil),
+		httptest.NewRequest(http.MethodGet, "/admin/pubsub", nil),
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s = %d, want %d", req.Method, req.URL.Path, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	// 管理用のルーティングでは提供される
+	admin := AdminRoute(registry, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/sessions", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("admin GET /admin/sessions = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,224 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+	"withered/server/transport/memtest"
+)
+
+var testRoomID = domain.RoomID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+func TestWriterRead_RoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 123)
+	records := []Record{
+		{Kind: KindOutbound, Offset: 0, Data: []byte{1, 2}},
+		{Kind: KindInbound, Offset: 15 * time.Millisecond, Data: []byte{3}},
+		{Kind: KindClose, Offset: time.Second, Data: encodeClose(4002, "kicked")},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, start)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	w.Flush()
+
+	c, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !c.Start.Equal(start) {
+		t.Errorf("Start = %v, want %v", c.Start, start)
+	}
+	if len(c.Records) != len(records) {
+		t.Fatalf("len(Records) = %d, want %d", len(c.Records), len(records))
+	}
+	for i, want := range records {
+		got := c.Records[i]
+		if got.Kind != want.Kind || got.Offset != want.Offset || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("Records[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	code, reason, err := DecodeClose(c.Records[2].Data)
+	if err != nil || code != 4002 || reason != "kicked" {
+		t.Errorf("DecodeClose = (%d, %q, %v), want (4002, kicked)", code, reason, err)
+	}
+
+	// 末尾が途中で切れていても、完全なレコードまでは読める
+	truncated, err := Read(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != nil {
+		t.Fatalf("Read truncated failed: %v", err)
+	}
+	if len(truncated.Records) != 2 {
+		t.Errorf("len(truncated.Records) = %d, want 2", len(truncated.Records))
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("WXYZ\x01\x00\x00\x00\x00\x00\x00\x00\x00"))); !errors.Is(err, ErrInvalidCapture) {
+		t.Errorf("Read with bad magic error = %v, want %v", err, ErrInvalidCapture)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := [][]byte{{1}, {2}, {3}}
+	got := [][]byte{{1}, {9}}
+
+	diffs := Diff(want, got)
+	if len(diffs) != 2 {
+		t.Fatalf("len(diffs) = %d, want 2: %v", len(diffs), diffs)
+	}
+	if diffs[0].Index != 1 || diffs[0].Got[0] != 9 {
+		t.Errorf("diffs[0] = %v, want mismatch at 1", diffs[0])
+	}
+	if diffs[1].Index != 2 || diffs[1].Got != nil {
+		t.Errorf("diffs[1] = %v, want missing frame at 2", diffs[1])
+	}
+}
+
+// runRoom はEchoApplicationのRoomを実時間のtickで起動します。
+func runRoom(t *testing.T, ctx context.Context) (domain.PubSub, domain.RoomManager) {
+	t.Helper()
+	pubsub := memtest.NewTrackingPubSub(domain.NewSimplePubSub())
+	room := domain.NewRoom(testRoomID, pubsub, domain.NewEchoApplication())
+	go room.Run(ctx)
+	if err := pubsub.WaitSubscribed(ctx, domain.Topic("room:"+testRoomID.String())); err != nil {
+		t.Fatalf("room did not subscribe: %v", err)
+	}
+	return pubsub, domain.NewSimpleRoomManager(testRoomID)
+}
+
+func runEndpoint(t *testing.T, transport domain.Transport, pubsub domain.PubSub, rooms domain.RoomManager) <-chan struct{} {
+	t.Helper()
+	session := domain.NewSession()
+	endpoint, err := domain.NewSessionEndpoint(session, domain.NewConnection(session.ID(), transport, "capture-test"), pubsub, rooms, domain.NewSessionRegistry())
+	if err != nil {
+		t.Fatalf("NewSessionEndpoint failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		endpoint.Run()
+	}()
+	t.Cleanup(endpoint.ForceClose)
+	return done
+}
+
+func frame(sessionID [16]byte, dataType domain.DataType, subType uint8, payload []byte) []byte {
+	header := domain.Header{Version: 1, SessionID: sessionID, Length: uint16(domain.PayloadHeaderSize + len(payload))}
+	data := append(header.Encode(), (&domain.PayloadHeader{DataType: dataType, SubType: subType}).Encode()...)
+	return append(data, payload...)
+}
+
+// record はJoin→Inputを送り、Inputのエコーを受け取ってから切断するセッションを記録します。
+func record(t *testing.T) *Capture {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubsub, rooms := runRoom(t, ctx)
+
+	client, server := memtest.Pipe(memtest.Options{Buffer: 64})
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(server, &buf)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	done := runEndpoint(t, recorder, pubsub, rooms)
+
+	assign, err := client.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read assign failed: %v", err)
+	}
+	header, _ := domain.ParseHeader(assign)
+	join := domain.JoinPayload{}
+	client.Write(ctx, frame(header.SessionID, domain.DataTypeControl, uint8(domain.ControlSubTypeJoin), join.Encode()))
+	time.Sleep(100 * time.Millisecond)
+	input := domain.InputPayload{KeyMask: 0x05}
+	client.Write(ctx, frame(header.SessionID, domain.DataTypeInput, 0, input.Encode()))
+	for {
+		data, err := client.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if len(data) > domain.HeaderSize && domain.DataType(data[domain.HeaderSize]) == domain.DataTypeInput {
+			break
+		}
+	}
+	client.Close(1000, "")
+	<-done
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("recorder error: %v", err)
+	}
+
+	c, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read capture failed: %v", err)
+	}
+	return c
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	c := record(t)
+	if n := len(c.Frames(KindInbound)); n != 2 {
+		t.Fatalf("recorded %d inbound frames, want 2", n)
+	}
+	if c.Records[len(c.Records)-1].Kind != KindClose {
+		t.Errorf("last record = %v, want close", c.Records[len(c.Records)-1].Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubsub, rooms := runRoom(t, ctx)
+
+	opts := DefaultReplayOptions()
+	opts.Speed = 2
+	replayer := NewReplayer(c, opts)
+	start := time.Now()
+	done := runEndpoint(t, replayer, pubsub, rooms)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("replay did not finish")
+	}
+
+	// 2倍速のため、応答がそろった時点で記録より短い時間で終わる
+	if elapsed := time.Since(start); elapsed > c.Duration()+opts.Linger {
+		t.Errorf("replay took %v, want less than recorded %v", elapsed, c.Duration())
+	}
+	if diffs := replayer.Diff(); len(diffs) != 0 {
+		t.Errorf("replay diverged from recording:\n%v\nrecorded: %+v\nreplayed: %+v", diffs, c.Records, replayer.Responses())
+	}
+}
+
+func TestReplay_DetectsDivergence(t *testing.T) {
+	c := record(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubsub, rooms := runRoom(t, ctx)
+
+	// 記録されたInputを書き換えると、エコーの内容が記録と一致しなくなる
+	for i, r := range c.Records {
+		if r.Kind == KindInbound && domain.DataType(r.Data[domain.HeaderSize]) == domain.DataTypeInput {
+			c.Records[i].Data = append([]byte(nil), r.Data...)
+			c.Records[i].Data[len(r.Data)-1] ^= 0xFF
+		}
+	}
+	opts := DefaultReplayOptions()
+	opts.Speed = 2
+	opts.Linger = 50 * time.Millisecond
+	replayer := NewReplayer(c, opts)
+	<-runEndpoint(t, replayer, pubsub, rooms)
+
+	if diffs := replayer.Diff(); len(diffs) == 0 {
+		t.Error("Diff reported no differences for a modified recording")
+	}
+}
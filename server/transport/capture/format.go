@@ -0,0 +1,210 @@
+// Package capture はセッションの送受信フレームを記録するTransportと、
+// 記録を再生してサーバーの応答を比較するTransportを提供します。
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// キャプチャファイルの形式（リトルエンディアン）
+//
+//	magic    [4]byte "WCAP"
+//	version  u8
+//	start    i64     記録開始時刻（UnixNano）
+//	records  ...
+//
+// 各レコード:
+//
+//	kind     u8
+//	delta    uvarint 直前のレコードからの経過時間（ナノ秒、単調時計）
+//	length   uvarint
+//	data     [length]byte
+const (
+	fileMagic     = "WCAP"
+	fileVersion   = 1
+	fileHeaderLen = len(fileMagic) + 1 + 8
+)
+
+var (
+	// ErrInvalidCapture はキャプチャファイルの形式が不正な場合に返されるエラーです。
+	ErrInvalidCapture = errors.New("capture: invalid capture file")
+	// ErrUnsupportedVersion はキャプチャファイルのバージョンに対応していない場合に返されるエラーです。
+	ErrUnsupportedVersion = errors.New("capture: unsupported version")
+)
+
+// Kind はレコードの種類です。向きはサーバーから見たものです。
+type Kind uint8
+
+const (
+	KindInbound  Kind = 1 // クライアントから受信したフレーム
+	KindOutbound Kind = 2 // クライアントへ送信したフレーム
+	KindClose    Kind = 3 // サーバーが接続を閉じた（dataはクローズコードと理由）
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInbound:
+		return "inbound"
+	case KindOutbound:
+		return "outbound"
+	case KindClose:
+		return "close"
+	default:
+		return fmt.Sprintf("unknown(%d)", k)
+	}
+}
+
+// Record はキャプチャの1レコードです。
+type Record struct {
+	Kind Kind
+	// Offset は記録開始からの経過時間です。
+	Offset time.Duration
+	Data   []byte
+}
+
+// Capture は読み込んだキャプチャファイルの内容です。
+type Capture struct {
+	Start   time.Time
+	Records []Record
+}
+
+// Frames は指定した種類のレコードのデータを順に返します。
+func (c *Capture) Frames(kind Kind) [][]byte {
+	var frames [][]byte
+	for _, r := range c.Records {
+		if r.Kind == kind {
+			frames = append(frames, r.Data)
+		}
+	}
+	return frames
+}
+
+// Duration は最後のレコードの経過時間を返します。
+func (c *Capture) Duration() time.Duration {
+	if len(c.Records) == 0 {
+		return 0
+	}
+	return c.Records[len(c.Records)-1].Offset
+}
+
+// Writer はキャプチャファイルを書き込みます。並行に使用することはできません。
+type Writer struct {
+	w    *bufio.Writer
+	last time.Duration
+	buf  [2*binary.MaxVarintLen64 + 1]byte
+}
+
+// NewWriter はファイルヘッダーを書き込み、Writerを作成します。
+func NewWriter(w io.Writer, start time.Time) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriter(w)}
+	header := make([]byte, 0, fileHeaderLen)
+	header = append(header, fileMagic...)
+	header = append(header, fileVersion)
+	header = binary.LittleEndian.AppendUint64(header, uint64(start.UnixNano()))
+	if _, err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write はレコードを書き込みます。Offsetは前のレコード以上である必要があります。
+func (w *Writer) Write(r Record) error {
+	delta := r.Offset - w.last
+	if delta < 0 {
+		delta = 0
+	}
+	w.last += delta
+
+	b := w.buf[:0]
+	b = append(b, byte(r.Kind))
+	b = binary.AppendUvarint(b, uint64(delta))
+	b = binary.AppendUvarint(b, uint64(len(r.Data)))
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	_, err := w.w.Write(r.Data)
+	return err
+}
+
+// Flush はバッファ中のレコードを書き出します。
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Read はキャプチャファイルを読み込みます。
+// 末尾のレコードが途中で切れている場合（記録中のプロセス終了等）は、そこまでを返します。
+func Read(r io.Reader) (*Capture, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, fileHeaderLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCapture, err)
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		return nil, ErrInvalidCapture
+	}
+	if v := header[len(fileMagic)]; v != fileVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	c := &Capture{Start: time.Unix(0, int64(binary.LittleEndian.Uint64(header[len(fileMagic)+1:])))}
+
+	var offset time.Duration
+	for {
+		kind, err := br.ReadByte()
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return c, nil
+		}
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return c, nil
+		}
+		if length > maxRecordSize {
+			return nil, fmt.Errorf("%w: record too large (%d bytes)", ErrInvalidCapture, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return c, nil
+		}
+		offset += time.Duration(delta)
+		c.Records = append(c.Records, Record{Kind: Kind(kind), Offset: offset, Data: data})
+	}
+}
+
+// ReadFile はキャプチャファイルをpathから読み込みます。
+func ReadFile(path string) (*Capture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// maxRecordSize は1レコードの最大サイズです（不正なファイルで巨大な確保をしないため）。
+const maxRecordSize = 1 << 20
+
+// encodeClose はクローズコードと理由をKindCloseのデータにエンコードします。
+func encodeClose(code int32, reason string) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(code))
+	return append(data, reason...)
+}
+
+// DecodeClose はKindCloseのデータからクローズコードと理由を取り出します。
+func DecodeClose(data []byte) (code int32, reason string, err error) {
+	if len(data) < 4 {
+		return 0, "", ErrInvalidCapture
+	}
+	return int32(binary.LittleEndian.Uint32(data)), string(data[4:]), nil
+}
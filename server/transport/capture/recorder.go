@@ -0,0 +1,121 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"withered/server/domain"
+)
+
+// Recorder は別のdomain.Transportをラップし、送受信した全フレームを記録するdomain.Transport実装です。
+// 記録に失敗した場合は以降の記録を止め、接続自体には影響を与えません。
+type Recorder struct {
+	inner domain.Transport
+	start time.Time
+
+	mu     sync.Mutex
+	w      *Writer
+	closer io.Closer
+	err    error
+}
+
+// NewRecorder はinnerの送受信をwに記録するRecorderを作成します。
+// wがio.Closerを実装している場合、RecorderのClose時に閉じられます。
+func NewRecorder(inner domain.Transport, w io.Writer) (*Recorder, error) {
+	start := time.Now()
+	cw, err := NewWriter(w, start)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{inner: inner, start: start, w: cw}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	return r, nil
+}
+
+func (r *Recorder) Read(ctx context.Context) ([]byte, error) {
+	data, err := r.inner.Read(ctx)
+	if err == nil {
+		r.record(KindInbound, data)
+	}
+	return data, err
+}
+
+func (r *Recorder) Write(ctx context.Context, data []byte) error {
+	if err := r.inner.Write(ctx, data); err != nil {
+		return err
+	}
+	r.record(KindOutbound, data)
+	return nil
+}
+
+// Close はクローズを記録してキャプチャを書き出し、内側のTransportを閉じます。
+func (r *Recorder) Close(code int32, reason string) error {
+	err := r.inner.Close(code, reason)
+	r.record(KindClose, encodeClose(code, reason))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w != nil {
+		if ferr := r.w.Flush(); ferr != nil && r.err == nil {
+			r.err = ferr
+		}
+		r.w = nil
+		if r.closer != nil {
+			if cerr := r.closer.Close(); cerr != nil && r.err == nil {
+				r.err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Err は記録中に発生した最初のエラーを返します。
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(kind Kind, data []byte) {
+	offset := time.Since(r.start) // 単調時計による経過時間
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil || r.err != nil {
+		return
+	}
+	if err := r.w.Write(Record{Kind: kind, Offset: offset, Data: data}); err != nil {
+		r.err = err
+		slog.Warn("capture: recording stopped", "err", err)
+	}
+}
+
+// Middleware はdirに接続ごとのキャプチャファイルを作成して記録するミドルウェアを返します。
+// ファイルを作成できない場合は記録せずにinnerをそのまま返します。
+func Middleware(dir string) func(domain.Transport) domain.Transport {
+	var seq atomic.Uint64
+	return func(inner domain.Transport) domain.Transport {
+		name := fmt.Sprintf("%s-%04d.wcap", time.Now().Format("20060102T150405"), seq.Add(1))
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			slog.Warn("capture: failed to create capture file", "err", err)
+			return inner
+		}
+		r, err := NewRecorder(inner, f)
+		if err != nil {
+			f.Close()
+			slog.Warn("capture: failed to start recording", "err", err)
+			return inner
+		}
+		return r
+	}
+}
@@ -0,0 +1,314 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ErrTransportClosed はClose済みのReplayerを操作した場合に返されるエラーです。
+var ErrTransportClosed = fmt.Errorf("capture: %w", domain.ErrTransportClosed)
+
+// ReplayOptions はReplayerの設定です。
+type ReplayOptions struct {
+	// Speed は再生速度の倍率です。2の場合は記録の2倍の速さで再生します。
+	// 0以下の場合は待ち時間なしで再生します。
+	Speed float64
+	// Linger は全ての受信フレームを返した後、記録の終端に加えて応答を待つ時間です。
+	// 応答はtick単位で送られるため、記録より遅れて届く分の猶予になります。
+	Linger time.Duration
+}
+
+// DefaultReplayOptions は記録と同じ速さで再生するReplayOptionsを返します。
+func DefaultReplayOptions() ReplayOptions {
+	return ReplayOptions{Speed: 1, Linger: 100 * time.Millisecond}
+}
+
+// Replayer は記録された受信フレームを元のタイミングで返すdomain.Transport実装です。
+// SessionEndpointに渡して再生し、サーバーの応答をDiffで記録と比較します。
+//
+// 再生中のセッションIDは記録時と異なるため、サーバーからのAssignを受け取った後、
+// 受信フレームのヘッダーのセッションIDを新しいものに書き換えて返します。
+// 全ての受信フレームを返した後、記録と同じ数の応答を受け取るか、最後の受信フレームから
+// 記録の終端までの時間とLingerが経過するとReadはio.EOFを返します。この待ち時間は
+// tickが実時間で進むためSpeedによらず記録と同じ長さです。
+type Replayer struct {
+	capture *Capture
+	opts    ReplayOptions
+	start   time.Time
+
+	inbound    []Record
+	next       int // Readからのみ参照する
+	recordedID [16]byte
+	hasID      bool
+
+	mu        sync.Mutex
+	sessionID [16]byte
+	assigned  chan struct{}
+	responses []Record
+	written   chan struct{} // Writeのたびに通知する（容量1）
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewReplayer はcaptureを再生するReplayerを作成します。再生の時刻は作成時点から数えます。
+func NewReplayer(c *Capture, opts ReplayOptions) *Replayer {
+	r := &Replayer{
+		capture:  c,
+		opts:     opts,
+		start:    time.Now(),
+		assigned: make(chan struct{}),
+		written:  make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	for _, rec := range c.Records {
+		switch rec.Kind {
+		case KindInbound:
+			r.inbound = append(r.inbound, rec)
+		case KindOutbound:
+			if !r.hasID && domain.IsControlMessage(rec.Data, domain.ControlSubTypeAssign) {
+				copy(r.recordedID[:], rec.Data[domain.HeaderSessionIDStart:domain.HeaderSessionIDEnd])
+				r.hasID = true
+			}
+		}
+	}
+	return r
+}
+
+func (r *Replayer) Read(ctx context.Context) ([]byte, error) {
+	if r.next >= len(r.inbound) {
+		if err := r.waitResponses(ctx); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if r.hasID {
+		// 書き換え先のセッションIDが決まるまで待つ
+		select {
+		case <-r.assigned:
+		case <-r.closed:
+			return nil, ErrTransportClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	rec := r.inbound[r.next]
+	if err := r.waitUntil(ctx, rec.Offset); err != nil {
+		return nil, err
+	}
+	r.next++
+
+	data := append([]byte(nil), rec.Data...)
+	if r.hasID && len(data) >= domain.HeaderSize && bytes.Equal(data[domain.HeaderSessionIDStart:domain.HeaderSessionIDEnd], r.recordedID[:]) {
+		r.mu.Lock()
+		copy(data[domain.HeaderSessionIDStart:domain.HeaderSessionIDEnd], r.sessionID[:])
+		r.mu.Unlock()
+	}
+	return data, nil
+}
+
+func (r *Replayer) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-r.closed:
+		return ErrTransportClosed
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if domain.IsControlMessage(data, domain.ControlSubTypeAssign) {
+		select {
+		case <-r.assigned:
+		default:
+			copy(r.sessionID[:], data[domain.HeaderSessionIDStart:domain.HeaderSessionIDEnd])
+			close(r.assigned)
+		}
+	}
+	r.responses = append(r.responses, Record{Kind: KindOutbound, Offset: r.elapsed(), Data: append([]byte(nil), data...)})
+	select {
+	case r.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (r *Replayer) Close(code int32, reason string) error {
+	err := ErrTransportClosed
+	r.closeOnce.Do(func() {
+		err = nil
+		r.mu.Lock()
+		r.responses = append(r.responses, Record{Kind: KindClose, Offset: r.elapsed(), Data: encodeClose(code, reason)})
+		r.mu.Unlock()
+		close(r.closed)
+	})
+	return err
+}
+
+// Done はサーバーが接続を閉じると閉じられるチャネルを返します。
+func (r *Replayer) Done() <-chan struct{} {
+	return r.closed
+}
+
+// Responses は再生中にサーバーが送信したフレームとクローズを記録の時間軸で返します。
+func (r *Replayer) Responses() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.responses...)
+}
+
+// Diff は記録された送信フレームと再生中の送信フレームを比較します。
+// セッションIDは記録時のものに揃え、ヘッダーのタイムスタンプは比較しません。
+func (r *Replayer) Diff() []Difference {
+	r.mu.Lock()
+	sessionID := r.sessionID
+	r.mu.Unlock()
+
+	want := r.capture.Frames(KindOutbound)
+	var got [][]byte
+	for _, rec := range r.Responses() {
+		if rec.Kind == KindOutbound {
+			got = append(got, rec.Data)
+		}
+	}
+	for i := range want {
+		want[i] = NormalizeFrame(want[i], r.recordedID, r.recordedID)
+	}
+	for i := range got {
+		got[i] = NormalizeFrame(got[i], sessionID, r.recordedID)
+	}
+	return Diff(want, got)
+}
+
+// waitUntil は記録上の経過時間offsetに対応する時刻まで待ちます。
+func (r *Replayer) waitUntil(ctx context.Context, offset time.Duration) error {
+	if r.opts.Speed <= 0 {
+		return nil
+	}
+	d := time.Until(r.start.Add(time.Duration(float64(offset) / r.opts.Speed)))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.closed:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitResponses は記録と同じ数の応答を受け取るか、最後の受信フレームから記録の終端までの時間とLingerが経過するまで待ちます。
+func (r *Replayer) waitResponses(ctx context.Context) error {
+	tail := r.capture.Duration() + r.opts.Linger
+	if n := len(r.inbound); n > 0 {
+		tail -= r.inbound[n-1].Offset
+	}
+	timer := time.NewTimer(tail)
+	defer timer.Stop()
+
+	want := len(r.capture.Frames(KindOutbound))
+	for {
+		r.mu.Lock()
+		got := 0
+		for _, rec := range r.responses {
+			if rec.Kind == KindOutbound {
+				got++
+			}
+		}
+		r.mu.Unlock()
+		if got >= want {
+			return nil
+		}
+
+		select {
+		case <-r.written:
+		case <-timer.C:
+			return nil
+		case <-r.closed:
+			return ErrTransportClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// elapsed は再生開始からの経過時間を記録の時間軸に換算して返します。
+func (r *Replayer) elapsed() time.Duration {
+	d := time.Since(r.start)
+	if r.opts.Speed > 0 {
+		d = time.Duration(float64(d) * r.opts.Speed)
+	}
+	return d
+}
+
+// Difference は送信フレームの比較で一致しなかった箇所です。
+type Difference struct {
+	// Index は送信フレームの順番です。
+	Index int
+	// Want は記録されたフレームです。再生側の方が多い場合はnilです。
+	Want []byte
+	// Got は再生中のフレームです。記録側の方が多い場合はnilです。
+	Got []byte
+}
+
+func (d Difference) String() string {
+	switch {
+	case d.Want == nil:
+		return fmt.Sprintf("#%d: unexpected frame %s", d.Index, describeFrame(d.Got))
+	case d.Got == nil:
+		return fmt.Sprintf("#%d: missing frame %s", d.Index, describeFrame(d.Want))
+	default:
+		return fmt.Sprintf("#%d: want %s %x, got %s %x", d.Index, describeFrame(d.Want), d.Want, describeFrame(d.Got), d.Got)
+	}
+}
+
+// Diff はwantとgotを先頭から順に比較し、一致しないフレームを返します。
+func Diff(want, got [][]byte) []Difference {
+	var diffs []Difference
+	for i := 0; i < max(len(want), len(got)); i++ {
+		var w, g []byte
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != nil && g != nil && bytes.Equal(w, g) {
+			continue
+		}
+		diffs = append(diffs, Difference{Index: i, Want: w, Got: g})
+	}
+	return diffs
+}
+
+// NormalizeFrame は比較のためにフレームを正規化した複製を返します。
+// ヘッダーのタイムスタンプを0にし、フレーム中のセッションIDfromをtoに置き換えます。
+func NormalizeFrame(data []byte, from, to [16]byte) []byte {
+	var out []byte
+	if from == to || from == ([16]byte{}) {
+		out = bytes.Clone(data)
+	} else {
+		out = bytes.ReplaceAll(data, from[:], to[:])
+	}
+	if len(out) >= domain.HeaderSize {
+		clear(out[domain.HeaderTimestampStart:domain.HeaderTimestampEnd])
+	}
+	return out
+}
+
+func describeFrame(data []byte) string {
+	if len(data) < domain.HeaderSize+domain.PayloadHeaderSize {
+		return fmt.Sprintf("(%d bytes)", len(data))
+	}
+	return fmt.Sprintf("(type=%d subtype=%d, %d bytes)", data[domain.HeaderSize], data[domain.HeaderSize+1], len(data))
+}
@@ -0,0 +1,175 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"withered/server/domain"
+)
+
+// latencyBounds は書き込み所要時間のヒストグラムのバケット上限です。
+var latencyBounds = [...]time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// closedErrors は接続が閉じられていたことを表すエラーです。
+// 各アダプターのクローズのエラーはdomain.ErrTransportClosedをラップしています。
+var closedErrors = []error{
+	io.EOF,
+	io.ErrUnexpectedEOF,
+	net.ErrClosed,
+	domain.ErrTransportClosed,
+}
+
+// errorClass はエラーの種類です。
+type errorClass int
+
+const (
+	classTimeout errorClass = iota
+	classCanceled
+	classClosed
+	classOther
+	numClasses
+)
+
+// classify はエラーを種類に分類します。
+func classify(err error) errorClass {
+	if errors.Is(err, context.Canceled) {
+		return classCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return classTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return classTimeout
+	}
+	for _, target := range closedErrors {
+		if errors.Is(err, target) {
+			return classClosed
+		}
+	}
+	return classOther
+}
+
+const (
+	dirIn = iota
+	dirOut
+	numDirs
+)
+
+// counters は1つのTransportの計測値です。ホットパスでロックを取らないよう全てアトミックに更新します。
+type counters struct {
+	frames       [numDirs]atomic.Uint64
+	bytes        [numDirs]atomic.Uint64
+	errors       [numDirs][numClasses]atomic.Uint64
+	latency      [len(latencyBounds) + 1]atomic.Uint64
+	latencySum   atomic.Int64
+	lastActivity [numDirs]atomic.Int64
+}
+
+func (c *counters) observe(dir int, n int, now time.Time) {
+	c.frames[dir].Add(1)
+	c.bytes[dir].Add(uint64(n))
+	c.lastActivity[dir].Store(now.UnixNano())
+}
+
+func (c *counters) observeError(dir int, err error) {
+	c.errors[dir][classify(err)].Add(1)
+}
+
+func (c *counters) observeLatency(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	c.latency[i].Add(1)
+	c.latencySum.Add(int64(d))
+}
+
+// addTo はcの現在値をtに加算します。
+func (c *counters) addTo(t *totals) {
+	for dir := range numDirs {
+		t.frames[dir] += c.frames[dir].Load()
+		t.bytes[dir] += c.bytes[dir].Load()
+		for class := range numClasses {
+			t.errors[dir][class] += c.errors[dir][class].Load()
+		}
+		t.lastActivity[dir] = max(t.lastActivity[dir], c.lastActivity[dir].Load())
+	}
+	for i := range c.latency {
+		t.latency[i] += c.latency[i].Load()
+	}
+	t.latencySum += c.latencySum.Load()
+}
+
+// totals は集計用のcountersのコピーです。
+type totals struct {
+	frames       [numDirs]uint64
+	bytes        [numDirs]uint64
+	errors       [numDirs][numClasses]uint64
+	latency      [len(latencyBounds) + 1]uint64
+	latencySum   int64
+	lastActivity [numDirs]int64
+}
+
+func (t *totals) stats() domain.TransportStats {
+	return domain.TransportStats{
+		FramesIn:     t.frames[dirIn],
+		FramesOut:    t.frames[dirOut],
+		BytesIn:      t.bytes[dirIn],
+		BytesOut:     t.bytes[dirOut],
+		ReadErrors:   t.transportErrors(dirIn),
+		WriteErrors:  t.transportErrors(dirOut),
+		WriteLatency: t.histogram(),
+		LastRead:     unixNano(t.lastActivity[dirIn]),
+		LastWrite:    unixNano(t.lastActivity[dirOut]),
+	}
+}
+
+func (t *totals) transportErrors(dir int) domain.TransportErrors {
+	return domain.TransportErrors{
+		Timeout:  t.errors[dir][classTimeout],
+		Canceled: t.errors[dir][classCanceled],
+		Closed:   t.errors[dir][classClosed],
+		Other:    t.errors[dir][classOther],
+	}
+}
+
+func (t *totals) histogram() domain.LatencyHistogram {
+	h := domain.LatencyHistogram{
+		Buckets: make([]domain.LatencyBucket, len(latencyBounds)),
+		Sum:     time.Duration(t.latencySum),
+	}
+	var cumulative uint64
+	for i, bound := range latencyBounds {
+		cumulative += t.latency[i]
+		h.Buckets[i] = domain.LatencyBucket{UpperBound: bound, Count: cumulative}
+	}
+	h.Count = cumulative + t.latency[len(latencyBounds)]
+	return h
+}
+
+func unixNano(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
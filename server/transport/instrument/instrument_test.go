@@ -0,0 +1,130 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+	"withered/server/transport/memtest"
+)
+
+func TestTransport_CountsFramesAndBytes(t *testing.T) {
+	ctx := context.Background()
+	client, server := memtest.Pipe(memtest.Options{Buffer: 8})
+	tr := Wrap(server, nil)
+
+	before := time.Now()
+	tr.Write(ctx, make([]byte, 10))
+	tr.Write(ctx, make([]byte, 20))
+	client.Write(ctx, make([]byte, 5))
+	if _, err := tr.Read(ctx); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	s := tr.TransportStats()
+	if s.FramesOut != 2 || s.BytesOut != 30 {
+		t.Errorf("out = (%d frames, %d bytes), want (2, 30)", s.FramesOut, s.BytesOut)
+	}
+	if s.FramesIn != 1 || s.BytesIn != 5 {
+		t.Errorf("in = (%d frames, %d bytes), want (1, 5)", s.FramesIn, s.BytesIn)
+	}
+	if s.WriteLatency.Count != 2 {
+		t.Errorf("WriteLatency.Count = %d, want 2", s.WriteLatency.Count)
+	}
+	last := s.WriteLatency.Buckets[len(s.WriteLatency.Buckets)-1]
+	if last.Count != 2 {
+		t.Errorf("last bucket count = %d, want 2 (cumulative)", last.Count)
+	}
+	if s.LastRead.Before(before) || s.LastWrite.Before(before) {
+		t.Errorf("last activity = (%v, %v), want after %v", s.LastRead, s.LastWrite, before)
+	}
+}
+
+func TestTransport_ClassifiesErrors(t *testing.T) {
+	ctx := context.Background()
+	_, server := memtest.Pipe(memtest.Options{Buffer: 8})
+	tr := Wrap(server, nil)
+
+	// アダプターのクローズのエラーはdomain.ErrTransportClosedをラップしている
+	closed := fmt.Errorf("adapter: %w", domain.ErrTransportClosed)
+	for _, err := range []error{context.DeadlineExceeded, context.Canceled, io.EOF, closed, errors.New("boom")} {
+		server.FailWrites(err)
+		tr.Write(ctx, []byte{1})
+		server.FailReads(err)
+		tr.Read(ctx)
+	}
+
+	s := tr.TransportStats()
+	want := domain.TransportErrors{Timeout: 1, Canceled: 1, Closed: 2, Other: 1}
+	if s.WriteErrors != want {
+		t.Errorf("WriteErrors = %+v, want %+v", s.WriteErrors, want)
+	}
+	if s.ReadErrors != want {
+		t.Errorf("ReadErrors = %+v, want %+v", s.ReadErrors, want)
+	}
+	if s.FramesOut != 0 || s.FramesIn != 0 {
+		t.Errorf("failed I/O counted as frames: %+v", s)
+	}
+}
+
+func TestCollector_KeepsTotalsAfterClose(t *testing.T) {
+	ctx := context.Background()
+	collector := NewCollector()
+	wrap := collector.Middleware()
+
+	_, a := memtest.Pipe(memtest.Options{Buffer: 8})
+	_, b := memtest.Pipe(memtest.Options{Buffer: 8})
+	ta, tb := wrap(a), wrap(b)
+	ta.Write(ctx, make([]byte, 3))
+	tb.Write(ctx, make([]byte, 4))
+	if collector.Active() != 2 {
+		t.Errorf("Active = %d, want 2", collector.Active())
+	}
+
+	ta.Close(domain.CloseCodeNormal, "")
+	ta.Close(domain.CloseCodeNormal, "")
+	if collector.Active() != 1 {
+		t.Errorf("Active after close = %d, want 1", collector.Active())
+	}
+	s := collector.TransportStats()
+	if s.FramesOut != 2 || s.BytesOut != 7 {
+		t.Errorf("totals = (%d frames, %d bytes), want (2, 7)", s.FramesOut, s.BytesOut)
+	}
+}
+
+// TestTransport_ReportsToRegistry はラップしたTransportの計測値がセッション情報に含まれることを確認します。
+func TestTransport_ReportsToRegistry(t *testing.T) {
+	h := memtest.NewHarness(t, domain.NewEchoApplication(), 0)
+	client := h.ConnectWith(NewCollector().Middleware())
+
+	info, ok := h.Registry.Info(client.SessionID)
+	if !ok {
+		t.Fatal("session not registered")
+	}
+	if info.Transport == nil {
+		t.Fatal("SessionInfo.Transport is nil")
+	}
+	if info.Transport.FramesOut == 0 {
+		t.Error("assign message was not counted")
+	}
+}
+
+func BenchmarkTransport_Write(b *testing.B) {
+	ctx := context.Background()
+	tr := Wrap(discard{}, NewCollector())
+	data := make([]byte, 64)
+	b.ReportAllocs()
+	for b.Loop() {
+		tr.Write(ctx, data)
+	}
+}
+
+type discard struct{}
+
+func (discard) Read(ctx context.Context) ([]byte, error)     { return nil, io.EOF }
+func (discard) Write(ctx context.Context, data []byte) error { return nil }
+func (discard) Close(code int32, reason string) error        { return nil }
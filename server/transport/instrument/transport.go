@@ -0,0 +1,124 @@
+// Package instrument はdomain.Transportをラップし、送受信のフレーム数・バイト数、
+// 書き込みの所要時間、エラーの種類、最終アクティビティ時刻を計測します。
+//
+// 計測値はアトミックなカウンタで保持するため、Read/Writeのホットパスでロックは取りません。
+// ラップしたTransportはdomain.TransportStatsReporterを実装するため、
+// SessionRegistryのセッション情報に計測値が含まれます。
+package instrument
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// Transport は別のdomain.Transportをラップし、I/Oを計測するdomain.Transport実装です。
+type Transport struct {
+	inner     domain.Transport
+	collector *Collector
+	counters  counters
+	closeOnce sync.Once
+}
+
+// Wrap はinnerをラップしたTransportを作成します。
+// collectorがnilでない場合、Transportの計測値はcollectorの集計に含まれます。
+func Wrap(inner domain.Transport, collector *Collector) *Transport {
+	t := &Transport{inner: inner, collector: collector}
+	if collector != nil {
+		collector.add(t)
+	}
+	return t
+}
+
+func (t *Transport) Read(ctx context.Context) ([]byte, error) {
+	data, err := t.inner.Read(ctx)
+	if err != nil {
+		t.counters.observeError(dirIn, err)
+		return nil, err
+	}
+	t.counters.observe(dirIn, len(data), time.Now())
+	return data, nil
+}
+
+func (t *Transport) Write(ctx context.Context, data []byte) error {
+	start := time.Now()
+	err := t.inner.Write(ctx, data)
+	end := time.Now()
+	t.counters.observeLatency(end.Sub(start))
+	if err != nil {
+		t.counters.observeError(dirOut, err)
+		return err
+	}
+	t.counters.observe(dirOut, len(data), end)
+	return nil
+}
+
+// Close は内側のTransportを閉じ、計測値をcollectorの累計に移します。
+func (t *Transport) Close(code int32, reason string) error {
+	err := t.inner.Close(code, reason)
+	t.closeOnce.Do(func() {
+		if t.collector != nil {
+			t.collector.remove(t)
+		}
+	})
+	return err
+}
+
+// TransportStats は計測値のスナップショットを返します。
+func (t *Transport) TransportStats() domain.TransportStats {
+	var s totals
+	t.counters.addTo(&s)
+	return s.stats()
+}
+
+// Collector は複数のTransportの計測値を集計します。
+// 閉じられたTransportの計測値は累計として保持されます。
+type Collector struct {
+	mu      sync.Mutex
+	live    map[*Transport]struct{}
+	retired totals
+}
+
+func NewCollector() *Collector {
+	return &Collector{live: make(map[*Transport]struct{})}
+}
+
+// Middleware は接続ごとのTransportをラップして計測するミドルウェアを返します。
+func (c *Collector) Middleware() func(domain.Transport) domain.Transport {
+	return func(inner domain.Transport) domain.Transport {
+		return Wrap(inner, c)
+	}
+}
+
+// Active は計測中（未Close）のTransportの数を返します。
+func (c *Collector) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.live)
+}
+
+// TransportStats は閉じられたものも含む全Transportの計測値の合計を返します。
+func (c *Collector) TransportStats() domain.TransportStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.retired
+	for t := range c.live {
+		t.counters.addTo(&s)
+	}
+	return s.stats()
+}
+
+func (c *Collector) add(t *Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live[t] = struct{}{}
+}
+
+func (c *Collector) remove(t *Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.live, t)
+	t.counters.addTo(&c.retired)
+}
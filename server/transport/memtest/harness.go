@@ -0,0 +1,243 @@
+package memtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+)
+
+// DefaultRoomID はハーネスが起動するRoomのIDです。
+var DefaultRoomID = domain.RoomID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+// Harness はRoom・SimplePubSub・RoomManager・SessionEndpointを1プロセス内で起動し、
+// インメモリTransportで接続したClientから操作するためのテストハーネスです。
+// Roomのtickは自動では進まず、Tickを呼んだ時だけ1tick分処理されます。
+type Harness struct {
+	t testing.TB
+
+	PubSub      *TrackingPubSub
+	RoomManager *domain.SimpleRoomManager
+	Registry    *domain.SessionRegistry
+	Room        *domain.Room
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ticks    chan time.Time
+	tickDone chan struct{}
+	wg       sync.WaitGroup
+
+	clients []*Client
+}
+
+// NewHarness はappを実行するRoomを起動し、n個のClientを接続します。
+// 後始末はt.Cleanupで行われます。
+func NewHarness(t testing.TB, app domain.Application, n int) *Harness {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Harness{
+		t:           t,
+		PubSub:      NewTrackingPubSub(domain.NewSimplePubSub()),
+		RoomManager: domain.NewSimpleRoomManager(DefaultRoomID),
+		Registry:    domain.NewSessionRegistry(),
+		ctx:         ctx,
+		cancel:      cancel,
+		ticks:       make(chan time.Time),
+		tickDone:    make(chan struct{}),
+	}
+	h.Room = domain.NewRoom(DefaultRoomID, h.PubSub, &tickNotifier{Application: app, done: h.tickDone})
+	t.Cleanup(h.close)
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.Room.RunWithTicks(ctx, h.ticks)
+	}()
+	// Roomの購読が完了する前にJoinすると取りこぼすため待機する
+	if err := h.PubSub.WaitSubscribed(h.waitCtx(), domain.RoomTopic(DefaultRoomID)); err != nil {
+		t.Fatalf("memtest: room did not subscribe: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		h.Connect()
+	}
+	return h
+}
+
+// Connect は新しいClientを接続し、セッションIDの通知を受け取るまで待ちます。
+func (h *Harness) Connect() *Client {
+	h.t.Helper()
+	return h.ConnectWith(nil)
+}
+
+// ConnectWith はサーバー側のTransportをwrapでラップしてClientを接続します。
+// 回線状態の再現（netsim）等、トランスポートのミドルウェアを挟む場合に使用します。
+func (h *Harness) ConnectWith(wrap func(domain.Transport) domain.Transport) *Client {
+	h.t.Helper()
+	clientSide, pipeSide := Pipe(Options{Buffer: domain.DefaultSendQueueSize})
+	var serverSide domain.Transport = pipeSide
+	if wrap != nil {
+		serverSide = wrap(pipeSide)
+	}
+	session := domain.NewSession()
+	connection := domain.NewConnection(session.ID(), serverSide, fmt.Sprintf("memtest:%d", len(h.clients)))
+	endpoint, err := domain.NewSessionEndpoint(session, connection, h.PubSub, h.RoomManager, h.Registry)
+	if err != nil {
+		h.t.Fatalf("memtest: failed to create session endpoint: %v", err)
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		endpoint.Run()
+	}()
+
+	c := &Client{h: h, Transport: clientSide, Endpoint: endpoint}
+	assign, err := c.ReadUntil(h.waitCtx(), func(data []byte) bool {
+		return domain.IsControlMessage(data, domain.ControlSubTypeAssign)
+	})
+	if err != nil {
+		h.t.Fatalf("memtest: assign message not received: %v", err)
+	}
+	header, err := domain.ParseHeader(assign)
+	if err != nil {
+		h.t.Fatalf("memtest: invalid assign message: %v", err)
+	}
+	c.SessionID = domain.SessionIDFromBytes(header.SessionID)
+	h.clients = append(h.clients, c)
+	return c
+}
+
+// Client はi番目に接続したClientを返します。
+func (h *Harness) Client(i int) *Client {
+	return h.clients[i]
+}
+
+// Clients は接続済みの全Clientを返します。
+func (h *Harness) Clients() []*Client {
+	return h.clients
+}
+
+// Tick はRoomを1tick進め、ApplicationのTickが終わるまで待ちます。
+func (h *Harness) Tick(ctx context.Context) error {
+	select {
+	case h.ticks <- time.Now():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-h.tickDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Harness) waitCtx() context.Context {
+	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
+	h.t.Cleanup(cancel)
+	return ctx
+}
+
+func (h *Harness) close() {
+	for _, c := range h.clients {
+		c.Transport.Close(1000, "")
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.Registry.CloseAll(cancelled)
+	h.cancel()
+	h.wg.Wait()
+}
+
+// tickNotifier はApplicationのTick完了をHarnessに通知します。
+type tickNotifier struct {
+	domain.Application
+	done chan<- struct{}
+}
+
+func (n *tickNotifier) Tick(ctx context.Context) interface{} {
+	out := n.Application.Tick(ctx)
+	select {
+	case n.done <- struct{}{}:
+	case <-ctx.Done():
+	}
+	return out
+}
+
+// Client はハーネスに接続したクライアント側の操作を提供します。
+type Client struct {
+	h *Harness
+
+	SessionID domain.SessionID
+	Transport *Transport
+	Endpoint  *domain.SessionEndpoint
+
+	seq uint16
+}
+
+// Send はフレームをそのまま送信します。
+func (c *Client) Send(ctx context.Context, data []byte) error {
+	return c.Transport.Write(ctx, data)
+}
+
+// Join はデフォルトルームへの参加を送信し、Roomに届くまで待ちます。
+func (c *Client) Join(ctx context.Context) error {
+	join := domain.JoinPayload{}
+	return c.sendToRoom(ctx, c.frame(domain.DataTypeControl, uint8(domain.ControlSubTypeJoin), join.Encode()))
+}
+
+// Leave はルームからの退出を送信し、Roomに届くまで待ちます。
+func (c *Client) Leave(ctx context.Context) error {
+	return c.sendToRoom(ctx, c.frame(domain.DataTypeControl, uint8(domain.ControlSubTypeLeave), nil))
+}
+
+// SendInput は入力を送信し、Roomに届くまで待ちます。
+func (c *Client) SendInput(ctx context.Context, keyMask uint32) error {
+	input := domain.InputPayload{KeyMask: keyMask}
+	return c.sendToRoom(ctx, c.frame(domain.DataTypeInput, 0, input.Encode()))
+}
+
+// Read は次のフレームを受信します。
+func (c *Client) Read(ctx context.Context) ([]byte, error) {
+	return c.Transport.Read(ctx)
+}
+
+// ReadUntil はmatchがtrueを返すフレームを受信するまで読み進めます。
+func (c *Client) ReadUntil(ctx context.Context, match func([]byte) bool) ([]byte, error) {
+	for {
+		data, err := c.Transport.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if match(data) {
+			return data, nil
+		}
+	}
+}
+
+// sendToRoom はフレームを送信し、SessionEndpointがroom topicにpublishするまで待ちます。
+func (c *Client) sendToRoom(ctx context.Context, data []byte) error {
+	topic := domain.RoomTopic(DefaultRoomID)
+	before := c.h.PubSub.PublishedBy(topic, c.SessionID)
+	if err := c.Send(ctx, data); err != nil {
+		return err
+	}
+	return c.h.PubSub.WaitPublishedBy(ctx, topic, c.SessionID, before+1)
+}
+
+func (c *Client) frame(dataType domain.DataType, subType uint8, payload []byte) []byte {
+	c.seq++
+	header := domain.Header{
+		Version:   1,
+		SessionID: c.SessionID.Bytes(),
+		Seq:       c.seq,
+		Length:    uint16(domain.PayloadHeaderSize + len(payload)),
+		Timestamp: uint32(time.Now().UnixMilli() & 0xFFFFFFFF),
+	}
+	payloadHeader := domain.PayloadHeader{DataType: dataType, SubType: subType}
+	data := append(header.Encode(), payloadHeader.Encode()...)
+	return append(data, payload...)
+}
@@ -0,0 +1,109 @@
+package memtest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+)
+
+func isInput(data []byte) bool {
+	return len(data) > domain.HeaderSize && domain.DataType(data[domain.HeaderSize]) == domain.DataTypeInput
+}
+
+// TestHarness_EchoBroadcast はJoin→Input→Tickで、ルームの全メンバーにブロードキャストされることを確認します。
+func TestHarness_EchoBroadcast(t *testing.T) {
+	h := NewHarness(t, domain.NewEchoApplication(), 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, c := range h.Clients() {
+		if err := c.Join(ctx); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+	}
+	if err := h.Tick(ctx); err != nil {
+		t.Fatalf("Tick failed: %v", err)
+	}
+
+	if err := h.Client(0).SendInput(ctx, 0x01); err != nil {
+		t.Fatalf("SendInput failed: %v", err)
+	}
+	if err := h.Tick(ctx); err != nil {
+		t.Fatalf("Tick failed: %v", err)
+	}
+
+	sender := h.Client(0).SessionID.Bytes()
+	for i, c := range h.Clients() {
+		data, err := c.ReadUntil(ctx, isInput)
+		if err != nil {
+			t.Fatalf("client %d: ReadUntil failed: %v", i, err)
+		}
+		header, _ := domain.ParseHeader(data)
+		if header.SessionID != sender {
+			t.Errorf("client %d: SessionID = %v, want %v", i, header.SessionID, sender)
+		}
+	}
+}
+
+// TestHarness_LeaveStopsDelivery は退出したClientにブロードキャストが届かないことを確認します。
+func TestHarness_LeaveStopsDelivery(t *testing.T) {
+	h := NewHarness(t, domain.NewEchoApplication(), 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, c := range h.Clients() {
+		c.Join(ctx)
+	}
+	if err := h.Client(1).Leave(ctx); err != nil {
+		t.Fatalf("Leave failed: %v", err)
+	}
+	h.Client(0).SendInput(ctx, 0x01)
+	h.Tick(ctx)
+
+	if _, err := h.Client(0).ReadUntil(ctx, isInput); err != nil {
+		t.Fatalf("member did not receive broadcast: %v", err)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if data, err := h.Client(1).ReadUntil(short, isInput); err == nil {
+		t.Errorf("left client received broadcast: %v", data)
+	}
+}
+
+// TestHarness_MulticastExclude はルームのマルチキャストが除外したメンバー以外に届き、
+// 共有バッファが全員への送信後に解放されることを確認します。
+func TestHarness_MulticastExclude(t *testing.T) {
+	h := NewHarness(t, domain.NewEchoApplication(), 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, c := range h.Clients() {
+		if err := c.Join(ctx); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+	}
+
+	frame := []byte("multicast")
+	isFrame := func(data []byte) bool { return bytes.Equal(data, frame) }
+	released := make(chan struct{})
+	h.Room.Multicast(ctx, domain.NewSharedPayload(frame, func([]byte) { close(released) }), h.Client(0).SessionID)
+
+	for _, c := range h.Clients()[1:] {
+		if _, err := c.ReadUntil(ctx, isFrame); err != nil {
+			t.Fatalf("member did not receive multicast: %v", err)
+		}
+	}
+	select {
+	case <-released:
+	case <-ctx.Done():
+		t.Fatal("shared payload was not released")
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if data, err := h.Client(0).ReadUntil(short, isFrame); err == nil {
+		t.Errorf("excluded client received multicast: %v", data)
+	}
+}
@@ -0,0 +1,102 @@
+package memtest
+
+import (
+	"context"
+	"sync"
+
+	"withered/server/domain"
+)
+
+// TrackingPubSub はPubSubをラップし、購読とpublishの回数を記録します。
+// 非同期に流れるメッセージがRoomに届いたことをテストから待つために使用します。
+type TrackingPubSub struct {
+	inner domain.PubSub
+
+	mu          sync.Mutex
+	subscribers map[domain.Topic]int
+	published   map[publishKey]int
+	changed     chan struct{}
+}
+
+type publishKey struct {
+	topic     domain.Topic
+	sessionID domain.SessionID
+}
+
+func NewTrackingPubSub(inner domain.PubSub) *TrackingPubSub {
+	return &TrackingPubSub{
+		inner:       inner,
+		subscribers: make(map[domain.Topic]int),
+		published:   make(map[publishKey]int),
+		changed:     make(chan struct{}),
+	}
+}
+
+func (p *TrackingPubSub) Subscribe(ctx context.Context, topic domain.Topic, opts domain.SubscribeOptions) *domain.Subscription {
+	sub := p.inner.Subscribe(ctx, topic, opts)
+	p.update(func() { p.subscribers[topic]++ })
+	go func() {
+		<-sub.Done()
+		p.update(func() { p.subscribers[topic]-- })
+	}()
+	return sub
+}
+
+func (p *TrackingPubSub) SubscribePattern(ctx context.Context, pattern domain.TopicPattern, opts domain.SubscribeOptions) *domain.Subscription {
+	return p.inner.SubscribePattern(ctx, pattern, opts)
+}
+
+func (p *TrackingPubSub) Publish(ctx context.Context, topic domain.Topic, msg domain.Message) {
+	p.inner.Publish(ctx, topic, msg)
+	p.update(func() { p.published[publishKey{topic, msg.SessionID}]++ })
+}
+
+// PubSubStats はラップしたPubSubの計測値を返します。計測値を提供しない場合はゼロ値を返します。
+func (p *TrackingPubSub) PubSubStats() domain.PubSubStats {
+	if reporter, ok := p.inner.(domain.PubSubStatsReporter); ok {
+		return reporter.PubSubStats()
+	}
+	return domain.PubSubStats{}
+}
+
+// PublishedBy はsessionIDを送信元としてtopicにpublishされた回数を返します。
+func (p *TrackingPubSub) PublishedBy(topic domain.Topic, sessionID domain.SessionID) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.published[publishKey{topic, sessionID}]
+}
+
+// WaitPublishedBy はsessionIDからtopicへのpublishがn回に達するまで待ちます。
+func (p *TrackingPubSub) WaitPublishedBy(ctx context.Context, topic domain.Topic, sessionID domain.SessionID, n int) error {
+	return p.wait(ctx, func() bool { return p.published[publishKey{topic, sessionID}] >= n })
+}
+
+// WaitSubscribed はtopicに購読者が現れるまで待ちます。
+func (p *TrackingPubSub) WaitSubscribed(ctx context.Context, topic domain.Topic) error {
+	return p.wait(ctx, func() bool { return p.subscribers[topic] > 0 })
+}
+
+func (p *TrackingPubSub) update(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *TrackingPubSub) wait(ctx context.Context, cond func() bool) error {
+	for {
+		p.mu.Lock()
+		ok := cond()
+		changed := p.changed
+		p.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
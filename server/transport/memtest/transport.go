@@ -0,0 +1,174 @@
+// Package memtest はテスト用のインメモリTransportと、
+// Room/PubSub/SessionEndpointを1プロセス内で組み立てるハーネスを提供します。
+package memtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"withered/server/domain"
+)
+
+// ErrClosed はClose済みのTransportを操作した場合に返されるエラーです。
+var ErrClosed = fmt.Errorf("memtest: %w", domain.ErrTransportClosed)
+
+// CloseError は相手側がCloseした場合にReadが返すエラーです。
+type CloseError struct {
+	Code   int32
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("memtest: closed by peer (code=%d, reason=%q)", e.Code, e.Reason)
+}
+
+// Options はPipeの設定です。
+type Options struct {
+	// Buffer は片方向あたりに保持できる未読フレーム数です。
+	// 0の場合は相手がReadするまでWriteがブロックします。
+	Buffer int
+}
+
+// pipe は片方向のフレームの流れです。
+type pipe struct {
+	ch chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  *CloseError
+}
+
+func newPipe(buffer int) *pipe {
+	return &pipe{
+		ch:     make(chan []byte, buffer),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *pipe) close(code int32, reason string) {
+	p.closeOnce.Do(func() {
+		p.closeErr = &CloseError{Code: code, Reason: reason}
+		close(p.closed)
+	})
+}
+
+// Transport はPipeで接続されたインメモリのdomain.Transport実装です。
+// どちらかがCloseすると、相手側は未読のフレームを読み切った後にCloseErrorを受け取ります。
+type Transport struct {
+	in  *pipe
+	out *pipe
+
+	mu       sync.Mutex
+	readErr  error
+	writeErr error
+	closed   bool
+}
+
+// Pipe は互いに接続されたTransportの組を作成します。
+func Pipe(opts Options) (*Transport, *Transport) {
+	ab := newPipe(opts.Buffer)
+	ba := newPipe(opts.Buffer)
+	return &Transport{in: ba, out: ab}, &Transport{in: ab, out: ba}
+}
+
+func (t *Transport) Read(ctx context.Context) ([]byte, error) {
+	if err := t.injected(&t.readErr); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-t.in.ch:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-t.in.ch:
+		return data, nil
+	case <-t.in.closed:
+		// 相手がCloseする前に書き込んだフレームは読み切る
+		select {
+		case data := <-t.in.ch:
+			return data, nil
+		default:
+		}
+		if t.isClosed() {
+			return nil, ErrClosed
+		}
+		return nil, t.in.closeErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Transport) Write(ctx context.Context, data []byte) error {
+	if err := t.injected(&t.writeErr); err != nil {
+		return err
+	}
+	select {
+	case <-t.out.closed:
+		return ErrClosed
+	default:
+	}
+	// 書き込み後に呼び出し側がバッファを再利用しても影響しないよう複製する
+	frame := make([]byte, len(data))
+	copy(frame, data)
+	select {
+	case t.out.ch <- frame:
+		return nil
+	case <-t.out.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close は両方向を閉じます。2回目以降の呼び出しはErrClosedを返します。
+func (t *Transport) Close(code int32, reason string) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrClosed
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	t.out.close(code, reason)
+	t.in.close(code, reason)
+	return nil
+}
+
+// CloseStatus はCloseで渡されたコードと理由を返します。まだ閉じられていない場合はfalseを返します。
+func (t *Transport) CloseStatus() (code int32, reason string, ok bool) {
+	select {
+	case <-t.out.closed:
+		return t.out.closeErr.Code, t.out.closeErr.Reason, true
+	default:
+		return 0, "", false
+	}
+}
+
+// FailReads は以降のReadがerrを返すようにします。nilを渡すと解除されます。
+func (t *Transport) FailReads(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readErr = err
+}
+
+// FailWrites は以降のWriteがerrを返すようにします。nilを渡すと解除されます。
+func (t *Transport) FailWrites(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeErr = err
+}
+
+func (t *Transport) injected(errp *error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *errp
+}
+
+func (t *Transport) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
@@ -0,0 +1,87 @@
+package memtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPipe_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	a, b := Pipe(Options{Buffer: 4})
+
+	buf := []byte{1, 2, 3}
+	if err := a.Write(ctx, buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf[0] = 9 // 書き込み後の変更は相手に影響しない
+
+	got, err := b.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if got[0] != 1 {
+		t.Errorf("Read = %v, want [1 2 3]", got)
+	}
+}
+
+func TestPipe_UnbufferedWriteBlocks(t *testing.T) {
+	a, _ := Pipe(Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := a.Write(ctx, []byte{1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Write error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPipe_CloseDrainsThenReportsPeerClose(t *testing.T) {
+	ctx := context.Background()
+	a, b := Pipe(Options{Buffer: 4})
+
+	a.Write(ctx, []byte{1})
+	if err := a.Close(4001, "slow consumer"); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := a.Close(1000, ""); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close error = %v, want %v", err, ErrClosed)
+	}
+
+	if _, err := b.Read(ctx); err != nil {
+		t.Fatalf("Read of buffered frame failed: %v", err)
+	}
+	var closeErr *CloseError
+	if _, err := b.Read(ctx); !errors.As(err, &closeErr) {
+		t.Fatalf("Read error = %v, want CloseError", err)
+	}
+	if closeErr.Code != 4001 || closeErr.Reason != "slow consumer" {
+		t.Errorf("CloseError = %+v, want code 4001", closeErr)
+	}
+	if err := b.Write(ctx, []byte{1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write after peer close error = %v, want %v", err, ErrClosed)
+	}
+	if code, _, ok := b.CloseStatus(); !ok || code != 4001 {
+		t.Errorf("CloseStatus = (%d, %v), want (4001, true)", code, ok)
+	}
+}
+
+func TestPipe_ErrorInjection(t *testing.T) {
+	ctx := context.Background()
+	a, b := Pipe(Options{Buffer: 4})
+	injected := errors.New("injected")
+
+	a.FailWrites(injected)
+	if err := a.Write(ctx, []byte{1}); !errors.Is(err, injected) {
+		t.Errorf("Write error = %v, want %v", err, injected)
+	}
+	a.FailWrites(nil)
+	if err := a.Write(ctx, []byte{1}); err != nil {
+		t.Errorf("Write after clear failed: %v", err)
+	}
+
+	b.FailReads(injected)
+	if _, err := b.Read(ctx); !errors.Is(err, injected) {
+		t.Errorf("Read error = %v, want %v", err, injected)
+	}
+}
@@ -0,0 +1,183 @@
+package multiplex
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+
+	"withered/server/domain"
+)
+
+// Client は多重化接続のクライアント側です。負荷試験やボットが1接続で多数のセッションを扱うために使用します。
+type Client struct {
+	physical domain.Transport
+	w        *writer
+
+	mu       sync.Mutex
+	sessions map[[16]byte]*ClientSession
+	err      error
+	done     chan struct{}
+}
+
+// NewClient は物理Transportの受信を開始し、Clientを返します。
+func NewClient(physical domain.Transport) *Client {
+	c := &Client{
+		physical: physical,
+		w:        newWriter(physical),
+		sessions: make(map[[16]byte]*ClientSession),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Open は新しい論理セッションを開きます。
+// サーバー側のセッションは最初のフレームで作られるため、Pingを送って開始します。
+// サーバーからのAssignは、返されたClientSessionのReadで受け取ります。
+func (c *Client) Open(ctx context.Context) (*ClientSession, error) {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	id := domain.SessionIDFromBytes(b)
+	s := &ClientSession{
+		ID:     id,
+		c:      c,
+		key:    b,
+		inbox:  make(chan []byte, 256),
+		closed: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.sessions[b] = s
+	c.mu.Unlock()
+
+	if err := c.w.write(ctx, domain.EncodeControlMessage(id, domain.ControlSubTypePing, nil)); err != nil {
+		c.remove(s)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close は物理Transportを閉じます。
+func (c *Client) Close() error {
+	return c.physical.Close(domain.CloseCodeNormal, "")
+}
+
+// Done は物理Transportの受信が終了すると閉じられるチャネルを返します。
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	for {
+		data, err := c.physical.Read(context.Background())
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			sessions := c.sessions
+			c.sessions = make(map[[16]byte]*ClientSession)
+			c.mu.Unlock()
+			for _, s := range sessions {
+				s.shutdown(err)
+			}
+			return
+		}
+		id, kind, body, err := decodeEnvelope(data)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		s := c.sessions[id]
+		c.mu.Unlock()
+		if s == nil {
+			continue
+		}
+		switch kind {
+		case kindFrame:
+			select {
+			case s.inbox <- body:
+			case <-s.closed:
+			}
+		case kindClose:
+			c.remove(s)
+			s.shutdown(decodeCloseBody(body))
+		}
+	}
+}
+
+func (c *Client) remove(s *ClientSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions[s.key] == s {
+		delete(c.sessions, s.key)
+	}
+}
+
+// ClientSession は多重化接続上の1つの論理セッションです。domain.Transportとして使用できます。
+// サーバーに閉じられた場合、未読のフレームを読み切った後にReadは*CloseErrorを返します。
+type ClientSession struct {
+	ID domain.SessionID
+
+	c     *Client
+	key   [16]byte
+	inbox chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+func (s *ClientSession) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-s.inbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-s.inbox:
+		return data, nil
+	case <-s.closed:
+		select {
+		case data := <-s.inbox:
+			return data, nil
+		default:
+		}
+		return nil, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *ClientSession) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-s.closed:
+		return ErrTransportClosed
+	default:
+	}
+	return s.c.w.write(ctx, data)
+}
+
+// Close はサーバーに論理セッションの終了を通知します。codeとreasonはサーバーに送られません。
+func (s *ClientSession) Close(code int32, reason string) error {
+	if !s.shutdown(ErrTransportClosed) {
+		return ErrTransportClosed
+	}
+	s.c.remove(s)
+	ctx, cancel := context.WithTimeout(context.Background(), closeWriteTimeout)
+	defer cancel()
+	return s.c.w.write(ctx, EncodeCloseFrame(s.ID))
+}
+
+func (s *ClientSession) shutdown(err error) bool {
+	first := false
+	s.closeOnce.Do(func() {
+		first = true
+		s.err = err
+		close(s.closed)
+	})
+	return first
+}
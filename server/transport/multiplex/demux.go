@@ -0,0 +1,288 @@
+package multiplex
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// closeWriteTimeout は論理セッションのクローズを通知する書き込みの待ち時間の上限です。
+const closeWriteTimeout = time.Second
+
+// closeCodeTooManySessions は論理セッション数が上限に達した場合のクローズコードです。
+const closeCodeTooManySessions int32 = 1013 // Try Again Later
+
+// maxRejectedSessions はクローズを通知済みの拒否したセッションIDを覚えておく数です。
+const maxRejectedSessions = 1024
+
+// OpenFunc は新しい論理セッションごとに呼ばれ、セッションが終了するまでブロックします。
+type OpenFunc func(ctx context.Context, transport domain.Transport, sessionID domain.SessionID)
+
+// Options はDemuxの設定です。
+type Options struct {
+	// InboxSize は論理セッションごとに保持できる未読の上りフレーム数です。
+	// 1つのセッションの遅れが他のセッションを止めないよう、超えたフレームは破棄します。
+	InboxSize int
+	// MaxSessions は1接続あたりの論理セッション数の上限です。0の場合は無制限です。
+	MaxSessions int
+}
+
+// DefaultOptions はデフォルトのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{
+		InboxSize:   256,
+		MaxSessions: 10000,
+	}
+}
+
+// Demux は物理Transportで届くフレームを論理セッションごとのTransportに振り分けます。
+type Demux struct {
+	physical domain.Transport
+	opts     Options
+	open     OpenFunc
+	w        *writer
+
+	mu       sync.Mutex
+	sessions map[[16]byte]*sessionTransport
+	// rejected は上限のため拒否したセッションID。同じIDのフレームが続いてもクローズの通知は一度だけにする
+	rejected rejectedSet
+	wg       sync.WaitGroup
+}
+
+func NewDemux(physical domain.Transport, opts Options, open OpenFunc) *Demux {
+	if opts.InboxSize < 1 {
+		opts.InboxSize = DefaultOptions().InboxSize
+	}
+	return &Demux{
+		physical: physical,
+		opts:     opts,
+		open:     open,
+		w:        newWriter(physical),
+		sessions: make(map[[16]byte]*sessionTransport),
+		rejected: newRejectedSet(maxRejectedSessions),
+	}
+}
+
+// Run は物理Transportから読み込んだフレームを振り分けます。
+// 物理Transportの読み込みが失敗すると全ての論理セッションを終了させ、それらが終わるのを待ってから返ります。
+func (d *Demux) Run(ctx context.Context) error {
+	err := d.readLoop(ctx)
+
+	d.mu.Lock()
+	sessions := make([]*sessionTransport, 0, len(d.sessions))
+	for _, s := range d.sessions {
+		sessions = append(sessions, s)
+	}
+	d.mu.Unlock()
+	for _, s := range sessions {
+		s.peerClose()
+	}
+	d.wg.Wait()
+	return err
+}
+
+// Len は稼働中の論理セッション数を返します。
+func (d *Demux) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sessions)
+}
+
+func (d *Demux) readLoop(ctx context.Context) error {
+	for {
+		frame, err := d.physical.Read(ctx)
+		if err != nil {
+			return err
+		}
+		id, ok := sessionKey(frame)
+		if !ok {
+			slog.WarnContext(ctx, "multiplex: frame too short", "len", len(frame))
+			continue
+		}
+
+		d.mu.Lock()
+		s, exists := d.sessions[id]
+		reject := false
+		if !exists && len(frame) > domain.HeaderSize {
+			s, reject = d.openLocked(ctx, id)
+		}
+		d.mu.Unlock()
+		if reject {
+			// 拒否したIDごとに一度だけ、読み込みと同じgoroutineで通知する
+			_ = d.sendClose(id, closeCodeTooManySessions, "too many sessions")
+		}
+		if s == nil {
+			continue
+		}
+
+		if len(frame) == domain.HeaderSize {
+			s.peerClose()
+			continue
+		}
+		s.deliver(ctx, frame)
+	}
+}
+
+// openLocked は論理セッションを作成してOpenFuncを起動します。d.muを保持して呼び出す必要があります。
+// 論理セッション数が上限に達している場合はnilを返し、そのIDを初めて拒否した場合はrejectにtrueを返します。
+func (d *Demux) openLocked(ctx context.Context, id [16]byte) (s *sessionTransport, reject bool) {
+	if d.opts.MaxSessions > 0 && len(d.sessions) >= d.opts.MaxSessions {
+		if !d.rejected.add(id) {
+			return nil, false
+		}
+		slog.WarnContext(ctx, "multiplex: too many sessions", "max", d.opts.MaxSessions)
+		return nil, true
+	}
+	d.rejected.remove(id)
+	s = &sessionTransport{
+		id:     id,
+		d:      d,
+		inbox:  make(chan []byte, d.opts.InboxSize),
+		closed: make(chan struct{}),
+	}
+	d.sessions[id] = s
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.open(ctx, s, domain.SessionIDFromBytes(id))
+		// OpenFuncがCloseせずに戻った場合もクローズを通知する
+		s.Close(domain.CloseCodeNormal, "")
+	}()
+	return s, false
+}
+
+func (d *Demux) remove(s *sessionTransport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sessions[s.id] == s {
+		delete(d.sessions, s.id)
+	}
+}
+
+// rejectedSet は容量固定のセッションIDの集合です。満杯の場合は古いものから忘れます。
+type rejectedSet struct {
+	ids   map[[16]byte]int // IDとorderでの位置
+	order [][16]byte       // 追加順のリングバッファ
+	next  int
+}
+
+func newRejectedSet(capacity int) rejectedSet {
+	return rejectedSet{
+		ids:   make(map[[16]byte]int, capacity),
+		order: make([][16]byte, 0, capacity),
+	}
+}
+
+// add はidを追加します。既に含まれていた場合はfalseを返します。
+func (r *rejectedSet) add(id [16]byte) bool {
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	i := len(r.order)
+	if i < cap(r.order) {
+		r.order = append(r.order, id)
+	} else {
+		i = r.next
+		// removeの後に追加し直したIDは別の位置にあるため残す
+		if old := r.order[i]; r.ids[old] == i {
+			delete(r.ids, old)
+		}
+		r.order[i] = id
+		r.next = (r.next + 1) % len(r.order)
+	}
+	r.ids[id] = i
+	return true
+}
+
+// remove はidを取り除きます。
+func (r *rejectedSet) remove(id [16]byte) {
+	delete(r.ids, id)
+}
+
+func (d *Demux) sendClose(id [16]byte, code int32, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeWriteTimeout)
+	defer cancel()
+	return d.w.write(ctx, encodeEnvelope(id, kindClose, encodeCloseBody(code, reason)))
+}
+
+// sessionTransport は1つの論理セッションを表すdomain.Transport実装です。
+type sessionTransport struct {
+	id    [16]byte
+	d     *Demux
+	inbox chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	mu        sync.Mutex
+	readErr   error
+	dropped   uint64
+}
+
+func (s *sessionTransport) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-s.inbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-s.inbox:
+		return data, nil
+	case <-s.closed:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.readErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *sessionTransport) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-s.closed:
+		return ErrTransportClosed
+	default:
+	}
+	return s.d.w.write(ctx, encodeEnvelope(s.id, kindFrame, data))
+}
+
+// Close は論理セッションを閉じ、クライアントにクローズを通知します。物理Transportは閉じません。
+func (s *sessionTransport) Close(code int32, reason string) error {
+	if !s.shutdown(ErrTransportClosed) {
+		return ErrTransportClosed
+	}
+	return s.d.sendClose(s.id, code, reason)
+}
+
+func (s *sessionTransport) deliver(ctx context.Context, frame []byte) {
+	select {
+	case s.inbox <- frame:
+	case <-s.closed:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		slog.WarnContext(ctx, "multiplex: session inbox full, dropping frame", "sessionID", domain.SessionIDFromBytes(s.id))
+	}
+}
+
+// peerClose はクライアントが論理セッションを終了したことを通知します。以降のReadはio.EOFを返します。
+func (s *sessionTransport) peerClose() {
+	s.shutdown(io.EOF)
+}
+
+func (s *sessionTransport) shutdown(readErr error) bool {
+	first := false
+	s.closeOnce.Do(func() {
+		first = true
+		s.mu.Lock()
+		s.readErr = readErr
+		s.mu.Unlock()
+		close(s.closed)
+		s.d.remove(s)
+	})
+	return first
+}
@@ -0,0 +1,112 @@
+// Package multiplex は1つのTransportで複数の論理セッションを運ぶ多重化接続を提供します。
+//
+// 上り（クライアント→サーバー）は通常のフレームをそのまま送り、Header.SessionIDで論理セッションを区別します。
+// 未知のSessionIDのフレームが届くと、そのSessionIDで新しい論理セッションを開きます。
+// ヘッダーのみ（HeaderSizeちょうど）のフレームは、その論理セッションの終了を表します。
+//
+// 下り（サーバー→クライアント）のフレームはヘッダーに送信元のSessionIDを含むため、
+// 宛先の論理セッションを示すエンベロープで包みます。
+//
+//	sessionID [16]byte 宛先の論理セッション
+//	kind      u8       1: フレーム, 2: クローズ
+//	body      ...      フレーム、またはクローズコード（i32）と理由
+package multiplex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"withered/server/domain"
+)
+
+// EnvelopeHeaderSize は下りエンベロープのヘッダーサイズです。
+const EnvelopeHeaderSize = 17
+
+const (
+	kindFrame uint8 = 1
+	kindClose uint8 = 2
+)
+
+var (
+	// ErrTransportClosed はClose済みの論理セッションを操作した場合に返されるエラーです。
+	ErrTransportClosed = fmt.Errorf("multiplex: %w", domain.ErrTransportClosed)
+	// ErrInvalidEnvelope はエンベロープの形式が不正な場合に返されるエラーです。
+	ErrInvalidEnvelope = errors.New("multiplex: invalid envelope")
+)
+
+// CloseError は論理セッションがサーバーによって閉じられた場合にクライアント側のReadが返すエラーです。
+type CloseError struct {
+	Code   int32
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("multiplex: session closed (code=%d, reason=%q)", e.Code, e.Reason)
+}
+
+func encodeEnvelope(id [16]byte, kind uint8, body []byte) []byte {
+	b := make([]byte, 0, EnvelopeHeaderSize+len(body))
+	b = append(b, id[:]...)
+	b = append(b, kind)
+	return append(b, body...)
+}
+
+func encodeCloseBody(code int32, reason string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(code))
+	return append(b, reason...)
+}
+
+func decodeEnvelope(b []byte) (id [16]byte, kind uint8, body []byte, err error) {
+	if len(b) < EnvelopeHeaderSize {
+		return id, 0, nil, ErrInvalidEnvelope
+	}
+	copy(id[:], b[:16])
+	kind = b[16]
+	body = b[EnvelopeHeaderSize:]
+	if kind == kindClose && len(body) < 4 {
+		return id, 0, nil, ErrInvalidEnvelope
+	}
+	return id, kind, body, nil
+}
+
+func decodeCloseBody(body []byte) *CloseError {
+	return &CloseError{Code: int32(binary.LittleEndian.Uint32(body)), Reason: string(body[4:])}
+}
+
+// EncodeCloseFrame は論理セッションの終了を表すヘッダーのみのフレームを返します。
+func EncodeCloseFrame(id domain.SessionID) []byte {
+	header := domain.Header{Version: 1, SessionID: id.Bytes()}
+	return header.Encode()
+}
+
+// sessionKey はフレームのヘッダーからSessionIDを取り出します。
+func sessionKey(frame []byte) ([16]byte, bool) {
+	var id [16]byte
+	if len(frame) < domain.HeaderSize {
+		return id, false
+	}
+	copy(id[:], frame[domain.HeaderSessionIDStart:domain.HeaderSessionIDEnd])
+	return id, true
+}
+
+// writer は物理Transportへの書き込みを直列化します。
+type writer struct {
+	physical domain.Transport
+	mu       chan struct{}
+}
+
+func newWriter(physical domain.Transport) *writer {
+	return &writer{physical: physical, mu: make(chan struct{}, 1)}
+}
+
+func (w *writer) write(ctx context.Context, data []byte) error {
+	select {
+	case w.mu <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-w.mu }()
+	return w.physical.Write(ctx, data)
+}
@@ -0,0 +1,200 @@
+package multiplex
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+	"withered/server/transport/memtest"
+)
+
+// startEcho は各論理セッションで受信したフレームをそのまま返すDemuxを起動します。
+func startEcho(t *testing.T, opts Options) (*Demux, *Client, <-chan domain.Transport) {
+	t.Helper()
+	clientSide, serverSide := memtest.Pipe(memtest.Options{Buffer: 64})
+	opened := make(chan domain.Transport, 16)
+	demux := NewDemux(serverSide, opts, func(ctx context.Context, transport domain.Transport, sessionID domain.SessionID) {
+		opened <- transport
+		for {
+			data, err := transport.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := transport.Write(ctx, data); err != nil {
+				return
+			}
+		}
+	})
+	done := make(chan error, 1)
+	go func() { done <- demux.Run(context.Background()) }()
+	client := NewClient(clientSide)
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return demux, client, opened
+}
+
+func readWithin(t *testing.T, s *ClientSession) ([]byte, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return s.Read(ctx)
+}
+
+func TestDemux_RoutesBySessionID(t *testing.T) {
+	ctx := context.Background()
+	demux, client, _ := startEcho(t, DefaultOptions())
+
+	sessions := make([]*ClientSession, 3)
+	for i := range sessions {
+		s, err := client.Open(ctx)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		sessions[i] = s
+		// Openで送ったPingがエコーされる
+		if data, err := readWithin(t, s); err != nil || !domain.IsControlMessage(data, domain.ControlSubTypePing) {
+			t.Fatalf("session %d: Read = (%v, %v), want ping", i, data, err)
+		}
+	}
+	if demux.Len() != 3 {
+		t.Errorf("Len = %d, want 3", demux.Len())
+	}
+
+	for i, s := range sessions {
+		input := domain.InputPayload{KeyMask: uint32(i)}
+		s.Write(ctx, domain.EncodeControlMessage(s.ID, domain.ControlSubTypeJoin, input.Encode()))
+	}
+	for i, s := range sessions {
+		data, err := readWithin(t, s)
+		if err != nil {
+			t.Fatalf("session %d: Read failed: %v", i, err)
+		}
+		header, _ := domain.ParseHeader(data)
+		if domain.SessionIDFromBytes(header.SessionID) != s.ID {
+			t.Errorf("session %d received a frame for %v", i, domain.SessionIDFromBytes(header.SessionID))
+		}
+	}
+}
+
+func TestDemux_Close(t *testing.T) {
+	ctx := context.Background()
+	demux, client, opened := startEcho(t, DefaultOptions())
+
+	// クライアントが閉じると、サーバー側のReadはio.EOFを返す
+	s, _ := client.Open(ctx)
+	server := <-opened
+	readWithin(t, s)
+	s.Close(domain.CloseCodeNormal, "")
+	deadline := time.Now().Add(time.Second)
+	for demux.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session not removed after client close")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := server.Read(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("server Read error = %v, want %v", err, io.EOF)
+	}
+
+	// サーバーが閉じると、クライアント側のReadはクローズコード付きで終わる
+	s, _ = client.Open(ctx)
+	server = <-opened
+	readWithin(t, s)
+	server.Close(domain.CloseCodeKicked, "kicked")
+	var closeErr *CloseError
+	if _, err := readWithin(t, s); !errors.As(err, &closeErr) || closeErr.Code != domain.CloseCodeKicked {
+		t.Errorf("client Read error = %v, want close code %d", err, domain.CloseCodeKicked)
+	}
+}
+
+func TestDemux_MaxSessions(t *testing.T) {
+	ctx := context.Background()
+	_, client, _ := startEcho(t, Options{MaxSessions: 1})
+
+	first, _ := client.Open(ctx)
+	if _, err := readWithin(t, first); err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	second, _ := client.Open(ctx)
+	var closeErr *CloseError
+	if _, err := readWithin(t, second); !errors.As(err, &closeErr) || closeErr.Code != closeCodeTooManySessions {
+		t.Errorf("second session Read error = %v, want close code %d", err, closeCodeTooManySessions)
+	}
+}
+
+// TestDemux_RejectedSessionClosedOnce は上限で拒否したIDにフレームが届き続けても、
+// クローズの通知が一度だけであることを確認します。
+func TestDemux_RejectedSessionClosedOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientSide, serverSide := memtest.Pipe(memtest.Options{Buffer: 64})
+	demux := NewDemux(serverSide, Options{MaxSessions: 1}, func(ctx context.Context, transport domain.Transport, _ domain.SessionID) {
+		for {
+			data, err := transport.Read(ctx)
+			if err != nil {
+				return
+			}
+			transport.Write(ctx, data)
+		}
+	})
+	go demux.Run(ctx)
+	defer clientSide.Close(domain.CloseCodeNormal, "")
+
+	accepted, rejected := domain.NewSessionID(), domain.NewSessionID()
+	clientSide.Write(ctx, domain.EncodeControlMessage(accepted, domain.ControlSubTypePing, nil))
+	for range 20 {
+		clientSide.Write(ctx, domain.EncodeControlMessage(rejected, domain.ControlSubTypePing, nil))
+	}
+	// 拒否したフレームの処理が終わったことを、受理済みのセッションのエコーで確かめる
+	clientSide.Write(ctx, domain.EncodeControlMessage(accepted, domain.ControlSubTypePong, nil))
+
+	closes := 0
+	for {
+		data, err := clientSide.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v (closes = %d)", err, closes)
+		}
+		id, kind, body, err := decodeEnvelope(data)
+		if err != nil {
+			continue
+		}
+		if kind == kindClose {
+			closes++
+			continue
+		}
+		if domain.SessionIDFromBytes(id) == accepted && domain.IsControlMessage(body, domain.ControlSubTypePong) {
+			break
+		}
+	}
+	if closes != 1 {
+		t.Errorf("close envelopes for rejected session = %d, want 1", closes)
+	}
+}
+
+func TestDemux_PhysicalCloseEndsAllSessions(t *testing.T) {
+	ctx := context.Background()
+	_, client, opened := startEcho(t, DefaultOptions())
+
+	var servers []domain.Transport
+	for i := 0; i < 2; i++ {
+		s, _ := client.Open(ctx)
+		readWithin(t, s)
+		servers = append(servers, <-opened)
+	}
+	client.Close()
+	for i, server := range servers {
+		if _, err := server.Read(ctx); !errors.Is(err, io.EOF) {
+			t.Errorf("server %d Read error = %v, want %v", i, err, io.EOF)
+		}
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Error("client did not stop after physical close")
+	}
+}
@@ -0,0 +1,138 @@
+// Package netsim は遅延・ジッタ・パケットロス等の回線状態を再現するTransportミドルウェアを提供します。
+// 外部ツールを使わずに、クライアントの予測・補間を現実的なネットワーク条件で検証するために使用します。
+package netsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidProfile はプロファイル文字列を解釈できない場合に返されるエラーです。
+var ErrInvalidProfile = errors.New("netsim: invalid profile")
+
+// Conditions は片方向の回線状態です。ゼロ値は何もしない（素通し）ことを表します。
+type Conditions struct {
+	// Latency はフレームが届くまでの基本の遅延です。
+	Latency time.Duration
+	// Jitter は遅延の揺らぎです。遅延は Latency±Jitter の一様分布になります。
+	// 揺らぎによってフレームの順序は入れ替わりません（入れ替えはReorderで指定します）。
+	Jitter time.Duration
+	// Loss はフレームを破棄する確率（0〜1）です。
+	Loss float64
+	// Duplicate はフレームを重複して届ける確率（0〜1）です。
+	Duplicate float64
+	// Reorder はフレームを遅延なしで送り、先行するフレームを追い越させる確率（0〜1）です。
+	// Latencyが0の場合は効果がありません。
+	Reorder float64
+	// Bandwidth は帯域の上限（バイト/秒）です。0の場合は無制限です。
+	Bandwidth int
+}
+
+// Profile は双方向の回線状態です。向きはサーバーから見たものです。
+type Profile struct {
+	// Upstream はクライアントからサーバーへの向き（Read）の回線状態です。
+	Upstream Conditions
+	// Downstream はサーバーからクライアントへの向き（Write）の回線状態です。
+	Downstream Conditions
+}
+
+// Symmetric は両方向に同じ回線状態を持つProfileを返します。
+func Symmetric(c Conditions) Profile {
+	return Profile{Upstream: c, Downstream: c}
+}
+
+// Step はScriptの1区間です。接続からAtだけ経過した時点でProfileに切り替わります。
+type Step struct {
+	At      time.Duration
+	Profile Profile
+}
+
+// Script は接続からの経過時間に応じて回線状態を切り替える台本です。
+// 例えば「10秒後に遅延が増える」といった変化を再現できます。
+type Script []Step
+
+// Static は常に同じ回線状態を返すScriptを返します。
+func Static(p Profile) Script {
+	return Script{{At: 0, Profile: p}}
+}
+
+// At は経過時間elapsedにおける回線状態を返します。
+// Atがelapsed以下のStepのうち最後のものが有効になり、該当するStepがない場合はゼロ値を返します。
+// Stepは時刻順に並んでいる必要があります。
+func (s Script) At(elapsed time.Duration) Profile {
+	var p Profile
+	for _, step := range s {
+		if step.At > elapsed {
+			break
+		}
+		p = step.Profile
+	}
+	return p
+}
+
+// ParseProfile は "latency=80ms,jitter=10ms,loss=0.02" 形式の文字列をProfileに変換します。
+// キーに "up." または "down." を付けると片方向のみに適用されます（例: "down.bandwidth=64000"）。
+// 使用できるキーは latency, jitter, loss, dup, reorder, bandwidth です。
+func ParseProfile(s string) (Profile, error) {
+	var p Profile
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Profile{}, fmt.Errorf("%w: %q", ErrInvalidProfile, field)
+		}
+		targets := []*Conditions{&p.Upstream, &p.Downstream}
+		if k, found := strings.CutPrefix(key, "up."); found {
+			key, targets = k, targets[:1]
+		} else if k, found := strings.CutPrefix(key, "down."); found {
+			key, targets = k, targets[1:]
+		}
+		for _, c := range targets {
+			if err := c.set(key, value); err != nil {
+				return Profile{}, err
+			}
+		}
+	}
+	return p, nil
+}
+
+func (c *Conditions) set(key, value string) error {
+	var err error
+	switch key {
+	case "latency":
+		c.Latency, err = time.ParseDuration(value)
+	case "jitter":
+		c.Jitter, err = time.ParseDuration(value)
+	case "loss":
+		c.Loss, err = parseProbability(value)
+	case "dup":
+		c.Duplicate, err = parseProbability(value)
+	case "reorder":
+		c.Reorder, err = parseProbability(value)
+	case "bandwidth":
+		c.Bandwidth, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidProfile, key)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s=%s: %v", ErrInvalidProfile, key, value, err)
+	}
+	return nil
+}
+
+func parseProbability(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1 {
+		return 0, errors.New("out of range [0, 1]")
+	}
+	return v, nil
+}
@@ -0,0 +1,204 @@
+package netsim
+
+import (
+	"container/heap"
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// LinkStats は片方向の回線で発生した事象の累計です。
+type LinkStats struct {
+	Frames     uint64 `json:"frames"`
+	Dropped    uint64 `json:"dropped"`
+	Duplicated uint64 `json:"duplicated"`
+	Reordered  uint64 `json:"reordered"`
+}
+
+// frame は配送待ちのフレームです。errが設定されている場合は回線の終端を表します。
+type frame struct {
+	at   time.Time
+	seq  uint64
+	data []byte
+	err  error
+}
+
+type frameHeap []*frame
+
+func (h frameHeap) Len() int { return len(h) }
+func (h frameHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h frameHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *frameHeap) Push(x interface{}) { *h = append(*h, x.(*frame)) }
+func (h *frameHeap) Pop() interface{} {
+	old := *h
+	f := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return f
+}
+
+// link は片方向の回線です。sendされたフレームを回線状態に従って遅延・破棄・複製し、
+// 配送時刻になったものからrunに渡したdeliverで届けます。
+type link struct {
+	limit int
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	queue    frameHeap
+	seq      uint64
+	nextFree time.Time // 帯域制限下で次のフレームを送り出せる時刻
+	lastAt   time.Time // 順序を保つための直前のフレームの配送時刻
+	closed   bool
+	stats    LinkStats
+
+	wake chan struct{}
+}
+
+func newLink(rng *rand.Rand, limit int) *link {
+	return &link{
+		limit: limit,
+		rng:   rng,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// send はフレームを回線に流します。キューが満杯の場合は破棄します（テールドロップ）。
+func (l *link) send(now time.Time, c Conditions, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.Frames++
+	if l.closed || len(l.queue) >= l.limit || l.chance(c.Loss) {
+		l.stats.Dropped++
+		return
+	}
+
+	// 帯域制限: 直前のフレームを送り終えるまで送り出せない
+	departAt := now
+	if c.Bandwidth > 0 {
+		if l.nextFree.After(departAt) {
+			departAt = l.nextFree
+		}
+		departAt = departAt.Add(time.Duration(len(data)) * time.Second / time.Duration(c.Bandwidth))
+		l.nextFree = departAt
+	}
+
+	var at time.Time
+	if c.Latency > 0 && l.chance(c.Reorder) {
+		// 遅延なしで送り、配送待ちのフレームを追い越させる
+		at = departAt
+		l.stats.Reordered++
+	} else {
+		at = departAt.Add(l.delay(c))
+		if at.Before(l.lastAt) {
+			at = l.lastAt
+		}
+		l.lastAt = at
+	}
+	l.push(&frame{at: at, data: data})
+
+	if l.chance(c.Duplicate) {
+		l.push(&frame{at: at, data: data})
+		l.stats.Duplicated++
+	}
+}
+
+// fail は回線の終端（相手側のエラー）を、送信済みのフレームの後に届けます。
+func (l *link) fail(now time.Time, c Conditions, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := now.Add(c.Latency)
+	if at.Before(l.lastAt) {
+		at = l.lastAt
+	}
+	l.push(&frame{at: at, err: err})
+}
+
+// close は新たなフレームの受け付けを止めます。配送待ちのフレームを届け終えるとrunが終了します。
+func (l *link) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.notify()
+}
+
+func (l *link) Stats() LinkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// run は配送時刻になったフレームを順にdeliverに渡します。
+// deliverがfalseを返すか、closeされて配送待ちがなくなるか、ctxがキャンセルされると終了します。
+func (l *link) run(ctx context.Context, deliver func(*frame) bool) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 && l.closed {
+			l.mu.Unlock()
+			return
+		}
+		var next *frame
+		wait := time.Hour
+		if len(l.queue) > 0 {
+			if d := time.Until(l.queue[0].at); d > 0 {
+				wait = d
+			} else {
+				next = heap.Pop(&l.queue).(*frame)
+			}
+		}
+		l.mu.Unlock()
+
+		if next != nil {
+			if !deliver(next) {
+				return
+			}
+			continue
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-l.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *link) push(f *frame) {
+	l.seq++
+	f.seq = l.seq
+	heap.Push(&l.queue, f)
+	l.notify()
+}
+
+func (l *link) notify() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// delay は Latency±Jitter の遅延を返します。
+func (l *link) delay(c Conditions) time.Duration {
+	d := c.Latency
+	if c.Jitter > 0 {
+		d += time.Duration(l.rng.Int64N(int64(2*c.Jitter)+1)) - c.Jitter
+	}
+	return max(d, 0)
+}
+
+func (l *link) chance(p float64) bool {
+	return p > 0 && l.rng.Float64() < p
+}
@@ -0,0 +1,257 @@
+package netsim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"withered/server/domain"
+	"withered/server/transport/memtest"
+)
+
+// newPair はサーバー側をWrapしたTransportと、クライアント側のmemtest.Transportを返します。
+func newPair(t *testing.T, p Profile) (*Transport, *memtest.Transport) {
+	t.Helper()
+	client, server := memtest.Pipe(memtest.Options{Buffer: 64})
+	opts := DefaultOptions()
+	opts.Script = Static(p)
+	opts.Seed = 1
+	sim := Wrap(server, opts)
+	t.Cleanup(func() { sim.Close(1000, "") })
+	return sim, client
+}
+
+func readWithin(t *testing.T, read func(context.Context) ([]byte, error), d time.Duration) ([]byte, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return read(ctx)
+}
+
+func TestTransport_Passthrough(t *testing.T) {
+	ctx := context.Background()
+	sim, client := newPair(t, Profile{})
+
+	sim.Write(ctx, []byte{1})
+	if got, err := readWithin(t, client.Read, time.Second); err != nil || got[0] != 1 {
+		t.Fatalf("downstream Read = (%v, %v), want [1]", got, err)
+	}
+	client.Write(ctx, []byte{2})
+	if got, err := readWithin(t, sim.Read, time.Second); err != nil || got[0] != 2 {
+		t.Fatalf("upstream Read = (%v, %v), want [2]", got, err)
+	}
+}
+
+func TestTransport_Latency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	sim, client := newPair(t, Profile{Downstream: Conditions{Latency: latency}})
+
+	start := time.Now()
+	sim.Write(context.Background(), []byte{1})
+	if _, err := readWithin(t, client.Read, time.Second); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("frame arrived after %v, want >= %v", elapsed, latency)
+	}
+}
+
+func TestTransport_JitterKeepsOrder(t *testing.T) {
+	ctx := context.Background()
+	sim, client := newPair(t, Profile{Downstream: Conditions{Latency: 10 * time.Millisecond, Jitter: 10 * time.Millisecond}})
+
+	for i := 0; i < 20; i++ {
+		sim.Write(ctx, []byte{byte(i)})
+	}
+	for i := 0; i < 20; i++ {
+		got, err := readWithin(t, client.Read, time.Second)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if got[0] != byte(i) {
+			t.Fatalf("frame %d = %d, want in order", i, got[0])
+		}
+	}
+}
+
+func TestTransport_LossAndDuplicate(t *testing.T) {
+	ctx := context.Background()
+	sim, client := newPair(t, Profile{
+		Upstream:   Conditions{Loss: 1},
+		Downstream: Conditions{Duplicate: 1},
+	})
+
+	client.Write(ctx, []byte{1})
+	if _, err := readWithin(t, sim.Read, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("upstream Read error = %v, want frame to be dropped", err)
+	}
+
+	sim.Write(ctx, []byte{2})
+	for i := 0; i < 2; i++ {
+		if got, err := readWithin(t, client.Read, time.Second); err != nil || got[0] != 2 {
+			t.Fatalf("copy %d: Read = (%v, %v), want [2]", i, got, err)
+		}
+	}
+
+	up, down := sim.Stats()
+	if up.Frames != 1 || up.Dropped != 1 {
+		t.Errorf("upstream stats = %+v, want 1 frame dropped", up)
+	}
+	if down.Frames != 1 || down.Duplicated != 1 {
+		t.Errorf("downstream stats = %+v, want 1 frame duplicated", down)
+	}
+}
+
+func TestTransport_Reorder(t *testing.T) {
+	ctx := context.Background()
+	sim, client := newPair(t, Profile{Downstream: Conditions{Latency: 50 * time.Millisecond}})
+
+	sim.Write(ctx, []byte{1})
+	sim.SetScript(Static(Profile{Downstream: Conditions{Latency: 50 * time.Millisecond, Reorder: 1}}))
+	sim.Write(ctx, []byte{2})
+
+	for _, want := range []byte{2, 1} {
+		got, err := readWithin(t, client.Read, time.Second)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if got[0] != want {
+			t.Errorf("Read = %d, want %d", got[0], want)
+		}
+	}
+}
+
+func TestTransport_Bandwidth(t *testing.T) {
+	ctx := context.Background()
+	// 10000バイト/秒で500バイトのフレーム2つは、2つ目が届くまでに100ms以上かかる
+	sim, client := newPair(t, Profile{Downstream: Conditions{Bandwidth: 10000}})
+
+	start := time.Now()
+	sim.Write(ctx, make([]byte, 500))
+	sim.Write(ctx, make([]byte, 500))
+	for i := 0; i < 2; i++ {
+		if _, err := readWithin(t, client.Read, time.Second); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("frames arrived after %v, want >= 100ms", elapsed)
+	}
+}
+
+func TestTransport_CloseDeliversPendingFrames(t *testing.T) {
+	client, server := memtest.Pipe(memtest.Options{Buffer: 64})
+	opts := DefaultOptions()
+	opts.Script = Static(Profile{Downstream: Conditions{Latency: 30 * time.Millisecond}})
+	sim := Wrap(server, opts)
+
+	sim.Write(context.Background(), []byte{1})
+	if err := sim.Close(4002, "kicked"); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := sim.Write(context.Background(), []byte{2}); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("Write after Close error = %v, want %v", err, ErrTransportClosed)
+	}
+
+	if got, err := readWithin(t, client.Read, time.Second); err != nil || got[0] != 1 {
+		t.Fatalf("Read = (%v, %v), want pending frame", got, err)
+	}
+	var closeErr *memtest.CloseError
+	if _, err := readWithin(t, client.Read, time.Second); !errors.As(err, &closeErr) || closeErr.Code != 4002 {
+		t.Errorf("Read error = %v, want close code 4002", err)
+	}
+}
+
+func TestTransport_UpstreamErrorAfterFrames(t *testing.T) {
+	ctx := context.Background()
+	sim, client := newPair(t, Profile{Upstream: Conditions{Latency: 20 * time.Millisecond}})
+
+	client.Write(ctx, []byte{1})
+	client.Close(1000, "bye")
+
+	if got, err := readWithin(t, sim.Read, time.Second); err != nil || got[0] != 1 {
+		t.Fatalf("Read = (%v, %v), want [1]", got, err)
+	}
+	var closeErr *memtest.CloseError
+	if _, err := readWithin(t, sim.Read, time.Second); !errors.As(err, &closeErr) {
+		t.Errorf("Read error = %v, want CloseError", err)
+	}
+}
+
+func TestScript_At(t *testing.T) {
+	calm := Symmetric(Conditions{Latency: 20 * time.Millisecond})
+	congested := Symmetric(Conditions{Latency: 200 * time.Millisecond})
+	script := Script{{At: 0, Profile: calm}, {At: 10 * time.Second, Profile: congested}}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    Profile
+	}{
+		{0, calm},
+		{9 * time.Second, calm},
+		{10 * time.Second, congested},
+		{time.Minute, congested},
+	}
+	for _, tt := range tests {
+		if got := script.At(tt.elapsed); got != tt.want {
+			t.Errorf("At(%v) = %+v, want %+v", tt.elapsed, got, tt.want)
+		}
+	}
+	if got := (Script{{At: time.Second, Profile: calm}}).At(0); got != (Profile{}) {
+		t.Errorf("At before first step = %+v, want zero", got)
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	got, err := ParseProfile("latency=80ms, jitter=10ms,loss=0.02,down.bandwidth=64000,up.reorder=0.1,dup=0.01")
+	if err != nil {
+		t.Fatalf("ParseProfile failed: %v", err)
+	}
+	want := Profile{
+		Upstream:   Conditions{Latency: 80 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.02, Reorder: 0.1, Duplicate: 0.01},
+		Downstream: Conditions{Latency: 80 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.02, Bandwidth: 64000, Duplicate: 0.01},
+	}
+	if got != want {
+		t.Errorf("ParseProfile = %+v, want %+v", got, want)
+	}
+
+	for _, s := range []string{"latency", "latency=fast", "loss=2", "speed=1"} {
+		if _, err := ParseProfile(s); !errors.Is(err, ErrInvalidProfile) {
+			t.Errorf("ParseProfile(%q) error = %v, want %v", s, err, ErrInvalidProfile)
+		}
+	}
+}
+
+// TestHarness_DelayedClient はハーネス経由で片方のClientにだけ遅延を入れ、ブロードキャストの到着が遅れることを確認します。
+func TestHarness_DelayedClient(t *testing.T) {
+	const latency = 80 * time.Millisecond
+	h := memtest.NewHarness(t, domain.NewEchoApplication(), 1)
+	opts := DefaultOptions()
+	opts.Script = Static(Profile{Downstream: Conditions{Latency: latency}})
+	slow := h.ConnectWith(Middleware(opts))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, c := range h.Clients() {
+		if err := c.Join(ctx); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+	}
+	h.Client(0).SendInput(ctx, 0x01)
+	start := time.Now()
+	h.Tick(ctx)
+
+	isInput := func(data []byte) bool {
+		return len(data) > domain.HeaderSize && domain.DataType(data[domain.HeaderSize]) == domain.DataTypeInput
+	}
+	if _, err := h.Client(0).ReadUntil(ctx, isInput); err != nil {
+		t.Fatalf("fast client: %v", err)
+	}
+	if _, err := slow.ReadUntil(ctx, isInput); err != nil {
+		t.Fatalf("slow client: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("slow client received broadcast after %v, want >= %v", elapsed, latency)
+	}
+}
@@ -0,0 +1,219 @@
+package netsim
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"withered/server/domain"
+)
+
+// ErrTransportClosed はClose済みのTransportを操作した場合に返されるエラーです。
+var ErrTransportClosed = fmt.Errorf("netsim: %w", domain.ErrTransportClosed)
+
+// closeDrainTimeout はClose後に配送待ちのフレームを届け終えるまで待つ上限です。
+const closeDrainTimeout = 5 * time.Second
+
+// Options はTransportの設定です。
+type Options struct {
+	// Script は接続からの経過時間に応じた回線状態です。nilの場合は素通しになります。
+	Script Script
+	// Seed は乱数のシードです。0の場合はランダムに決まります。
+	// 同じシードと同じ入力であれば、破棄・複製・追い越しの判定が再現されます。
+	Seed uint64
+	// QueueLimit は片方向あたりの配送待ちフレーム数の上限です。超えたフレームは破棄されます。
+	QueueLimit int
+}
+
+// DefaultOptions は素通しのOptionsを返します。
+func DefaultOptions() Options {
+	return Options{QueueLimit: 4096}
+}
+
+// Transport は別のdomain.Transportをラップし、回線状態を再現するdomain.Transport実装です。
+// Writeは回線に流した時点で返り、フレームは配送時刻になってから内側のTransportに書き込まれます。
+// Readは同時に1つのgoroutineからのみ呼び出せます。
+type Transport struct {
+	inner domain.Transport
+	start time.Time
+
+	mu     sync.Mutex
+	script Script
+
+	up   *link
+	down *link
+
+	readCh  chan *frame
+	readErr error
+
+	writeMu  sync.Mutex
+	writeErr error
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	downDone  chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Wrap はinnerをラップしたTransportを作成し、回線の処理を開始します。
+func Wrap(inner domain.Transport, opts Options) *Transport {
+	if opts.QueueLimit < 1 {
+		opts.QueueLimit = DefaultOptions().QueueLimit
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Transport{
+		inner:    inner,
+		start:    time.Now(),
+		script:   opts.Script,
+		up:       newLink(rand.New(rand.NewPCG(seed, 1)), opts.QueueLimit),
+		down:     newLink(rand.New(rand.NewPCG(seed, 2)), opts.QueueLimit),
+		readCh:   make(chan *frame),
+		ctx:      ctx,
+		cancel:   cancel,
+		downDone: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go t.readLoop()
+	go t.up.run(ctx, t.deliverUp)
+	go t.downLoop()
+	return t
+}
+
+// Middleware は接続ごとにWrapするミドルウェアを返します。
+// Seedを指定した場合、接続ごとにSeedをずらして使用します。
+func Middleware(opts Options) func(domain.Transport) domain.Transport {
+	var mu sync.Mutex
+	return func(inner domain.Transport) domain.Transport {
+		o := opts
+		if opts.Seed != 0 {
+			mu.Lock()
+			o.Seed = opts.Seed
+			opts.Seed++
+			mu.Unlock()
+		}
+		return Wrap(inner, o)
+	}
+}
+
+// SetScript は回線状態の台本を差し替えます。経過時間は接続時点から数えたままです。
+func (t *Transport) SetScript(s Script) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.script = s
+}
+
+// Profile は現在の回線状態を返します。
+func (t *Transport) Profile() Profile {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.script.At(time.Since(t.start))
+}
+
+// Stats は両方向の回線の統計を返します。
+func (t *Transport) Stats() (upstream, downstream LinkStats) {
+	return t.up.Stats(), t.down.Stats()
+}
+
+func (t *Transport) Read(ctx context.Context) ([]byte, error) {
+	if t.readErr != nil {
+		return nil, t.readErr
+	}
+	select {
+	case f := <-t.readCh:
+		if f.err != nil {
+			t.readErr = f.err
+			return nil, f.err
+		}
+		return f.data, nil
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Transport) Write(ctx context.Context, data []byte) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	err := t.writeErr
+	t.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	t.down.send(time.Now(), t.Profile().Downstream, buf)
+	return nil
+}
+
+// Close は新たなWriteを止め、配送待ちのフレームを届けた後に内側のTransportを閉じます。
+// 配送は呼び出し元をブロックせずに行われ、closeDrainTimeoutを超えた分は破棄されます。
+func (t *Transport) Close(code int32, reason string) error {
+	err := ErrTransportClosed
+	t.closeOnce.Do(func() {
+		err = nil
+		close(t.closed)
+		t.up.close()
+		t.down.close()
+		go func() {
+			timer := time.AfterFunc(closeDrainTimeout, t.cancel)
+			defer timer.Stop()
+			<-t.downDone
+			t.cancel()
+			_ = t.inner.Close(code, reason)
+		}()
+	})
+	return err
+}
+
+// readLoop は内側のTransportから読んだフレームを上り回線に流します。
+func (t *Transport) readLoop() {
+	for {
+		data, err := t.inner.Read(t.ctx)
+		if err != nil {
+			if t.ctx.Err() == nil {
+				t.up.fail(time.Now(), t.Profile().Upstream, err)
+			}
+			return
+		}
+		t.up.send(time.Now(), t.Profile().Upstream, data)
+	}
+}
+
+func (t *Transport) deliverUp(f *frame) bool {
+	select {
+	case t.readCh <- f:
+		return f.err == nil
+	case <-t.closed:
+		return false
+	}
+}
+
+// downLoop は配送時刻になったフレームを内側のTransportに書き込みます。
+func (t *Transport) downLoop() {
+	defer close(t.downDone)
+	t.down.run(t.ctx, func(f *frame) bool {
+		if err := t.inner.Write(t.ctx, f.data); err != nil {
+			t.writeMu.Lock()
+			t.writeErr = err
+			t.writeMu.Unlock()
+			return false
+		}
+		return true
+	})
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 func GetEnvDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -9,3 +13,36 @@ func GetEnvDefault(key, defaultValue string) string {
 	}
 	return value
 }
+
+// GetEnvList はカンマ区切りの環境変数を空要素を除いたスライスとして返す
+func GetEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// GetEnvBool は真偽値の環境変数を返す。解釈できない場合はdefaultValueを返す
+func GetEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvInt は整数の環境変数を返す。解釈できない場合はdefaultValueを返す
+func GetEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}